@@ -0,0 +1,45 @@
+#!/bin/sh
+# Records the responses the internal/web tests use from a live server into internal/web/testdata, with the API key
+# and the server address scrubbed. Check the rest of each file for anything private before committing it, and update
+# the test assertions to the captured values.
+#
+# Usage: bin/capture-fixtures.sh radarr|sonarr|lidarr http://host:port apikey id command-id [tagged-id]
+#   radarr: id is a movie with a file and no tags, tagged-id one with tags and genres
+#   sonarr: id is an episode file, whose series is captured too
+#   lidarr: id is a track file
+set -eu
+
+kind=$1
+endpoint=${2%/}
+key=$3
+id=$4
+command=$5
+out=internal/web/testdata
+
+get() {
+	curl -sf -H "X-Api-Key: $key" "$endpoint/$1" | sed -e "s#$key#<api-key>#g" -e "s#$endpoint#http://localhost#g" > "$out/$2"
+	echo "captured $2"
+}
+
+case $kind in
+radarr)
+	get "api/v3/movie/$id" radarr_v3_movie.json
+	get "api/v3/movie/${6:?radarr needs a tagged movie id}" radarr_v3_movie_tagged.json
+	get "api/v3/tag" tags.json
+	get "api/v3/command/$command" radarr_v3_command.json
+	;;
+sonarr)
+	get "api/v3/episodefile/$id" sonarr_v3_episodefile.json
+	series=$(sed -n 's/.*"seriesId": *\([0-9]*\).*/\1/p' "$out/sonarr_v3_episodefile.json" | head -n 1)
+	get "api/v3/series/$series" sonarr_v3_series.json
+	get "api/v3/command/$command" sonarr_v3_command.json
+	;;
+lidarr)
+	get "api/v1/trackfile/$id" lidarr_trackfile.json
+	get "api/v1/command/$command" lidarr_command.json
+	;;
+*)
+	echo "unknown kind $kind, expected radarr, sonarr or lidarr" >&2
+	exit 1
+	;;
+esac
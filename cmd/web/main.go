@@ -4,13 +4,18 @@ import (
 	"context"
 	"media-web/internal/config"
 	"media-web/internal/controllers"
+	"media-web/internal/filter"
+	"media-web/internal/recycle"
 	"media-web/internal/web"
 	"media-web/internal/worker"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
+	// The runtime image removes its zoneinfo, so TRANSCODE_TIMEZONE and CRON_TZ need the database in the binary
+	_ "time/tzdata"
 
 	"github.com/robfig/cron/v3"
 
@@ -25,46 +30,42 @@ func startWorker(ctx context.Context) {
 	worker.StartWorkerPool(worker.GetWorkerContext(), worker.WorkerPoolFactoryImpl{}, ctx)
 }
 
-func performTVScan() {
-	log.Info().Msg("Scanning for TV in wrong format")
-	worker.ScanForTVShows(web.GetSonarrClient(), worker.Enqueuer)
-	log.Info().Msg("Done scanning for TV shows")
-}
-
-func performScan(scanner worker.MovieScanner) {
-	log.Info().Msg("Scanning for missing movies")
-	err := scanner.SearchForMissingMovies()
+func purgeRecycleBin(bin recycle.Bin) {
+	purged, err := bin.Purge(time.Now())
 	if err != nil {
-		log.Err(err).Msg("Error searching for movies")
+		log.Err(err).Int("purged", purged).Msg("Error purging recycle bin")
+		return
 	}
-	log.Info().Msg("Scanning for movies in wrong format")
-	err = scanner.ScanForMovies()
-	log.Info().Msg("Done scanning for movies")
+	log.Info().Int("purged", purged).Msg("Done purging recycle bin")
+}
+
+// scheduleScan runs an incremental scan on the scanner's schedule. A run is skipped when the previous one is still going
+func scheduleScan(c *cron.Cron, runner *worker.ScanRunner) {
+	status := runner.Status()
+	_, err := c.AddFunc(status.Schedule, func() {
+		if err := runner.Run(false); err == worker.ErrScanRunning {
+			log.Warn().Str("scanner", status.Scanner).Str("instance", status.Instance).Msg("Skipping scan, the previous one is still running")
+		}
+	})
 	if err != nil {
-		log.Err(err).Msg("Error scanning for movies")
+		log.Fatal().Err(err).Str("scanner", status.Scanner).Str("instance", status.Instance).Msg("Failed to start scanner")
 	}
 }
 
-func startScanners(ctx context.Context) {
+func startScanners(ctx context.Context, runners worker.ScanRunners) {
 	c := cron.New()
 
-	if config.GetConfig().EnableRadarrScanner {
-		scanner := worker.NewMovieScanner(web.GetRadarrClient(), worker.Enqueuer)
-
-		_, err := c.AddFunc("0 0 * * *", func() {
-			performScan(scanner)
-		})
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to start Radarr scanner")
+	for _, runner := range runners {
+		if runner.Status().Schedule != "" {
+			scheduleScan(c, runner)
 		}
 	}
-
-	if config.GetConfig().EnableSonarrScanner {
-		_, err := c.AddFunc("0 1 * * *", func() {
-			performTVScan()
+	if bin := recycle.GetBin(); bin != nil {
+		_, err := c.AddFunc("@hourly", func() {
+			purgeRecycleBin(bin)
 		})
 		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to start Radarr scanner")
+			log.Fatal().Err(err).Msg("Failed to start recycle bin purge")
 		}
 	}
 	c.Start()
@@ -73,6 +74,12 @@ func startScanners(ctx context.Context) {
 	c.Stop()
 }
 
+func startWatchFolders(ctx context.Context) {
+	opts := worker.WatchOptionsFromConfig(config.GetConfig())
+	log.Info().Strs("dirs", opts.Dirs).Msg("Starting watch folders")
+	worker.NewWatchFolder(opts, worker.Enqueuer, worker.GetWatchTracker()).Run(ctx)
+}
+
 func recoverHandler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, req *http.Request) {
 		defer func() {
@@ -86,23 +93,89 @@ func recoverHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func startWebserver(ctx context.Context) {
+// webhookPath is /api/radarr/webhook for the default instance and /api/radarr/{instance}/webhook for named ones
+func webhookPath(kind string, instance config.Instance) string {
+	if instance.IsDefault() {
+		return "/api/" + kind + "/webhook"
+	}
+	return "/api/" + kind + "/" + instance.Name + "/webhook"
+}
+
+func webhookOptions(instance config.Instance, inspector worker.JobInspector) (controllers.WebhookCredentials, controllers.WebhookOptions) {
+	creds := controllers.WebhookCredentials{
+		Username: instance.WebhookUsername,
+		Password: instance.WebhookPassword,
+		Token:    instance.WebhookToken,
+	}
+	opts := controllers.WebhookOptions{
+		Instance:      instance.Name,
+		Inspector:     inspector,
+		PathMapper:    instance.PathMappings,
+		UpgradePolicy: instance.UpgradePolicy,
+	}
+	return creds, opts
+}
+
+func startWebserver(ctx context.Context, scanRunners worker.ScanRunners) {
 	log.Info().Msg("Starting server.")
 	ro := mux.NewRouter()
 
 	ro.StrictSlash(true)
-	//r.Use(static.ServeRoot("/", "./public"))
-	ro.HandleFunc("/health", controllers.HealthHandler)
-	/*ro.HandleFunc("/api/movies", mvCtl.HandleCreate).Methods("POST")
-	ro.HandleFunc("/api/movies", mvCtl.HandleList).Methods("GET")
-	ro.HandleFunc("/api/movies/{id}", mvCtl.HandleDelete).Methods("DELETE")
-	ro.HandleFunc("/api/search/movies", mvCtl.HandleSearch).Methods("GET")*/
-	ro.HandleFunc("/api/radarr/webhook", controllers.GetRadarrWebhookHandler(worker.Enqueuer))
-	ro.HandleFunc("/api/sonarr/webhook", controllers.GetSonarrWebhookHandler(worker.Enqueuer)).Methods(http.MethodPost)
+	cfg := config.GetConfig()
+	// The endpoints that change jobs or files need the API credentials
+	apiCreds := controllers.WebhookCredentials{Username: cfg.ApiUsername, Password: cfg.ApiPassword, Token: cfg.ApiToken}
+	if !apiCreds.Enabled() {
+		log.Warn().Msg("API_PASSWORD and API_TOKEN are not set, so the API endpoints that change jobs or files are disabled")
+	}
+	pauser := worker.GetQueuePauser()
+	go worker.ReportQueuePauses(ctx, pauser)
+	ro.HandleFunc("/health", controllers.GetHealthHandler(pauser))
+	ro.HandleFunc("/api/config", controllers.GetConfigHandler).Methods(http.MethodGet)
+	ro.HandleFunc("/api/transcode", controllers.APIAuth(apiCreds, controllers.GetTranscodeHandler(worker.Enqueuer, web.GetSonarrClients(),
+		web.GetRadarrClients(), cfg.PathTranscodeRoots()))).Methods(http.MethodPost)
+	ro.HandleFunc("/api/history", controllers.GetJobHistoryHandler(worker.GetJobHistory())).Methods(http.MethodGet)
+	inspector := worker.GetJobInspector()
+	rules := filter.GetRules()
+	for _, instance := range cfg.RadarrInstances {
+		creds, opts := webhookOptions(instance, inspector)
+		opts.MovieFilter = worker.NewMovieFilter(rules.Radarr, instance.Name, web.NewRadarrClient(instance))
+		ro.HandleFunc(webhookPath("radarr", instance), controllers.WebhookAuth("radarr", creds, controllers.GetRadarrWebhookHandler(worker.Enqueuer, opts)))
+	}
+	for _, instance := range cfg.SonarrInstances {
+		creds, opts := webhookOptions(instance, inspector)
+		opts.SeriesFilter = worker.NewSeriesFilter(rules.Sonarr, instance.Name, web.NewSonarrClient(instance))
+		ro.HandleFunc(webhookPath("sonarr", instance), controllers.WebhookAuth("sonarr", creds, controllers.GetSonarrWebhookHandler(worker.Enqueuer, opts))).Methods(http.MethodPost)
+	}
+	lidarrCreds := controllers.WebhookCredentials{
+		Username: cfg.LidarrWebhookUsername,
+		Password: cfg.LidarrWebhookPassword,
+		Token:    cfg.LidarrWebhookToken,
+	}
+	lidarrOpts := controllers.WebhookOptions{
+		Inspector:     inspector,
+		PathMapper:    cfg.LidarrPathMappings,
+		UpgradePolicy: cfg.LidarrUpgradePolicy,
+	}
+	ro.HandleFunc("/api/lidarr/webhook", controllers.WebhookAuth("lidarr", lidarrCreds, controllers.GetLidarrWebhookHandler(worker.Enqueuer, lidarrOpts))).Methods(http.MethodPost)
+	ro.HandleFunc("/api/jobs", controllers.GetJobsSummaryHandler(inspector)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/jobs/{status}", controllers.GetJobsHandler(inspector)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/jobs/dead/{diedAt}/{id}/retry", controllers.APIAuth(apiCreds, controllers.GetRetryDeadJobHandler(inspector))).Methods(http.MethodPost)
+	ro.HandleFunc("/api/jobs/queued/{name}/{id}", controllers.APIAuth(apiCreds, controllers.GetDeleteQueuedJobHandler(inspector))).Methods(http.MethodDelete)
+	ro.HandleFunc("/api/jobs/{id}", controllers.APIAuth(apiCreds, controllers.GetCancelJobHandler(inspector))).Methods(http.MethodDelete)
+	ro.HandleFunc("/api/queue", controllers.GetQueueHandler(pauser)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/queue/pause", controllers.APIAuth(apiCreds, controllers.GetPauseQueueHandler(pauser))).Methods(http.MethodPost)
+	ro.HandleFunc("/api/queue/resume", controllers.APIAuth(apiCreds, controllers.GetResumeQueueHandler(pauser))).Methods(http.MethodPost)
+	ro.HandleFunc("/api/scan", controllers.GetScanStatusHandler(scanRunners)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/scan/{scanner}", controllers.APIAuth(apiCreds, controllers.GetScanHandler(scanRunners))).Methods(http.MethodPost)
+	if bin := recycle.GetBin(); bin != nil {
+		ro.HandleFunc("/api/recycle", controllers.GetRecycleListHandler(bin)).Methods(http.MethodGet)
+		ro.HandleFunc("/api/recycle/{id}/restore", controllers.APIAuth(apiCreds, controllers.GetRecycleRestoreHandler(bin, worker.Enqueuer))).Methods(http.MethodPost)
+	}
 	ro.Handle("/metrics", promhttp.Handler())
 	ro.HandleFunc("/debug/pprof/", pprof.Index).Methods("GET")
 	ro.HandleFunc("/debug/pprof/{name}", pprofHandler())
-	//r.GET("/api/config", controllers.GetConfigHandler)
+	// The dashboard is registered last so it doesn't shadow any API routes
+	ro.PathPrefix("/").Handler(http.FileServer(http.Dir(config.GetConfig().PublicDir)))
 
 	serv := http.Server{
 		Addr:         ":8080",
@@ -147,18 +220,32 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	go startWebserver(ctx)
+	scanRunners := worker.GetScanRunners()
+
+	go startWebserver(ctx, scanRunners)
 
+	workerStopped := make(chan struct{})
 	if config.GetConfig().EnableWorker {
-		go startWorker(ctx)
+		go func() {
+			startWorker(ctx)
+			close(workerStopped)
+		}()
+	} else {
+		close(workerStopped)
 	}
 
-	go startScanners(ctx)
+	go startScanners(ctx, scanRunners)
+
+	if len(config.GetConfig().WatchDirs) > 0 {
+		go startWatchFolders(ctx)
+	}
 
 	log.Debug().Msg("Waiting for exit signal")
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, os.Kill)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	<-signalChan
 	cancel()
+	// Running transcodes get the drain timeout to finish before the worker pool stops
+	<-workerStopped
 	log.Debug().Msg("Exiting.")
 }
@@ -1,27 +1,100 @@
 package config
 
 import (
+	"fmt"
+	"media-web/internal/pathmap"
+	"media-web/internal/timewindow"
 	"net/url"
+	"os"
 	"reflect"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/rs/zerolog/log"
 )
 
 type Config struct {
-	EnableWeb           bool     `env:"ENABLE_WEB" envDefault:"true"`
-	EnableWorker        bool     `env:"ENABLE_WORKER" envDefault:"false"`
-	EnableRadarrScanner bool     `env:"ENABLE_RADARR_SCANNER" envDefault:"false"`
-	EnableSonarrScanner bool     `env:"ENABLE_SONARR_SCANNER" envDefault:"false"`
-	EnablePrettyLog     bool     `env:"ENABLE_PRETTYLOG" envDefault:"false"`
-	RadarrApiKey        string   `env:"RADARR_API_KEY"`
-	SonarrApiKey        string   `env:"SONARR_API_KEY"`
-	RadarrBaseEndpoint  *url.URL `env:"RADARR_BASE_ENDPOINT"`
-	SonarrBaseEndpoint  *url.URL `env:"SONARR_BASE_ENDPOINT"`
-	RedisAddress        *url.URL `env:"REDIS_ADDRESS"`
-	JobQueueNamespace   string   `env:"JOB_QUEUE_NAMESPACE" envDefault:"media-web"`
-	FfmpegPath          string   `env:"FFMPEG_PATH" envDefault:"/usr/bin/ffmpeg"`
-	FfprobePath         string   `env:"FFPROBE_PATH" envDefault:"/usr/bin/ffprobe"`
+	EnableWeb               bool           `env:"ENABLE_WEB" envDefault:"true"`
+	EnableWorker            bool           `env:"ENABLE_WORKER" envDefault:"false"`
+	EnableRadarrScanner     bool           `env:"ENABLE_RADARR_SCANNER" envDefault:"false"`
+	EnableSonarrScanner     bool           `env:"ENABLE_SONARR_SCANNER" envDefault:"false"`
+	RadarrScannerSchedule   string         `env:"RADARR_SCANNER_SCHEDULE" envDefault:"0 0 * * *"`
+	SonarrScannerSchedule   string         `env:"SONARR_SCANNER_SCHEDULE" envDefault:"0 1 * * *"`
+	RadarrScannerTimezone   string         `env:"RADARR_SCANNER_TIMEZONE"`
+	SonarrScannerTimezone   string         `env:"SONARR_SCANNER_TIMEZONE"`
+	RadarrSearchMissing     bool           `env:"RADARR_SEARCH_MISSING" envDefault:"true"`
+	EnablePrettyLog         bool           `env:"ENABLE_PRETTYLOG" envDefault:"false"`
+	RadarrApiKey            string         `env:"RADARR_API_KEY"`
+	SonarrApiKey            string         `env:"SONARR_API_KEY"`
+	RadarrApiVersion        string         `env:"RADARR_API_VERSION" envDefault:"auto"`
+	SonarrApiVersion        string         `env:"SONARR_API_VERSION" envDefault:"auto"`
+	RadarrWebhookUsername   string         `env:"RADARR_WEBHOOK_USERNAME"`
+	RadarrWebhookPassword   string         `env:"RADARR_WEBHOOK_PASSWORD"`
+	RadarrWebhookToken      string         `env:"RADARR_WEBHOOK_TOKEN"`
+	SonarrWebhookUsername   string         `env:"SONARR_WEBHOOK_USERNAME"`
+	SonarrWebhookPassword   string         `env:"SONARR_WEBHOOK_PASSWORD"`
+	SonarrWebhookToken      string         `env:"SONARR_WEBHOOK_TOKEN"`
+	RadarrBaseEndpoint      *url.URL       `env:"RADARR_BASE_ENDPOINT"`
+	SonarrBaseEndpoint      *url.URL       `env:"SONARR_BASE_ENDPOINT"`
+	LidarrApiKey            string         `env:"LIDARR_API_KEY"`
+	LidarrBaseEndpoint      *url.URL       `env:"LIDARR_BASE_ENDPOINT"`
+	LidarrWebhookUsername   string         `env:"LIDARR_WEBHOOK_USERNAME"`
+	LidarrWebhookPassword   string         `env:"LIDARR_WEBHOOK_PASSWORD"`
+	LidarrWebhookToken      string         `env:"LIDARR_WEBHOOK_TOKEN"`
+	LidarrPathMappings      pathmap.Mapper `env:"LIDARR_PATH_MAPPINGS"`
+	LidarrUpgradePolicy     string         `env:"LIDARR_UPGRADE_POLICY" envDefault:"transcode"`
+	RedisAddress            *url.URL       `env:"REDIS_ADDRESS"`
+	JobQueueNamespace       string         `env:"JOB_QUEUE_NAMESPACE" envDefault:"media-web"`
+	JobHistorySize          int            `env:"JOB_HISTORY_SIZE" envDefault:"100"`
+	PublicDir               string         `env:"PUBLIC_DIR" envDefault:"./public"`
+	ApiUsername             string         `env:"API_USERNAME"`
+	ApiPassword             string         `env:"API_PASSWORD"`
+	ApiToken                string         `env:"API_TOKEN"`
+	FfmpegPath              string         `env:"FFMPEG_PATH" envDefault:"/usr/bin/ffmpeg"`
+	FfprobePath             string         `env:"FFPROBE_PATH" envDefault:"/usr/bin/ffprobe"`
+	ProbeCacheTTL           time.Duration  `env:"PROBE_CACHE_TTL" envDefault:"720h"`
+	RadarrUpgradePolicy     string         `env:"RADARR_UPGRADE_POLICY" envDefault:"transcode"`
+	SonarrUpgradePolicy     string         `env:"SONARR_UPGRADE_POLICY" envDefault:"transcode"`
+	TranscodeProfilesPath   string         `env:"TRANSCODE_PROFILES_PATH"`
+	FilterRulesPath         string         `env:"FILTER_RULES_PATH"`
+	DefaultTVProfile        string         `env:"DEFAULT_TV_PROFILE" envDefault:"default"`
+	DefaultMovieProfile     string         `env:"DEFAULT_MOVIE_PROFILE" envDefault:"default"`
+	DefaultMusicProfile     string         `env:"DEFAULT_MUSIC_PROFILE" envDefault:"music"`
+	ScratchDir              string         `env:"SCRATCH_DIR"`
+	VerifyDurationTolerance time.Duration  `env:"VERIFY_DURATION_TOLERANCE" envDefault:"2s"`
+	VerifyDecode            bool           `env:"VERIFY_DECODE" envDefault:"true"`
+	WorkerDrainTimeout      time.Duration  `env:"WORKER_DRAIN_TIMEOUT" envDefault:"1m"`
+	ChunkMinDuration        time.Duration  `env:"CHUNK_MIN_DURATION"`
+	ChunkDuration           time.Duration  `env:"CHUNK_DURATION" envDefault:"5m"`
+	ChunkConcurrency        uint           `env:"CHUNK_CONCURRENCY" envDefault:"0"`
+	WorkerClass             string         `env:"WORKER_CLASS"`
+	WorkerMaxEncodes        uint           `env:"WORKER_MAX_ENCODES" envDefault:"1"`
+	WorkerProfiles          []string       `env:"WORKER_PROFILES"`
+	WorkerPathRoots         []string       `env:"WORKER_PATH_ROOTS"`
+	WorkerCPUClass          string         `env:"WORKER_CPU_CLASS"`
+	TranscodeTimezone       string         `env:"TRANSCODE_TIMEZONE"`
+	TranscodeMaxLoad        float64        `env:"TRANSCODE_MAX_LOAD"`
+	TranscodeNice           int            `env:"TRANSCODE_NICE"`
+	TranscodeIOClass        int            `env:"TRANSCODE_IONICE_CLASS"`
+	TranscodeIOLevel        int            `env:"TRANSCODE_IONICE_LEVEL" envDefault:"4"`
+	RecycleDir              string         `env:"RECYCLE_DIR"`
+	RecycleRetention        time.Duration  `env:"RECYCLE_RETENTION" envDefault:"168h"`
+	RadarrPathMappings      pathmap.Mapper `env:"RADARR_PATH_MAPPINGS"`
+	SonarrPathMappings      pathmap.Mapper `env:"SONARR_PATH_MAPPINGS"`
+	MediaRoots              []string       `env:"MEDIA_ROOTS"`
+	WatchDirs               []string       `env:"WATCH_DIRS"`
+	WatchDestination        string         `env:"WATCH_DESTINATION"`
+	WatchProfile            string         `env:"WATCH_PROFILE"`
+	WatchExtensions         []string       `env:"WATCH_EXTENSIONS" envDefault:".mkv,.mp4,.m4v,.avi,.mov,.ts,.wmv"`
+	WatchStableTime         time.Duration  `env:"WATCH_STABLE_TIME" envDefault:"30s"`
+	WatchPollInterval       time.Duration  `env:"WATCH_POLL_INTERVAL" envDefault:"1m"`
+	RadarrInstanceNames     []string       `env:"RADARR_INSTANCES"`
+	SonarrInstanceNames     []string       `env:"SONARR_INSTANCES"`
+	// TranscodeWindows are the times encodes may start. Empty allows any time
+	TranscodeWindows timewindow.Windows `env:"TRANSCODE_WINDOWS"`
+	// RadarrInstances and SonarrInstances are the default instance followed by the named ones
+	RadarrInstances []Instance
+	SonarrInstances []Instance
 }
 
 var config = ValidateConfig()
@@ -32,13 +105,74 @@ func ValidateConfig() Config {
 	funcs[reflect.TypeOf(&url.URL{})] = func(v string) (i interface{}, e error) {
 		return url.Parse(v)
 	}
+	funcs[reflect.TypeOf(pathmap.Mapper{})] = func(v string) (i interface{}, e error) {
+		return pathmap.Parse(v)
+	}
+	funcs[reflect.TypeOf(timewindow.Windows{})] = func(v string) (i interface{}, e error) {
+		return timewindow.Parse(v)
+	}
 
 	if err := env.ParseWithFuncs(&cfg, funcs); err != nil {
 		log.Fatal().Err(err).Msg("Failed to parse config")
 	}
+
+	var err error
+	cfg.RadarrInstances, err = parseInstances("RADARR", cfg.RadarrInstanceNames, cfg.defaultRadarrInstance(), os.Environ(), funcs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse Radarr instances")
+	}
+	cfg.SonarrInstances, err = parseInstances("SONARR", cfg.SonarrInstanceNames, cfg.defaultSonarrInstance(), os.Environ(), funcs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse Sonarr instances")
+	}
+	if err = cfg.validateUpgradePolicies(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid upgrade policy")
+	}
+	if err = cfg.validateAPIVersions(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid API version")
+	}
+	if err = cfg.validateTranscodePriority(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid transcode priority")
+	}
 	return cfg
 }
 
+// validateTranscodePriority checks TRANSCODE_NICE and the TRANSCODE_IONICE_ settings are in the ranges nice and
+// ionice accept
+func (c Config) validateTranscodePriority() error {
+	if c.TranscodeNice < -20 || c.TranscodeNice > 19 {
+		return fmt.Errorf("TRANSCODE_NICE must be from -20 to 19, got %d", c.TranscodeNice)
+	}
+	if c.TranscodeIOClass < 0 || c.TranscodeIOClass > 3 {
+		return fmt.Errorf("TRANSCODE_IONICE_CLASS must be from 0 to 3, got %d", c.TranscodeIOClass)
+	}
+	if c.TranscodeIOLevel < 0 || c.TranscodeIOLevel > 7 {
+		return fmt.Errorf("TRANSCODE_IONICE_LEVEL must be from 0 to 7, got %d", c.TranscodeIOLevel)
+	}
+	return nil
+}
+
+// PathTranscodeRoots are the folders files transcoded by path may be in: MEDIA_ROOTS, the local folders of the path
+// mappings, and the watch folders and their destination. The scratch folder only holds outputs that are still being
+// written, so it isn't one of them
+func (c Config) PathTranscodeRoots() []string {
+	roots := append([]string{}, c.MediaRoots...)
+	mappers := []pathmap.Mapper{c.LidarrPathMappings}
+	for _, instance := range append(append([]Instance{}, c.RadarrInstances...), c.SonarrInstances...) {
+		mappers = append(mappers, instance.PathMappings)
+	}
+	for _, mapper := range mappers {
+		for _, mapping := range mapper {
+			roots = append(roots, mapping.Local)
+		}
+	}
+	roots = append(roots, c.WatchDirs...)
+	if c.WatchDestination != "" {
+		roots = append(roots, c.WatchDestination)
+	}
+	return roots
+}
+
 func GetConfig() Config {
 	return config
 }
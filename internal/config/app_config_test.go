@@ -0,0 +1,30 @@
+package config
+
+import (
+	"media-web/internal/pathmap"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathTranscodeRoots(t *testing.T) {
+	c := Config{
+		MediaRoots:         []string{"/media/other"},
+		WatchDirs:          []string{"/media/incoming"},
+		ScratchDir:         "/scratch",
+		LidarrPathMappings: pathmap.Mapper{{Remote: "/music", Local: "/media/music"}},
+		RadarrInstances:    []Instance{{Name: DefaultInstance, PathMappings: pathmap.Mapper{{Remote: "/movies", Local: "/media/movies"}}}},
+		SonarrInstances:    []Instance{{Name: DefaultInstance}},
+	}
+
+	assert.Equal(t, []string{"/media/other", "/media/music", "/media/movies", "/media/incoming"}, c.PathTranscodeRoots())
+}
+
+func TestValidateTranscodePriority(t *testing.T) {
+	assert.NoError(t, Config{TranscodeNice: 19, TranscodeIOClass: 3, TranscodeIOLevel: 7}.validateTranscodePriority())
+	assert.NoError(t, Config{TranscodeNice: -20, TranscodeIOLevel: 0}.validateTranscodePriority())
+
+	assert.EqualError(t, Config{TranscodeNice: 20}.validateTranscodePriority(), "TRANSCODE_NICE must be from -20 to 19, got 20")
+	assert.EqualError(t, Config{TranscodeIOClass: 4}.validateTranscodePriority(), "TRANSCODE_IONICE_CLASS must be from 0 to 3, got 4")
+	assert.EqualError(t, Config{TranscodeIOLevel: -1}.validateTranscodePriority(), "TRANSCODE_IONICE_LEVEL must be from 0 to 7, got -1")
+}
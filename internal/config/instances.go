@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+	"media-web/internal/pathmap"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+)
+
+// DefaultInstance is the name of the Sonarr or Radarr server configured without a prefix, like
+// RADARR_BASE_ENDPOINT. Jobs without an instance argument belong to it
+const DefaultInstance = "default"
+
+const (
+	// UpgradeTranscode transcodes upgraded files like any other download
+	UpgradeTranscode = "transcode"
+	// UpgradeSkip leaves upgraded files as they were imported
+	UpgradeSkip = "skip"
+)
+
+// The Sonarr and Radarr API versions an instance can be configured with
+const (
+	APIVersionAuto   = "auto"
+	APIVersionV3     = "v3"
+	APIVersionLegacy = "legacy"
+)
+
+// Instance is one Sonarr or Radarr server. Named instances are listed in RADARR_INSTANCES or SONARR_INSTANCES and
+// configured with variables prefixed by the kind and name, like RADARR_UHD_BASE_ENDPOINT for the instance "uhd"
+type Instance struct {
+	Name            string
+	BaseEndpoint    *url.URL       `env:"BASE_ENDPOINT"`
+	ApiKey          string         `env:"API_KEY"`
+	ApiVersion      string         `env:"API_VERSION" envDefault:"auto"`
+	PathMappings    pathmap.Mapper `env:"PATH_MAPPINGS"`
+	WebhookUsername string         `env:"WEBHOOK_USERNAME"`
+	WebhookPassword string         `env:"WEBHOOK_PASSWORD"`
+	WebhookToken    string         `env:"WEBHOOK_TOKEN"`
+	UpgradePolicy   string         `env:"UPGRADE_POLICY" envDefault:"transcode"`
+	EnableScanner   bool           `env:"ENABLE_SCANNER" envDefault:"false"`
+	// ScannerSchedule is a cron expression, defaulting to the schedule of the default instance
+	ScannerSchedule string `env:"SCANNER_SCHEDULE"`
+	// ScannerTimezone is the IANA time zone the schedule is in, defaulting to the default instance's. Empty is the
+	// local time of the server
+	ScannerTimezone string `env:"SCANNER_TIMEZONE"`
+	// SearchMissing asks Radarr to search for missing movies before each scan. Sonarr instances ignore it
+	SearchMissing bool `env:"SEARCH_MISSING" envDefault:"true"`
+	// DefaultProfile defaults to DEFAULT_MOVIE_PROFILE or DEFAULT_TV_PROFILE
+	DefaultProfile string `env:"DEFAULT_PROFILE"`
+}
+
+// validateUpgradePolicy checks policy is UpgradeTranscode or UpgradeSkip
+func validateUpgradePolicy(name string, policy string) error {
+	if policy != UpgradeTranscode && policy != UpgradeSkip {
+		return fmt.Errorf("upgrade policy of %s must be %s or %s, got %q", name, UpgradeTranscode, UpgradeSkip, policy)
+	}
+	return nil
+}
+
+// validateUpgradePolicies checks the upgrade policy of every instance and of Lidarr
+func (c Config) validateUpgradePolicies() error {
+	for _, instance := range c.RadarrInstances {
+		if err := validateUpgradePolicy("Radarr instance "+instance.Name, instance.UpgradePolicy); err != nil {
+			return err
+		}
+	}
+	for _, instance := range c.SonarrInstances {
+		if err := validateUpgradePolicy("Sonarr instance "+instance.Name, instance.UpgradePolicy); err != nil {
+			return err
+		}
+	}
+	return validateUpgradePolicy("Lidarr", c.LidarrUpgradePolicy)
+}
+
+// validateAPIVersion checks version is one of the API versions. Empty means APIVersionAuto
+func validateAPIVersion(name string, version string) error {
+	switch version {
+	case "", APIVersionAuto, APIVersionV3, APIVersionLegacy:
+		return nil
+	}
+	return fmt.Errorf("API version of %s must be %s, %s or %s, got %q", name, APIVersionAuto, APIVersionV3,
+		APIVersionLegacy, version)
+}
+
+// validateAPIVersions checks the API version of every Radarr and Sonarr instance
+func (c Config) validateAPIVersions() error {
+	for _, instance := range c.RadarrInstances {
+		if err := validateAPIVersion("Radarr instance "+instance.Name, instance.ApiVersion); err != nil {
+			return err
+		}
+	}
+	for _, instance := range c.SonarrInstances {
+		if err := validateAPIVersion("Sonarr instance "+instance.Name, instance.ApiVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsDefault reports whether this is the instance configured without a prefix
+func (i Instance) IsDefault() bool {
+	return i.Name == DefaultInstance
+}
+
+// CronSpec returns the scanner schedule in the instance's time zone
+func (i Instance) CronSpec() string {
+	if i.ScannerTimezone == "" {
+		return i.ScannerSchedule
+	}
+	return "CRON_TZ=" + i.ScannerTimezone + " " + i.ScannerSchedule
+}
+
+func (c Config) defaultRadarrInstance() Instance {
+	return Instance{
+		Name:            DefaultInstance,
+		BaseEndpoint:    c.RadarrBaseEndpoint,
+		ApiKey:          c.RadarrApiKey,
+		ApiVersion:      c.RadarrApiVersion,
+		PathMappings:    c.RadarrPathMappings,
+		WebhookUsername: c.RadarrWebhookUsername,
+		WebhookPassword: c.RadarrWebhookPassword,
+		WebhookToken:    c.RadarrWebhookToken,
+		UpgradePolicy:   c.RadarrUpgradePolicy,
+		EnableScanner:   c.EnableRadarrScanner,
+		ScannerSchedule: c.RadarrScannerSchedule,
+		ScannerTimezone: c.RadarrScannerTimezone,
+		SearchMissing:   c.RadarrSearchMissing,
+		DefaultProfile:  c.DefaultMovieProfile,
+	}
+}
+
+func (c Config) defaultSonarrInstance() Instance {
+	return Instance{
+		Name:            DefaultInstance,
+		BaseEndpoint:    c.SonarrBaseEndpoint,
+		ApiKey:          c.SonarrApiKey,
+		ApiVersion:      c.SonarrApiVersion,
+		PathMappings:    c.SonarrPathMappings,
+		WebhookUsername: c.SonarrWebhookUsername,
+		WebhookPassword: c.SonarrWebhookPassword,
+		WebhookToken:    c.SonarrWebhookToken,
+		UpgradePolicy:   c.SonarrUpgradePolicy,
+		EnableScanner:   c.EnableSonarrScanner,
+		ScannerSchedule: c.SonarrScannerSchedule,
+		ScannerTimezone: c.SonarrScannerTimezone,
+		DefaultProfile:  c.DefaultTVProfile,
+	}
+}
+
+// RadarrInstance finds a Radarr instance by name. An empty name is the default instance
+func (c Config) RadarrInstance(name string) (Instance, bool) {
+	return findInstance(c.RadarrInstances, name)
+}
+
+// SonarrInstance finds a Sonarr instance by name. An empty name is the default instance
+func (c Config) SonarrInstance(name string) (Instance, bool) {
+	return findInstance(c.SonarrInstances, name)
+}
+
+func findInstance(instances []Instance, name string) (Instance, bool) {
+	if name == "" {
+		name = DefaultInstance
+	}
+	for _, instance := range instances {
+		if instance.Name == name {
+			return instance, true
+		}
+	}
+	return Instance{}, false
+}
+
+// parseInstances reads each named instance from the variables starting with kind and its name. The default
+// instance comes first
+func parseInstances(kind string, names []string, defaults Instance, environ []string, funcs map[reflect.Type]env.ParserFunc) ([]Instance, error) {
+	instances := []Instance{defaults}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || name == DefaultInstance {
+			continue
+		}
+		instance := Instance{Name: name}
+		opts := env.Options{Environment: prefixedEnvironment(environ, kind+"_"+strings.ToUpper(name)+"_")}
+		if err := env.ParseWithFuncs(&instance, funcs, opts); err != nil {
+			return nil, err
+		}
+		if instance.ScannerSchedule == "" {
+			instance.ScannerSchedule = defaults.ScannerSchedule
+		}
+		if instance.ScannerTimezone == "" {
+			instance.ScannerTimezone = defaults.ScannerTimezone
+		}
+		if instance.DefaultProfile == "" {
+			instance.DefaultProfile = defaults.DefaultProfile
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// prefixedEnvironment returns the variables starting with prefix, without it
+func prefixedEnvironment(environ []string, prefix string) map[string]string {
+	vars := make(map[string]string)
+	for _, e := range environ {
+		if !strings.HasPrefix(e, prefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(e, prefix), "=", 2)
+		if len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
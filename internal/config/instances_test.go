@@ -0,0 +1,119 @@
+package config
+
+import (
+	"media-web/internal/pathmap"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInstances(t *testing.T) {
+	funcs := map[reflect.Type]env.ParserFunc{
+		reflect.TypeOf(&url.URL{}): func(v string) (interface{}, error) {
+			return url.Parse(v)
+		},
+		reflect.TypeOf(pathmap.Mapper{}): func(v string) (interface{}, error) {
+			return pathmap.Parse(v)
+		},
+	}
+	environ := []string{
+		"RADARR_BASE_ENDPOINT=http://radarr:7878",
+		"RADARR_UHD_BASE_ENDPOINT=http://radarr-4k:7878",
+		"RADARR_UHD_API_KEY=secret",
+		"RADARR_UHD_DEFAULT_PROFILE=hevc",
+		"RADARR_UHD_ENABLE_SCANNER=true",
+		"RADARR_UHD_PATH_MAPPINGS=/movies=/media/movies-4k",
+		"RADARR_KIDS_SCANNER_SCHEDULE=0 3 * * *",
+		"RADARR_KIDS_SCANNER_TIMEZONE=America/New_York",
+		"RADARR_KIDS_SEARCH_MISSING=false",
+	}
+	defaults := Instance{Name: DefaultInstance, ScannerSchedule: "0 0 * * *", ScannerTimezone: "Europe/London", DefaultProfile: "default"}
+
+	instances, err := parseInstances("RADARR", []string{"uhd", " kids", "default"}, defaults, environ, funcs)
+
+	assert.NoError(t, err)
+	assert.Len(t, instances, 3)
+	assert.Equal(t, defaults, instances[0])
+
+	uhd := instances[1]
+	assert.Equal(t, "uhd", uhd.Name)
+	assert.Equal(t, "radarr-4k:7878", uhd.BaseEndpoint.Host)
+	assert.Equal(t, "secret", uhd.ApiKey)
+	assert.Equal(t, "hevc", uhd.DefaultProfile)
+	assert.Equal(t, "auto", uhd.ApiVersion)
+	assert.True(t, uhd.EnableScanner)
+	assert.Equal(t, "0 0 * * *", uhd.ScannerSchedule)
+	assert.Equal(t, "Europe/London", uhd.ScannerTimezone)
+	assert.True(t, uhd.SearchMissing)
+	assert.Equal(t, pathmap.Mapper{{Remote: "/movies", Local: "/media/movies-4k"}}, uhd.PathMappings)
+
+	kids := instances[2]
+	assert.Nil(t, kids.BaseEndpoint)
+	assert.Equal(t, "default", kids.DefaultProfile)
+	assert.Equal(t, "0 3 * * *", kids.ScannerSchedule)
+	assert.Equal(t, "America/New_York", kids.ScannerTimezone)
+	assert.False(t, kids.SearchMissing)
+	assert.False(t, kids.EnableScanner)
+	assert.Empty(t, kids.PathMappings)
+}
+
+func TestFindInstance(t *testing.T) {
+	cfg := Config{RadarrInstances: []Instance{{Name: DefaultInstance}, {Name: "uhd"}}}
+
+	instance, ok := cfg.RadarrInstance("")
+	assert.True(t, ok)
+	assert.True(t, instance.IsDefault())
+
+	instance, ok = cfg.RadarrInstance("uhd")
+	assert.True(t, ok)
+	assert.Equal(t, "uhd", instance.Name)
+
+	_, ok = cfg.RadarrInstance("anime")
+	assert.False(t, ok)
+	_, ok = cfg.SonarrInstance("")
+	assert.False(t, ok)
+}
+
+func TestCronSpec(t *testing.T) {
+	instance := Instance{ScannerSchedule: "0 0 * * *"}
+	assert.Equal(t, "0 0 * * *", instance.CronSpec())
+
+	instance.ScannerTimezone = "Europe/London"
+	assert.Equal(t, "CRON_TZ=Europe/London 0 0 * * *", instance.CronSpec())
+}
+
+func TestValidateUpgradePolicies(t *testing.T) {
+	valid := Config{
+		RadarrInstances:     []Instance{{Name: DefaultInstance, UpgradePolicy: UpgradeTranscode}},
+		SonarrInstances:     []Instance{{Name: DefaultInstance, UpgradePolicy: UpgradeSkip}},
+		LidarrUpgradePolicy: UpgradeTranscode,
+	}
+	assert.NoError(t, valid.validateUpgradePolicies())
+
+	typo := valid
+	typo.SonarrInstances = []Instance{{Name: DefaultInstance, UpgradePolicy: UpgradeSkip}, {Name: "anime", UpgradePolicy: "skipped"}}
+	assert.EqualError(t, typo.validateUpgradePolicies(), `upgrade policy of Sonarr instance anime must be transcode or skip, got "skipped"`)
+
+	lidarr := valid
+	lidarr.LidarrUpgradePolicy = "Skip"
+	assert.Error(t, lidarr.validateUpgradePolicies())
+}
+
+func TestValidateAPIVersions(t *testing.T) {
+	valid := Config{
+		RadarrInstances: []Instance{{Name: DefaultInstance, ApiVersion: APIVersionAuto}, {Name: "uhd", ApiVersion: APIVersionLegacy}},
+		SonarrInstances: []Instance{{Name: DefaultInstance, ApiVersion: APIVersionV3}, {Name: "anime"}},
+	}
+	assert.NoError(t, valid.validateAPIVersions())
+
+	typo := valid
+	typo.RadarrInstances = []Instance{{Name: "uhd", ApiVersion: "V3"}}
+	assert.EqualError(t, typo.validateAPIVersions(), `API version of Radarr instance uhd must be auto, v3 or legacy, got "V3"`)
+
+	sonarr := valid
+	sonarr.SonarrInstances = []Instance{{Name: DefaultInstance, ApiVersion: "v4"}}
+	assert.Error(t, sonarr.validateAPIVersions())
+}
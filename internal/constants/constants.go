@@ -7,14 +7,30 @@ var IsLocal = os.Getenv("LOCAL") == "true"
 const SeriesIdKey = "seriesId"
 const MovieIdKey = "movieId"
 const TranscodeJobType = "transcode-job"
+const PriorityTranscodeJobType = "transcode-job-priority"
 const UpdateRadarrJobName = "update-radarr"
 const UpdateSonarrJobName = "update-sonarr"
+const UpdateLidarrJobName = "update-lidarr"
+const TranscodeChunkJobName = "transcode-chunk"
 const EpisodeFileIdKey = "episodeFileId"
+const ArtistIdKey = "artistId"
+const TrackFileIdKey = "trackFileId"
 const TranscodeTypeKey = "transcodeType"
+const ProfileKey = "profile"
+const FilePathKey = "filePath"
+const InstanceKey = "instance"
+const DestinationKey = "destination"
+const WatchedKey = "watched"
+const ChunkPlanKey = "chunkPlan"
+const ChunkIndexKey = "chunkIndex"
 
 type TranscodeType string
 
 const (
 	TV    TranscodeType = "TV"
 	Movie               = "Movie"
+	// Music is a Lidarr track file, transcoded with an audio profile
+	Music = "Music"
+	// File is a path that isn't managed by Sonarr or Radarr so there is nothing to rescan afterwards
+	File = "File"
 )
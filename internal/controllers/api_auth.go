@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// APIAuth guards the API endpoints that change jobs or files. Whoever can reach them can stop, delete or replace
+// work, so unlike webhooks they refuse every request when no credentials are configured. The credentials are
+// checked the same way as a webhook's
+func APIAuth(creds WebhookCredentials, next http.HandlerFunc) http.HandlerFunc {
+	if !creds.Enabled() {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Warn().Str("path", r.URL.Path).Msg("Refused API call, API_PASSWORD or API_TOKEN is not set")
+			http.Error(w, "set API_PASSWORD or API_TOKEN to use this endpoint", http.StatusForbidden)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !creds.authorized(r) {
+			log.Warn().Str("path", r.URL.Path).Str("remote", r.RemoteAddr).Msg("Rejected unauthenticated API call")
+			if creds.Password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="media-web"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func apiRequest(creds WebhookCredentials, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := APIAuth(creds, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w, called
+}
+
+func TestAPIAuthRefusesWithoutCredentials(t *testing.T) {
+	w, called := apiRequest(WebhookCredentials{}, httptest.NewRequest("POST", "/api/queue/pause", nil))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, called)
+}
+
+func TestAPIAuthAcceptsBasicAuth(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/api/jobs/abc", nil)
+	req.SetBasicAuth("admin", "secret")
+
+	_, called := apiRequest(WebhookCredentials{Username: "admin", Password: "secret"}, req)
+
+	assert.True(t, called)
+}
+
+func TestAPIAuthAcceptsToken(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/scan/radarr?token=abc", nil)
+
+	_, called := apiRequest(WebhookCredentials{Token: "abc"}, req)
+
+	assert.True(t, called)
+}
+
+func TestAPIAuthRejectsWrongCredentials(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/recycle/abc/restore", nil)
+	req.SetBasicAuth("admin", "wrong")
+
+	w, called := apiRequest(WebhookCredentials{Username: "admin", Password: "secret"}, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="media-web"`, w.Header().Get("WWW-Authenticate"))
+	assert.False(t, called)
+}
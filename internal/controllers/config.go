@@ -1,16 +1,29 @@
 package controllers
 
+import (
+	"math"
+	"media-web/internal/config"
+	"media-web/internal/transcode"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
 type Config struct {
-	RadarrApiKey         string `json:"RadarrApiKey"`
-	RadarrEndpoint       string `json:"RadarrEndpoint"`
-	SonarrApiKey         string `json:"SonarrApiKey"`
-	SonarrEndpoint       string `json:"SonarrEndpoint"`
-	WorkerEnabled        bool   `json:"WorkerEnabled"`
-	RadarrScannerEnabled bool   `json:"RadarrScannerEnabled"`
-	SonarrScannerEnabled bool   `json:"SonarrScannerEnabled"`
+	RadarrApiKey         string   `json:"RadarrApiKey"`
+	RadarrEndpoint       string   `json:"RadarrEndpoint"`
+	SonarrApiKey         string   `json:"SonarrApiKey"`
+	SonarrEndpoint       string   `json:"SonarrEndpoint"`
+	RedisAddress         string   `json:"RedisAddress"`
+	WorkerEnabled        bool     `json:"WorkerEnabled"`
+	RadarrScannerEnabled bool     `json:"RadarrScannerEnabled"`
+	SonarrScannerEnabled bool     `json:"SonarrScannerEnabled"`
+	DefaultTVProfile     string   `json:"DefaultTVProfile"`
+	DefaultMovieProfile  string   `json:"DefaultMovieProfile"`
+	Profiles             []string `json:"Profiles"`
 }
 
-/*
+// SecretKey hides the second half of a key so it can be recognised without being exposed
 func SecretKey(key string) string {
 
 	keyLen := len(key)
@@ -25,37 +38,41 @@ func SecretKey(key string) string {
 	return key[0:(len(key)-amountToTrim)] + secret
 }
 
-func SecretUrl(urlString string) string {
-	u, err := url.Parse(urlString)
-	if err != nil {
-		return ""
-	}
+// SecretUrl hides any credentials in a url
+func SecretUrl(u *url.URL) string {
 	if u == nil {
 		return ""
 	}
+	masked := *u
 
-	if u.User != nil {
+	if masked.User != nil {
 
-		_, set := u.User.Password()
+		_, set := masked.User.Password()
 		if set {
-			secretUser := url.UserPassword(u.User.Username(), strings.Repeat("$", 3))
-			u.User = secretUser
-			return u.String()
+			masked.User = url.UserPassword(masked.User.Username(), strings.Repeat("$", 3))
 		} else {
-			secretUser := url.User(strings.Repeat("$", 3))
-			u.User = secretUser
-			return u.String()
+			masked.User = url.User(strings.Repeat("$", 3))
 		}
-
-
 	}
-	return u.String()
+	return masked.String()
 }
 
-func GetConfigHandler(c *gin.Context) {
+func GetConfigHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
 
 	conf := Config{
+		RadarrApiKey:         SecretKey(cfg.RadarrApiKey),
+		RadarrEndpoint:       SecretUrl(cfg.RadarrBaseEndpoint),
+		SonarrApiKey:         SecretKey(cfg.SonarrApiKey),
+		SonarrEndpoint:       SecretUrl(cfg.SonarrBaseEndpoint),
+		RedisAddress:         SecretUrl(cfg.RedisAddress),
+		WorkerEnabled:        cfg.EnableWorker,
+		RadarrScannerEnabled: cfg.EnableRadarrScanner,
+		SonarrScannerEnabled: cfg.EnableSonarrScanner,
+		DefaultTVProfile:     cfg.DefaultTVProfile,
+		DefaultMovieProfile:  cfg.DefaultMovieProfile,
+		Profiles:             transcode.GetProfiles().Names(),
 	}
 
-	c.JSON(200, conf)
-}*/
+	writeJSON(w, http.StatusOK, conf)
+}
@@ -1,9 +1,34 @@
 package controllers
 
 import (
+	"media-web/internal/worker"
 	"net/http"
+
+	"github.com/rs/zerolog/log"
 )
 
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+type Health struct {
+	// Status is degraded when Redis can't be read. The service itself is still up, so the check doesn't fail
+	Status string `json:"status"`
+	// Paused holds whether each job type is paused. It's left out when Redis can't be read
+	Paused map[string]bool `json:"paused,omitempty"`
+	// Error is why Paused couldn't be read
+	Error string `json:"error,omitempty"`
+}
+
+// GetHealthHandler reports the service is up along with the paused job queues. A paused queue is maintenance rather
+// than a fault, so it doesn't fail the check
+func GetHealthHandler(pauser worker.QueuePauser) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := Health{Status: "ok"}
+		paused, err := pauser.Paused()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to read paused job queues")
+			health.Status = "degraded"
+			health.Error = "failed to read paused job queues: " + err.Error()
+		} else {
+			health.Paused = paused
+		}
+		writeJSON(w, http.StatusOK, health)
+	}
 }
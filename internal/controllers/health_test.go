@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"media-web/internal/constants"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -12,6 +15,38 @@ func TestHealthHandler(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/health", nil)
 	w := httptest.NewRecorder()
-	HealthHandler(w, req)
+	GetHealthHandler(newMemoryPauser())(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestHealthHandlerReportsPausedQueues(t *testing.T) {
+	pauser := newMemoryPauser()
+	pauser.paused[constants.TranscodeJobType] = true
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	GetHealthHandler(pauser)(w, req)
+
+	var health Health
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&health))
+	assert.Equal(t, "ok", health.Status)
+	assert.True(t, health.Paused[constants.TranscodeJobType])
+	assert.False(t, health.Paused[constants.UpdateSonarrJobName])
+}
+
+func TestHealthHandlerStaysUpWhenRedisIsDown(t *testing.T) {
+	pauser := newMemoryPauser()
+	pauser.err = errors.New("connection refused")
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	GetHealthHandler(pauser)(w, req)
+
+	var health Health
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&health))
+	assert.Equal(t, "degraded", health.Status)
+	assert.Equal(t, "failed to read paused job queues: connection refused", health.Error)
+	assert.Nil(t, health.Paused)
+}
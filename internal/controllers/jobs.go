@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"encoding/json"
+	"media-web/internal/worker"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+var jobStatuses = []worker.JobStatus{worker.JobsQueued, worker.JobsInProgress, worker.JobsRetrying, worker.JobsScheduled, worker.JobsDead}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// GetJobsSummaryHandler returns the first page of jobs for every status
+func GetJobsSummaryHandler(inspector worker.JobInspector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary := make(map[worker.JobStatus]*worker.JobList)
+		for _, status := range jobStatuses {
+			list, err := inspector.ListJobs(status, 1)
+			if err != nil {
+				log.Err(err).Str("status", string(status)).Msg("Failed to list jobs")
+				http.Error(w, "failed to list jobs", http.StatusInternalServerError)
+				return
+			}
+			summary[status] = list
+		}
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// GetJobsHandler returns a page of jobs with the status in the path
+func GetJobsHandler(inspector worker.JobInspector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := worker.JobStatus(mux.Vars(r)["status"])
+		known := false
+		for _, s := range jobStatuses {
+			known = known || s == status
+		}
+		if !known {
+			http.Error(w, "unknown job status", http.StatusNotFound)
+			return
+		}
+
+		page := uint64(1)
+		if value := r.URL.Query().Get("page"); value != "" {
+			var err error
+			page, err = strconv.ParseUint(value, 10, 32)
+			if err != nil || page == 0 {
+				http.Error(w, "invalid page", http.StatusBadRequest)
+				return
+			}
+		}
+
+		list, err := inspector.ListJobs(status, uint(page))
+		if err != nil {
+			log.Err(err).Str("status", string(status)).Msg("Failed to list jobs")
+			http.Error(w, "failed to list jobs", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// GetRetryDeadJobHandler puts a dead job back on its queue
+func GetRetryDeadJobHandler(inspector worker.JobInspector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		diedAt, err := strconv.ParseInt(vars["diedAt"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid diedAt", http.StatusBadRequest)
+			return
+		}
+
+		err = inspector.RetryDeadJob(diedAt, vars["id"])
+		if err == worker.JobNotFoundError {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Err(err).Str("id", vars["id"]).Msg("Failed to retry dead job")
+			http.Error(w, "failed to retry job", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetDeleteQueuedJobHandler removes a job that hasn't started yet
+func GetDeleteQueuedJobHandler(inspector worker.JobInspector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		err := inspector.DeleteQueuedJob(vars["name"], vars["id"])
+		if err == worker.JobNotFoundError {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Err(err).Str("id", vars["id"]).Msg("Failed to delete queued job")
+			http.Error(w, "failed to delete job", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetCancelJobHandler cancels a queued, retrying or running job. A running job is stopped by its worker shortly after
+// the request is accepted
+func GetCancelJobHandler(inspector worker.JobInspector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		running, err := inspector.CancelJob(id)
+		if err == worker.JobNotFoundError {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Err(err).Str("id", id).Msg("Failed to cancel job")
+			http.Error(w, "failed to cancel job", http.StatusInternalServerError)
+			return
+		}
+		if running {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetJobHistoryHandler returns the most recently finished jobs
+func GetJobHistoryHandler(history worker.JobHistory) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := history.Recent()
+		if err != nil {
+			log.Err(err).Msg("Failed to read job history")
+			http.Error(w, "failed to read job history", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"media-web/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockInspector struct {
+	listJobs         func(status worker.JobStatus, page uint) (*worker.JobList, error)
+	retryDeadJob     func(diedAt int64, id string) error
+	deleteQueuedJob  func(name string, id string) error
+	cancelJobs       func(match func(job *work.Job) bool) ([]*work.Job, error)
+	cancelJob        func(id string) (bool, error)
+	updateQueuedJobs func(update func(job *work.Job) bool) (int, error)
+	countPendingJobs func(match func(job *work.Job) bool) (int, error)
+}
+
+func (m mockInspector) ListJobs(status worker.JobStatus, page uint) (*worker.JobList, error) {
+	return m.listJobs(status, page)
+}
+
+func (m mockInspector) RetryDeadJob(diedAt int64, id string) error {
+	return m.retryDeadJob(diedAt, id)
+}
+
+func (m mockInspector) DeleteQueuedJob(name string, id string) error {
+	return m.deleteQueuedJob(name, id)
+}
+
+func (m mockInspector) CancelJobs(match func(job *work.Job) bool) ([]*work.Job, error) {
+	return m.cancelJobs(match)
+}
+
+func (m mockInspector) CancelJob(id string) (bool, error) {
+	return m.cancelJob(id)
+}
+
+func (m mockInspector) UpdateQueuedJobs(update func(job *work.Job) bool) (int, error) {
+	return m.updateQueuedJobs(update)
+}
+
+func (m mockInspector) CountPendingJobs(match func(job *work.Job) bool) (int, error) {
+	return m.countPendingJobs(match)
+}
+
+func jobsRouter(inspector worker.JobInspector) *mux.Router {
+	ro := mux.NewRouter()
+	ro.HandleFunc("/api/jobs", GetJobsSummaryHandler(inspector)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/jobs/{status}", GetJobsHandler(inspector)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/jobs/dead/{diedAt}/{id}/retry", GetRetryDeadJobHandler(inspector)).Methods(http.MethodPost)
+	ro.HandleFunc("/api/jobs/queued/{name}/{id}", GetDeleteQueuedJobHandler(inspector)).Methods(http.MethodDelete)
+	ro.HandleFunc("/api/jobs/{id}", GetCancelJobHandler(inspector)).Methods(http.MethodDelete)
+	return ro
+}
+
+func serve(ro *mux.Router, method string, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ro.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestJobsSummaryListsEveryStatus(t *testing.T) {
+	inspector := mockInspector{listJobs: func(status worker.JobStatus, page uint) (*worker.JobList, error) {
+		return &worker.JobList{Status: status, Page: page, Jobs: []worker.JobInfo{{ID: string(status)}}}, nil
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodGet, "/api/jobs")
+
+	summary := make(map[worker.JobStatus]worker.JobList)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&summary))
+	assert.Len(t, summary, 5)
+	assert.Equal(t, "in_progress", summary[worker.JobsInProgress].Jobs[0].ID)
+}
+
+func TestJobsListsPage(t *testing.T) {
+	inspector := mockInspector{listJobs: func(status worker.JobStatus, page uint) (*worker.JobList, error) {
+		assert.Equal(t, worker.JobsDead, status)
+		assert.EqualValues(t, 2, page)
+		return &worker.JobList{Status: status, Page: page, Jobs: []worker.JobInfo{{ID: "1", LastErr: "boom"}}}, nil
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodGet, "/api/jobs/dead?page=2")
+
+	var list worker.JobList
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, "boom", list.Jobs[0].LastErr)
+}
+
+func TestJobsRejectsUnknownStatus(t *testing.T) {
+	w := serve(jobsRouter(mockInspector{}), http.MethodGet, "/api/jobs/finished")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestJobsRejectsInvalidPage(t *testing.T) {
+	w := serve(jobsRouter(mockInspector{}), http.MethodGet, "/api/jobs/queued?page=0")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRetryDeadJob(t *testing.T) {
+	inspector := mockInspector{retryDeadJob: func(diedAt int64, id string) error {
+		assert.EqualValues(t, 1600000000, diedAt)
+		assert.Equal(t, "abc", id)
+		return nil
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodPost, "/api/jobs/dead/1600000000/abc/retry")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestRetryMissingDeadJob(t *testing.T) {
+	inspector := mockInspector{retryDeadJob: func(diedAt int64, id string) error {
+		return worker.JobNotFoundError
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodPost, "/api/jobs/dead/1/abc/retry")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeleteQueuedJob(t *testing.T) {
+	inspector := mockInspector{deleteQueuedJob: func(name string, id string) error {
+		assert.Equal(t, "transcode", name)
+		assert.Equal(t, "abc", id)
+		return nil
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodDelete, "/api/jobs/queued/transcode/abc")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestDeleteQueuedJobError(t *testing.T) {
+	inspector := mockInspector{deleteQueuedJob: func(name string, id string) error {
+		return errors.New("redis down")
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodDelete, "/api/jobs/queued/transcode/abc")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	inspector := mockInspector{cancelJob: func(id string) (bool, error) {
+		assert.Equal(t, "abc", id)
+		return false, nil
+	}}
+
+	w := serve(jobsRouter(inspector), http.MethodDelete, "/api/jobs/abc")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	inspector := mockInspector{cancelJob: func(id string) (bool, error) { return true, nil }}
+
+	w := serve(jobsRouter(inspector), http.MethodDelete, "/api/jobs/abc")
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestCancelMissingJob(t *testing.T) {
+	inspector := mockInspector{cancelJob: func(id string) (bool, error) { return false, worker.JobNotFoundError }}
+
+	w := serve(jobsRouter(inspector), http.MethodDelete, "/api/jobs/abc")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+	"media-web/internal/worker"
+	"net/http"
+
+	"github.com/gocraft/work"
+	"github.com/rs/zerolog/log"
+)
+
+func GetLidarrWebhookHandler(scheduler worker.WorkScheduler, opts WebhookOptions) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body web.LidarrWebhook
+		err := json.NewDecoder(r.Body).Decode(&body)
+
+		if err != nil {
+			log.Err(err).Msg("Failed to bind json")
+			http.Error(w, "invalid json input", http.StatusBadRequest)
+			return
+		}
+
+		switch body.EventType {
+		case "Test":
+			log.Info().Msg("Got Test request from Lidarr")
+		case "Download":
+			log.Info().Msg("Got Download request")
+			if body.IsUpgrade && opts.UpgradePolicy == config.UpgradeSkip {
+				log.Info().Int("artistId", body.Artist.ID).Msg("Skipping upgraded tracks")
+				break
+			}
+			// The artist lets the rescan after each transcode wait until the rest of the album is done
+			for _, file := range body.TrackFiles {
+				job, err := scheduler.EnqueueUnique(constants.TranscodeJobType, work.Q{
+					constants.TrackFileIdKey:   file.ID,
+					constants.ArtistIdKey:      body.Artist.ID,
+					constants.TranscodeTypeKey: constants.Music,
+				})
+
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to enqueue work")
+					http.Error(w, "failed to enqueue work", http.StatusInternalServerError)
+					return
+				}
+
+				if job != nil {
+					log.Info().Msgf("Enqueued job: %s", job.ID)
+				}
+			}
+		default:
+			log.Debug().Msg("Ignoring Lidarr event: " + body.EventType)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&body)
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func lidarrDownload() web.LidarrWebhook {
+	body := web.LidarrWebhook{EventType: "Download", TrackFiles: []web.LidarrWebhookTrackFile{{ID: 7}, {ID: 8}}}
+	body.Artist.ID = 4
+	return body
+}
+
+func TestLidarrReturnsErrorForBadPayload(t *testing.T) {
+	m := mockWorker{}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/lidarr/webhook", bytes.NewBufferString("Not valid json"))
+	w := httptest.NewRecorder()
+	GetLidarrWebhookHandler(&m, WebhookOptions{})(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestLidarrEnqueuesEveryTrackFile(t *testing.T) {
+	m := mockWorker{}
+	for _, id := range []int{7, 8} {
+		m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+			constants.TrackFileIdKey:   id,
+			constants.ArtistIdKey:      4,
+			constants.TranscodeTypeKey: constants.Music,
+		}).Once().Return(&work.Job{ID: "foo"}, nil)
+	}
+
+	w := postWebhook(t, GetLidarrWebhookHandler(&m, WebhookOptions{}), lidarrDownload())
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestLidarrSkipsUpgrades(t *testing.T) {
+	m := mockWorker{}
+	body := lidarrDownload()
+	body.IsUpgrade = true
+
+	w := postWebhook(t, GetLidarrWebhookHandler(&m, WebhookOptions{UpgradePolicy: config.UpgradeSkip}), body)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestLidarrReturnsErrorForFailedEnqueue(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", mock.Anything, mock.Anything).Return(nil, errors.New("boom"))
+
+	w := postWebhook(t, GetLidarrWebhookHandler(&m, WebhookOptions{}), lidarrDownload())
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestLidarrTestEventEnqueuesNothing(t *testing.T) {
+	m := mockWorker{}
+
+	w := postWebhook(t, GetLidarrWebhookHandler(&m, WebhookOptions{}), web.LidarrWebhook{EventType: "Test"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
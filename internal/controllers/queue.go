@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"media-web/internal/worker"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GetQueueHandler returns whether each job type is paused
+func GetQueueHandler(pauser worker.QueuePauser) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paused, err := pauser.Paused()
+		if err != nil {
+			log.Err(err).Msg("Failed to read paused job queues")
+			http.Error(w, "failed to read paused job queues", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, paused)
+	}
+}
+
+// GetPauseQueueHandler stops workers picking up the job type in the jobType query parameter, or every job type
+// when it's left out
+func GetPauseQueueHandler(pauser worker.QueuePauser) func(w http.ResponseWriter, r *http.Request) {
+	return queueChangeHandler(pauser, pauser.Pause)
+}
+
+// GetResumeQueueHandler lets workers pick up the job type in the jobType query parameter again, or every job type
+// when it's left out
+func GetResumeQueueHandler(pauser worker.QueuePauser) func(w http.ResponseWriter, r *http.Request) {
+	return queueChangeHandler(pauser, pauser.Resume)
+}
+
+func queueChangeHandler(pauser worker.QueuePauser, change func(jobType string) error) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobTypes := worker.PausableJobTypes
+		if jobType := r.URL.Query().Get("jobType"); jobType != "" {
+			jobTypes = []string{jobType}
+		}
+		for _, jobType := range jobTypes {
+			err := change(jobType)
+			if err == worker.UnknownJobTypeError {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Err(err).Str("jobType", jobType).Msg("Failed to change job queue pause")
+				http.Error(w, "failed to change job queue pause", http.StatusInternalServerError)
+				return
+			}
+		}
+		GetQueueHandler(pauser)(w, r)
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"media-web/internal/constants"
+	"media-web/internal/worker"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryPauser struct {
+	paused map[string]bool
+	err    error
+}
+
+func newMemoryPauser() *memoryPauser {
+	return &memoryPauser{paused: map[string]bool{}}
+}
+
+func (m *memoryPauser) Pause(jobType string) error {
+	return m.set(jobType, true)
+}
+
+func (m *memoryPauser) Resume(jobType string) error {
+	return m.set(jobType, false)
+}
+
+func (m *memoryPauser) set(jobType string, paused bool) error {
+	if m.err != nil {
+		return m.err
+	}
+	known := false
+	for _, t := range worker.PausableJobTypes {
+		known = known || t == jobType
+	}
+	if !known {
+		return worker.UnknownJobTypeError
+	}
+	m.paused[jobType] = paused
+	return nil
+}
+
+func (m *memoryPauser) Paused() (map[string]bool, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	paused := map[string]bool{}
+	for _, jobType := range worker.PausableJobTypes {
+		paused[jobType] = m.paused[jobType]
+	}
+	return paused, nil
+}
+
+func queueRouter(pauser worker.QueuePauser) *mux.Router {
+	ro := mux.NewRouter()
+	ro.HandleFunc("/api/queue", GetQueueHandler(pauser)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/queue/pause", GetPauseQueueHandler(pauser)).Methods(http.MethodPost)
+	ro.HandleFunc("/api/queue/resume", GetResumeQueueHandler(pauser)).Methods(http.MethodPost)
+	return ro
+}
+
+func TestPauseQueuePausesJobType(t *testing.T) {
+	pauser := newMemoryPauser()
+
+	w := serve(queueRouter(pauser), http.MethodPost, "/api/queue/pause?jobType=transcode-job")
+
+	paused := map[string]bool{}
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&paused))
+	assert.True(t, paused[constants.TranscodeJobType])
+	assert.False(t, paused[constants.UpdateSonarrJobName])
+}
+
+func TestPauseQueueWithoutJobTypePausesEverything(t *testing.T) {
+	pauser := newMemoryPauser()
+
+	w := serve(queueRouter(pauser), http.MethodPost, "/api/queue/pause")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, jobType := range worker.PausableJobTypes {
+		assert.True(t, pauser.paused[jobType], jobType)
+	}
+}
+
+func TestResumeQueueResumesJobType(t *testing.T) {
+	pauser := newMemoryPauser()
+	pauser.paused[constants.UpdateRadarrJobName] = true
+	pauser.paused[constants.TranscodeJobType] = true
+
+	w := serve(queueRouter(pauser), http.MethodPost, "/api/queue/resume?jobType=update-radarr")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, pauser.paused[constants.UpdateRadarrJobName])
+	assert.True(t, pauser.paused[constants.TranscodeJobType])
+}
+
+func TestPauseQueueRejectsUnknownJobType(t *testing.T) {
+	w := serve(queueRouter(newMemoryPauser()), http.MethodPost, "/api/queue/pause?jobType=probe")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPauseQueueFailsWhenRedisDoes(t *testing.T) {
+	pauser := newMemoryPauser()
+	pauser.err = errors.New("connection refused")
+
+	w := serve(queueRouter(pauser), http.MethodPost, "/api/queue/pause")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"media-web/internal/config"
 	"media-web/internal/constants"
 	"media-web/internal/web"
 	"media-web/internal/worker"
@@ -11,7 +12,7 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func GetRadarrWebhookHandler(scheduler worker.WorkScheduler) func(w http.ResponseWriter, r *http.Request) {
+func GetRadarrWebhookHandler(scheduler worker.WorkScheduler, opts WebhookOptions) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var body web.RadarrWebhook
 		err := json.NewDecoder(r.Body).Decode(&body)
@@ -23,12 +24,28 @@ func GetRadarrWebhookHandler(scheduler worker.WorkScheduler) func(w http.Respons
 		}
 
 		switch body.EventType {
+		case "Test":
+			log.Info().Msg("Got Test request from Radarr")
 		case "Download":
 			log.Info().Msg("Got Download request")
-			job, err := scheduler.EnqueueUnique(constants.TranscodeJobType, work.Q{
+			if body.IsUpgrade && opts.UpgradePolicy == config.UpgradeSkip {
+				log.Info().Int("movieId", body.Movie.ID).Msg("Skipping upgraded movie")
+				break
+			}
+			allowed, err := opts.MovieFilter.AllowsID(body.Movie.ID)
+			if err != nil {
+				log.Error().Err(err).Int("movieId", body.Movie.ID).Msg("Failed to apply filter rules")
+				http.Error(w, "failed to apply filter rules", http.StatusBadGateway)
+				return
+			}
+			if !allowed {
+				log.Info().Int("movieId", body.Movie.ID).Msg("Skipping movie excluded by the filter rules")
+				break
+			}
+			job, err := scheduler.EnqueueUnique(constants.TranscodeJobType, worker.SetInstance(work.Q{
 				constants.MovieIdKey:       body.Movie.ID,
 				constants.TranscodeTypeKey: constants.Movie,
-			})
+			}, opts.Instance))
 
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to enqueue work")
@@ -36,8 +53,29 @@ func GetRadarrWebhookHandler(scheduler worker.WorkScheduler) func(w http.Respons
 				return
 			}
 
-			log.Info().Msgf("Enqueued job: %s", job.ID)
-			break
+			if job != nil {
+				log.Info().Msgf("Enqueued job: %s", job.ID)
+			}
+		case "Rename":
+			err = renameQueuedPaths(opts.Inspector, opts.PathMapper, body.RenamedMovieFiles)
+			if err == nil && len(body.RenamedMovieFiles) > 0 {
+				err = requeueRenamedTranscodes(opts.Inspector, scheduler, opts.Instance, constants.Movie, constants.MovieIdKey,
+					[]int64{int64(body.Movie.ID)})
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to update queued jobs for renamed files")
+				http.Error(w, "failed to update queued jobs", http.StatusInternalServerError)
+				return
+			}
+		case "MovieFileDelete", "MovieDelete":
+			err = cancelTranscodes(opts.Inspector, opts.Instance, constants.Movie, constants.MovieIdKey, int64(body.Movie.ID))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to cancel transcodes for deleted movie")
+				http.Error(w, "failed to cancel queued jobs", http.StatusInternalServerError)
+				return
+			}
+		default:
+			log.Debug().Msg("Ignoring Radarr event: " + body.EventType)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
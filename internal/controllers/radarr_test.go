@@ -20,7 +20,7 @@ type mockWorker struct {
 	mock.Mock
 }
 
-func (m mockWorker) EnqueueUnique(jobName string, args map[string]interface{}) (*work.Job, error) {
+func (m *mockWorker) EnqueueUnique(jobName string, args map[string]interface{}) (*work.Job, error) {
 	resp := m.Called(jobName, args)
 
 	arg := resp.Get(0)
@@ -34,6 +34,14 @@ func (m mockWorker) EnqueueUnique(jobName string, args map[string]interface{}) (
 	return nil, resp.Error(1)
 }
 
+func (m *mockWorker) EnqueueUniqueIn(jobName string, secondsFromNow int64, args map[string]interface{}) (*work.ScheduledJob, error) {
+	resp := m.Called(jobName, secondsFromNow, args)
+
+	job, _ := resp.Get(0).(*work.ScheduledJob)
+
+	return job, resp.Error(1)
+}
+
 func TestReturnsErrorForBadPayload(t *testing.T) {
 
 	m := mockWorker{}
@@ -42,7 +50,7 @@ func TestReturnsErrorForBadPayload(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "/api/radarr/webhook", body)
 	w := httptest.NewRecorder()
-	GetRadarrWebhookHandler(m)(w, req)
+	GetRadarrWebhookHandler(&m, WebhookOptions{})(w, req)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	m.AssertExpectations(t)
@@ -62,7 +70,7 @@ func TestReturnsErrorForFailedEnqueue(t *testing.T) {
 	m.On("EnqueueUnique", mock.Anything, mock.Anything).Return(&work.Job{}, errors.New("boom"))
 	req, _ := http.NewRequest("POST", "/api/radarr/webhook", bytes.NewBuffer(payload))
 	w := httptest.NewRecorder()
-	GetRadarrWebhookHandler(m)(w, req)
+	GetRadarrWebhookHandler(&m, WebhookOptions{})(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	m.AssertExpectations(t)
@@ -85,7 +93,7 @@ func TestEnqueuesJobForValidInput(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "/api/radarr/webhook", bytes.NewBuffer(payload))
 	w := httptest.NewRecorder()
-	GetRadarrWebhookHandler(m)(w, req)
+	GetRadarrWebhookHandler(&m, WebhookOptions{})(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	_, err = ioutil.ReadAll(w.Body)
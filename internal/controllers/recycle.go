@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"encoding/json"
+	"media-web/internal/recycle"
+	"media-web/internal/worker"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+func GetRecycleListHandler(bin recycle.Bin) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := bin.List()
+		if err != nil {
+			log.Err(err).Msg("Failed to list recycled files")
+			http.Error(w, "failed to list recycled files", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// GetRecycleRestoreHandler puts a recycled original back and asks Sonarr or Radarr to rescan so it is picked up again
+func GetRecycleRestoreHandler(bin recycle.Bin, scheduler worker.WorkScheduler) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		entry, err := bin.Restore(id)
+
+		if err == recycle.NotFoundError {
+			http.Error(w, "recycle entry not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Err(err).Str("id", id).Msg("Failed to restore recycled file")
+			http.Error(w, "failed to restore file", http.StatusInternalServerError)
+			return
+		}
+
+		err = worker.EnqueueRescan(scheduler, *entry, "")
+		if err != nil {
+			log.Err(err).Msg("Failed to enqueue rescan after restore")
+			http.Error(w, "restored file but failed to enqueue rescan", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(entry)
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"encoding/json"
+	"media-web/internal/constants"
+	"media-web/internal/recycle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockBin struct {
+	entries []recycle.Entry
+	restore func(id string) (*recycle.Entry, error)
+}
+
+func (m mockBin) Recycle(path string, entry recycle.Entry) (*recycle.Entry, error) {
+	return &entry, nil
+}
+
+func (m mockBin) List() ([]recycle.Entry, error) {
+	return m.entries, nil
+}
+
+func (m mockBin) Restore(id string) (*recycle.Entry, error) {
+	return m.restore(id)
+}
+
+func (m mockBin) Purge(now time.Time) (int, error) {
+	return 0, nil
+}
+
+func restoreRequest(bin recycle.Bin, m *mockWorker, id string) *httptest.ResponseRecorder {
+	ro := mux.NewRouter()
+	ro.HandleFunc("/api/recycle/{id}/restore", GetRecycleRestoreHandler(bin, m))
+	req := httptest.NewRequest("POST", "/api/recycle/"+id+"/restore", nil)
+	w := httptest.NewRecorder()
+	ro.ServeHTTP(w, req)
+	return w
+}
+
+func TestRecycleListReturnsEntries(t *testing.T) {
+	bin := mockBin{entries: []recycle.Entry{{ID: "1", OriginalPath: "/movies/a.mkv"}}}
+
+	req := httptest.NewRequest("GET", "/api/recycle", nil)
+	w := httptest.NewRecorder()
+	GetRecycleListHandler(bin)(w, req)
+
+	var entries []recycle.Entry
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+	assert.Equal(t, "/movies/a.mkv", entries[0].OriginalPath)
+}
+
+func TestRecycleRestoreEnqueuesRescan(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.UpdateRadarrJobName, map[string]interface{}{constants.MovieIdKey: int64(7)}).
+		Return(&work.Job{ID: "update"}, nil)
+	bin := mockBin{restore: func(id string) (*recycle.Entry, error) {
+		return &recycle.Entry{ID: id, TranscodeType: constants.Movie, MovieID: 7}, nil
+	}}
+
+	w := restoreRequest(bin, &m, "1")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestRecycleRestoreUnknownEntry(t *testing.T) {
+	m := mockWorker{}
+	bin := mockBin{restore: func(id string) (*recycle.Entry, error) {
+		return nil, recycle.NotFoundError
+	}}
+
+	w := restoreRequest(bin, &m, "missing")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	m.AssertExpectations(t)
+}
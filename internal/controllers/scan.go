@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"media-web/internal/worker"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetScanStatusHandler returns the schedule and last run of every scanner
+func GetScanStatusHandler(runners worker.ScanRunners) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, runners.Statuses())
+	}
+}
+
+// GetScanHandler starts the radarr or sonarr scanner in the path right away. ?instance= picks a named instance and
+// ?full=true checks every file rather than only new or changed ones
+func GetScanHandler(runners worker.ScanRunners) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		runner := runners.Find(mux.Vars(r)["scanner"], query.Get("instance"))
+		if runner == nil {
+			http.Error(w, "unknown scanner", http.StatusNotFound)
+			return
+		}
+
+		full := false
+		if value := query.Get("full"); value != "" {
+			var err error
+			if full, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, "invalid full", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := runner.Start(full); err == worker.ErrScanRunning {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, runner.Status())
+	}
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/worker"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func scanRouter(runners worker.ScanRunners) *mux.Router {
+	ro := mux.NewRouter()
+	ro.HandleFunc("/api/scan", GetScanStatusHandler(runners)).Methods(http.MethodGet)
+	ro.HandleFunc("/api/scan/{scanner}", GetScanHandler(runners)).Methods(http.MethodPost)
+	return ro
+}
+
+func TestScanStartsScanner(t *testing.T) {
+	scanned := make(chan string, 2)
+	runners := worker.ScanRunners{
+		worker.NewScanRunner(worker.RadarrScanner, config.Instance{Name: config.DefaultInstance}, "", func(full bool) (worker.ScanSummary, error) {
+			scanned <- "default"
+			return worker.ScanSummary{}, nil
+		}),
+		worker.NewScanRunner(worker.RadarrScanner, config.Instance{Name: "uhd"}, "", func(full bool) (worker.ScanSummary, error) {
+			scanned <- "uhd"
+			return worker.ScanSummary{}, nil
+		}),
+	}
+	ro := scanRouter(runners)
+
+	w := serve(ro, http.MethodPost, "/api/scan/radarr?instance=uhd")
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	select {
+	case instance := <-scanned:
+		assert.Equal(t, "uhd", instance)
+	case <-time.After(time.Second):
+		t.Fatal("scan did not start")
+	}
+	assert.Eventually(t, func() bool { return runners[1].Status().Result == "success" }, time.Second, time.Millisecond)
+
+	w = serve(ro, http.MethodGet, "/api/scan")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var statuses []worker.ScanStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &statuses))
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, "", statuses[0].Result)
+	assert.Equal(t, "success", statuses[1].Result)
+}
+
+func TestScanRunsFullScan(t *testing.T) {
+	scanned := make(chan bool, 1)
+	runners := worker.ScanRunners{
+		worker.NewScanRunner(worker.RadarrScanner, config.Instance{Name: config.DefaultInstance}, "", func(full bool) (worker.ScanSummary, error) {
+			scanned <- full
+			return worker.ScanSummary{}, nil
+		}),
+	}
+	ro := scanRouter(runners)
+
+	assert.Equal(t, http.StatusBadRequest, serve(ro, http.MethodPost, "/api/scan/radarr?full=maybe").Code)
+	assert.Equal(t, http.StatusAccepted, serve(ro, http.MethodPost, "/api/scan/radarr?full=true").Code)
+	assert.True(t, <-scanned)
+}
+
+func TestScanRejectsOverlappingScan(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	runners := worker.ScanRunners{
+		worker.NewScanRunner(worker.SonarrScanner, config.Instance{Name: config.DefaultInstance}, "", func(full bool) (worker.ScanSummary, error) {
+			<-release
+			return worker.ScanSummary{}, nil
+		}),
+	}
+	ro := scanRouter(runners)
+
+	assert.Equal(t, http.StatusAccepted, serve(ro, http.MethodPost, "/api/scan/sonarr").Code)
+	assert.Equal(t, http.StatusConflict, serve(ro, http.MethodPost, "/api/scan/sonarr").Code)
+}
+
+func TestScanUnknownScanner(t *testing.T) {
+	runners := worker.ScanRunners{
+		worker.NewScanRunner(worker.SonarrScanner, config.Instance{Name: config.DefaultInstance}, "", nil),
+	}
+	ro := scanRouter(runners)
+
+	assert.Equal(t, http.StatusNotFound, serve(ro, http.MethodPost, "/api/scan/radarr").Code)
+	assert.Equal(t, http.StatusNotFound, serve(ro, http.MethodPost, "/api/scan/sonarr?instance=anime").Code)
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"media-web/internal/config"
 	"media-web/internal/constants"
 	"media-web/internal/web"
 	"media-web/internal/worker"
@@ -11,7 +12,7 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func GetSonarrWebhookHandler(scheduler worker.WorkScheduler) func(w http.ResponseWriter, r *http.Request) {
+func GetSonarrWebhookHandler(scheduler worker.WorkScheduler, opts WebhookOptions) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var body web.SonarrWebhook
 		err := json.NewDecoder(r.Body).Decode(&body)
@@ -23,21 +24,64 @@ func GetSonarrWebhookHandler(scheduler worker.WorkScheduler) func(w http.Respons
 		}
 
 		switch body.EventType {
+		case "Test":
+			log.Info().Msg("Got Test request from Sonarr")
 		case "Download":
 			log.Info().Msg("Got Download request")
-			job, err := scheduler.EnqueueUnique(constants.TranscodeJobType, work.Q{
-				constants.EpisodeFileIdKey: body.EpisodeFile.ID,
-				constants.TranscodeTypeKey: constants.TV,
-			})
-
+			if body.IsUpgrade && opts.UpgradePolicy == config.UpgradeSkip {
+				log.Info().Int("seriesId", body.Series.ID).Msg("Skipping upgraded episodes")
+				break
+			}
+			files, err := opts.SeriesFilter.AllowedFiles(body.Series.ID, body.ImportedFiles())
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to enqueue work")
-				http.Error(w, "failed to enqueue work", http.StatusInternalServerError)
+				log.Error().Err(err).Int("seriesId", body.Series.ID).Msg("Failed to apply filter rules")
+				http.Error(w, "failed to apply filter rules", http.StatusBadGateway)
 				return
 			}
+			if skipped := len(body.ImportedFiles()) - len(files); skipped > 0 {
+				log.Info().Int("seriesId", body.Series.ID).Int("skipped", skipped).Msg("Skipping episode files excluded by the filter rules")
+			}
+			// The series lets the rescan after each transcode wait until the rest of a season pack is done
+			for _, file := range files {
+				job, err := scheduler.EnqueueUnique(constants.TranscodeJobType, worker.SetInstance(work.Q{
+					constants.EpisodeFileIdKey: file.ID,
+					constants.SeriesIdKey:      body.Series.ID,
+					constants.TranscodeTypeKey: constants.TV,
+				}, opts.Instance))
 
-			log.Info().Msgf("Enqueued job: %s", job.ID)
-			break
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to enqueue work")
+					http.Error(w, "failed to enqueue work", http.StatusInternalServerError)
+					return
+				}
+
+				if job != nil {
+					log.Info().Msgf("Enqueued job: %s", job.ID)
+				}
+			}
+		case "Rename":
+			err = renameQueuedPaths(opts.Inspector, opts.PathMapper, body.RenamedEpisodeFiles)
+			if err == nil {
+				ids := make([]int64, 0, len(body.RenamedEpisodeFiles))
+				for _, file := range body.RenamedEpisodeFiles {
+					ids = append(ids, int64(file.ID))
+				}
+				err = requeueRenamedTranscodes(opts.Inspector, scheduler, opts.Instance, constants.TV, constants.EpisodeFileIdKey, ids)
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to update queued jobs for renamed files")
+				http.Error(w, "failed to update queued jobs", http.StatusInternalServerError)
+				return
+			}
+		case "EpisodeFileDelete":
+			err = cancelTranscodes(opts.Inspector, opts.Instance, constants.TV, constants.EpisodeFileIdKey, int64(body.EpisodeFile.ID))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to cancel transcodes for deleted episode file")
+				http.Error(w, "failed to cancel queued jobs", http.StatusInternalServerError)
+				return
+			}
+		default:
+			log.Debug().Msg("Ignoring Sonarr event: " + body.EventType)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(&body)
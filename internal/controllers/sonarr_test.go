@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"media-web/internal/config"
 	"media-web/internal/constants"
+	"media-web/internal/filter"
 	"media-web/internal/web"
+	"media-web/internal/worker"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -25,7 +28,7 @@ func TestSonarrReturnsErrorForBadPayload(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "/api/sonarr/webhook", body)
 	w := httptest.NewRecorder()
-	GetSonarrWebhookHandler(m)(w, req)
+	GetSonarrWebhookHandler(&m, WebhookOptions{})(w, req)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	m.AssertExpectations(t)
@@ -36,6 +39,7 @@ func TestSonarrReturnsErrorForFailedEnqueue(t *testing.T) {
 	m := mockWorker{}
 
 	body := web.SonarrWebhook{EventType: "Download"}
+	body.EpisodeFile.ID = 1
 
 	payload, err := json.Marshal(body)
 
@@ -46,7 +50,7 @@ func TestSonarrReturnsErrorForFailedEnqueue(t *testing.T) {
 	m.On("EnqueueUnique", constants.TranscodeJobType, mock.Anything).Return(&work.Job{}, errors.New("boom"))
 	req, _ := http.NewRequest("POST", "/api/sonarr/webhook", bytes.NewBuffer(payload))
 	w := httptest.NewRecorder()
-	GetSonarrWebhookHandler(m)(w, req)
+	GetSonarrWebhookHandler(&m, WebhookOptions{})(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	m.AssertExpectations(t)
@@ -57,6 +61,7 @@ func TestSonarrEnqueuesJobForValidInput(t *testing.T) {
 	m := mockWorker{}
 
 	body := web.SonarrWebhook{EventType: "Download"}
+	body.EpisodeFile.ID = 1
 
 	payload, err := json.Marshal(body)
 
@@ -67,10 +72,94 @@ func TestSonarrEnqueuesJobForValidInput(t *testing.T) {
 	m.On("EnqueueUnique", constants.TranscodeJobType, mock.Anything).Return(&work.Job{ID: "blah"}, nil)
 	req := httptest.NewRequest("POST", "/api/sonarr/webhook", bytes.NewBuffer(payload))
 	w := httptest.NewRecorder()
-	GetSonarrWebhookHandler(m)(w, req)
+	GetSonarrWebhookHandler(&m, WebhookOptions{})(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	_, err = ioutil.ReadAll(w.Body)
 	assert.NoError(t, err)
 	m.AssertExpectations(t)
 }
+
+func TestSonarrEnqueuesEveryFileOfSeasonPack(t *testing.T) {
+
+	m := mockWorker{}
+
+	body := web.SonarrWebhook{EventType: "Download", EpisodeFiles: []web.SonarrWebhookEpisodeFile{{ID: 1}, {ID: 2}}}
+	body.Series.ID = 5
+
+	payload, err := json.Marshal(body)
+
+	if err != nil {
+		t.Error("Failed to encode json")
+	}
+
+	for _, id := range []int{1, 2} {
+		m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+			constants.EpisodeFileIdKey: id,
+			constants.SeriesIdKey:      5,
+			constants.TranscodeTypeKey: constants.TV,
+		}).Once().Return(&work.Job{ID: "blah"}, nil)
+	}
+	req := httptest.NewRequest("POST", "/api/sonarr/webhook", bytes.NewBuffer(payload))
+	w := httptest.NewRecorder()
+	GetSonarrWebhookHandler(&m, WebhookOptions{})(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestSonarrSkipsFilesExcludedByFilterRules(t *testing.T) {
+
+	m := mockWorker{}
+
+	body := web.SonarrWebhook{EventType: "Download", EpisodeFiles: []web.SonarrWebhookEpisodeFile{
+		{ID: 1, Path: "/tv/4k/Show/S01E01.mkv"},
+		{ID: 2, Path: "/tv/hd/Show/S01E02.mkv"},
+	}}
+	body.Series.ID = 5
+
+	payload, err := json.Marshal(body)
+
+	if err != nil {
+		t.Error("Failed to encode json")
+	}
+
+	m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.EpisodeFileIdKey: 2,
+		constants.SeriesIdKey:      5,
+		constants.TranscodeTypeKey: constants.TV,
+	}).Once().Return(&work.Job{ID: "blah"}, nil)
+	sonarr := mockSonarr{lookupSeries: func(id int) (*web.Series, error) { return &web.Series{ID: id}, nil }}
+	rules := filter.Rules{Exclude: []filter.Condition{{Paths: []string{"/tv/4k"}}}}
+	opts := WebhookOptions{SeriesFilter: worker.NewSeriesFilter(rules, config.DefaultInstance, sonarr)}
+	req := httptest.NewRequest("POST", "/api/sonarr/webhook", bytes.NewBuffer(payload))
+	w := httptest.NewRecorder()
+	GetSonarrWebhookHandler(&m, opts)(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestSonarrReturnsErrorWhenFilterRulesFail(t *testing.T) {
+
+	m := mockWorker{}
+
+	body := web.SonarrWebhook{EventType: "Download"}
+	body.EpisodeFile.ID = 1
+
+	payload, err := json.Marshal(body)
+
+	if err != nil {
+		t.Error("Failed to encode json")
+	}
+
+	sonarr := mockSonarr{lookupSeries: func(id int) (*web.Series, error) { return nil, errors.New("sonarr is down") }}
+	rules := filter.Rules{Exclude: []filter.Condition{{Paths: []string{"/tv/4k"}}}}
+	opts := WebhookOptions{SeriesFilter: worker.NewSeriesFilter(rules, config.DefaultInstance, sonarr)}
+	req := httptest.NewRequest("POST", "/api/sonarr/webhook", bytes.NewBuffer(payload))
+	w := httptest.NewRecorder()
+	GetSonarrWebhookHandler(&m, opts)(w, req)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/transcode"
+	"media-web/internal/utils"
+	"media-web/internal/web"
+	"media-web/internal/worker"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gocraft/work"
+	"github.com/rs/zerolog/log"
+)
+
+// TranscodeRequest asks for a Radarr movie, a Sonarr episode file, every episode file of a Sonarr series or a
+// local file that no *arr manages to be transcoded
+type TranscodeRequest struct {
+	MovieID       int64  `json:"movieId"`
+	EpisodeFileID int64  `json:"episodeFileId"`
+	SeriesID      int64  `json:"seriesId"`
+	Path          string `json:"path"`
+	Profile       string `json:"profile"`
+	// Priority is "normal" or "high". High priority jobs go on their own queue, which workers sample more often than
+	// the regular one
+	Priority string `json:"priority"`
+	// Instance is the Sonarr or Radarr instance the movie, episode file or series belongs to. Defaults to "default"
+	Instance string `json:"instance"`
+}
+
+type TranscodeResponse struct {
+	JobIDs []string `json:"jobIds"`
+	// Duplicates is the number of transcodes that were already queued
+	Duplicates int `json:"duplicates"`
+}
+
+// validatePath only allows absolute paths inside roots. The worker replaces the file, so anything else could be used
+// to rewrite or delete files elsewhere
+func validatePath(path string, roots []string) error {
+	if !filepath.IsAbs(path) {
+		return errors.New("path must be absolute")
+	}
+	for _, element := range strings.Split(filepath.ToSlash(path), "/") {
+		if element == ".." {
+			return errors.New("path must not contain ..")
+		}
+		// Without SCRATCH_DIR unfinished outputs and chunks are written next to the library files
+		if strings.HasSuffix(element, ".partial") || strings.HasSuffix(element, ".chunks") {
+			return errors.New("path must not be an unfinished transcode")
+		}
+	}
+	for _, root := range roots {
+		if utils.IsUnder(path, root) {
+			return nil
+		}
+	}
+	return errors.New("path must be inside MEDIA_ROOTS, a path mapping or a watch folder")
+}
+
+func (t TranscodeRequest) validate(roots []string) error {
+	targets := 0
+	for _, set := range []bool{t.MovieID > 0, t.EpisodeFileID > 0, t.SeriesID > 0, t.Path != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return errors.New("exactly one of movieId, episodeFileId, seriesId or path is required")
+	}
+	if t.Path != "" {
+		if err := validatePath(t.Path, roots); err != nil {
+			return err
+		}
+	}
+	if t.Priority != "" && t.Priority != "normal" && t.Priority != "high" {
+		return errors.New("priority must be normal or high")
+	}
+	if t.Profile != "" {
+		if _, err := transcode.GetProfiles().Get(t.Profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t TranscodeRequest) jobName() string {
+	if t.Priority == "high" {
+		return constants.PriorityTranscodeJobType
+	}
+	return constants.TranscodeJobType
+}
+
+func (t TranscodeRequest) args(transcodeType constants.TranscodeType, key string, value interface{}) work.Q {
+	args := work.Q{
+		constants.TranscodeTypeKey: transcodeType,
+		key:                        value,
+	}
+	if transcodeType != constants.File {
+		worker.SetInstance(args, t.Instance)
+	}
+	if t.Profile != "" {
+		args[constants.ProfileKey] = t.Profile
+	}
+	return args
+}
+
+// instanceExists checks the requested instance against the configured ones for the kind of request
+func (t TranscodeRequest) instanceExists(sonarrClients map[string]web.SonarrClient, radarrClients map[string]web.RadarrClient) bool {
+	switch {
+	case t.MovieID > 0:
+		_, ok := radarrClients[t.Instance]
+		return ok
+	case t.EpisodeFileID > 0, t.SeriesID > 0:
+		_, ok := sonarrClients[t.Instance]
+		return ok
+	}
+	return true
+}
+
+// jobArgs expands the request into the args of each transcode job. A series becomes one job per episode file
+func (t TranscodeRequest) jobArgs(sonarrClient web.SonarrClient) ([]work.Q, error) {
+	switch {
+	case t.MovieID > 0:
+		return []work.Q{t.args(constants.Movie, constants.MovieIdKey, t.MovieID)}, nil
+	case t.EpisodeFileID > 0:
+		return []work.Q{t.args(constants.TV, constants.EpisodeFileIdKey, t.EpisodeFileID)}, nil
+	case t.Path != "":
+		return []work.Q{t.args(constants.File, constants.FilePathKey, filepath.Clean(t.Path))}, nil
+	}
+
+	episodeFiles, err := sonarrClient.GetAllEpisodeFiles(int(t.SeriesID))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]work.Q, 0, len(episodeFiles))
+	for _, file := range episodeFiles {
+		args := t.args(constants.TV, constants.EpisodeFileIdKey, int64(file.ID))
+		args[constants.SeriesIdKey] = t.SeriesID
+		jobs = append(jobs, args)
+	}
+	return jobs, nil
+}
+
+// GetTranscodeHandler enqueues transcodes requested by hand. The clients are the configured instances by name and
+// roots are the folders files transcoded by path must be in
+func GetTranscodeHandler(scheduler worker.WorkScheduler, sonarrClients map[string]web.SonarrClient, radarrClients map[string]web.RadarrClient,
+	roots []string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body TranscodeRequest
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			log.Err(err).Msg("Failed to bind json")
+			http.Error(w, "invalid json input", http.StatusBadRequest)
+			return
+		}
+
+		if err = body.validate(roots); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if body.Instance == "" {
+			body.Instance = config.DefaultInstance
+		}
+		if !body.instanceExists(sonarrClients, radarrClients) {
+			http.Error(w, "unknown instance: "+body.Instance, http.StatusBadRequest)
+			return
+		}
+
+		jobs, err := body.jobArgs(sonarrClients[body.Instance])
+		if err != nil {
+			log.Err(err).Int64("seriesId", body.SeriesID).Msg("Failed to get episode files")
+			http.Error(w, "failed to get episode files", http.StatusBadGateway)
+			return
+		}
+
+		resp := TranscodeResponse{JobIDs: make([]string, 0, len(jobs))}
+		for _, args := range jobs {
+			job, err := scheduler.EnqueueUnique(body.jobName(), args)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to enqueue work")
+				http.Error(w, "failed to enqueue work", http.StatusInternalServerError)
+				return
+			}
+			// A nil job means the same transcode is already queued
+			if job == nil {
+				resp.Duplicates++
+				continue
+			}
+			log.Info().Msgf("Enqueued job: %s", job.ID)
+			resp.JobIDs = append(resp.JobIDs, job.ID)
+		}
+
+		status := http.StatusOK
+		if len(resp.JobIDs) > 0 {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, resp)
+	}
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSonarr only implements the calls the handlers make. Anything else panics on the nil interface
+type mockSonarr struct {
+	web.SonarrClient
+	getAllEpisodeFiles func(seriesId int) ([]web.SonarrEpisodeFile, error)
+	lookupSeries       func(id int) (*web.Series, error)
+}
+
+func (m mockSonarr) GetAllEpisodeFiles(seriesId int) ([]web.SonarrEpisodeFile, error) {
+	return m.getAllEpisodeFiles(seriesId)
+}
+
+func (m mockSonarr) LookupSeries(id int) (*web.Series, error) {
+	return m.lookupSeries(id)
+}
+
+func transcodeRequest(m *mockWorker, sonarr web.SonarrClient, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/transcode", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	sonarrClients := map[string]web.SonarrClient{config.DefaultInstance: sonarr, "anime": sonarr}
+	radarrClients := map[string]web.RadarrClient{config.DefaultInstance: nil}
+	GetTranscodeHandler(m, sonarrClients, radarrClients, []string{"/downloads", "/media/"})(w, req)
+	return w
+}
+
+func decodeTranscodeResponse(t *testing.T, w *httptest.ResponseRecorder) TranscodeResponse {
+	var resp TranscodeResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+func TestTranscodeEnqueuesMovie(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.TranscodeType(constants.Movie),
+		constants.MovieIdKey:       int64(5),
+		constants.ProfileKey:       "default",
+	}).Return(&work.Job{ID: "abc"}, nil)
+
+	w := transcodeRequest(&m, nil, `{"movieId": 5, "profile": "default"}`)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, []string{"abc"}, decodeTranscodeResponse(t, w).JobIDs)
+	m.AssertExpectations(t)
+}
+
+func TestTranscodeEnqueuesEpisodeFile(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.TV,
+		constants.EpisodeFileIdKey: int64(9),
+	}).Return(nil, nil)
+
+	w := transcodeRequest(&m, nil, `{"episodeFileId": 9}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, decodeTranscodeResponse(t, w).Duplicates)
+	m.AssertExpectations(t)
+}
+
+func TestTranscodeEnqueuesEpisodeFileOfNamedInstance(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.TV,
+		constants.EpisodeFileIdKey: int64(9),
+		constants.InstanceKey:      "anime",
+	}).Return(&work.Job{ID: "abc"}, nil)
+
+	w := transcodeRequest(&m, nil, `{"episodeFileId": 9, "instance": "anime"}`)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestTranscodeEnqueuesEverySeriesEpisode(t *testing.T) {
+	m := mockWorker{}
+	for _, id := range []int64{1, 2} {
+		m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+			constants.TranscodeTypeKey: constants.TV,
+			constants.EpisodeFileIdKey: id,
+			constants.SeriesIdKey:      int64(3),
+		}).Return(&work.Job{ID: "job"}, nil)
+	}
+	sonarr := mockSonarr{getAllEpisodeFiles: func(seriesId int) ([]web.SonarrEpisodeFile, error) {
+		assert.Equal(t, 3, seriesId)
+		return []web.SonarrEpisodeFile{{ID: 1}, {ID: 2}}, nil
+	}}
+
+	w := transcodeRequest(&m, sonarr, `{"seriesId": 3}`)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, decodeTranscodeResponse(t, w).JobIDs, 2)
+	m.AssertExpectations(t)
+}
+
+func TestTranscodeSeriesLookupFailure(t *testing.T) {
+	m := mockWorker{}
+	sonarr := mockSonarr{getAllEpisodeFiles: func(seriesId int) ([]web.SonarrEpisodeFile, error) {
+		return nil, errors.New("sonarr down")
+	}}
+
+	w := transcodeRequest(&m, sonarr, `{"seriesId": 3}`)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestTranscodeEnqueuesHighPriorityPath(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.PriorityTranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.TranscodeType(constants.File),
+		constants.FilePathKey:      "/downloads/home video.mkv",
+	}).Return(&work.Job{ID: "abc"}, nil)
+
+	w := transcodeRequest(&m, nil, `{"path": "/downloads/./home video.mkv", "priority": "high"}`)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestTranscodeRejectsInvalidRequests(t *testing.T) {
+	m := mockWorker{}
+
+	for _, body := range []string{
+		`{}`,
+		`{"movieId": 1, "episodeFileId": 2}`,
+		`{"path": "relative/movie.mkv"}`,
+		`{"path": "/etc/passwd"}`,
+		`{"path": "/downloads/../etc/passwd"}`,
+		`{"path": "/downloads2/movie.mkv"}`,
+		`{"path": "/media-old/movie.mkv"}`,
+		`{"path": "/downloads/movie.mp4.job.partial"}`,
+		`{"path": "/downloads/movie.mp4.plan.chunks/0000.partial"}`,
+		`{"movieId": 1, "priority": "urgent"}`,
+		`{"movieId": 1, "profile": "missing"}`,
+		`{"movieId": 1, "instance": "uhd"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, transcodeRequest(&m, nil, body).Code, body)
+	}
+	m.AssertExpectations(t)
+}
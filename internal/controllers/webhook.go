@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/pathmap"
+	"media-web/internal/web"
+	"media-web/internal/worker"
+
+	"github.com/gocraft/work"
+	"github.com/rs/zerolog/log"
+)
+
+// WebhookOptions configure how the webhook events of a Sonarr or Radarr instance are handled
+type WebhookOptions struct {
+	// Instance is added to the args of every job so it runs against the server that sent the event
+	Instance      string
+	Inspector     worker.JobInspector
+	PathMapper    pathmap.Mapper
+	UpgradePolicy string
+	// MovieFilter and SeriesFilter skip downloads the filter rules exclude. Nil allows everything
+	MovieFilter  *worker.MovieFilter
+	SeriesFilter *worker.SeriesFilter
+}
+
+// isTranscodeOf reports whether job transcodes one of ids. Ids are only unique within an instance
+func isTranscodeOf(job *work.Job, instance string, transcodeType constants.TranscodeType, key string, ids ...int64) bool {
+	if instance == "" {
+		instance = config.DefaultInstance
+	}
+	if !worker.IsTranscodeJob(job) || constants.TranscodeType(job.ArgString(constants.TranscodeTypeKey)) != transcodeType ||
+		worker.JobInstance(job) != instance {
+		return false
+	}
+	for _, id := range ids {
+		if job.ArgInt64(key) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelTranscodes removes pending transcodes of a deleted file
+func cancelTranscodes(inspector worker.JobInspector, instance string, transcodeType constants.TranscodeType, key string, id int64) error {
+	cancelled, err := inspector.CancelJobs(func(job *work.Job) bool {
+		return isTranscodeOf(job, instance, transcodeType, key, id)
+	})
+	if err == nil && len(cancelled) > 0 {
+		log.Info().Int("cancelled", len(cancelled)).Int64(key, id).Msg("Cancelled pending transcodes for deleted file")
+	}
+	return err
+}
+
+// requeueRenamedTranscodes queues the transcodes of renamed files again. They look up the path when they run, but
+// were routed to a worker class by the old path. Retrying jobs are left alone so they keep their failures
+func requeueRenamedTranscodes(inspector worker.JobInspector, scheduler worker.WorkScheduler, instance string,
+	transcodeType constants.TranscodeType, key string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	renamed, err := inspector.CancelJobs(func(job *work.Job) bool {
+		return job.Fails == 0 && isTranscodeOf(job, instance, transcodeType, key, ids...)
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range renamed {
+		if _, err = scheduler.EnqueueUnique(job.Name, job.Args); err != nil {
+			return err
+		}
+	}
+	if len(renamed) > 0 {
+		log.Info().Int("requeued", len(renamed)).Msg("Queued transcodes of renamed files again")
+	}
+	return nil
+}
+
+// renameQueuedPaths points queued jobs that hold a path at the new location of renamed files. Path transcodes hold
+// local paths and rescan jobs hold the path as the *arr sees it, so both forms are checked
+func renameQueuedPaths(inspector worker.JobInspector, mapper pathmap.Mapper, files []web.RenamedFile) error {
+	renames := make(map[string]string)
+	for _, file := range files {
+		renames[file.PreviousPath] = file.Path
+		renames[mapper.ToLocal(file.PreviousPath)] = mapper.ToLocal(file.Path)
+	}
+	updated, err := inspector.UpdateQueuedJobs(func(job *work.Job) bool {
+		path, ok := job.Args[constants.FilePathKey].(string)
+		if !ok {
+			return false
+		}
+		renamed, ok := renames[path]
+		if !ok || renamed == path {
+			return false
+		}
+		job.Args[constants.FilePathKey] = renamed
+		return true
+	})
+	if err == nil && updated > 0 {
+		log.Info().Int("updated", updated).Msg("Updated queued jobs for renamed files")
+	}
+	return err
+}
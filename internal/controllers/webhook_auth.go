@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"media-web/internal/utils"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WebhookCredentials are the shared secrets a Sonarr or Radarr webhook has to present. Basic auth matches the
+// username and password in the *arr connection settings and the token is passed as ?token= in the webhook url
+type WebhookCredentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// Enabled reports whether a password or token is configured
+func (c WebhookCredentials) Enabled() bool {
+	return c.Password != "" || c.Token != ""
+}
+
+func secretEqual(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// authorized accepts a request presenting any of the configured secrets
+func (c WebhookCredentials) authorized(r *http.Request) bool {
+	if c.Password != "" {
+		username, password, ok := r.BasicAuth()
+		if ok && secretEqual(username, c.Username) && secretEqual(password, c.Password) {
+			return true
+		}
+	}
+	if c.Token != "" {
+		if token := r.URL.Query().Get("token"); token != "" && secretEqual(token, c.Token) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookAuth rejects webhook calls from source that don't present its credentials. Webhooks are left open
+// when no credentials are configured
+func WebhookAuth(source string, creds WebhookCredentials, next http.HandlerFunc) http.HandlerFunc {
+	if !creds.Enabled() {
+		log.Warn().Str("source", source).Msg("Webhook authentication is disabled")
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !creds.authorized(r) {
+			utils.WebhookAuthFailures.WithLabelValues(source).Inc()
+			log.Warn().Str("source", source).Str("remote", r.RemoteAddr).Msg("Rejected unauthenticated webhook")
+			if creds.Password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="media-web"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
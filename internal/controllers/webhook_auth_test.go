@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"media-web/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func authRequest(creds WebhookCredentials, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := WebhookAuth("test", creds, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w, called
+}
+
+func TestWebhookAuthDisabledWithoutCredentials(t *testing.T) {
+	w, called := authRequest(WebhookCredentials{}, httptest.NewRequest("POST", "/api/radarr/webhook", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, called)
+}
+
+func TestWebhookAuthAcceptsBasicAuth(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/radarr/webhook", nil)
+	req.SetBasicAuth("radarr", "secret")
+
+	_, called := authRequest(WebhookCredentials{Username: "radarr", Password: "secret"}, req)
+
+	assert.True(t, called)
+}
+
+func TestWebhookAuthAcceptsToken(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/radarr/webhook?token=abc", nil)
+
+	_, called := authRequest(WebhookCredentials{Username: "radarr", Password: "secret", Token: "abc"}, req)
+
+	assert.True(t, called)
+}
+
+func TestWebhookAuthRejectsWrongCredentials(t *testing.T) {
+	before := testutil.ToFloat64(utils.WebhookAuthFailures.WithLabelValues("test"))
+	req := httptest.NewRequest("POST", "/api/radarr/webhook?token=wrong", nil)
+	req.SetBasicAuth("radarr", "wrong")
+
+	w, called := authRequest(WebhookCredentials{Username: "radarr", Password: "secret", Token: "abc"}, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, called)
+	assert.Equal(t, before+1, testutil.ToFloat64(utils.WebhookAuthFailures.WithLabelValues("test")))
+}
+
+func TestWebhookAuthRejectsMissingToken(t *testing.T) {
+	w, called := authRequest(WebhookCredentials{Token: "abc"}, httptest.NewRequest("POST", "/api/sonarr/webhook", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+	assert.False(t, called)
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/pathmap"
+	"media-web/internal/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func postWebhook(t *testing.T, handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	payload, err := json.Marshal(body)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/api/webhook", bytes.NewBuffer(payload)))
+	return w
+}
+
+func TestWebhookTestEventEnqueuesNothing(t *testing.T) {
+	m := mockWorker{}
+
+	w := postWebhook(t, GetRadarrWebhookHandler(&m, WebhookOptions{}), web.RadarrWebhook{EventType: "Test"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestWebhookSkipsUpgrades(t *testing.T) {
+	m := mockWorker{}
+	opts := WebhookOptions{UpgradePolicy: config.UpgradeSkip}
+
+	w := postWebhook(t, GetSonarrWebhookHandler(&m, opts), web.SonarrWebhook{EventType: "Download", IsUpgrade: true})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestWebhookTranscodesUpgradesByDefault(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.TranscodeJobType, mock.Anything).Return(&work.Job{ID: "foo"}, nil)
+
+	w := postWebhook(t, GetRadarrWebhookHandler(&m, WebhookOptions{}), web.RadarrWebhook{EventType: "Download", IsUpgrade: true})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestWebhookTagsJobsWithNamedInstance(t *testing.T) {
+	m := mockWorker{}
+	m.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.Movie,
+		constants.MovieIdKey:       4,
+		constants.InstanceKey:      "uhd",
+	}).Return(&work.Job{ID: "foo"}, nil)
+
+	body := web.RadarrWebhook{EventType: "Download", Movie: web.Movie{ID: 4}}
+	w := postWebhook(t, GetRadarrWebhookHandler(&m, WebhookOptions{Instance: "uhd"}), body)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestWebhookRenameUpdatesQueuedPaths(t *testing.T) {
+	m := mockWorker{}
+	mapper := pathmap.Mapper{{Remote: "/movies", Local: "/mnt/movies"}}
+	queued := []*work.Job{
+		{Name: constants.TranscodeJobType, Args: map[string]interface{}{constants.FilePathKey: "/mnt/movies/a.mkv"}},
+		{Name: constants.UpdateRadarrJobName, Args: map[string]interface{}{constants.FilePathKey: "/movies/a.mkv"}},
+		{Name: constants.TranscodeJobType, Args: map[string]interface{}{constants.FilePathKey: "/mnt/movies/b.mkv"}},
+	}
+	inspector := mockInspector{updateQueuedJobs: func(update func(job *work.Job) bool) (int, error) {
+		assert.True(t, update(queued[0]))
+		assert.True(t, update(queued[1]))
+		assert.False(t, update(queued[2]))
+		return 2, nil
+	}, cancelJobs: func(match func(job *work.Job) bool) ([]*work.Job, error) {
+		for _, job := range queued {
+			assert.False(t, match(job))
+		}
+		return nil, nil
+	}}
+	body := web.RadarrWebhook{EventType: "Rename", RenamedMovieFiles: []web.RenamedFile{
+		{PreviousPath: "/movies/a.mkv", Path: "/movies/A (2020).mkv"},
+	}}
+
+	w := postWebhook(t, GetRadarrWebhookHandler(&m, WebhookOptions{Inspector: inspector, PathMapper: mapper}), body)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/mnt/movies/A (2020).mkv", queued[0].ArgString(constants.FilePathKey))
+	assert.Equal(t, "/movies/A (2020).mkv", queued[1].ArgString(constants.FilePathKey))
+	assert.Equal(t, "/mnt/movies/b.mkv", queued[2].ArgString(constants.FilePathKey))
+}
+
+func TestWebhookRenameRequeuesTranscodesOfRenamedFiles(t *testing.T) {
+	m := mockWorker{}
+	// As the webhook and scanner queue them, and as they come back out of Redis
+	renamed := &work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+		constants.EpisodeFileIdKey: float64(5), constants.SeriesIdKey: float64(3), constants.TranscodeTypeKey: string(constants.TV),
+	}}
+	retrying := &work.Job{Name: constants.TranscodeJobType, Fails: 1, Args: renamed.Args}
+	other := &work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+		constants.EpisodeFileIdKey: float64(6), constants.SeriesIdKey: float64(3), constants.TranscodeTypeKey: string(constants.TV),
+	}}
+	inspector := mockInspector{updateQueuedJobs: func(update func(job *work.Job) bool) (int, error) {
+		assert.False(t, update(renamed))
+		return 0, nil
+	}, cancelJobs: func(match func(job *work.Job) bool) ([]*work.Job, error) {
+		assert.True(t, match(renamed))
+		assert.False(t, match(retrying))
+		assert.False(t, match(other))
+		return []*work.Job{renamed}, nil
+	}}
+	m.On("EnqueueUnique", constants.TranscodeJobType, renamed.Args).Return(&work.Job{ID: "requeued"}, nil)
+	body := map[string]interface{}{"eventType": "Rename", "series": map[string]interface{}{"id": 3}, "renamedEpisodeFiles": []interface{}{
+		map[string]interface{}{"id": 5, "previousPath": "/tv/Show/S01E01.mkv", "path": "/tv/Show/Show - S01E01.mkv"},
+	}}
+
+	w := postWebhook(t, GetSonarrWebhookHandler(&m, WebhookOptions{Inspector: inspector}), body)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestWebhookDeleteCancelsTranscodes(t *testing.T) {
+	m := mockWorker{}
+	inspector := mockInspector{cancelJobs: func(match func(job *work.Job) bool) ([]*work.Job, error) {
+		assert.True(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.TV), constants.EpisodeFileIdKey: float64(7),
+		}}))
+		assert.True(t, match(&work.Job{Name: constants.PriorityTranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.TV), constants.EpisodeFileIdKey: float64(7),
+		}}))
+		assert.False(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.TV), constants.EpisodeFileIdKey: float64(8),
+		}}))
+		assert.False(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: constants.Movie, constants.MovieIdKey: float64(7),
+		}}))
+		return []*work.Job{{ID: "cancelled"}}, nil
+	}}
+	body := web.SonarrWebhook{EventType: "EpisodeFileDelete"}
+	body.EpisodeFile.ID = 7
+
+	w := postWebhook(t, GetSonarrWebhookHandler(&m, WebhookOptions{Inspector: inspector}), body)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
+
+func TestWebhookGrabIsIgnored(t *testing.T) {
+	m := mockWorker{}
+
+	w := postWebhook(t, GetSonarrWebhookHandler(&m, WebhookOptions{}), web.SonarrWebhook{EventType: "Grab"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,229 @@
+package filter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"media-web/internal/config"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Media is a Radarr movie or a Sonarr episode file with the fields rules can match on
+type Media struct {
+	Instance         string
+	Tags             []string
+	QualityProfileID int
+	// Path is where Sonarr or Radarr has the file, before any path mapping
+	Path   string
+	Genres []string
+	Year   int
+	// Added is when the file was imported, or when the movie or series was added if that isn't known
+	Added time.Time
+}
+
+// Duration is a time.Duration written like "720h" in the rules file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Condition matches media when every field that is set matches. A list matches when any of its values does
+type Condition struct {
+	Instances       []string `yaml:"instances"`
+	Tags            []string `yaml:"tags"`
+	QualityProfiles []int    `yaml:"qualityProfiles"`
+	Paths           []string `yaml:"paths"`
+	Genres          []string `yaml:"genres"`
+	MinYear         int      `yaml:"minYear"`
+	MaxYear         int      `yaml:"maxYear"`
+	// OlderThan and NewerThan compare the age of the file against now
+	OlderThan Duration `yaml:"olderThan"`
+	NewerThan Duration `yaml:"newerThan"`
+}
+
+// Matches reports whether the condition holds for the media at the given time
+func (c Condition) Matches(media Media, now time.Time) bool {
+	if len(c.Instances) > 0 && !containsFold(c.Instances, media.Instance) {
+		return false
+	}
+	if len(c.Tags) > 0 && !anyContainsFold(c.Tags, media.Tags) {
+		return false
+	}
+	if len(c.QualityProfiles) > 0 && !containsInt(c.QualityProfiles, media.QualityProfileID) {
+		return false
+	}
+	if len(c.Paths) > 0 && !hasPathPrefix(c.Paths, media.Path) {
+		return false
+	}
+	if len(c.Genres) > 0 && !anyContainsFold(c.Genres, media.Genres) {
+		return false
+	}
+	if c.MinYear != 0 && media.Year < c.MinYear {
+		return false
+	}
+	if c.MaxYear != 0 && media.Year > c.MaxYear {
+		return false
+	}
+	age := now.Sub(media.Added)
+	if c.OlderThan != 0 && age <= time.Duration(c.OlderThan) {
+		return false
+	}
+	if c.NewerThan != 0 && age >= time.Duration(c.NewerThan) {
+		return false
+	}
+	return true
+}
+
+// Rules decide which media of one kind of *arr may be transcoded. Media matching any exclude condition never is.
+// When there are include conditions media has to match one of them, otherwise everything that isn't excluded is
+type Rules struct {
+	Include []Condition `yaml:"include"`
+	Exclude []Condition `yaml:"exclude"`
+}
+
+// Empty reports whether the rules allow everything
+func (r Rules) Empty() bool {
+	return len(r.Include) == 0 && len(r.Exclude) == 0
+}
+
+func (r Rules) conditions() []Condition {
+	conditions := make([]Condition, 0, len(r.Include)+len(r.Exclude))
+	return append(append(conditions, r.Include...), r.Exclude...)
+}
+
+// NeedsTags reports whether any condition matches on tags, which have to be looked up by id
+func (r Rules) NeedsTags() bool {
+	for _, condition := range r.conditions() {
+		if len(condition.Tags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// DependsOnAge reports whether any condition matches on the age of the file, so the same file can be allowed one day
+// and not the next
+func (r Rules) DependsOnAge() bool {
+	for _, condition := range r.conditions() {
+		if condition.OlderThan != 0 || condition.NewerThan != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies the rules, changing whenever they do. It is empty when the rules allow everything
+func (r Rules) Key() string {
+	if r.Empty() {
+		return ""
+	}
+	hash := fnv.New32a()
+	_, _ = fmt.Fprintf(hash, "%+v", r)
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// Allows reports whether the media may be transcoded
+func (r Rules) Allows(media Media, now time.Time) bool {
+	for _, condition := range r.Exclude {
+		if condition.Matches(media, now) {
+			return false
+		}
+	}
+	if len(r.Include) == 0 {
+		return true
+	}
+	for _, condition := range r.Include {
+		if condition.Matches(media, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleSet holds the rules for Radarr movies and Sonarr episode files
+type RuleSet struct {
+	Radarr Rules `yaml:"radarr"`
+	Sonarr Rules `yaml:"sonarr"`
+}
+
+// LoadRules reads the rules from a YAML or JSON file. Without a file everything is allowed
+func LoadRules(path string) (RuleSet, error) {
+	var rules RuleSet
+	if path == "" {
+		return rules, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rules, errors.Wrap(err, "failed to read filter rules")
+	}
+	// YAML is a superset of JSON so this handles both formats
+	if err = yaml.Unmarshal(data, &rules); err != nil {
+		return rules, errors.Wrap(err, "failed to parse filter rules")
+	}
+	return rules, nil
+}
+
+func loadConfiguredRules() RuleSet {
+	rules, err := LoadRules(config.GetConfig().FilterRulesPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load filter rules")
+	}
+	return rules
+}
+
+var rules = loadConfiguredRules()
+
+// GetRules returns the rules loaded from the configured rules file
+func GetRules() RuleSet {
+	return rules
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyContainsFold(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if containsFold(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix matches whole path segments, so /movies/4k doesn't match /movies/4k-remux
+func hasPathPrefix(prefixes []string, path string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package filter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestEmptyRulesAllowEverything(t *testing.T) {
+	rules := Rules{}
+
+	assert.True(t, rules.Empty())
+	assert.True(t, rules.Allows(Media{Path: "/movies/a.mkv"}, now))
+}
+
+func TestIncludeRulesRequireAMatch(t *testing.T) {
+	rules := Rules{Include: []Condition{{Tags: []string{"transcode"}}}}
+
+	assert.True(t, rules.Allows(Media{Tags: []string{"kids", "Transcode"}}, now))
+	assert.False(t, rules.Allows(Media{Tags: []string{"kids"}}, now))
+	assert.False(t, rules.Allows(Media{}, now))
+	assert.True(t, rules.NeedsTags())
+	assert.False(t, rules.DependsOnAge())
+}
+
+func TestRulesKeyChangesWithRules(t *testing.T) {
+	include := Rules{Include: []Condition{{Tags: []string{"transcode"}}}}
+	exclude := Rules{Exclude: []Condition{{Tags: []string{"transcode"}}}}
+
+	assert.Empty(t, Rules{}.Key())
+	assert.NotEmpty(t, include.Key())
+	assert.Equal(t, include.Key(), Rules{Include: []Condition{{Tags: []string{"transcode"}}}}.Key())
+	assert.NotEqual(t, include.Key(), exclude.Key())
+}
+
+func TestExcludeRulesWin(t *testing.T) {
+	rules := Rules{
+		Include: []Condition{{Genres: []string{"Animation"}}},
+		Exclude: []Condition{{Paths: []string{"/movies/4k/"}}},
+	}
+
+	assert.True(t, rules.Allows(Media{Genres: []string{"animation"}, Path: "/movies/hd/a.mkv"}, now))
+	assert.False(t, rules.Allows(Media{Genres: []string{"Animation"}, Path: "/movies/4k/a.mkv"}, now))
+	assert.True(t, rules.Allows(Media{Genres: []string{"Animation"}, Path: "/movies/4k-remux/a.mkv"}, now))
+	assert.False(t, rules.NeedsTags())
+}
+
+func TestConditionRequiresEveryField(t *testing.T) {
+	condition := Condition{Instances: []string{"uhd"}, QualityProfiles: []int{4, 5}, MinYear: 2000, MaxYear: 2010}
+
+	assert.True(t, condition.Matches(Media{Instance: "uhd", QualityProfileID: 5, Year: 2005}, now))
+	assert.False(t, condition.Matches(Media{Instance: "default", QualityProfileID: 5, Year: 2005}, now))
+	assert.False(t, condition.Matches(Media{Instance: "uhd", QualityProfileID: 1, Year: 2005}, now))
+	assert.False(t, condition.Matches(Media{Instance: "uhd", QualityProfileID: 5, Year: 1999}, now))
+	assert.False(t, condition.Matches(Media{Instance: "uhd", QualityProfileID: 5, Year: 2011}, now))
+}
+
+func TestConditionMatchesAge(t *testing.T) {
+	older := Condition{OlderThan: Duration(30 * 24 * time.Hour)}
+	newer := Condition{NewerThan: Duration(24 * time.Hour)}
+	month := Media{Added: now.Add(-40 * 24 * time.Hour)}
+	hour := Media{Added: now.Add(-time.Hour)}
+
+	assert.True(t, Rules{Exclude: []Condition{older}}.DependsOnAge())
+	assert.True(t, older.Matches(month, now))
+	assert.False(t, older.Matches(hour, now))
+	assert.False(t, newer.Matches(month, now))
+	assert.True(t, newer.Matches(hour, now))
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	err := ioutil.WriteFile(path, []byte(`
+radarr:
+  exclude:
+    - paths: [/movies/4k]
+sonarr:
+  include:
+    - tags: [transcode]
+      newerThan: 720h
+`), 0644)
+	assert.NoError(t, err)
+
+	rules, err := LoadRules(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Condition{{Paths: []string{"/movies/4k"}}}, rules.Radarr.Exclude)
+	assert.Equal(t, []Condition{{Tags: []string{"transcode"}, NewerThan: Duration(720 * time.Hour)}}, rules.Sonarr.Include)
+	assert.Empty(t, rules.Radarr.Include)
+}
+
+func TestLoadRulesRejectsInvalidDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	err := ioutil.WriteFile(path, []byte("radarr:\n  exclude:\n    - olderThan: soon\n"), 0644)
+	assert.NoError(t, err)
+
+	_, err = LoadRules(path)
+
+	assert.Error(t, err)
+}
+
+func TestLoadRulesWithoutFileAllowsEverything(t *testing.T) {
+	rules, err := LoadRules("")
+
+	assert.NoError(t, err)
+	assert.True(t, rules.Radarr.Empty())
+	assert.True(t, rules.Sonarr.Empty())
+}
@@ -0,0 +1,115 @@
+package pathmap
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Mapping translates paths under Remote, as Sonarr/Radarr see them, to paths under Local on this host
+type Mapping struct {
+	Remote string
+	Local  string
+}
+
+// Mapper holds the path mappings for one Sonarr/Radarr instance. A nil Mapper leaves paths unchanged
+type Mapper []Mapping
+
+// Parse reads mappings in the form "/remote=/local,D:\TV=/other/local". The older "/remote:/local" form is still
+// read when there is no "=", which only works for remote paths without a drive letter
+func Parse(value string) (Mapper, error) {
+	mapper := make(Mapper, 0)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		separator := "="
+		if !strings.Contains(pair, separator) {
+			separator = ":"
+		}
+		parts := strings.SplitN(pair, separator, 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid path mapping %q, expected /remote=/local", pair)
+		}
+		if separator == ":" && len(parts[0]) == 1 {
+			return nil, fmt.Errorf("invalid path mapping %q, use = to separate paths with a drive letter", pair)
+		}
+		mapper = append(mapper, Mapping{Remote: cleanRemote(parts[0]), Local: clean(parts[1])})
+	}
+	return mapper, nil
+}
+
+func clean(p string) string {
+	return path.Clean(strings.TrimSpace(p))
+}
+
+// isWindows reports whether p is a Windows path, like those of a Sonarr or Radarr running on Windows
+func isWindows(p string) bool {
+	return strings.Contains(p, `\`) || (len(p) >= 2 && p[1] == ':' &&
+		(('a' <= p[0] && p[0] <= 'z') || ('A' <= p[0] && p[0] <= 'Z')))
+}
+
+func toSlash(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+// cleanRemote cleans a remote path, keeping the backslashes of Windows paths
+func cleanRemote(p string) string {
+	p = strings.TrimSpace(p)
+	if !isWindows(p) {
+		return clean(p)
+	}
+	return strings.ReplaceAll(path.Clean(toSlash(p)), "/", `\`)
+}
+
+// replacePrefix swaps the longest matching prefix. p and the prefixes are compared with forward slashes and
+// prefixes only match whole path elements so /tv doesn't match /tv2. to builds the new path from the mapping and
+// the rest of p after the prefix
+func replacePrefix(p string, mappings Mapper, from func(Mapping) string, to func(m Mapping, rest string) string) string {
+	sorted := make(Mapper, len(mappings))
+	copy(sorted, mappings)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(from(sorted[i])) > len(from(sorted[j]))
+	})
+	slashed := toSlash(p)
+	for _, mapping := range sorted {
+		prefix := toSlash(from(mapping))
+		if slashed == prefix {
+			return to(mapping, "")
+		}
+		if prefix == "/" {
+			return to(mapping, slashed)
+		}
+		if strings.HasPrefix(slashed, prefix+"/") {
+			return to(mapping, strings.TrimPrefix(slashed, prefix))
+		}
+	}
+	return p
+}
+
+func remote(m Mapping) string {
+	return m.Remote
+}
+
+func local(m Mapping) string {
+	return m.Local
+}
+
+// ToLocal converts a path reported by Sonarr/Radarr into one that can be opened on this host
+func (m Mapper) ToLocal(p string) string {
+	return replacePrefix(p, m, remote, func(mapping Mapping, rest string) string {
+		return path.Join(mapping.Local, rest)
+	})
+}
+
+// ToRemote converts a path on this host into the path Sonarr/Radarr know it by
+func (m Mapper) ToRemote(p string) string {
+	return replacePrefix(p, m, local, func(mapping Mapping, rest string) string {
+		if isWindows(mapping.Remote) {
+			return strings.TrimSuffix(mapping.Remote, `\`) + strings.ReplaceAll(rest, "/", `\`)
+		}
+		return path.Join(mapping.Remote, rest)
+	})
+}
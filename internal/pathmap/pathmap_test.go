@@ -0,0 +1,83 @@
+package pathmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	mapper, err := Parse("/tv=/mnt/nas/tv, /movies/=/mnt/nas/movies")
+
+	assert.NoError(t, err)
+	assert.Equal(t, Mapper{{Remote: "/tv", Local: "/mnt/nas/tv"}, {Remote: "/movies", Local: "/mnt/nas/movies"}}, mapper)
+}
+
+func TestParseColonSeparated(t *testing.T) {
+	mapper, err := Parse("/tv:/mnt/nas/tv")
+
+	assert.NoError(t, err)
+	assert.Equal(t, Mapper{{Remote: "/tv", Local: "/mnt/nas/tv"}}, mapper)
+}
+
+func TestParseWindowsRemote(t *testing.T) {
+	mapper, err := Parse(`D:\TV\=/tv`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Mapper{{Remote: `D:\TV`, Local: "/tv"}}, mapper)
+}
+
+func TestParseRejectsColonWithDriveLetter(t *testing.T) {
+	_, err := Parse(`D:\TV:/tv`)
+
+	assert.Error(t, err)
+}
+
+func TestParseRejectsInvalidMapping(t *testing.T) {
+	_, err := Parse("/tv")
+
+	assert.Error(t, err)
+}
+
+func TestParseEmpty(t *testing.T) {
+	mapper, err := Parse("")
+
+	assert.NoError(t, err)
+	assert.Empty(t, mapper)
+}
+
+func TestToLocalUsesLongestPrefix(t *testing.T) {
+	mapper := Mapper{{Remote: "/data", Local: "/mnt/data"}, {Remote: "/data/tv", Local: "/mnt/tv"}}
+
+	assert.Equal(t, "/mnt/tv/Show/S01E01.mkv", mapper.ToLocal("/data/tv/Show/S01E01.mkv"))
+	assert.Equal(t, "/mnt/data/movies/Movie.mkv", mapper.ToLocal("/data/movies/Movie.mkv"))
+}
+
+func TestToLocalMatchesWholeElements(t *testing.T) {
+	mapper := Mapper{{Remote: "/tv", Local: "/mnt/tv"}}
+
+	assert.Equal(t, "/tv2/Show/S01E01.mkv", mapper.ToLocal("/tv2/Show/S01E01.mkv"))
+	assert.Equal(t, "/mnt/tv", mapper.ToLocal("/tv"))
+}
+
+func TestToRemote(t *testing.T) {
+	mapper := Mapper{{Remote: "/movies", Local: "/mnt/nas/movies"}}
+
+	assert.Equal(t, "/movies/Movie (2020)/Movie.mp4", mapper.ToRemote("/mnt/nas/movies/Movie (2020)/Movie.mp4"))
+	assert.Equal(t, "/other/Movie.mp4", mapper.ToRemote("/other/Movie.mp4"))
+}
+
+func TestNilMapperLeavesPathsAlone(t *testing.T) {
+	var mapper Mapper
+
+	assert.Equal(t, "/movies/Movie.mkv", mapper.ToLocal("/movies/Movie.mkv"))
+	assert.Equal(t, "/movies/Movie.mkv", mapper.ToRemote("/movies/Movie.mkv"))
+}
+
+func TestWindowsRemote(t *testing.T) {
+	mapper := Mapper{{Remote: `D:\TV`, Local: "/mnt/tv"}}
+
+	assert.Equal(t, "/mnt/tv/Show/Season 1/S01E01.mkv", mapper.ToLocal(`D:\TV\Show\Season 1\S01E01.mkv`))
+	assert.Equal(t, `D:\TV\Show\Season 1\S01E01.mp4`, mapper.ToRemote("/mnt/tv/Show/Season 1/S01E01.mp4"))
+	assert.Equal(t, `E:\Movies\Movie.mkv`, mapper.ToLocal(`E:\Movies\Movie.mkv`))
+}
@@ -0,0 +1,182 @@
+package recycle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const entryFileName = "entry.json"
+
+var NotFoundError = errors.New("recycle entry not found")
+
+// Entry describes an original file that was replaced by a transcode
+type Entry struct {
+	ID              string                  `json:"id"`
+	OriginalPath    string                  `json:"originalPath"`
+	ReplacementPath string                  `json:"replacementPath"`
+	TranscodeType   constants.TranscodeType `json:"transcodeType"`
+	MovieID         int64                   `json:"movieId,omitempty"`
+	SeriesID        int64                   `json:"seriesId,omitempty"`
+	ArtistID        int64                   `json:"artistId,omitempty"`
+	Instance        string                  `json:"instance,omitempty"`
+	RecycledAt      time.Time               `json:"recycledAt"`
+	ExpiresAt       time.Time               `json:"expiresAt"`
+}
+
+// Bin keeps replaced originals around for a retention period instead of deleting them
+type Bin interface {
+	Recycle(path string, entry Entry) (*Entry, error)
+	List() ([]Entry, error)
+	Restore(id string) (*Entry, error)
+	Purge(now time.Time) (int, error)
+}
+
+type binImpl struct {
+	dir       string
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewBin creates a Bin that stores each original in its own folder under dir
+func NewBin(dir string, retention time.Duration) Bin {
+	return binImpl{dir: dir, retention: retention, now: time.Now}
+}
+
+// GetBin returns the configured Bin, or nil when originals should be deleted
+func GetBin() Bin {
+	cfg := config.GetConfig()
+	if cfg.RecycleDir == "" {
+		return nil
+	}
+	return NewBin(cfg.RecycleDir, cfg.RecycleRetention)
+}
+
+func (b binImpl) entryDir(id string) string {
+	return filepath.Join(b.dir, filepath.Base(id))
+}
+
+func (b binImpl) Recycle(path string, entry Entry) (*Entry, error) {
+	now := b.now()
+	entry.ID = fmt.Sprintf("%d", now.UnixNano())
+	entry.OriginalPath = path
+	entry.RecycledAt = now
+	entry.ExpiresAt = now.Add(b.retention)
+
+	dir := b.entryDir(entry.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := b.writeEntry(entry); err != nil {
+		return nil, err
+	}
+	if err := utils.MoveFile(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	log.Info().Str("id", entry.ID).Msg("Recycled: " + path)
+	return &entry, nil
+}
+
+func (b binImpl) writeEntry(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(b.entryDir(entry.ID), entryFileName), data, 0644)
+}
+
+func (b binImpl) readEntry(id string) (*Entry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.entryDir(id), entryFileName))
+	if os.IsNotExist(err) {
+		return nil, NotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (b binImpl) List() ([]Entry, error) {
+	entries := make([]Entry, 0)
+	dirs, err := ioutil.ReadDir(b.dir)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		entry, err := b.readEntry(dir.Name())
+		if err != nil {
+			log.Warn().Err(err).Msg("Skipping unreadable recycle entry: " + dir.Name())
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RecycledAt.After(entries[j].RecycledAt)
+	})
+	return entries, nil
+}
+
+// Restore moves the original back into place. The transcoded replacement is removed so the *arr doesn't
+// end up with two files for the same item. It is only removed once the original is back, so a failed move
+// leaves both files where they were
+func (b binImpl) Restore(id string) (*Entry, error) {
+	entry, err := b.readEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := filepath.Join(b.entryDir(id), filepath.Base(entry.OriginalPath))
+	if err = utils.MoveFile(stored, entry.OriginalPath); err != nil {
+		return nil, err
+	}
+	log.Info().Str("id", id).Msg("Restored: " + entry.OriginalPath)
+
+	if entry.ReplacementPath != "" && entry.ReplacementPath != entry.OriginalPath {
+		err = os.Remove(entry.ReplacementPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return entry, os.RemoveAll(b.entryDir(id))
+}
+
+// Purge deletes entries that have outlived the retention period and returns how many were removed
+func (b binImpl) Purge(now time.Time) (int, error) {
+	entries, err := b.List()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, entry := range entries {
+		if now.Before(entry.ExpiresAt) {
+			continue
+		}
+		if err = os.RemoveAll(b.entryDir(entry.ID)); err != nil {
+			return purged, err
+		}
+		log.Info().Str("id", entry.ID).Msg("Purged recycled file: " + entry.OriginalPath)
+		purged++
+	}
+	return purged, nil
+}
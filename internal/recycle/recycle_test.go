@@ -0,0 +1,102 @@
+package recycle
+
+import (
+	"io/ioutil"
+	"media-web/internal/constants"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBin(t *testing.T) (binImpl, string) {
+	media := t.TempDir()
+	return binImpl{dir: t.TempDir(), retention: time.Hour, now: time.Now}, media
+}
+
+func writeFile(t *testing.T, path string) {
+	assert.NoError(t, ioutil.WriteFile(path, []byte("original"), 0644))
+}
+
+func TestRecycleAndRestore(t *testing.T) {
+	bin, media := testBin(t)
+	original := filepath.Join(media, "movie.mkv")
+	replacement := filepath.Join(media, "movie.mp4")
+	writeFile(t, original)
+	writeFile(t, replacement)
+
+	entry, err := bin.Recycle(original, Entry{ReplacementPath: replacement, TranscodeType: constants.Movie, MovieID: 4})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, original)
+
+	entries, err := bin.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, original, entries[0].OriginalPath)
+
+	restored, err := bin.Restore(entry.ID)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, restored.MovieID)
+	assert.FileExists(t, original)
+	assert.NoFileExists(t, replacement)
+
+	entries, err = bin.List()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestFailedRestoreKeepsReplacement(t *testing.T) {
+	bin, media := testBin(t)
+	original := filepath.Join(media, "show", "episode.mkv")
+	replacement := filepath.Join(media, "episode.mp4")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(original), 0755))
+	writeFile(t, original)
+	writeFile(t, replacement)
+
+	entry, err := bin.Recycle(original, Entry{ReplacementPath: replacement})
+	assert.NoError(t, err)
+	// The original's folder is gone so it can't be moved back
+	assert.NoError(t, os.RemoveAll(filepath.Dir(original)))
+
+	_, err = bin.Restore(entry.ID)
+	assert.Error(t, err)
+	assert.FileExists(t, replacement)
+	assert.FileExists(t, filepath.Join(bin.entryDir(entry.ID), "episode.mkv"))
+}
+
+func TestRestoreUnknownEntry(t *testing.T) {
+	bin, _ := testBin(t)
+
+	_, err := bin.Restore("missing")
+
+	assert.Equal(t, NotFoundError, err)
+}
+
+func TestPurgeRemovesExpiredEntries(t *testing.T) {
+	bin, media := testBin(t)
+	original := filepath.Join(media, "episode.mkv")
+	writeFile(t, original)
+	_, err := bin.Recycle(original, Entry{TranscodeType: constants.TV, SeriesID: 1})
+	assert.NoError(t, err)
+
+	purged, err := bin.Purge(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	purged, err = bin.Purge(time.Now().Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	entries, _ := bin.List()
+	assert.Empty(t, entries)
+}
+
+func TestListMissingDirectory(t *testing.T) {
+	bin := binImpl{dir: filepath.Join(t.TempDir(), "missing")}
+
+	entries, err := bin.List()
+
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
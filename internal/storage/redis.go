@@ -7,6 +7,14 @@ import (
 	"github.com/gomodule/redigo/redis"
 )
 
+// NamespacePrefix mirrors how gocraft/work prefixes its keys so ours sit alongside them
+func NamespacePrefix(namespace string) string {
+	if len(namespace) > 0 && namespace[len(namespace)-1] != ':' {
+		namespace += ":"
+	}
+	return namespace
+}
+
 // Make a redis pool
 var RedisPool = redis.Pool{
 	MaxActive: 10,
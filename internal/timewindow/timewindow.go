@@ -0,0 +1,155 @@
+package timewindow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a time of day range on some days of the week. A window whose end is not after its start runs past
+// midnight into the next day
+type Window struct {
+	// Days holds the weekdays the window starts on, indexed by time.Weekday
+	Days [7]bool
+	// Start and End are minutes after midnight
+	Start int
+	End   int
+}
+
+// Windows are the times work is allowed. Empty Windows allow any time
+type Windows []Window
+
+var dayNames = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// Parse reads windows in the form "Mon-Fri 01:00-08:00,Sat 00:00-10:00". Leaving the days out allows every day
+func Parse(value string) (Windows, error) {
+	windows := make(Windows, 0)
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		fields := strings.Fields(spec)
+		var window Window
+		var err error
+		switch len(fields) {
+		case 1:
+			window.Days = [7]bool{true, true, true, true, true, true, true}
+		case 2:
+			window.Days, err = parseDays(fields[0])
+		default:
+			err = fmt.Errorf("invalid time window %q, expected Mon-Fri 01:00-08:00", spec)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if window.Start, window.End, err = parseTimes(fields[len(fields)-1]); err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseDays reads a single day or a range of days, which may wrap around the end of the week like Sat-Sun
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+	parts := strings.SplitN(value, "-", 2)
+	first, err := parseDay(parts[0])
+	if err != nil {
+		return days, err
+	}
+	last := first
+	if len(parts) == 2 {
+		if last, err = parseDay(parts[1]); err != nil {
+			return days, err
+		}
+	}
+	for day := first; ; day = (day + 1) % 7 {
+		days[day] = true
+		if day == last {
+			break
+		}
+	}
+	return days, nil
+}
+
+// parseDay reads a day name or its first three letters
+func parseDay(value string) (int, error) {
+	value = strings.ToLower(value)
+	for i, name := range dayNames {
+		if value == name || value == name[:3] {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q", value)
+}
+
+func parseTimes(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid time range %q, expected 01:00-08:00", value)
+	}
+	start, err := parseTime(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTime(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseTime(value string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 ||
+		(hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// contains reports whether the window is open at t
+func (w Window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if w.Start < w.End {
+		return w.Days[day] && minute >= w.Start && minute < w.End
+	}
+	// The window runs past midnight, so it may have opened the day before
+	return (w.Days[day] && minute >= w.Start) || (w.Days[(day+6)%7] && minute < w.End)
+}
+
+// Contains reports whether t falls in one of the windows
+func (ws Windows) Contains(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns t when it falls in a window, otherwise when the next window opens
+func (ws Windows) Next(t time.Time) time.Time {
+	if ws.Contains(t) {
+		return t
+	}
+	var next time.Time
+	for days := 0; days <= 7; days++ {
+		for _, w := range ws {
+			open := time.Date(t.Year(), t.Month(), t.Day()+days, w.Start/60, w.Start%60, 0, 0, t.Location())
+			if !w.Days[int(open.Weekday())] || !open.After(t) {
+				continue
+			}
+			if next.IsZero() || open.Before(next) {
+				next = open
+			}
+		}
+	}
+	return next
+}
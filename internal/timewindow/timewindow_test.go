@@ -0,0 +1,68 @@
+package timewindow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var weekdays = [7]bool{false, true, true, true, true, true, false}
+
+// at returns a time in the week of Monday 2024-01-01
+func at(day int, hour int, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	windows, err := Parse("Mon-Fri 01:00-08:00, sat-sun 22:30-10:00,12:00-13:00")
+
+	assert.NoError(t, err)
+	assert.Equal(t, Windows{
+		{Days: weekdays, Start: 60, End: 480},
+		{Days: [7]bool{true, false, false, false, false, false, true}, Start: 1350, End: 600},
+		{Days: [7]bool{true, true, true, true, true, true, true}, Start: 720, End: 780},
+	}, windows)
+}
+
+func TestParseRejectsInvalidWindows(t *testing.T) {
+	for _, value := range []string{"Mon-Fri", "Someday 01:00-02:00", "Mon 1-2", "Mon 25:00-26:00", "Mon Tue 01:00-02:00"} {
+		_, err := Parse(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	windows, err := Parse("")
+
+	assert.NoError(t, err)
+	assert.Empty(t, windows)
+	assert.True(t, windows.Contains(at(1, 20, 0)))
+}
+
+func TestContains(t *testing.T) {
+	windows, _ := Parse("Mon-Fri 01:00-08:00")
+
+	assert.True(t, windows.Contains(at(1, 1, 0)))
+	assert.True(t, windows.Contains(at(5, 7, 59)))
+	assert.False(t, windows.Contains(at(1, 8, 0)))
+	assert.False(t, windows.Contains(at(6, 2, 0)))
+}
+
+func TestContainsPastMidnight(t *testing.T) {
+	windows, _ := Parse("Fri 23:00-06:00")
+
+	assert.True(t, windows.Contains(at(5, 23, 30)))
+	assert.True(t, windows.Contains(at(6, 5, 0)))
+	assert.False(t, windows.Contains(at(6, 23, 30)))
+	assert.False(t, windows.Contains(at(5, 5, 0)))
+}
+
+func TestNext(t *testing.T) {
+	windows, _ := Parse("Mon-Fri 01:00-08:00")
+
+	assert.Equal(t, at(1, 2, 0), windows.Next(at(1, 2, 0)))
+	assert.Equal(t, at(2, 1, 0), windows.Next(at(1, 20, 0)))
+	// Friday evening waits for Monday
+	assert.Equal(t, at(8, 1, 0), windows.Next(at(5, 20, 0)))
+}
@@ -0,0 +1,114 @@
+package transcode
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"media-web/internal/config"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Chunk is a part of the source's video encoded on its own. Chunks start at keyframes so they can be concatenated
+// without re-encoding
+type Chunk struct {
+	Index int `json:"index"`
+	// Start and End are seconds into the source
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	// Last chunks run to the end of the source rather than stopping at End
+	Last bool `json:"last,omitempty"`
+}
+
+// Duration is the length of the chunk in seconds
+func (c Chunk) Duration() float64 {
+	return c.End - c.Start
+}
+
+// PlanChunks splits a source of the given duration at the first keyframe after every chunkDuration seconds. A short
+// tail is added to the chunk before it
+func PlanChunks(keyframes []float64, duration float64, chunkDuration float64) []Chunk {
+	sorted := append([]float64(nil), keyframes...)
+	sort.Float64s(sorted)
+
+	chunks := make([]Chunk, 0)
+	start := 0.0
+	for _, keyframe := range sorted {
+		if keyframe >= start+chunkDuration && duration-keyframe >= chunkDuration/2 {
+			chunks = append(chunks, Chunk{Index: len(chunks), Start: start, End: keyframe})
+			start = keyframe
+		}
+	}
+	return append(chunks, Chunk{Index: len(chunks), Start: start, End: duration, Last: true})
+}
+
+// KeyframeReader lists the keyframe times of a file's first video stream
+type KeyframeReader interface {
+	Keyframes(path string) ([]float64, error)
+}
+
+// GetKeyframeReader returns a KeyframeReader using the configured ffprobe binary
+func GetKeyframeReader() KeyframeReader {
+	return FfprobeProber{Path: config.GetConfig().FfprobePath}
+}
+
+// Keyframes reads the packets of the first video stream without decoding them, which is quick even for long files
+func (p FfprobeProber) Keyframes(path string) ([]float64, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(p.Path, "-v", "error", "-select_streams", "v:0", "-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, "ffprobe failed: "+stderr.String())
+	}
+	return parseKeyframes(stdout.String()), nil
+}
+
+// parseKeyframes reads "pts_time,flags" lines, keeping the packets flagged as keyframes
+func parseKeyframes(output string) []float64 {
+	keyframes := make([]float64, 0)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "K") {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, seconds)
+	}
+	sort.Float64s(keyframes)
+	return keyframes
+}
+
+// WriteConcatList writes the list the concat demuxer reads the encoded chunks from
+func WriteConcatList(path string, chunkPaths []string) error {
+	var list strings.Builder
+	for _, chunkPath := range chunkPaths {
+		list.WriteString("file '" + strings.Replace(chunkPath, "'", `'\''`, -1) + "'\n")
+	}
+	return ioutil.WriteFile(path, []byte(list.String()), 0644)
+}
+
+// chunkArguments encodes only the first video stream of the chunk into a matroska file. The video encoding options
+// of ExtraArgs apply here, the others when the chunks are assembled
+func (o Options) chunkArguments() []string {
+	args := append([]string{"-map", "0:v:0"}, o.videoArguments()...)
+	video, _ := o.Profile.splitExtraArgs()
+	args = append(args, video...)
+	if !o.Chunk.Last {
+		args = append(args, "-t", seconds(o.Chunk.Duration()))
+	}
+	return append(args, "-an", "-sn", "-dn", "-f", "matroska")
+}
+
+func seconds(value float64) string {
+	return fmt.Sprintf("%.6f", value)
+}
@@ -0,0 +1,99 @@
+package transcode
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanChunksSplitsAtKeyframes(t *testing.T) {
+	keyframes := []float64{0, 4, 8, 12, 16, 20, 24, 28}
+
+	chunks := PlanChunks(keyframes, 30, 10)
+
+	assert.Equal(t, []Chunk{
+		{Index: 0, Start: 0, End: 12},
+		{Index: 1, Start: 12, End: 24},
+		{Index: 2, Start: 24, End: 30, Last: true},
+	}, chunks)
+}
+
+func TestPlanChunksAddsShortTailToLastChunk(t *testing.T) {
+	keyframes := []float64{0, 4, 8, 12, 16, 20, 24, 28}
+
+	chunks := PlanChunks(keyframes, 27, 10)
+
+	assert.Equal(t, []Chunk{
+		{Index: 0, Start: 0, End: 12},
+		{Index: 1, Start: 12, End: 27, Last: true},
+	}, chunks)
+}
+
+func TestPlanChunksWithoutKeyframesIsOneChunk(t *testing.T) {
+	assert.Equal(t, []Chunk{{Index: 0, Start: 0, End: 30, Last: true}}, PlanChunks(nil, 30, 10))
+}
+
+func TestParseKeyframes(t *testing.T) {
+	output := "0.000000,K__\n0.041708,___\nN/A,K__\n2.002000,K_\n\n"
+
+	assert.Equal(t, []float64{0, 2.002}, parseKeyframes(output))
+}
+
+func TestChunkArguments(t *testing.T) {
+	opts := Options{Profile: DefaultProfile, Decision: FullTranscode, Chunk: &Chunk{Index: 1, Start: 12, End: 24}}
+
+	assert.Equal(t, []string{"-ss", "12.000000", "-i", "/media/movie.mkv"}, opts.InputArguments("/media/movie.mkv"))
+	assert.Equal(t, []string{"-map", "0:v:0", "-c:v", "libx264", "-preset", "veryfast", "-tune", "film", "-crf", "23",
+		"-t", "12.000000", "-an", "-sn", "-dn", "-f", "matroska"}, opts.GetStrArguments())
+	assert.Equal(t, 12*time.Second, opts.Duration())
+}
+
+func TestLastChunkRunsToTheEnd(t *testing.T) {
+	opts := Options{Profile: DefaultProfile, Chunk: &Chunk{Index: 1, Start: 5000, End: 5400, Last: true}}
+
+	assert.NotContains(t, opts.GetStrArguments(), "-t")
+	assert.Equal(t, 400*time.Second, opts.Duration())
+}
+
+func TestAssembleArguments(t *testing.T) {
+	opts := Options{Profile: DefaultProfile, Decision: FullTranscode, Info: parsedInfo(t), ChunkList: "/scratch/list.txt"}
+
+	assert.Equal(t, []string{"-f", "concat", "-safe", "0", "-i", "/scratch/list.txt", "-i", "/media/movie.mkv"},
+		opts.InputArguments("/media/movie.mkv"))
+	assert.Equal(t, []string{"-c:v", "copy", "-map_metadata", "1", "-map_chapters", "1", "-map", "0:v:0",
+		"-map", "1:1", "-c:a:0", "copy", "-f", "mp4"}, opts.GetStrArguments())
+	assert.Equal(t, &StreamCounts{Video: 1, Audio: 1}, opts.ExpectedStreams())
+}
+
+func TestChunkedExtraArgs(t *testing.T) {
+	profile := DefaultProfile
+	profile.Container = "mp4"
+	profile.ExtraArgs = []string{"-vf", "scale=-2:720", "-x264-params", "aq-mode=3", "-tag:v", "avc1", "-ac:a", "2",
+		"-movflags", "+faststart"}
+
+	chunk := Options{Profile: profile, Decision: FullTranscode, Chunk: &Chunk{Index: 0, Start: 0, End: 12}}
+	assert.Equal(t, []string{"-map", "0:v:0", "-c:v", "libx264", "-preset", "veryfast", "-tune", "film", "-crf", "23",
+		"-vf", "scale=-2:720", "-x264-params", "aq-mode=3", "-t", "12.000000", "-an", "-sn", "-dn", "-f", "matroska"},
+		chunk.GetStrArguments())
+
+	assemble := Options{Profile: profile, Decision: FullTranscode, Info: parsedInfo(t), ChunkList: "/scratch/list.txt"}
+	assert.Equal(t, []string{"-c:v", "copy", "-map_metadata", "1", "-map_chapters", "1", "-map", "0:v:0",
+		"-map", "1:1", "-c:a:0", "copy", "-tag:v", "avc1", "-ac:a", "2", "-movflags", "+faststart", "-f", "mp4"},
+		assemble.GetStrArguments())
+
+	whole := Options{Profile: profile, Decision: FullTranscode}
+	assert.Subset(t, whole.GetStrArguments(), profile.ExtraArgs, "an encode in one go keeps every extra argument")
+}
+
+func TestWriteConcatList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+
+	assert.NoError(t, WriteConcatList(path, []string{"/scratch/0000.partial", "/scratch/it's/0001.partial"}))
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "file '/scratch/0000.partial'\nfile '/scratch/it'\\''s/0001.partial'\n", string(data))
+}
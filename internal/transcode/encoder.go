@@ -0,0 +1,106 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"media-web/internal/config"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Progress is how far an encode has got
+type Progress struct {
+	// Percent is 0 when the source duration isn't known
+	Percent float64
+	Speed   string
+}
+
+// Encoder runs the ffmpeg encode of one file. Cancelling ctx kills ffmpeg and Encode returns the context's error
+type Encoder interface {
+	Encode(ctx context.Context, input string, output string, opts Options, progress func(Progress)) error
+}
+
+type ffmpegEncoder struct {
+	path     string
+	priority Priority
+}
+
+// NewEncoder creates an Encoder running the ffmpeg binary at path with the given priority
+func NewEncoder(path string, priority Priority) Encoder {
+	return ffmpegEncoder{path: path, priority: priority}
+}
+
+// GetEncoder returns an Encoder using the configured ffmpeg binary and priority
+func GetEncoder() Encoder {
+	return NewEncoder(config.GetConfig().FfmpegPath, GetPriority())
+}
+
+func (e ffmpegEncoder) Encode(ctx context.Context, input string, output string, opts Options, progress func(Progress)) error {
+	args := append([]string{"-nostdin", "-hide_banner", "-loglevel", "error", "-nostats", "-progress", "pipe:1"}, opts.InputArguments(input)...)
+	args = append(append(args, opts.GetStrArguments()...), output)
+
+	var stderr bytes.Buffer
+	name, args := e.priority.command(e.path, args)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to start ffmpeg")
+	}
+
+	readProgress(stdout, opts.Duration(), progress)
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return errors.Wrap(err, "ffmpeg failed: "+strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// readProgress reads the key=value blocks ffmpeg writes with -progress. Each block ends with a progress key
+func readProgress(r io.Reader, duration time.Duration, progress func(Progress)) {
+	var current Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "out_time_us":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && duration > 0 {
+				current.Percent = float64(time.Duration(us)*time.Microsecond) * 100 / float64(duration)
+			}
+		case "speed":
+			current.Speed = strings.TrimSpace(value)
+		case "progress":
+			if value == "end" {
+				current.Percent = 100
+			}
+			if current.Percent > 100 {
+				current.Percent = 100
+			}
+			if progress != nil {
+				progress(current)
+			}
+		}
+	}
+	// Keep draining so ffmpeg never blocks on a full pipe
+	_, _ = io.Copy(ioutil.Discard, r)
+}
@@ -0,0 +1,69 @@
+package transcode
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFfmpeg writes a shell script standing in for ffmpeg
+func fakeFfmpeg(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	return path
+}
+
+func TestReadProgress(t *testing.T) {
+	output := "frame=10\nout_time_us=2500000\nspeed=1.5x\nprogress=continue\nout_time_us=9000000\nspeed=2x\nprogress=end\n"
+	var reported []Progress
+
+	readProgress(strings.NewReader(output), 10*time.Second, func(p Progress) { reported = append(reported, p) })
+
+	assert.Equal(t, []Progress{{Percent: 25, Speed: "1.5x"}, {Percent: 100, Speed: "2x"}}, reported)
+}
+
+func TestReadProgressWithoutDuration(t *testing.T) {
+	var reported []Progress
+
+	readProgress(strings.NewReader("out_time_us=2500000\nspeed=1x\nprogress=continue\n"), 0, func(p Progress) { reported = append(reported, p) })
+
+	assert.Equal(t, []Progress{{Speed: "1x"}}, reported)
+}
+
+func TestEncodeReportsProgress(t *testing.T) {
+	encoder := NewEncoder(fakeFfmpeg(t, "echo out_time_us=1000000\necho progress=continue\necho progress=end"), Priority{})
+	var reported []Progress
+
+	err := encoder.Encode(context.Background(), "in.mkv", "out.mp4", Options{Profile: DefaultProfile}, func(p Progress) {
+		reported = append(reported, p)
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Progress{{}, {Percent: 100}}, reported)
+}
+
+func TestEncodeReturnsFfmpegError(t *testing.T) {
+	encoder := NewEncoder(fakeFfmpeg(t, "echo 'Invalid data found' >&2\nexit 1"), Priority{})
+
+	err := encoder.Encode(context.Background(), "in.mkv", "out.mp4", Options{Profile: DefaultProfile}, nil)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid data found")
+}
+
+func TestEncodeKillsFfmpegWhenCancelled(t *testing.T) {
+	encoder := NewEncoder(fakeFfmpeg(t, "exec sleep 10"), Priority{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+
+	err := encoder.Encode(ctx, "in.mkv", "out.mp4", Options{Profile: DefaultProfile}, nil)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
@@ -0,0 +1,49 @@
+package transcode
+
+import (
+	"media-web/internal/config"
+	"strconv"
+)
+
+// IO scheduling classes accepted by ionice
+const (
+	IOClassNone       = 0
+	IOClassRealtime   = 1
+	IOClassBestEffort = 2
+	IOClassIdle       = 3
+)
+
+// Priority is the CPU and IO priority ffmpeg runs at so encodes don't slow down playback on the same machine
+type Priority struct {
+	// Nice is added to ffmpeg's CPU niceness, from -20 to 19
+	Nice int
+	// IOClass is the IO scheduling class. IOClassNone leaves it unchanged. ionice is only available on Linux
+	IOClass int
+	// IOLevel is the priority within the best effort and realtime classes, from 0 (highest) to 7
+	IOLevel int
+}
+
+// GetPriority returns the configured priority of encodes and the other ffmpeg runs of a transcode
+func GetPriority() Priority {
+	cfg := config.GetConfig()
+	return Priority{Nice: cfg.TranscodeNice, IOClass: cfg.TranscodeIOClass, IOLevel: cfg.TranscodeIOLevel}
+}
+
+// command wraps a command in nice and ionice, so it runs at the priority from the start along with every thread
+// it creates
+func (p Priority) command(name string, args []string) (string, []string) {
+	if p.Nice != 0 {
+		args = append([]string{"-n", strconv.Itoa(p.Nice), name}, args...)
+		name = "nice"
+	}
+	if p.IOClass != IOClassNone {
+		prefix := []string{"-c", strconv.Itoa(p.IOClass)}
+		// The idle class has no levels
+		if p.IOClass != IOClassIdle {
+			prefix = append(prefix, "-n", strconv.Itoa(p.IOLevel))
+		}
+		args = append(append(prefix, name), args...)
+		name = "ionice"
+	}
+	return name, args
+}
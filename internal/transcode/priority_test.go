@@ -0,0 +1,21 @@
+package transcode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityCommand(t *testing.T) {
+	name, args := Priority{}.command("ffmpeg", []string{"-i", "in.mkv"})
+	assert.Equal(t, "ffmpeg", name)
+	assert.Equal(t, []string{"-i", "in.mkv"}, args)
+
+	name, args = Priority{Nice: 10, IOClass: IOClassBestEffort, IOLevel: 7}.command("ffmpeg", []string{"-i", "in.mkv"})
+	assert.Equal(t, "ionice", name)
+	assert.Equal(t, []string{"-c", "2", "-n", "7", "nice", "-n", "10", "ffmpeg", "-i", "in.mkv"}, args)
+
+	name, args = Priority{IOClass: IOClassIdle, IOLevel: 4}.command("ffmpeg", []string{"-i", "in.mkv"})
+	assert.Equal(t, "ionice", name)
+	assert.Equal(t, []string{"-c", "3", "ffmpeg", "-i", "in.mkv"}, args)
+}
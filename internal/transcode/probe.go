@@ -0,0 +1,196 @@
+package transcode
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MediaInfo is the subset of ffprobe output used to decide how a file should be processed
+type MediaInfo struct {
+	Format  ProbeFormat   `json:"format"`
+	Streams []ProbeStream `json:"streams"`
+}
+
+type ProbeFormat struct {
+	Filename   string `json:"filename"`
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+}
+
+type ProbeStream struct {
+	Index       int    `json:"index"`
+	CodecName   string `json:"codec_name"`
+	CodecType   string `json:"codec_type"`
+	PixFmt      string `json:"pix_fmt"`
+	Channels    int    `json:"channels"`
+	Disposition struct {
+		Default     int `json:"default"`
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+	Tags struct {
+		Language string `json:"language"`
+	} `json:"tags"`
+}
+
+// StreamsOfType returns the streams with the given codec type (video, audio, subtitle). Cover art
+// attached to the file is not counted as a video stream
+func (m MediaInfo) StreamsOfType(codecType string) []ProbeStream {
+	streams := make([]ProbeStream, 0)
+	for _, stream := range m.Streams {
+		if stream.CodecType == codecType && stream.Disposition.AttachedPic == 0 {
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+// Prober inspects a media file
+type Prober interface {
+	Probe(path string) (*MediaInfo, error)
+}
+
+// FfprobeProber runs the ffprobe binary to inspect files
+type FfprobeProber struct {
+	Path string
+}
+
+func (p FfprobeProber) Probe(path string) (*MediaInfo, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(p.Path, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, "ffprobe failed: "+stderr.String())
+	}
+	return parseProbeOutput(stdout.Bytes())
+}
+
+func parseProbeOutput(output []byte) (*MediaInfo, error) {
+	var info MediaInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, errors.Wrap(err, "failed to parse ffprobe output")
+	}
+	return &info, nil
+}
+
+// Decision is the least amount of work needed to bring a file in line with a profile
+type Decision string
+
+const (
+	Skip           Decision = "skip"
+	Remux          Decision = "remux"
+	AudioTranscode Decision = "audio"
+	FullTranscode  Decision = "full"
+)
+
+// codecNames maps ffmpeg encoder names to the codec name reported by ffprobe
+var codecNames = map[string]string{
+	"libx264":    "h264",
+	"h264_nvenc": "h264",
+	"h264_qsv":   "h264",
+	"h264_vaapi": "h264",
+	"libx265":    "hevc",
+	"hevc_nvenc": "hevc",
+	"hevc_qsv":   "hevc",
+	"hevc_vaapi": "hevc",
+	"libvpx-vp9": "vp9",
+	"libaom-av1": "av1",
+	"libsvtav1":  "av1",
+	"libfdk_aac": "aac",
+	"libmp3lame": "mp3",
+	"libopus":    "opus",
+	"libvorbis":  "vorbis",
+}
+
+// CodecName returns the ffprobe codec name produced by an ffmpeg encoder
+func CodecName(encoder string) string {
+	if name, ok := codecNames[encoder]; ok {
+		return name
+	}
+	return encoder
+}
+
+// videoCompatible ignores cover art, and audio profiles have no video to compare against
+func (m MediaInfo) videoCompatible(profile Profile) bool {
+	if profile.IsAudio() {
+		return true
+	}
+	for _, stream := range m.StreamsOfType("video") {
+		if stream.CodecName != CodecName(profile.VideoCodec) {
+			return false
+		}
+		if profile.PixelFormat != "" && stream.PixFmt != profile.PixelFormat {
+			return false
+		}
+	}
+	return true
+}
+
+// audioCompatible only checks the tracks the profile's language rules keep, as the others are dropped anyway
+func (m MediaInfo) audioCompatible(profile Profile) bool {
+	for _, stream := range profile.Streams.selectAudio(&m) {
+		if stream.CodecName != CodecName(profile.AudioCodec) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m MediaInfo) containerCompatible(profile Profile) bool {
+	if filepath.Ext(m.Format.Filename) != profile.Extension() {
+		return false
+	}
+	for _, name := range strings.Split(m.Format.FormatName, ",") {
+		if name == profile.ProbeFormat() {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide compares the probed streams and container against the profile
+func Decide(info *MediaInfo, profile Profile) Decision {
+	if !info.videoCompatible(profile) {
+		return FullTranscode
+	}
+	if !info.audioCompatible(profile) {
+		return AudioTranscode
+	}
+	if !info.containerCompatible(profile) {
+		return Remux
+	}
+	return Skip
+}
+
+// Analyzer decides what needs to happen to a file for it to match a profile
+type Analyzer interface {
+	Analyze(path string, profile Profile) (Decision, *MediaInfo, error)
+}
+
+type analyzerImpl struct {
+	prober Prober
+}
+
+// NewAnalyzer creates an Analyzer backed by the given Prober
+func NewAnalyzer(prober Prober) Analyzer {
+	return analyzerImpl{prober: prober}
+}
+
+func (a analyzerImpl) Analyze(path string, profile Profile) (Decision, *MediaInfo, error) {
+	info, err := a.prober.Probe(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return Decide(info, profile), info, nil
+}
+
+// GetAnalyzer returns an Analyzer that uses the configured ffprobe binary and probe cache
+func GetAnalyzer() Analyzer {
+	return NewAnalyzer(getProber())
+}
@@ -0,0 +1,107 @@
+package transcode
+
+import (
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+	"os"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// CachedProbe is a probe result along with the file state it was taken from
+type CachedProbe struct {
+	Size    int64      `json:"size"`
+	ModTime int64      `json:"modTime"`
+	Info    *MediaInfo `json:"info"`
+}
+
+// ProbeCache stores probe results by path
+type ProbeCache interface {
+	Get(path string) (*CachedProbe, error)
+	Set(path string, probe CachedProbe) error
+}
+
+type redisProbeCache struct {
+	prefix string
+	ttl    time.Duration
+	pool   *redis.Pool
+}
+
+// NewRedisProbeCache creates a ProbeCache that keeps results in Redis for ttl
+func NewRedisProbeCache(namespace string, ttl time.Duration, pool *redis.Pool) ProbeCache {
+	return redisProbeCache{prefix: storage.NamespacePrefix(namespace) + "probe:", ttl: ttl, pool: pool}
+}
+
+func (c redisProbeCache) Get(path string) (*CachedProbe, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", c.prefix+path))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var probe CachedProbe
+	if err = json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	return &probe, nil
+}
+
+func (c redisProbeCache) Set(path string, probe CachedProbe) error {
+	data, err := json.Marshal(probe)
+	if err != nil {
+		return err
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", c.prefix+path, data, "EX", int64(c.ttl.Seconds()))
+	return err
+}
+
+// CachingProber reuses earlier probe results while the file's size and modification time are unchanged. Cache
+// errors are logged and the file is probed as normal
+type CachingProber struct {
+	Prober Prober
+	Cache  ProbeCache
+}
+
+func (c CachingProber) Probe(path string) (*MediaInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := c.Cache.Get(path)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read probe cache for " + path)
+	}
+	if cached != nil && cached.Size == stat.Size() && cached.ModTime == stat.ModTime().UnixNano() {
+		return cached.Info, nil
+	}
+
+	info, err := c.Prober.Probe(path)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Cache.Set(path, CachedProbe{Size: stat.Size(), ModTime: stat.ModTime().UnixNano(), Info: info})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to write probe cache for " + path)
+	}
+	return info, nil
+}
+
+func getProber() Prober {
+	cfg := config.GetConfig()
+	prober := FfprobeProber{Path: cfg.FfprobePath}
+	if cfg.ProbeCacheTTL <= 0 {
+		return prober
+	}
+	return CachingProber{Prober: prober, Cache: NewRedisProbeCache(cfg.JobQueueNamespace, cfg.ProbeCacheTTL, &storage.RedisPool)}
+}
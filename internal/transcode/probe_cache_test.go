@@ -0,0 +1,34 @@
+package transcode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisProbeCacheKeysSitInTheJobNamespace(t *testing.T) {
+	server, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer server.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", server.Addr())
+	}}
+	defer pool.Close()
+
+	for _, namespace := range []string{"media-web", "media-web:"} {
+		cache := NewRedisProbeCache(namespace, time.Hour, pool)
+		probe := CachedProbe{Size: 10, ModTime: 20}
+
+		assert.NoError(t, cache.Set("/tv/a.mkv", probe))
+
+		assert.True(t, server.Exists("media-web:probe:/tv/a.mkv"), namespace)
+		assert.Equal(t, time.Hour, server.TTL("media-web:probe:/tv/a.mkv"))
+		cached, err := cache.Get("/tv/a.mkv")
+		assert.NoError(t, err)
+		assert.Equal(t, &probe, cached)
+		server.FlushAll()
+	}
+}
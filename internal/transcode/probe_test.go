@@ -0,0 +1,122 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const probeOutput = `{
+    "streams": [
+        {"index": 0, "codec_name": "h264", "codec_type": "video", "pix_fmt": "yuv420p", "disposition": {"default": 1, "attached_pic": 0}},
+        {"index": 1, "codec_name": "aac", "codec_type": "audio", "channels": 2, "tags": {"language": "eng"}},
+        {"index": 2, "codec_name": "mjpeg", "codec_type": "video", "disposition": {"default": 0, "attached_pic": 1}}
+    ],
+    "format": {"filename": "/media/movie.mkv", "format_name": "matroska,webm", "duration": "5400.000000"}
+}`
+
+func parsedInfo(t *testing.T) *MediaInfo {
+	info, err := parseProbeOutput([]byte(probeOutput))
+	assert.NoError(t, err)
+	return info
+}
+
+func TestParseProbeOutput(t *testing.T) {
+	info := parsedInfo(t)
+
+	assert.Equal(t, "5400.000000", info.Format.Duration)
+	assert.Len(t, info.StreamsOfType("video"), 1)
+	assert.Equal(t, "eng", info.StreamsOfType("audio")[0].Tags.Language)
+}
+
+func TestDecideRemuxForCompatibleStreams(t *testing.T) {
+	assert.Equal(t, Remux, Decide(parsedInfo(t), DefaultProfile))
+}
+
+func TestDecideSkipForMatchingFile(t *testing.T) {
+	info := parsedInfo(t)
+	info.Format.Filename = "/media/movie.mp4"
+	info.Format.FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+
+	assert.Equal(t, Skip, Decide(info, DefaultProfile))
+}
+
+func TestDecideAudioTranscode(t *testing.T) {
+	info := parsedInfo(t)
+	info.Streams[1].CodecName = "dts"
+
+	assert.Equal(t, AudioTranscode, Decide(info, DefaultProfile))
+}
+
+func TestDecideIgnoresDroppedAudio(t *testing.T) {
+	info := parsedInfo(t)
+	info.Streams = append(info.Streams, ProbeStream{Index: 3, CodecName: "dts", CodecType: "audio"})
+	info.Streams[3].Tags.Language = "fre"
+	profile := DefaultProfile
+	profile.Streams = StreamRules{AudioLanguages: []string{"eng"}}
+
+	assert.Equal(t, Remux, Decide(info, profile))
+	assert.Equal(t, AudioTranscode, Decide(info, DefaultProfile))
+}
+
+func TestDecideFullTranscodeForVideoCodec(t *testing.T) {
+	info := parsedInfo(t)
+	info.Streams[0].CodecName = "hevc"
+
+	assert.Equal(t, FullTranscode, Decide(info, DefaultProfile))
+}
+
+func TestDecideFullTranscodeForPixelFormat(t *testing.T) {
+	profile := DefaultProfile
+	profile.PixelFormat = "yuv420p"
+	info := parsedInfo(t)
+	info.Streams[0].PixFmt = "yuv420p10le"
+
+	assert.Equal(t, FullTranscode, Decide(info, profile))
+}
+
+type mockProber struct {
+	info *MediaInfo
+	err  error
+}
+
+func (m mockProber) Probe(path string) (*MediaInfo, error) {
+	return m.info, m.err
+}
+
+func TestAnalyzerReturnsProbeError(t *testing.T) {
+	_, _, err := NewAnalyzer(mockProber{err: errors.New("boom")}).Analyze("/media/movie.mkv", DefaultProfile)
+
+	assert.Error(t, err)
+}
+
+func TestAnalyzerDecides(t *testing.T) {
+	decision, info, err := NewAnalyzer(mockProber{info: parsedInfo(t)}).Analyze("/media/movie.mkv", DefaultProfile)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, info)
+	assert.Equal(t, Remux, decision)
+}
+
+func TestDecideSkipForMatchingOpus(t *testing.T) {
+	info := &MediaInfo{
+		Format:  ProbeFormat{Filename: "/music/track.opus", FormatName: "ogg"},
+		Streams: []ProbeStream{{Index: 0, CodecName: "opus", CodecType: "audio"}},
+	}
+
+	assert.Equal(t, Skip, Decide(info, MusicProfile))
+}
+
+func TestDecideIgnoresCoverArtForAudioProfiles(t *testing.T) {
+	info := &MediaInfo{
+		Format: ProbeFormat{Filename: "/music/track.opus", FormatName: "ogg"},
+		Streams: []ProbeStream{
+			{Index: 0, CodecName: "opus", CodecType: "audio"},
+			// Cover art that lost its attached_pic disposition
+			{Index: 1, CodecName: "mjpeg", CodecType: "video"},
+		},
+	}
+
+	assert.Equal(t, Skip, Decide(info, MusicProfile))
+}
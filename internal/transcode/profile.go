@@ -0,0 +1,370 @@
+package transcode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"media-web/internal/config"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfileName is the profile used when nothing else is configured
+const DefaultProfileName = "default"
+
+// MusicProfileName is the built in profile for music
+const MusicProfileName = "music"
+
+// Profile describes how a file should be encoded by ffmpeg
+type Profile struct {
+	Name         string      `yaml:"-" json:"name"`
+	VideoCodec   string      `yaml:"videoCodec" json:"videoCodec"`
+	Preset       string      `yaml:"preset" json:"preset"`
+	Crf          uint32      `yaml:"crf" json:"crf"`
+	VideoBitrate string      `yaml:"videoBitrate" json:"videoBitrate"`
+	PixelFormat  string      `yaml:"pixelFormat" json:"pixelFormat"`
+	AudioCodec   string      `yaml:"audioCodec" json:"audioCodec"`
+	AudioBitrate string      `yaml:"audioBitrate" json:"audioBitrate"`
+	Tune         string      `yaml:"tune" json:"tune"`
+	Container    string      `yaml:"container" json:"container"`
+	ExtraArgs    []string    `yaml:"extraArgs" json:"extraArgs"`
+	Streams      StreamRules `yaml:"streams" json:"streams"`
+	// CPUClass limits the profile to worker classes advertising this CPU class
+	CPUClass string `yaml:"cpuClass" json:"cpuClass,omitempty"`
+}
+
+type profileFile struct {
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+type container struct {
+	format    string
+	extension string
+	// probeFormat is the name ffprobe reports for the container when it differs from the muxer name
+	probeFormat string
+	// audioCodec is the encoder used when the profile doesn't set one
+	audioCodec string
+	// audioOnly containers hold music. The profile needs no video codec and only audio and cover art are kept
+	audioOnly bool
+	// coverArt is set when the container can hold the album art of the source
+	coverArt  bool
+	muxerArgs []string
+}
+
+// containers maps the container names accepted in a profile to the ffmpeg muxer and file extension
+var containers = map[string]container{
+	"mp4":      {format: "mp4", extension: ".mp4", audioCodec: "aac"},
+	"mkv":      {format: "matroska", extension: ".mkv", audioCodec: "aac"},
+	"matroska": {format: "matroska", extension: ".mkv", audioCodec: "aac"},
+	"mov":      {format: "mov", extension: ".mov", audioCodec: "aac"},
+	"opus":     {format: "opus", extension: ".opus", probeFormat: "ogg", audioCodec: "libopus", audioOnly: true},
+	"m4a":      {format: "ipod", extension: ".m4a", probeFormat: "m4a", audioCodec: "aac", audioOnly: true, coverArt: true},
+	"mp3": {format: "mp3", extension: ".mp3", audioCodec: "libmp3lame", audioOnly: true, coverArt: true,
+		muxerArgs: []string{"-id3v2_version", "3"}},
+}
+
+// DefaultProfile matches the options the transcoder used before profiles were configurable
+var DefaultProfile = Profile{
+	Name:       DefaultProfileName,
+	VideoCodec: "libx264",
+	Preset:     "veryfast",
+	Crf:        23,
+	Tune:       "film",
+	AudioCodec: "aac",
+	Container:  "mp4",
+}
+
+// MusicProfile encodes music to Opus, which is transparent for most listeners at this bitrate
+var MusicProfile = Profile{
+	Name:         MusicProfileName,
+	AudioCodec:   "libopus",
+	AudioBitrate: "128k",
+	Container:    "opus",
+}
+
+// Format returns the ffmpeg muxer name for the profile's container
+func (p Profile) Format() string {
+	return containers[p.Container].format
+}
+
+// ProbeFormat returns the name ffprobe reports for the profile's container
+func (p Profile) ProbeFormat() string {
+	if c := containers[p.Container]; c.probeFormat != "" {
+		return c.probeFormat
+	}
+	return p.Format()
+}
+
+// IsAudio reports whether the profile is for music, encoding only audio
+func (p Profile) IsAudio() bool {
+	return containers[p.Container].audioOnly
+}
+
+// Extension returns the file extension, including the dot, for the profile's container
+func (p Profile) Extension() string {
+	return containers[p.Container].extension
+}
+
+func (p Profile) validate() error {
+	if _, ok := containers[p.Container]; !ok {
+		return errors.New("profile " + p.Name + " has unsupported container: " + p.Container)
+	}
+	if p.VideoCodec == "" && !p.IsAudio() {
+		return errors.New("profile " + p.Name + " has no videoCodec")
+	}
+	if p.Crf != 0 && p.VideoBitrate != "" {
+		return errors.New("profile " + p.Name + " sets both crf and videoBitrate")
+	}
+	return nil
+}
+
+// Options builds the ffmpeg arguments for a profile.
+// The decision controls which streams are re-encoded and which are copied as is. When the probed
+// media info is set streams are mapped explicitly following the profile's stream rules
+type Options struct {
+	Profile  Profile
+	Decision Decision
+	Info     *MediaInfo
+	// Chunk encodes only the video of one chunk of the source
+	Chunk *Chunk
+	// ChunkList is the concat list of encoded chunks. Their video is copied into the output along with the other
+	// streams of the source
+	ChunkList string
+}
+
+// InputArguments are the ffmpeg arguments reading the source. A chunk seeks to its start and assembling chunks
+// reads the encoded video from the concat list before the source
+func (o Options) InputArguments(input string) []string {
+	if o.Chunk != nil && o.Chunk.Start > 0 {
+		return []string{"-ss", seconds(o.Chunk.Start), "-i", input}
+	}
+	if o.ChunkList != "" {
+		return []string{"-f", "concat", "-safe", "0", "-i", o.ChunkList, "-i", input}
+	}
+	return []string{"-i", input}
+}
+
+// sourceInput is the ffmpeg input index of the source
+func (o Options) sourceInput() int {
+	if o.ChunkList != "" {
+		return 1
+	}
+	return 0
+}
+
+// Duration is how much of the source the encode covers, or 0 when that isn't known
+func (o Options) Duration() time.Duration {
+	if o.Chunk != nil {
+		return time.Duration(o.Chunk.Duration() * float64(time.Second))
+	}
+	if o.Info == nil {
+		return 0
+	}
+	duration, err := parseDuration(o.Info)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+func (o Options) GetStrArguments() []string {
+	if o.Profile.IsAudio() {
+		return o.audioArguments()
+	}
+	if o.Chunk != nil {
+		return o.chunkArguments()
+	}
+	var args []string
+	if o.Decision == Remux || o.Decision == AudioTranscode || o.ChunkList != "" {
+		args = []string{"-c:v", "copy"}
+	} else {
+		args = o.videoArguments()
+	}
+	if o.ChunkList != "" {
+		// The concat list has no metadata or chapters of its own
+		args = append(args, "-map_metadata", "1", "-map_chapters", "1")
+	}
+
+	if o.Info != nil {
+		args = append(args, o.mapArguments()...)
+	} else if o.Decision == Remux {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", o.Profile.AudioCodec)
+	}
+	if o.ChunkList != "" {
+		// The chunks were encoded with the video options and their video is copied, which can't be filtered
+		_, extraArgs := o.Profile.splitExtraArgs()
+		args = append(args, extraArgs...)
+	} else {
+		args = append(args, o.Profile.ExtraArgs...)
+	}
+	return append(args, "-f", o.Profile.Format())
+}
+
+// videoEncodingOptions are the ffmpeg options that only act when encoding video, and can't be used when it is copied
+var videoEncodingOptions = map[string]bool{
+	"vf": true, "filter": true, "r": true, "s": true, "pix_fmt": true, "preset": true, "tune": true, "crf": true,
+	"b": true, "vb": true, "q": true, "qscale": true, "maxrate": true, "minrate": true, "bufsize": true,
+	"profile": true, "level": true, "g": true, "keyint_min": true, "bf": true, "refs": true, "sc_threshold": true,
+	"x264-params": true, "x264opts": true, "x265-params": true, "svtav1-params": true,
+	"color_primaries": true, "color_trc": true, "colorspace": true, "color_range": true, "vsync": true, "fps_mode": true,
+}
+
+// isVideoEncodingOption reports whether an ffmpeg option only acts when encoding video. An option with a stream
+// specifier only does when the specifier selects video streams
+func isVideoEncodingOption(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	name := arg[1:]
+	specifier := ""
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, specifier = name[:i], name[i+1:]
+	}
+	return videoEncodingOptions[name] && (specifier == "" || strings.HasPrefix(specifier, "v"))
+}
+
+// splitExtraArgs separates the video encoding options of ExtraArgs and their values from the other arguments
+func (p Profile) splitExtraArgs() (video []string, other []string) {
+	for i := 0; i < len(p.ExtraArgs); i++ {
+		if !isVideoEncodingOption(p.ExtraArgs[i]) {
+			other = append(other, p.ExtraArgs[i])
+			continue
+		}
+		video = append(video, p.ExtraArgs[i])
+		if i+1 < len(p.ExtraArgs) {
+			video = append(video, p.ExtraArgs[i+1])
+			i++
+		}
+	}
+	return video, other
+}
+
+func (o Options) videoArguments() []string {
+	p := o.Profile
+	args := []string{"-c:v", p.VideoCodec}
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	if p.Tune != "" {
+		args = append(args, "-tune", p.Tune)
+	}
+	if p.Crf != 0 {
+		args = append(args, "-crf", fmt.Sprint(p.Crf))
+	}
+	if p.VideoBitrate != "" {
+		args = append(args, "-b:v", p.VideoBitrate)
+	}
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt", p.PixelFormat)
+	}
+	return args
+}
+
+// audioArguments encodes the audio and keeps the tags and, where the container allows it, the cover art
+func (o Options) audioArguments() []string {
+	var args []string
+	if o.Info != nil {
+		args = o.mapArguments()
+	} else {
+		codec := o.Profile.AudioCodec
+		if o.Decision == Remux {
+			codec = "copy"
+		}
+		args = []string{"-map", "0:a", "-c:a", codec}
+		if codec != "copy" && o.Profile.AudioBitrate != "" {
+			args = append(args, "-b:a", o.Profile.AudioBitrate)
+		}
+	}
+	args = append(args, "-map_metadata", "0")
+	args = append(args, containers[o.Profile.Container].muxerArgs...)
+	args = append(args, o.Profile.ExtraArgs...)
+	return append(args, "-f", o.Profile.Format())
+}
+
+// Profiles is the set of named transcode profiles
+type Profiles map[string]Profile
+
+// Get looks up a profile by name
+func (p Profiles) Get(name string) (Profile, error) {
+	profile, ok := p[name]
+	if !ok {
+		return Profile{}, errors.New("unknown transcode profile: " + name)
+	}
+	return profile, nil
+}
+
+// Names returns the sorted profile names
+func (p Profiles) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfiles reads profiles from a YAML or JSON file. The built in default and music profiles are
+// always available unless the file overrides them
+func LoadProfiles(path string) (Profiles, error) {
+	profiles := Profiles{DefaultProfileName: DefaultProfile, MusicProfileName: MusicProfile}
+	if path == "" {
+		return profiles, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read transcode profiles")
+	}
+
+	var file profileFile
+	// YAML is a superset of JSON so this handles both formats
+	if err = yaml.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to parse transcode profiles")
+	}
+
+	for name, profile := range file.Profiles {
+		profile.Name = name
+		if profile.Container == "" {
+			profile.Container = "mp4"
+		}
+		if profile.AudioCodec == "" {
+			profile.AudioCodec = containers[profile.Container].audioCodec
+		}
+		if err = profile.validate(); err != nil {
+			return nil, err
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+func loadConfiguredProfiles() Profiles {
+	cfg := config.GetConfig()
+	profiles, err := LoadProfiles(cfg.TranscodeProfilesPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load transcode profiles")
+	}
+	names := []string{cfg.DefaultTVProfile, cfg.DefaultMovieProfile, cfg.DefaultMusicProfile}
+	for _, instance := range append(cfg.RadarrInstances, cfg.SonarrInstances...) {
+		names = append(names, instance.DefaultProfile)
+	}
+	for _, name := range names {
+		if _, err = profiles.Get(name); err != nil {
+			log.Fatal().Err(err).Msg("Default transcode profile is not defined")
+		}
+	}
+	return profiles
+}
+
+var profiles = loadConfiguredProfiles()
+
+// GetProfiles returns the profiles loaded from the configured profile file
+func GetProfiles() Profiles {
+	return profiles
+}
@@ -0,0 +1,119 @@
+package transcode
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeProfiles(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	assert.NoError(t, err)
+	return path
+}
+
+func TestLoadProfilesWithoutFileReturnsDefault(t *testing.T) {
+	profiles, err := LoadProfiles("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DefaultProfileName, MusicProfileName}, profiles.Names())
+	assert.Equal(t, ".mp4", profiles[DefaultProfileName].Extension())
+}
+
+func TestLoadProfilesFromYaml(t *testing.T) {
+	path := writeProfiles(t, "profiles.yaml", `
+profiles:
+  hevc:
+    videoCodec: libx265
+    preset: medium
+    crf: 20
+    container: mkv
+    extraArgs: ["-tag:v", "hvc1"]
+`)
+	profiles, err := LoadProfiles(path)
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{DefaultProfileName, "hevc", MusicProfileName}, profiles.Names())
+	hevc, err := profiles.Get("hevc")
+	assert.NoError(t, err)
+	assert.Equal(t, "hevc", hevc.Name)
+	assert.Equal(t, ".mkv", hevc.Extension())
+	assert.Equal(t, []string{"-c:v", "libx265", "-preset", "medium", "-crf", "20", "-c:a", "aac", "-tag:v", "hvc1", "-f", "matroska"},
+		Options{Profile: hevc}.GetStrArguments())
+}
+
+func TestLoadProfilesFromJson(t *testing.T) {
+	path := writeProfiles(t, "profiles.json", `{"profiles": {"tv": {"videoCodec": "libx264", "videoBitrate": "2M"}}}`)
+	profiles, err := LoadProfiles(path)
+
+	assert.NoError(t, err)
+	tv, err := profiles.Get("tv")
+	assert.NoError(t, err)
+	assert.Equal(t, "mp4", tv.Container)
+	assert.Equal(t, []string{"-c:v", "libx264", "-b:v", "2M", "-c:a", "aac", "-f", "mp4"}, Options{Profile: tv}.GetStrArguments())
+}
+
+func TestLoadProfilesRejectsInvalidProfile(t *testing.T) {
+	path := writeProfiles(t, "profiles.yaml", `
+profiles:
+  broken:
+    videoCodec: libx264
+    container: avi
+`)
+	_, err := LoadProfiles(path)
+
+	assert.Error(t, err)
+}
+
+func TestGetUnknownProfileReturnsError(t *testing.T) {
+	_, err := Profiles{}.Get("missing")
+
+	assert.Error(t, err)
+}
+
+func TestDefaultProfileArguments(t *testing.T) {
+	assert.Equal(t, []string{"-c:v", "libx264", "-preset", "veryfast", "-tune", "film", "-crf", "23", "-c:a", "aac", "-f", "mp4"},
+		Options{Profile: DefaultProfile}.GetStrArguments())
+}
+
+func TestRemuxArguments(t *testing.T) {
+	assert.Equal(t, []string{"-c:v", "copy", "-c:a", "copy", "-f", "mp4"},
+		Options{Profile: DefaultProfile, Decision: Remux}.GetStrArguments())
+}
+
+func TestAudioTranscodeArguments(t *testing.T) {
+	assert.Equal(t, []string{"-c:v", "copy", "-c:a", "aac", "-f", "mp4"},
+		Options{Profile: DefaultProfile, Decision: AudioTranscode}.GetStrArguments())
+}
+
+func TestLoadAudioProfile(t *testing.T) {
+	path := writeProfiles(t, "profiles.yaml", `
+profiles:
+  mp3:
+    container: mp3
+    audioBitrate: 320k
+`)
+	profiles, err := LoadProfiles(path)
+
+	assert.NoError(t, err)
+	mp3, err := profiles.Get("mp3")
+	assert.NoError(t, err)
+	assert.True(t, mp3.IsAudio())
+	assert.Equal(t, "libmp3lame", mp3.AudioCodec)
+	assert.Equal(t, []string{"-map", "0:a", "-c:a", "libmp3lame", "-b:a", "320k", "-map_metadata", "0", "-id3v2_version", "3", "-f", "mp3"},
+		Options{Profile: mp3, Decision: AudioTranscode}.GetStrArguments())
+}
+
+func TestVideoProfileRequiresVideoCodec(t *testing.T) {
+	path := writeProfiles(t, "profiles.yaml", `
+profiles:
+  broken:
+    container: mkv
+`)
+	_, err := LoadProfiles(path)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,260 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StreamRules control which streams from the source end up in the output
+type StreamRules struct {
+	// AudioLanguages keeps only audio tracks in these languages. Untagged tracks are always kept and
+	// if no track matches every track is kept rather than producing a silent file
+	AudioLanguages []string `yaml:"audioLanguages" json:"audioLanguages"`
+	// StereoFallback adds an AAC stereo track after every surround track
+	StereoFallback bool `yaml:"stereoFallback" json:"stereoFallback"`
+	// ConvertTextSubtitles keeps text subtitles, converting them to mov_text for mp4 and mov containers
+	ConvertTextSubtitles bool `yaml:"convertTextSubtitles" json:"convertTextSubtitles"`
+	// ExtractImageSubtitles writes PGS and VobSub subtitles to sidecar files next to the output
+	ExtractImageSubtitles bool `yaml:"extractImageSubtitles" json:"extractImageSubtitles"`
+}
+
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// imageSubtitleExtensions maps image based subtitle codecs to the sidecar extension they are extracted to.
+// ffmpeg can't write VobSub idx/sub pairs so those are stored in a subtitle only matroska file
+var imageSubtitleExtensions = map[string]string{
+	"hdmv_pgs_subtitle": ".sup",
+	"dvd_subtitle":      ".mks",
+	"dvb_subtitle":      ".mks",
+}
+
+func isTextSubtitle(stream ProbeStream) bool {
+	return textSubtitleCodecs[stream.CodecName]
+}
+
+func isImageSubtitle(stream ProbeStream) bool {
+	_, ok := imageSubtitleExtensions[stream.CodecName]
+	return ok
+}
+
+func language(stream ProbeStream) string {
+	if stream.Tags.Language == "" {
+		return "und"
+	}
+	return stream.Tags.Language
+}
+
+func (r StreamRules) keepsLanguage(stream ProbeStream) bool {
+	if len(r.AudioLanguages) == 0 || language(stream) == "und" {
+		return true
+	}
+	for _, lang := range r.AudioLanguages {
+		if strings.EqualFold(lang, stream.Tags.Language) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r StreamRules) selectAudio(info *MediaInfo) []ProbeStream {
+	audio := info.StreamsOfType("audio")
+	selected := make([]ProbeStream, 0, len(audio))
+	for _, stream := range audio {
+		if r.keepsLanguage(stream) {
+			selected = append(selected, stream)
+		}
+	}
+	if len(selected) == 0 {
+		return audio
+	}
+	return selected
+}
+
+// StreamCounts is the number of streams of each type in a file
+type StreamCounts struct {
+	Video    int
+	Audio    int
+	Subtitle int
+}
+
+// Counts counts the streams of each type. Cover art is mapped as a video stream and not every container keeps its
+// attached_pic disposition, so it counts as video here
+func (m MediaInfo) Counts() StreamCounts {
+	counts := StreamCounts{}
+	for _, stream := range m.Streams {
+		switch stream.CodecType {
+		case "video":
+			counts.Video++
+		case "audio":
+			counts.Audio++
+		case "subtitle":
+			counts.Subtitle++
+		}
+	}
+	return counts
+}
+
+// mapArguments builds explicit -map arguments so every wanted stream is kept rather than ffmpeg's default of
+// one stream per type. Codecs are chosen per stream so compatible tracks are copied
+func (o Options) mapArguments() []string {
+	args, _ := o.streamPlan()
+	return args
+}
+
+// ExpectedStreams returns the stream counts the output should have, or nil when streams aren't mapped explicitly
+func (o Options) ExpectedStreams() *StreamCounts {
+	if o.Info == nil {
+		return nil
+	}
+	_, counts := o.streamPlan()
+	return &counts
+}
+
+func (o Options) streamPlan() ([]string, StreamCounts) {
+	if o.Profile.IsAudio() {
+		return o.audioStreamPlan()
+	}
+	info := o.Info
+	rules := o.Profile.Streams
+	args := make([]string, 0)
+	counts := StreamCounts{}
+	source := o.sourceInput()
+
+	for _, stream := range info.StreamsOfType("video") {
+		if o.ChunkList != "" {
+			// Chunks hold the first video stream only
+			args = append(args, "-map", "0:v:0")
+			counts.Video++
+			break
+		}
+		args = append(args, "-map", fmt.Sprintf("0:%d", stream.Index))
+		counts.Video++
+	}
+
+	for _, stream := range rules.selectAudio(info) {
+		codec := "copy"
+		if stream.CodecName != CodecName(o.Profile.AudioCodec) {
+			codec = o.Profile.AudioCodec
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:%d", source, stream.Index), fmt.Sprintf("-c:a:%d", counts.Audio), codec)
+		counts.Audio++
+
+		if rules.StereoFallback && stream.Channels > 2 {
+			args = append(args, "-map", fmt.Sprintf("%d:%d", source, stream.Index),
+				fmt.Sprintf("-c:a:%d", counts.Audio), "aac",
+				fmt.Sprintf("-ac:a:%d", counts.Audio), "2",
+				fmt.Sprintf("-metadata:s:a:%d", counts.Audio), "title=Stereo",
+				fmt.Sprintf("-disposition:a:%d", counts.Audio), "0")
+			counts.Audio++
+		}
+	}
+
+	for _, stream := range info.StreamsOfType("subtitle") {
+		codec := ""
+		if isTextSubtitle(stream) && rules.ConvertTextSubtitles {
+			codec = "mov_text"
+			if o.Profile.Format() == "matroska" {
+				codec = "copy"
+			}
+		} else if isImageSubtitle(stream) && o.Profile.Format() == "matroska" {
+			codec = "copy"
+		}
+		if codec == "" {
+			continue
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:%d", source, stream.Index), fmt.Sprintf("-c:s:%d", counts.Subtitle), codec)
+		counts.Subtitle++
+	}
+	return args, counts
+}
+
+// audioStreamPlan maps every audio stream and the cover art when the container can hold it. Music has no
+// language rules or subtitles
+func (o Options) audioStreamPlan() ([]string, StreamCounts) {
+	args := make([]string, 0)
+	counts := StreamCounts{}
+
+	for _, stream := range o.Info.StreamsOfType("audio") {
+		codec := "copy"
+		if stream.CodecName != CodecName(o.Profile.AudioCodec) {
+			codec = o.Profile.AudioCodec
+		}
+		args = append(args, "-map", fmt.Sprintf("0:%d", stream.Index), fmt.Sprintf("-c:a:%d", counts.Audio), codec)
+		if codec != "copy" && o.Profile.AudioBitrate != "" {
+			args = append(args, fmt.Sprintf("-b:a:%d", counts.Audio), o.Profile.AudioBitrate)
+		}
+		counts.Audio++
+	}
+
+	if containers[o.Profile.Container].coverArt {
+		for _, stream := range o.Info.Streams {
+			if stream.Disposition.AttachedPic == 1 {
+				args = append(args, "-map", fmt.Sprintf("0:%d", stream.Index), "-c:v", "copy", "-disposition:v:0", "attached_pic")
+				counts.Video++
+				break
+			}
+		}
+	}
+	return args, counts
+}
+
+// SubtitleSidecar is an image subtitle stream extracted next to the output file
+type SubtitleSidecar struct {
+	Stream ProbeStream
+	Path   string
+}
+
+// ImageSubtitleSidecars lists the sidecar files image subtitles would be extracted to. outputBase is the output
+// path without its extension
+func ImageSubtitleSidecars(info *MediaInfo, outputBase string) []SubtitleSidecar {
+	sidecars := make([]SubtitleSidecar, 0)
+	for _, stream := range info.StreamsOfType("subtitle") {
+		if !isImageSubtitle(stream) {
+			continue
+		}
+		path := fmt.Sprintf("%s.%s.%d%s", outputBase, language(stream), stream.Index, imageSubtitleExtensions[stream.CodecName])
+		sidecars = append(sidecars, SubtitleSidecar{Stream: stream, Path: path})
+	}
+	return sidecars
+}
+
+func extractArguments(input string, sidecars []SubtitleSidecar) []string {
+	args := []string{"-nostdin", "-v", "error", "-y", "-i", input}
+	for _, sidecar := range sidecars {
+		args = append(args, "-map", fmt.Sprintf("0:%d", sidecar.Stream.Index), "-c", "copy", sidecar.Path)
+	}
+	return args
+}
+
+// ExtractImageSubtitles copies image based subtitle streams into sidecar files with a single ffmpeg run at the given
+// priority. Cancelling ctx kills ffmpeg and the context's error is returned
+func ExtractImageSubtitles(ctx context.Context, ffmpegPath string, priority Priority, input string, sidecars []SubtitleSidecar) error {
+	if len(sidecars) == 0 {
+		return nil
+	}
+	var stderr bytes.Buffer
+	name, args := priority.command(ffmpegPath, extractArguments(input, sidecars))
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to extract subtitles: "+stderr.String())
+	}
+	return nil
+}
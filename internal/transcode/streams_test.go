@@ -0,0 +1,167 @@
+package transcode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const multiStreamOutput = `{
+    "streams": [
+        {"index": 0, "codec_name": "h264", "codec_type": "video"},
+        {"index": 1, "codec_name": "dts", "codec_type": "audio", "channels": 6, "tags": {"language": "eng"}},
+        {"index": 2, "codec_name": "aac", "codec_type": "audio", "channels": 2, "tags": {"language": "fre"}},
+        {"index": 3, "codec_name": "aac", "codec_type": "audio", "channels": 2},
+        {"index": 4, "codec_name": "subrip", "codec_type": "subtitle", "tags": {"language": "eng"}},
+        {"index": 5, "codec_name": "hdmv_pgs_subtitle", "codec_type": "subtitle", "tags": {"language": "eng"}},
+        {"index": 6, "codec_name": "dvd_subtitle", "codec_type": "subtitle"}
+    ],
+    "format": {"filename": "/media/movie.mkv", "format_name": "matroska,webm"}
+}`
+
+func multiStreamInfo(t *testing.T) *MediaInfo {
+	info, err := parseProbeOutput([]byte(multiStreamOutput))
+	assert.NoError(t, err)
+	return info
+}
+
+func TestMapKeepsAllStreamsWithoutRules(t *testing.T) {
+	args := Options{Profile: DefaultProfile, Decision: AudioTranscode, Info: multiStreamInfo(t)}.GetStrArguments()
+
+	assert.Equal(t, []string{"-c:v", "copy",
+		"-map", "0:0",
+		"-map", "0:1", "-c:a:0", "aac",
+		"-map", "0:2", "-c:a:1", "copy",
+		"-map", "0:3", "-c:a:2", "copy",
+		"-f", "mp4"}, args)
+}
+
+func TestMapFiltersAudioLanguagesAndAddsStereo(t *testing.T) {
+	profile := DefaultProfile
+	profile.Streams = StreamRules{AudioLanguages: []string{"eng"}, StereoFallback: true}
+
+	args := Options{Profile: profile, Decision: AudioTranscode, Info: multiStreamInfo(t)}.GetStrArguments()
+
+	assert.Equal(t, []string{"-c:v", "copy",
+		"-map", "0:0",
+		"-map", "0:1", "-c:a:0", "aac",
+		"-map", "0:1", "-c:a:1", "aac", "-ac:a:1", "2", "-metadata:s:a:1", "title=Stereo", "-disposition:a:1", "0",
+		"-map", "0:3", "-c:a:2", "copy",
+		"-f", "mp4"}, args)
+}
+
+func TestMapKeepsAllAudioWhenNoLanguageMatches(t *testing.T) {
+	info := multiStreamInfo(t)
+	info.Streams = info.Streams[:3]
+	rules := StreamRules{AudioLanguages: []string{"ger"}}
+
+	assert.Len(t, rules.selectAudio(info), 2)
+}
+
+func TestMapConvertsTextSubtitlesForMp4(t *testing.T) {
+	profile := DefaultProfile
+	profile.Streams = StreamRules{ConvertTextSubtitles: true}
+	info := multiStreamInfo(t)
+	info.Streams = []ProbeStream{info.Streams[0], info.Streams[4], info.Streams[5]}
+
+	args := Options{Profile: profile, Decision: Remux, Info: info}.GetStrArguments()
+
+	assert.Equal(t, []string{"-c:v", "copy", "-map", "0:0", "-map", "0:4", "-c:s:0", "mov_text", "-f", "mp4"}, args)
+}
+
+func TestMapCopiesSubtitlesForMatroska(t *testing.T) {
+	profile := DefaultProfile
+	profile.Container = "mkv"
+	profile.Streams = StreamRules{ConvertTextSubtitles: true}
+	info := multiStreamInfo(t)
+	info.Streams = []ProbeStream{info.Streams[0], info.Streams[4], info.Streams[5]}
+
+	args := Options{Profile: profile, Decision: Remux, Info: info}.GetStrArguments()
+
+	assert.Equal(t, []string{"-c:v", "copy", "-map", "0:0", "-map", "0:4", "-c:s:0", "copy",
+		"-map", "0:5", "-c:s:1", "copy", "-f", "matroska"}, args)
+}
+
+func TestImageSubtitleSidecars(t *testing.T) {
+	sidecars := ImageSubtitleSidecars(multiStreamInfo(t), "/media/movie")
+
+	assert.Len(t, sidecars, 2)
+	assert.Equal(t, "/media/movie.eng.5.sup", sidecars[0].Path)
+	assert.Equal(t, "/media/movie.und.6.mks", sidecars[1].Path)
+	assert.Equal(t, []string{"-nostdin", "-v", "error", "-y", "-i", "/media/movie.mkv",
+		"-map", "0:5", "-c", "copy", "/media/movie.eng.5.sup",
+		"-map", "0:6", "-c", "copy", "/media/movie.und.6.mks"}, extractArguments("/media/movie.mkv", sidecars))
+}
+
+func TestExtractImageSubtitlesStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := ExtractImageSubtitles(ctx, fakeFfmpeg(t, "exec sleep 10"), Priority{Nice: 5}, "/media/movie.mkv",
+		ImageSubtitleSidecars(multiStreamInfo(t), "/media/movie"))
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestExpectedStreams(t *testing.T) {
+	profile := DefaultProfile
+	profile.Streams = StreamRules{StereoFallback: true, ConvertTextSubtitles: true}
+
+	counts := Options{Profile: profile, Info: multiStreamInfo(t)}.ExpectedStreams()
+
+	assert.Equal(t, &StreamCounts{Video: 1, Audio: 4, Subtitle: 1}, counts)
+	assert.Nil(t, Options{Profile: profile}.ExpectedStreams())
+}
+
+const flacOutput = `{
+    "streams": [
+        {"index": 0, "codec_name": "flac", "codec_type": "audio", "channels": 2},
+        {"index": 1, "codec_name": "mjpeg", "codec_type": "video", "disposition": {"default": 0, "attached_pic": 1}}
+    ],
+    "format": {"filename": "/music/01 - Track.flac", "format_name": "flac", "duration": "215.000000"}
+}`
+
+func TestMapMusicKeepsCoverArtAndTags(t *testing.T) {
+	info, err := parseProbeOutput([]byte(flacOutput))
+	assert.NoError(t, err)
+	profile := Profile{Name: "mp3", Container: "mp3", AudioCodec: "libmp3lame", AudioBitrate: "320k"}
+
+	opts := Options{Profile: profile, Decision: Decide(info, profile), Info: info}
+
+	assert.Equal(t, AudioTranscode, opts.Decision)
+	assert.Equal(t, []string{
+		"-map", "0:0", "-c:a:0", "libmp3lame", "-b:a:0", "320k",
+		"-map", "0:1", "-c:v", "copy", "-disposition:v:0", "attached_pic",
+		"-map_metadata", "0", "-id3v2_version", "3", "-f", "mp3"}, opts.GetStrArguments())
+	assert.Equal(t, &StreamCounts{Video: 1, Audio: 1}, opts.ExpectedStreams())
+}
+
+func TestVerifyMusicWithCoverArt(t *testing.T) {
+	info, err := parseProbeOutput([]byte(flacOutput))
+	assert.NoError(t, err)
+	profile := Profile{Name: "mp3", Container: "mp3", AudioCodec: "libmp3lame", AudioBitrate: "320k"}
+	opts := Options{Profile: profile, Decision: Decide(info, profile), Info: info}
+	output, err := parseProbeOutput([]byte(`{
+    "streams": [
+        {"index": 0, "codec_name": "mp3", "codec_type": "audio", "channels": 2},
+        {"index": 1, "codec_name": "mjpeg", "codec_type": "video", "disposition": {"default": 0, "attached_pic": 0}}
+    ],
+    "format": {"filename": "/music/01 - Track.mp3", "format_name": "mp3", "duration": "215.020000"}
+}`))
+	assert.NoError(t, err)
+
+	err = NewVerifier(mockProber{info: output}, "", Priority{}, time.Second, false).Verify(context.Background(), info, "/music/01 - Track.mp3", opts.ExpectedStreams())
+
+	assert.NoError(t, err)
+}
+
+func TestMapMusicDropsCoverArtForOpus(t *testing.T) {
+	info, err := parseProbeOutput([]byte(flacOutput))
+	assert.NoError(t, err)
+
+	args := Options{Profile: MusicProfile, Decision: AudioTranscode, Info: info}.GetStrArguments()
+
+	assert.Equal(t, []string{"-map", "0:0", "-c:a:0", "libopus", "-b:a:0", "128k", "-map_metadata", "0", "-f", "opus"}, args)
+}
@@ -0,0 +1,95 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"media-web/internal/config"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Verifier checks that a finished encode is complete before it replaces the original. Cancelling ctx stops the
+// decode and Verify returns the context's error
+type Verifier interface {
+	Verify(ctx context.Context, source *MediaInfo, output string, expected *StreamCounts) error
+}
+
+type verifierImpl struct {
+	prober     Prober
+	ffmpegPath string
+	priority   Priority
+	tolerance  time.Duration
+	decode     bool
+}
+
+// NewVerifier creates a Verifier. The output duration must be within tolerance of the source and when decode
+// is set the whole output is decoded with the given priority to catch corrupt streams
+func NewVerifier(prober Prober, ffmpegPath string, priority Priority, tolerance time.Duration, decode bool) Verifier {
+	return verifierImpl{prober: prober, ffmpegPath: ffmpegPath, priority: priority, tolerance: tolerance, decode: decode}
+}
+
+// GetVerifier returns a Verifier using the configured binaries, priority and tolerances
+func GetVerifier() Verifier {
+	cfg := config.GetConfig()
+	return NewVerifier(FfprobeProber{Path: cfg.FfprobePath}, cfg.FfmpegPath, GetPriority(), cfg.VerifyDurationTolerance, cfg.VerifyDecode)
+}
+
+func parseDuration(info *MediaInfo) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(info.Format.Duration, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid duration: "+info.Format.Duration)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (v verifierImpl) Verify(ctx context.Context, source *MediaInfo, output string, expected *StreamCounts) error {
+	info, err := v.prober.Probe(output)
+	if err != nil {
+		return err
+	}
+
+	if source != nil {
+		if err = v.checkDuration(source, info); err != nil {
+			return err
+		}
+	}
+
+	if expected != nil && info.Counts() != *expected {
+		return fmt.Errorf("output streams %+v do not match expected %+v", info.Counts(), *expected)
+	}
+
+	if v.decode {
+		var stderr bytes.Buffer
+		name, args := v.priority.command(v.ffmpegPath, []string{"-nostdin", "-v", "error", "-xerror", "-i", output, "-map", "0", "-f", "null", "-"})
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return errors.Wrap(err, "output failed to decode: "+stderr.String())
+		}
+	}
+	return nil
+}
+
+func (v verifierImpl) checkDuration(source *MediaInfo, output *MediaInfo) error {
+	sourceDuration, err := parseDuration(source)
+	if err != nil {
+		return err
+	}
+	outputDuration, err := parseDuration(output)
+	if err != nil {
+		return err
+	}
+	if time.Duration(math.Abs(float64(sourceDuration-outputDuration))) > v.tolerance {
+		return fmt.Errorf("output duration %s differs from source duration %s", outputDuration, sourceDuration)
+	}
+	return nil
+}
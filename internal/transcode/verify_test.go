@@ -0,0 +1,77 @@
+package transcode
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPassesWithinTolerance(t *testing.T) {
+	source := parsedInfo(t)
+	output := parsedInfo(t)
+	output.Format.Duration = "5401.5"
+	// Cover art isn't mapped into video transcodes
+	output.Streams = output.Streams[:2]
+	verifier := NewVerifier(mockProber{info: output}, "", Priority{}, 2*time.Second, false)
+
+	err := verifier.Verify(context.Background(), source, "/media/movie.mp4", &StreamCounts{Video: 1, Audio: 1})
+
+	assert.NoError(t, err)
+}
+
+func TestVerifyFailsOnShortOutput(t *testing.T) {
+	source := parsedInfo(t)
+	output := parsedInfo(t)
+	output.Format.Duration = "2700.0"
+	verifier := NewVerifier(mockProber{info: output}, "", Priority{}, 2*time.Second, false)
+
+	err := verifier.Verify(context.Background(), source, "/media/movie.mp4", nil)
+
+	assert.Error(t, err)
+}
+
+func TestVerifyFailsOnMissingStreams(t *testing.T) {
+	verifier := NewVerifier(mockProber{info: parsedInfo(t)}, "", Priority{}, 2*time.Second, false)
+
+	err := verifier.Verify(context.Background(), parsedInfo(t), "/media/movie.mp4", &StreamCounts{Video: 1, Audio: 2})
+
+	assert.Error(t, err)
+}
+
+func TestVerifyDecodesAtPriority(t *testing.T) {
+	niceness := filepath.Join(t.TempDir(), "niceness")
+	ffmpeg := fakeFfmpeg(t, "nice > "+niceness)
+	verifier := NewVerifier(mockProber{info: parsedInfo(t)}, ffmpeg, Priority{Nice: 5}, 2*time.Second, true)
+
+	err := verifier.Verify(context.Background(), parsedInfo(t), "/media/movie.mp4", nil)
+
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(niceness)
+	assert.NoError(t, err)
+	base, err := exec.Command("nice").Output()
+	assert.NoError(t, err)
+	expected, _ := strconv.Atoi(strings.TrimSpace(string(base)))
+	if expected += 5; expected > 19 {
+		expected = 19
+	}
+	assert.Equal(t, strconv.Itoa(expected), strings.TrimSpace(string(data)))
+}
+
+func TestVerifyStopsDecodeWhenCancelled(t *testing.T) {
+	verifier := NewVerifier(mockProber{info: parsedInfo(t)}, fakeFfmpeg(t, "exec sleep 10"), Priority{}, 2*time.Second, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err := verifier.Verify(ctx, parsedInfo(t), "/media/movie.mp4", nil)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+}
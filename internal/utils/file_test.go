@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -30,3 +32,40 @@ func TestFileExists(t *testing.T) {
 	}
 
 }
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	err := ioutil.WriteFile(src, []byte("content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = MoveFile(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileExists(src) || !FileExists(dst) {
+		t.Error("File should have been moved")
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	err := ioutil.WriteFile(src, []byte("content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = copyFile(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(dst)
+	if string(data) != "content" {
+		t.Error("Copied content does not match")
+	}
+}
@@ -26,8 +26,53 @@ var InflightJob = prometheus.NewGaugeVec(
 		Help: "The number of jobs in progress",
 	}, []string{"job_name"})
 
+var WebhookAuthFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_auth_failures_total",
+		Help: "Number of webhook calls rejected for missing or wrong credentials",
+	}, []string{"source"})
+
+var ScanCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scans_performed",
+		Help: "Number of Sonarr and Radarr library scans performed",
+	}, []string{"scanner", "instance", "status"})
+
+var ScanLastRun = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "scan_last_run_timestamp_seconds",
+		Help: "When the last library scan finished",
+	}, []string{"scanner", "instance"})
+
+var ScanLastSuccess = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "scan_last_run_success",
+		Help: "1 if the last library scan succeeded, 0 if it failed",
+	}, []string{"scanner", "instance"})
+
+var ScanTime = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "scan_time",
+		Help:    "The time taken to scan a Sonarr or Radarr library",
+		Buckets: []float64{1, 5, 15, 30, 60, 60 * 5, 60 * 15, 60 * 30, 60 * 60},
+	},
+	[]string{"scanner", "instance", "status"})
+
+var ScanFiles = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scan_files",
+		Help: "Number of files scans checked, skipped as unchanged, enqueued for transcoding or excluded by the filter rules",
+	}, []string{"scanner", "instance", "result"})
+
+var QueuePaused = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "queue_paused",
+		Help: "1 if workers are paused from picking up the job type, 0 if they aren't",
+	}, []string{"job_name"})
+
 func register() bool {
-	prometheus.MustRegister(JobTime, JobCount, InflightJob)
+	prometheus.MustRegister(JobTime, JobCount, InflightJob, WebhookAuthFailures, ScanCount, ScanLastRun, ScanLastSuccess,
+		ScanTime, ScanFiles, QueuePaused)
 	return true
 }
 
@@ -26,11 +26,12 @@ type WebClient interface {
 }
 
 type WebClientImpl struct {
-	client http.Client
+	client  http.Client
+	headers http.Header
 }
 
 func (c WebClientImpl) PostRequest(url url.URL, path string, values url.Values, body interface{}, respObject interface{}) error {
-	resp, respBytes, err := makePostRequest(url, path, values, body)
+	resp, respBytes, err := makePostRequest(url, path, values, c.headers, body)
 
 	if err != nil {
 		return err
@@ -47,7 +48,7 @@ func (c WebClientImpl) PostRequest(url url.URL, path string, values url.Values,
 }
 
 func (c WebClientImpl) GetRequest(url url.URL, path string, values url.Values, respObject interface{}) error {
-	resp, body, err := makeGetRequest(url, path, values)
+	resp, body, err := makeGetRequest(url, path, values, c.headers)
 	if err != nil {
 		return err
 	}
@@ -68,19 +69,24 @@ func GetWebClient() WebClient {
 	return WebClientImpl{client: netClient}
 }
 
+// NewWebClient creates a WebClient that sends headers with every request
+func NewWebClient(headers http.Header) WebClient {
+	return WebClientImpl{client: netClient, headers: headers}
+}
+
 func (c WebClientImpl) MakeGetRequest(baseUrl url.URL, path string, values url.Values) (*http.Response, []byte, error) {
-	return makeGetRequest(baseUrl, path, values)
+	return makeGetRequest(baseUrl, path, values, c.headers)
 }
 
 func (c WebClientImpl) MakePostRequest(baseUrl url.URL, path string, values url.Values, requestBody interface{}) (*http.Response, []byte, error) {
-	return makePostRequest(baseUrl, path, values, requestBody)
+	return makePostRequest(baseUrl, path, values, c.headers, requestBody)
 }
 
 var netClient = http.Client{
 	Timeout: time.Second * 10,
 }
 
-func makePostRequest(base url.URL, path string, values url.Values, body interface{}) (*http.Response, []byte, error) {
+func makePostRequest(base url.URL, path string, values url.Values, headers http.Header, body interface{}) (*http.Response, []byte, error) {
 	log.Trace().Str("base", base.String()).Str("path", path).Msg("preparing post")
 	value, err := json.Marshal(body)
 
@@ -99,7 +105,13 @@ func makePostRequest(base url.URL, path string, values url.Values, body interfac
 	}
 	base.RawQuery = currentValues.Encode()
 	log.Trace().Str("url", base.String()).Msg("Making POST request")
-	resp, err := netClient.Post(base.String(), "application/json", buf)
+	req, err := http.NewRequest(http.MethodPost, base.String(), buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	setHeaders(req, headers)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := netClient.Do(req)
 
 	if err != nil {
 		return nil, nil, err
@@ -111,7 +123,7 @@ func makePostRequest(base url.URL, path string, values url.Values, body interfac
 	return resp, response, err
 }
 
-func makeGetRequest(base url.URL, path string, values url.Values) (*http.Response, []byte, error) {
+func makeGetRequest(base url.URL, path string, values url.Values, headers http.Header) (*http.Response, []byte, error) {
 	log.Trace().Str("base", base.String()).Str("path", path).Msg("preparing get")
 	finalPath := path2.Join(base.Path, path)
 	base.Path = finalPath
@@ -123,7 +135,12 @@ func makeGetRequest(base url.URL, path string, values url.Values) (*http.Respons
 	}
 	base.RawQuery = currentValues.Encode()
 	log.Trace().Str("url", base.String()).Msg("Making GET request")
-	resp, err := netClient.Get(base.String())
+	req, err := http.NewRequest(http.MethodGet, base.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	setHeaders(req, headers)
+	resp, err := netClient.Do(req)
 
 	if err != nil {
 		return nil, nil, err
@@ -134,3 +151,11 @@ func makeGetRequest(base url.URL, path string, values url.Values) (*http.Respons
 	response, err := ioutil.ReadAll(resp.Body)
 	return resp, response, err
 }
+
+func setHeaders(req *http.Request, headers http.Header) {
+	for k, v := range headers {
+		for _, value := range v {
+			req.Header.Add(k, value)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// APIVersion is the Sonarr/Radarr API a client talks to
+type APIVersion string
+
+const (
+	// APIAuto detects the version from the server the first time it's needed
+	APIAuto APIVersion = config.APIVersionAuto
+	// APIV3 uses the api/v3 routes and the X-Api-Key header
+	APIV3 APIVersion = config.APIVersionV3
+	// APILegacy uses the api routes of Sonarr v2 and Radarr v0.2 with the key as a query parameter
+	APILegacy APIVersion = config.APIVersionLegacy
+)
+
+const apiKeyHeader = "X-Api-Key"
+
+// ParseAPIVersion validates a configured API version. Empty means APIAuto
+func ParseAPIVersion(v string) (APIVersion, error) {
+	switch APIVersion(v) {
+	case "", APIAuto:
+		return APIAuto, nil
+	case APIV3, APILegacy:
+		return APIVersion(v), nil
+	}
+	return "", errors.New("unknown api version " + strconv.Quote(v) + ", expected auto, v3 or legacy")
+}
+
+// route returns the path of an API resource for this version
+func (v APIVersion) route(resource string) string {
+	if v == APIV3 {
+		return "api/v3/" + resource
+	}
+	return "api/" + resource
+}
+
+// authorize adds the API key to the query for the legacy API. The v3 API reads it from the header the web client
+// sends with every request
+func (v APIVersion) authorize(query url.Values, apiKey string) url.Values {
+	if v != APIV3 {
+		query.Add("apikey", apiKey)
+	}
+	return query
+}
+
+// apiKeyClient creates a web client sending the API key header
+func apiKeyClient(apiKey string) utils.WebClient {
+	headers := http.Header{}
+	headers.Set(apiKeyHeader, apiKey)
+	return utils.NewWebClient(headers)
+}
+
+// apiVersionResolver remembers the detected API version of a server. A nil resolver uses the legacy API
+type apiVersionResolver struct {
+	mu      sync.Mutex
+	version APIVersion
+}
+
+func newAPIVersionResolver(version APIVersion) *apiVersionResolver {
+	return &apiVersionResolver{version: version}
+}
+
+// resolve returns the configured version, detecting it on first use when set to auto. Detection is retried on
+// the next call if the server can't be reached
+func (r *apiVersionResolver) resolve(client utils.WebClient, base url.URL) (APIVersion, error) {
+	if r == nil {
+		return APILegacy, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.version != APIAuto {
+		return r.version, nil
+	}
+
+	resp, body, err := client.MakeGetRequest(base, APIV3.route("system/status"), url.Values{})
+	if err != nil {
+		return "", err
+	}
+	var status struct {
+		Version string `json:"version"`
+	}
+	detected := APILegacy
+	if resp.StatusCode == http.StatusOK && json.Unmarshal(body, &status) == nil && status.Version != "" {
+		detected = APIV3
+	} else if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode >= http.StatusInternalServerError {
+		return "", errors.New("bad status code from server: " + strconv.Itoa(resp.StatusCode))
+	}
+	log.Info().Str("base", base.String()).Str("apiVersion", string(detected)).Str("serverVersion", status.Version).Msg("Detected API version")
+	r.version = detected
+	return detected, nil
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"io/ioutil"
+	"media-web/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveFixture serves a response from testdata. bin/capture-fixtures.sh records them from a live server. The files
+// that haven't been recorded yet are written by hand after the examples in the Sonarr, Radarr and Lidarr API
+// documentation
+func serveFixture(t *testing.T, w http.ResponseWriter, name string) {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	assert.NoError(t, err)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func TestParseAPIVersion(t *testing.T) {
+	for input, expected := range map[string]APIVersion{"": APIAuto, "auto": APIAuto, "v3": APIV3, "legacy": APILegacy} {
+		version, err := ParseAPIVersion(input)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, version)
+	}
+
+	_, err := ParseAPIVersion("v4")
+	assert.Error(t, err)
+}
+
+func TestRoutes(t *testing.T) {
+	assert.Equal(t, "api/v3/movie/1", APIV3.route("movie/1"))
+	assert.Equal(t, "api/movie/1", APILegacy.route("movie/1"))
+	assert.Empty(t, APIV3.authorize(url.Values{}, "key"))
+	assert.Equal(t, "key", APILegacy.authorize(url.Values{}, "key").Get("apikey"))
+}
+
+func TestDetectsV3Once(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/api/v3/system/status", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		_, _ = w.Write([]byte(`{"appName": "Radarr", "version": "4.0.5.5981"}`))
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	resolver := newAPIVersionResolver(APIAuto)
+	for i := 0; i < 2; i++ {
+		version, err := resolver.resolve(apiKeyClient("secret"), *parsed)
+		assert.NoError(t, err)
+		assert.Equal(t, APIV3, version)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestDetectsLegacyWhenV3IsMissing(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	version, err := newAPIVersionResolver(APIAuto).resolve(apiKeyClient("secret"), *parsed)
+
+	assert.NoError(t, err)
+	assert.Equal(t, APILegacy, version)
+}
+
+func TestDetectionRetriedAfterServerError(t *testing.T) {
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"version": "3.0.7.1477"}`))
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	resolver := newAPIVersionResolver(APIAuto)
+	_, err := resolver.resolve(apiKeyClient("secret"), *parsed)
+	assert.Error(t, err)
+
+	fail = false
+	version, err := resolver.resolve(apiKeyClient("secret"), *parsed)
+	assert.NoError(t, err)
+	assert.Equal(t, APIV3, version)
+}
+
+func TestInvalidAPIVersionIsDetected(t *testing.T) {
+	instance := config.Instance{Name: config.DefaultInstance, ApiVersion: "v4"}
+
+	assert.Equal(t, APIAuto, NewRadarrClient(instance).(RadarrClientImpl).version.version)
+	assert.Equal(t, APIAuto, NewSonarrClient(instance).(SonarrClientImpl).version.version)
+}
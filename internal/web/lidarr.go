@@ -0,0 +1,87 @@
+package web
+
+import (
+	"fmt"
+	"media-web/internal/config"
+	"media-web/internal/pathmap"
+	"media-web/internal/utils"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+)
+
+type LidarrClient interface {
+	CheckLidarrCommand(id int) (*LidarrCommand, error)
+	RescanArtist(id int64) (*LidarrCommand, error)
+	LookupTrackFile(id int64) (*LidarrTrackFile, error)
+	GetTrackFilePath(id int64) (string, int, error)
+	PathMapper() pathmap.Mapper
+}
+
+// LidarrClientImpl talks to the Lidarr v1 API, the only one Lidarr has
+type LidarrClientImpl struct {
+	webClient          utils.WebClient
+	LidarrBaseEndpoint url.URL
+	pathMapper         pathmap.Mapper
+}
+
+// GetLidarrClient returns a client for the configured Lidarr server
+func GetLidarrClient() LidarrClient {
+	cfg := config.GetConfig()
+	var endpoint url.URL
+	if cfg.LidarrBaseEndpoint != nil {
+		endpoint = *cfg.LidarrBaseEndpoint
+	}
+	return LidarrClientImpl{
+		webClient:          apiKeyClient(cfg.LidarrApiKey),
+		LidarrBaseEndpoint: endpoint,
+		pathMapper:         cfg.LidarrPathMappings,
+	}
+}
+
+// PathMapper translates between the paths Lidarr reports and the paths on this host
+func (c LidarrClientImpl) PathMapper() pathmap.Mapper {
+	return c.pathMapper
+}
+
+func (c LidarrClientImpl) CheckLidarrCommand(id int) (*LidarrCommand, error) {
+	var response LidarrCommand
+	err := c.webClient.GetRequest(c.LidarrBaseEndpoint, fmt.Sprintf("api/v1/command/%d", id), url.Values{}, &response)
+	response.normalize()
+	return &response, err
+}
+
+func (c LidarrClientImpl) RescanArtist(id int64) (*LidarrCommand, error) {
+	payload := make(map[string]interface{})
+
+	payload["name"] = "RescanArtist"
+	payload["artistId"] = id
+
+	var response LidarrCommand
+	err := c.webClient.PostRequest(c.LidarrBaseEndpoint, "api/v1/command", url.Values{}, payload, &response)
+	response.normalize()
+
+	return &response, err
+}
+
+func (c LidarrClientImpl) LookupTrackFile(id int64) (*LidarrTrackFile, error) {
+	var response LidarrTrackFile
+	err := c.webClient.GetRequest(c.LidarrBaseEndpoint, fmt.Sprintf("api/v1/trackfile/%d", id), url.Values{}, &response)
+	if err == utils.NotFoundError {
+		return nil, nil
+	}
+	return &response, err
+}
+
+// GetTrackFilePath returns the local path of a track file and the artist it belongs to
+func (c LidarrClientImpl) GetTrackFilePath(id int64) (string, int, error) {
+	trackFile, err := c.LookupTrackFile(id)
+	if err != nil {
+		return "", -1, err
+	}
+	if trackFile == nil {
+		log.Warn().Msg("Could not find trackFile")
+		return "", -1, nil
+	}
+	return c.pathMapper.ToLocal(trackFile.Path), trackFile.ArtistID, nil
+}
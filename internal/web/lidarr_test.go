@@ -0,0 +1,81 @@
+package web
+
+import (
+	"encoding/json"
+	"media-web/internal/pathmap"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lidarrClient(srv *httptest.Server) LidarrClientImpl {
+	parsed, _ := url.Parse(srv.URL)
+	return LidarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		LidarrBaseEndpoint: *parsed,
+		pathMapper:         pathmap.Mapper{{Remote: "/music", Local: "/mnt/music"}},
+	}
+}
+
+func TestRescanArtist(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := make(map[string]interface{})
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/v1/command", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get(apiKeyHeader))
+		assert.Equal(t, "RescanArtist", payload["name"])
+		assert.EqualValues(t, 4, payload["artistId"])
+		serveFixture(t, w, "lidarr_command.json")
+	}))
+	defer srv.Close()
+
+	cmd, err := lidarrClient(srv).RescanArtist(4)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 12, cmd.ID)
+	assert.Equal(t, "completed", cmd.State)
+}
+
+func TestCheckLidarrCommand(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/command/12", r.URL.Path)
+		serveFixture(t, w, "lidarr_command.json")
+	}))
+	defer srv.Close()
+
+	cmd, err := lidarrClient(srv).CheckLidarrCommand(12)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", cmd.State)
+}
+
+func TestGetTrackFilePath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/trackfile/88", r.URL.Path)
+		serveFixture(t, w, "lidarr_trackfile.json")
+	}))
+	defer srv.Close()
+
+	path, artistId, err := lidarrClient(srv).GetTrackFilePath(88)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/music/Artist/Album (2020)/01 - Track.flac", path)
+	assert.Equal(t, 4, artistId)
+}
+
+func TestGetTrackFilePathNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	path, _, err := lidarrClient(srv).GetTrackFilePath(88)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", path)
+}
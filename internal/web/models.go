@@ -1,6 +1,7 @@
 package web
 
 import (
+	"encoding/json"
 	"media-web/internal/constants"
 	"time"
 )
@@ -40,11 +41,27 @@ type Series struct {
 	TitleSlug         string             `json:"titleSlug"`
 	Certification     string             `json:"certification"`
 	Genres            []string           `json:"genres"`
-	Tags              []interface{}      `json:"tags"`
+	Tags              []int              `json:"tags"`
 	Added             time.Time          `json:"added"`
 	Ratings           Ratings            `json:"ratings"`
 	QualityProfileID  int                `json:"qualityProfileId"`
-	ID                int                `json:"id"`
+	// Statistics is only sent by the v3 API, which leaves out the counts at the top level
+	Statistics Statistics `json:"statistics"`
+	ID         int        `json:"id"`
+}
+
+// FileStats returns the number of episode files of the series and their total size from either Sonarr API
+func (s Series) FileStats() (int, int64) {
+	if s.Statistics.EpisodeFileCount > 0 || s.Statistics.SizeOnDisk > 0 {
+		return s.Statistics.EpisodeFileCount, s.Statistics.SizeOnDisk
+	}
+	return s.EpisodeFileCount, s.SizeOnDisk
+}
+
+// Tag is a Sonarr or Radarr tag. Series and movies only carry the ids of their tags
+type Tag struct {
+	ID    int    `json:"id"`
+	Label string `json:"label"`
 }
 
 type AlternativeTitle struct {
@@ -83,7 +100,16 @@ type SonarrCommand struct {
 	StateChangeTime     time.Time `json:"stateChangeTime"`
 	SendUpdatesToClient bool      `json:"sendUpdatesToClient"`
 	State               string    `json:"state"`
-	ID                  int       `json:"id"`
+	// Status replaces State in the v3 API
+	Status string `json:"status"`
+	ID     int    `json:"id"`
+}
+
+// normalize copies the v3 status into State, which is what callers check
+func (c *SonarrCommand) normalize() {
+	if c.State == "" {
+		c.State = c.Status
+	}
 }
 
 type RadarrCommand struct {
@@ -107,6 +133,13 @@ type RadarrCommand struct {
 	ID                  int       `json:"id"`
 }
 
+// normalize copies the v3 status into State, which is what callers check
+func (c *RadarrCommand) normalize() {
+	if c.State == "" {
+		c.State = c.Status
+	}
+}
+
 type JobData struct {
 	TranscodeType constants.TranscodeType `json:"transcodeType"`
 	Id            int                     `json:"id"`
@@ -118,6 +151,15 @@ type Movie struct {
 	ReleaseDate string `json:"releaseDate"`
 }
 
+// RenamedFile is a file moved by a Rename event
+type RenamedFile struct {
+	ID                   int    `json:"id"`
+	RelativePath         string `json:"relativePath"`
+	Path                 string `json:"path"`
+	PreviousRelativePath string `json:"previousRelativePath"`
+	PreviousPath         string `json:"previousPath"`
+}
+
 type RadarrWebhook struct {
 	EventType   string `json:"eventType"`
 	Movie       Movie  `json:"movie"`
@@ -135,7 +177,9 @@ type RadarrWebhook struct {
 		QualityVersion int    `json:"qualityVersion"`
 		ReleaseGroup   string `json:"releaseGroup"`
 	} `json:"movieFile"`
-	IsUpgrade bool `json:"isUpgrade"`
+	IsUpgrade         bool          `json:"isUpgrade"`
+	DeleteReason      string        `json:"deleteReason"`
+	RenamedMovieFiles []RenamedFile `json:"renamedMovieFiles"`
 }
 
 type SonarrWebhook struct {
@@ -156,14 +200,37 @@ type SonarrWebhook struct {
 		Quality        string    `json:"quality"`
 		QualityVersion int       `json:"qualityVersion"`
 	} `json:"episodes"`
-	EpisodeFile struct {
-		ID             int    `json:"id"`
-		RelativePath   string `json:"relativePath"`
-		Path           string `json:"path"`
-		Quality        string `json:"quality"`
-		QualityVersion int    `json:"qualityVersion"`
-	} `json:"episodeFile"`
-	IsUpgrade bool `json:"isUpgrade"`
+	// EpisodeFile is set for single file imports and deletes
+	EpisodeFile SonarrWebhookEpisodeFile `json:"episodeFile"`
+	// EpisodeFiles is sent by newer versions of Sonarr when a download imports several files, like a season pack
+	EpisodeFiles        []SonarrWebhookEpisodeFile `json:"episodeFiles"`
+	DownloadClient      string                     `json:"downloadClient"`
+	DownloadID          string                     `json:"downloadId"`
+	IsUpgrade           bool                       `json:"isUpgrade"`
+	DeleteReason        string                     `json:"deleteReason"`
+	RenamedEpisodeFiles []RenamedFile              `json:"renamedEpisodeFiles"`
+}
+
+type SonarrWebhookEpisodeFile struct {
+	ID             int    `json:"id"`
+	RelativePath   string `json:"relativePath"`
+	Path           string `json:"path"`
+	Quality        string `json:"quality"`
+	QualityVersion int    `json:"qualityVersion"`
+	ReleaseGroup   string `json:"releaseGroup"`
+	SceneName      string `json:"sceneName"`
+	Size           int64  `json:"size"`
+}
+
+// ImportedFiles returns the episode files a Download event imported, whichever form the payload used
+func (s SonarrWebhook) ImportedFiles() []SonarrWebhookEpisodeFile {
+	if len(s.EpisodeFiles) > 0 {
+		return s.EpisodeFiles
+	}
+	if s.EpisodeFile.ID == 0 {
+		return nil
+	}
+	return []SonarrWebhookEpisodeFile{s.EpisodeFile}
 }
 
 type RadarrMovie struct {
@@ -179,28 +246,28 @@ type RadarrMovie struct {
 		CoverType string `json:"coverType"`
 		URL       string `json:"url"`
 	} `json:"images"`
-	Website             string        `json:"website"`
-	Downloaded          bool          `json:"downloaded"`
-	Year                int           `json:"year"`
-	HasFile             bool          `json:"hasFile"`
-	YouTubeTrailerID    string        `json:"youTubeTrailerId"`
-	Studio              string        `json:"studio"`
-	Path                string        `json:"path"`
-	ProfileID           int           `json:"profileId"`
-	PathState           string        `json:"pathState"`
-	Monitored           bool          `json:"monitored"`
-	MinimumAvailability string        `json:"minimumAvailability"`
-	IsAvailable         bool          `json:"isAvailable"`
-	FolderName          string        `json:"folderName"`
-	Runtime             int           `json:"runtime"`
-	LastInfoSync        time.Time     `json:"lastInfoSync"`
-	CleanTitle          string        `json:"cleanTitle"`
-	ImdbID              string        `json:"imdbId"`
-	TmdbID              int           `json:"tmdbId"`
-	TitleSlug           string        `json:"titleSlug"`
-	Genres              []interface{} `json:"genres"`
-	Tags                []interface{} `json:"tags"`
-	Added               time.Time     `json:"added"`
+	Website             string    `json:"website"`
+	Downloaded          bool      `json:"downloaded"`
+	Year                int       `json:"year"`
+	HasFile             bool      `json:"hasFile"`
+	YouTubeTrailerID    string    `json:"youTubeTrailerId"`
+	Studio              string    `json:"studio"`
+	Path                string    `json:"path"`
+	ProfileID           int       `json:"profileId"`
+	PathState           string    `json:"pathState"`
+	Monitored           bool      `json:"monitored"`
+	MinimumAvailability string    `json:"minimumAvailability"`
+	IsAvailable         bool      `json:"isAvailable"`
+	FolderName          string    `json:"folderName"`
+	Runtime             int       `json:"runtime"`
+	LastInfoSync        time.Time `json:"lastInfoSync"`
+	CleanTitle          string    `json:"cleanTitle"`
+	ImdbID              string    `json:"imdbId"`
+	TmdbID              int       `json:"tmdbId"`
+	TitleSlug           string    `json:"titleSlug"`
+	Genres              []string  `json:"genres"`
+	Tags                []int     `json:"tags"`
+	Added               time.Time `json:"added"`
 	Ratings             struct {
 		Votes int     `json:"votes"`
 		Value float64 `json:"value"`
@@ -210,49 +277,67 @@ type RadarrMovie struct {
 	ID               int       `json:"id"`
 }
 
+// FilePath is where Radarr has the movie's file. The v3 API sends it, older versions only send the path relative
+// to the movie folder
+func (m RadarrMovie) FilePath() string {
+	if m.MovieFile.Path != "" {
+		return m.MovieFile.Path
+	}
+	return m.Path + "/" + m.MovieFile.RelativePath
+}
+
+// MovieFile is a movie file as returned by either Radarr API. Path, OriginalFilePath and the codec fields of
+// MediaInfo are only sent by the v3 API
 type MovieFile struct {
-	MovieID      int       `json:"movieId"`
-	RelativePath string    `json:"relativePath"`
-	Size         int64     `json:"size"`
-	DateAdded    time.Time `json:"dateAdded"`
-	SceneName    string    `json:"sceneName"`
-	ReleaseGroup string    `json:"releaseGroup"`
-	Quality      struct {
+	MovieID          int       `json:"movieId"`
+	RelativePath     string    `json:"relativePath"`
+	Path             string    `json:"path"`
+	OriginalFilePath string    `json:"originalFilePath"`
+	Size             int64     `json:"size"`
+	DateAdded        time.Time `json:"dateAdded"`
+	SceneName        string    `json:"sceneName"`
+	ReleaseGroup     string    `json:"releaseGroup"`
+	Quality          struct {
 		Quality struct {
-			ID         int    `json:"id"`
-			Name       string `json:"name"`
-			Source     string `json:"source"`
-			Resolution string `json:"resolution"`
-			Modifier   string `json:"modifier"`
+			ID         int        `json:"id"`
+			Name       string     `json:"name"`
+			Source     string     `json:"source"`
+			Resolution Resolution `json:"resolution"`
+			Modifier   string     `json:"modifier"`
 		} `json:"quality"`
 		CustomFormats []interface{} `json:"customFormats"`
 		Revision      struct {
-			Version int `json:"version"`
-			Real    int `json:"real"`
+			Version  int  `json:"version"`
+			Real     int  `json:"real"`
+			IsRepack bool `json:"isRepack"`
 		} `json:"revision"`
 	} `json:"quality"`
 	Edition   string `json:"edition"`
 	MediaInfo struct {
 		ContainerFormat              string  `json:"containerFormat"`
 		VideoFormat                  string  `json:"videoFormat"`
+		VideoCodec                   string  `json:"videoCodec"`
 		VideoCodecID                 string  `json:"videoCodecID"`
 		VideoProfile                 string  `json:"videoProfile"`
 		VideoCodecLibrary            string  `json:"videoCodecLibrary"`
 		VideoBitrate                 int     `json:"videoBitrate"`
 		VideoBitDepth                int     `json:"videoBitDepth"`
+		VideoDynamicRangeType        string  `json:"videoDynamicRangeType"`
 		VideoMultiViewCount          int     `json:"videoMultiViewCount"`
 		VideoColourPrimaries         string  `json:"videoColourPrimaries"`
 		VideoTransferCharacteristics string  `json:"videoTransferCharacteristics"`
 		Width                        int     `json:"width"`
 		Height                       int     `json:"height"`
+		Resolution                   string  `json:"resolution"`
 		AudioFormat                  string  `json:"audioFormat"`
+		AudioCodec                   string  `json:"audioCodec"`
 		AudioCodecID                 string  `json:"audioCodecID"`
 		AudioCodecLibrary            string  `json:"audioCodecLibrary"`
 		AudioAdditionalFeatures      string  `json:"audioAdditionalFeatures"`
 		AudioBitrate                 int     `json:"audioBitrate"`
 		RunTime                      string  `json:"runTime"`
 		AudioStreamCount             int     `json:"audioStreamCount"`
-		AudioChannels                int     `json:"audioChannels"`
+		AudioChannels                float64 `json:"audioChannels"`
 		AudioChannelPositions        string  `json:"audioChannelPositions"`
 		AudioChannelPositionsText    string  `json:"audioChannelPositionsText"`
 		AudioProfile                 string  `json:"audioProfile"`
@@ -265,12 +350,30 @@ type MovieFile struct {
 	ID int `json:"id"`
 }
 
+// Resolution is a quality's resolution. The legacy Radarr API sends names like "r1080P" and the v3 API sends the
+// number of lines
+type Resolution string
+
+func (r *Resolution) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*r = Resolution(name)
+		return nil
+	}
+	var lines json.Number
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return err
+	}
+	*r = Resolution(lines.String())
+	return nil
+}
+
 type SonarrEpisodeFile struct {
 	SeriesID     int       `json:"seriesId"`
 	SeasonNumber int       `json:"seasonNumber"`
 	RelativePath string    `json:"relativePath"`
 	Path         string    `json:"path"`
-	Size         int       `json:"size"`
+	Size         int64     `json:"size"`
 	DateAdded    time.Time `json:"dateAdded"`
 	Quality      struct {
 		Quality struct {
@@ -320,3 +423,72 @@ type MovieSearchResult struct {
 type MovieSearchQuery struct {
 	Query string `json:"query"`
 }
+
+type LidarrCommand struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Queued    time.Time `json:"queued"`
+	StartedOn time.Time `json:"started"`
+	Trigger   string    `json:"trigger"`
+	// State isn't sent by Lidarr. It is filled from Status so callers can check it like the other commands
+	State string `json:"state"`
+	ID    int    `json:"id"`
+}
+
+// normalize copies the status into State, which is what callers check
+func (c *LidarrCommand) normalize() {
+	if c.State == "" {
+		c.State = c.Status
+	}
+}
+
+type LidarrTrackFile struct {
+	ID        int       `json:"id"`
+	ArtistID  int       `json:"artistId"`
+	AlbumID   int       `json:"albumId"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	DateAdded time.Time `json:"dateAdded"`
+	Quality   struct {
+		Quality struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"quality"`
+	} `json:"quality"`
+	MediaInfo struct {
+		AudioCodec      string `json:"audioCodec"`
+		AudioBitrate    string `json:"audioBitRate"`
+		AudioChannels   int    `json:"audioChannels"`
+		AudioBits       string `json:"audioBits"`
+		AudioSampleRate string `json:"audioSampleRate"`
+	} `json:"mediaInfo"`
+}
+
+type LidarrWebhook struct {
+	EventType string `json:"eventType"`
+	Artist    struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+		Path string `json:"path"`
+		MbID string `json:"mbId"`
+	} `json:"artist"`
+	Albums []struct {
+		ID          int    `json:"id"`
+		Title       string `json:"title"`
+		ReleaseDate string `json:"releaseDate"`
+	} `json:"albums"`
+	TrackFiles     []LidarrWebhookTrackFile `json:"trackFiles"`
+	DownloadClient string                   `json:"downloadClient"`
+	DownloadID     string                   `json:"downloadId"`
+	IsUpgrade      bool                     `json:"isUpgrade"`
+}
+
+type LidarrWebhookTrackFile struct {
+	ID             int    `json:"id"`
+	Path           string `json:"path"`
+	Quality        string `json:"quality"`
+	QualityVersion int    `json:"qualityVersion"`
+	ReleaseGroup   string `json:"releaseGroup"`
+	SceneName      string `json:"sceneName"`
+	Size           int64  `json:"size"`
+}
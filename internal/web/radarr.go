@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"media-web/internal/config"
+	"media-web/internal/pathmap"
 	"media-web/internal/utils"
 	"net/http"
 	"net/url"
@@ -17,34 +18,78 @@ type RadarrClient interface {
 	RescanMovie(id int64) (*RadarrCommand, error)
 	LookupMovie(id int64) (*RadarrMovie, error)
 	GetAllMovies() ([]RadarrMovie, error)
+	GetTags() ([]Tag, error)
 	GetMovieFilePath(id int64) (string, error)
 	ScanForMissingMovies() (*RadarrCommand, error)
+	PathMapper() pathmap.Mapper
 }
 
 type RadarrClientImpl struct {
 	webClient          utils.WebClient
 	RadarrBaseEndpoint url.URL
+	pathMapper         pathmap.Mapper
+	version            *apiVersionResolver
+	apiKey             string
 }
 
+// GetRadarrClient returns a client for the default Radarr instance
 func GetRadarrClient() RadarrClient {
+	instance, _ := config.GetConfig().RadarrInstance(config.DefaultInstance)
+	return NewRadarrClient(instance)
+}
+
+// GetRadarrClients returns a client for every configured Radarr instance by name
+func GetRadarrClients() map[string]RadarrClient {
+	clients := make(map[string]RadarrClient)
+	for _, instance := range config.GetConfig().RadarrInstances {
+		clients[instance.Name] = NewRadarrClient(instance)
+	}
+	return clients
+}
+
+// NewRadarrClient creates a client for a Radarr instance
+func NewRadarrClient(instance config.Instance) RadarrClient {
 	var endpoint url.URL
-	if config.GetConfig().RadarrBaseEndpoint != nil {
-		endpoint = *config.GetConfig().RadarrBaseEndpoint
+	if instance.BaseEndpoint != nil {
+		endpoint = *instance.BaseEndpoint
+	}
+	// ValidateConfig rejects unknown versions at startup, so this only catches instances configured elsewhere
+	version, err := ParseAPIVersion(instance.ApiVersion)
+	if err != nil {
+		log.Warn().Err(err).Str("instance", instance.Name).Msg("Detecting the Radarr API version instead")
+		version = APIAuto
 	}
 	return RadarrClientImpl{
-		webClient:          utils.GetWebClient(),
+		webClient:          apiKeyClient(instance.ApiKey),
 		RadarrBaseEndpoint: endpoint,
+		pathMapper:         instance.PathMappings,
+		version:            newAPIVersionResolver(version),
+		apiKey:             instance.ApiKey,
 	}
 }
 
-func (c RadarrClientImpl) radarrGetRequest(client utils.WebClient, path string, query url.Values, respObject interface{}) error {
-	query.Add("apikey", config.GetConfig().RadarrApiKey)
-	return client.GetRequest(c.RadarrBaseEndpoint, path, query, respObject)
+// PathMapper translates between the paths Radarr reports and the paths on this host
+func (c RadarrClientImpl) PathMapper() pathmap.Mapper {
+	return c.pathMapper
+}
+
+// radarrGetRequest requests an API resource, like "movie", from the route of the server's API version
+func (c RadarrClientImpl) radarrGetRequest(resource string, query url.Values, respObject interface{}) error {
+	version, err := c.version.resolve(c.webClient, c.RadarrBaseEndpoint)
+	if err != nil {
+		return err
+	}
+	query = version.authorize(query, c.apiKey)
+	return c.webClient.GetRequest(c.RadarrBaseEndpoint, version.route(resource), query, respObject)
 }
 
-func (c RadarrClientImpl) radarrPostRequest(client utils.WebClient, path string, query url.Values, body interface{}) (*http.Response, []byte, error) {
-	query.Add("apikey", config.GetConfig().RadarrApiKey)
-	resp, repBody, err := client.MakePostRequest(c.RadarrBaseEndpoint, path, query, body)
+func (c RadarrClientImpl) radarrPostRequest(resource string, query url.Values, body interface{}) (*http.Response, []byte, error) {
+	version, err := c.version.resolve(c.webClient, c.RadarrBaseEndpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	query = version.authorize(query, c.apiKey)
+	resp, repBody, err := c.webClient.MakePostRequest(c.RadarrBaseEndpoint, version.route(resource), query, body)
 	if resp != nil && resp.StatusCode >= 300 {
 		return resp, repBody, errors.New("got non-200 status code")
 	}
@@ -52,13 +97,23 @@ func (c RadarrClientImpl) radarrPostRequest(client utils.WebClient, path string,
 }
 
 func (c RadarrClientImpl) ScanForMissingMovies() (*RadarrCommand, error) {
+	version, err := c.version.resolve(c.webClient, c.RadarrBaseEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
 	payload := make(map[string]interface{})
 
-	payload["name"] = "missingMoviesSearch"
-	payload["filterKey"] = "monitored"
-	payload["filterValue"] = "true"
+	// v3 dropped the filter and only searches monitored movies
+	if version == APIV3 {
+		payload["name"] = "MissingMoviesSearch"
+	} else {
+		payload["name"] = "missingMoviesSearch"
+		payload["filterKey"] = "monitored"
+		payload["filterValue"] = "true"
+	}
 
-	resp, value, err := c.radarrPostRequest(utils.WebClientImpl{}, "api/command/", url.Values{}, payload)
+	resp, value, err := c.radarrPostRequest("command", url.Values{}, payload)
 
 	if err != nil {
 		return nil, err
@@ -73,6 +128,7 @@ func (c RadarrClientImpl) ScanForMissingMovies() (*RadarrCommand, error) {
 			return nil, err
 		}
 
+		response.normalize()
 		return &response, nil
 	} else {
 		log.Err(err).Int("status_code", resp.StatusCode).Str("response", string(value)).Msg("Error calling radarr")
@@ -82,7 +138,8 @@ func (c RadarrClientImpl) ScanForMissingMovies() (*RadarrCommand, error) {
 
 func (c RadarrClientImpl) CheckRadarrCommand(id int) (*RadarrCommand, error) {
 	var response RadarrCommand
-	err := c.radarrGetRequest(c.webClient, fmt.Sprintf("api/command/%d", id), url.Values{}, &response)
+	err := c.radarrGetRequest(fmt.Sprintf("command/%d", id), url.Values{}, &response)
+	response.normalize()
 	return &response, err
 }
 
@@ -93,7 +150,7 @@ func (c RadarrClientImpl) RescanMovie(id int64) (*RadarrCommand, error) {
 	payload["name"] = "RescanMovie"
 	payload["movieId"] = id
 
-	resp, value, err := c.radarrPostRequest(utils.WebClientImpl{}, "api/command/", url.Values{}, payload)
+	resp, value, err := c.radarrPostRequest("command", url.Values{}, payload)
 
 	if err != nil {
 		return nil, err
@@ -108,6 +165,7 @@ func (c RadarrClientImpl) RescanMovie(id int64) (*RadarrCommand, error) {
 			return nil, err
 		}
 
+		response.normalize()
 		return &response, nil
 	} else {
 		log.Err(err).Int("status_code", resp.StatusCode).Str("response", string(value)).Msg("Error calling radarr")
@@ -118,7 +176,7 @@ func (c RadarrClientImpl) RescanMovie(id int64) (*RadarrCommand, error) {
 
 func (c RadarrClientImpl) LookupMovie(id int64) (*RadarrMovie, error) {
 	var response RadarrMovie
-	err := c.radarrGetRequest(utils.WebClientImpl{}, fmt.Sprintf("api/movie/%d", id), url.Values{}, &response)
+	err := c.radarrGetRequest(fmt.Sprintf("movie/%d", id), url.Values{}, &response)
 
 	if err == utils.NotFoundError {
 		return nil, nil
@@ -128,7 +186,7 @@ func (c RadarrClientImpl) LookupMovie(id int64) (*RadarrMovie, error) {
 
 func (c RadarrClientImpl) GetAllMovies() ([]RadarrMovie, error) {
 	response := make([]RadarrMovie, 0)
-	err := c.radarrGetRequest(utils.WebClientImpl{}, "api/movie/", url.Values{}, &response)
+	err := c.radarrGetRequest("movie", url.Values{}, &response)
 
 	if err == utils.NotFoundError {
 		return response, nil
@@ -136,6 +194,12 @@ func (c RadarrClientImpl) GetAllMovies() ([]RadarrMovie, error) {
 	return response, err
 }
 
+func (c RadarrClientImpl) GetTags() ([]Tag, error) {
+	response := make([]Tag, 0)
+	err := c.radarrGetRequest("tag", url.Values{}, &response)
+	return response, err
+}
+
 func (c RadarrClientImpl) GetMovieFilePath(id int64) (string, error) {
 
 	movie, err := c.LookupMovie(id)
@@ -143,7 +207,7 @@ func (c RadarrClientImpl) GetMovieFilePath(id int64) (string, error) {
 		return "", err
 	}
 	if movie != nil {
-		return movie.Path + "/" + movie.MovieFile.RelativePath, nil
+		return c.pathMapper.ToLocal(movie.FilePath()), nil
 	} else {
 		log.Warn().Msg("Could not find movie from remote service")
 	}
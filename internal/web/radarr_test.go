@@ -162,3 +162,144 @@ func TestGetAllMoviesErrorReturnserror(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestLookupMovieV3(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/api/v3/movie/1", r.URL.Path)
+		assert.Empty(t, r.URL.Query().Get("apikey"))
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		serveFixture(t, w, "radarr_v3_movie.json")
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	client := RadarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		RadarrBaseEndpoint: *parsed,
+		version:            newAPIVersionResolver(APIV3),
+	}
+
+	movie, err := client.LookupMovie(1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, movie.ID)
+	assert.Equal(t, "/movies/Big Buck Bunny (2008)/Big Buck Bunny (2008) Bluray-1080p.mkv", movie.FilePath())
+	assert.Equal(t, Resolution("1080"), movie.MovieFile.Quality.Quality.Resolution)
+	assert.Equal(t, 5.1, movie.MovieFile.MediaInfo.AudioChannels)
+	assert.Equal(t, "x264", movie.MovieFile.MediaInfo.VideoCodec)
+	assert.Empty(t, movie.Tags)
+}
+
+func TestLookupMovieV3ReadsTagsAndGenres(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveFixture(t, w, "radarr_v3_movie_tagged.json")
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	client := RadarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		RadarrBaseEndpoint: *parsed,
+		version:            newAPIVersionResolver(APIV3),
+	}
+
+	movie, err := client.LookupMovie(1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, movie.Tags)
+	assert.Equal(t, []string{"Animation", "Comedy"}, movie.Genres)
+}
+
+func TestGetTagsV3(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/tag", r.URL.Path)
+		serveFixture(t, w, "tags.json")
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	client := RadarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		RadarrBaseEndpoint: *parsed,
+		version:            newAPIVersionResolver(APIV3),
+	}
+
+	tags, err := client.GetTags()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Tag{{ID: 1, Label: "transcode"}, {ID: 2, Label: "4k"}}, tags)
+}
+
+func TestRescanMovieV3(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := make(map[string]interface{})
+
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/v3/command", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		assert.Equal(t, "RescanMovie", payload["name"])
+		assert.EqualValues(t, 1, payload["movieId"])
+		w.WriteHeader(http.StatusCreated)
+		serveFixture(t, w, "radarr_v3_command.json")
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	client := RadarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		RadarrBaseEndpoint: *parsed,
+		version:            newAPIVersionResolver(APIV3),
+	}
+
+	cmd, err := client.RescanMovie(1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 12, cmd.ID)
+	assert.Equal(t, "completed", cmd.State)
+}
+
+func TestScanForMissingMoviesV3(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := make(map[string]interface{})
+
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		assert.Equal(t, "/api/v3/command", r.URL.Path)
+		assert.Equal(t, map[string]interface{}{"name": "MissingMoviesSearch"}, payload)
+		serveFixture(t, w, "radarr_v3_command.json")
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	client := RadarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		RadarrBaseEndpoint: *parsed,
+		version:            newAPIVersionResolver(APIV3),
+	}
+
+	_, err := client.ScanForMissingMovies()
+
+	assert.NoError(t, err)
+}
+
+func TestLegacyMovieFilePath(t *testing.T) {
+	movie := RadarrMovie{Path: "/movies/Movie (2020)", MovieFile: MovieFile{RelativePath: "Movie.mkv"}}
+
+	assert.Equal(t, "/movies/Movie (2020)/Movie.mkv", movie.FilePath())
+}
+
+func TestResolutionAcceptsLegacyNames(t *testing.T) {
+	var file MovieFile
+	err := json.Unmarshal([]byte(`{"quality": {"quality": {"resolution": "r1080P"}}}`), &file)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Resolution("r1080P"), file.Quality.Quality.Resolution)
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"fmt"
 	"media-web/internal/config"
+	"media-web/internal/pathmap"
 	"media-web/internal/utils"
 	"net/url"
 	"strconv"
@@ -13,43 +14,92 @@ import (
 type SonarrClient interface {
 	GetAllEpisodeFiles(seriesId int) ([]SonarrEpisodeFile, error)
 	GetAllSeries() ([]Series, error)
+	LookupSeries(id int) (*Series, error)
+	GetTags() ([]Tag, error)
 	CheckSonarrCommand(id int) (*SonarrCommand, error)
 	RescanSeries(id int64) (*SonarrCommand, error)
 	LookupTVEpisode(id int64) (*SonarrEpisodeFile, error)
 	GetEpisodeFilePath(id int64) (string, int, error)
+	PathMapper() pathmap.Mapper
 }
 
 type SonarrClientImpl struct {
 	webClient          utils.WebClient
 	BaseSonarrEndpoint url.URL
+	pathMapper         pathmap.Mapper
+	version            *apiVersionResolver
+	apiKey             string
 }
 
+// GetSonarrClient returns a client for the default Sonarr instance
 func GetSonarrClient() SonarrClient {
+	instance, _ := config.GetConfig().SonarrInstance(config.DefaultInstance)
+	return NewSonarrClient(instance)
+}
+
+// GetSonarrClients returns a client for every configured Sonarr instance by name
+func GetSonarrClients() map[string]SonarrClient {
+	clients := make(map[string]SonarrClient)
+	for _, instance := range config.GetConfig().SonarrInstances {
+		clients[instance.Name] = NewSonarrClient(instance)
+	}
+	return clients
+}
+
+// NewSonarrClient creates a client for a Sonarr instance
+func NewSonarrClient(instance config.Instance) SonarrClient {
 	var endpoint url.URL
-	if config.GetConfig().SonarrBaseEndpoint != nil {
-		endpoint = *config.GetConfig().SonarrBaseEndpoint
+	if instance.BaseEndpoint != nil {
+		endpoint = *instance.BaseEndpoint
+	}
+	// ValidateConfig rejects unknown versions at startup, so this only catches instances configured elsewhere
+	version, err := ParseAPIVersion(instance.ApiVersion)
+	if err != nil {
+		log.Warn().Err(err).Str("instance", instance.Name).Msg("Detecting the Sonarr API version instead")
+		version = APIAuto
 	}
 	return SonarrClientImpl{
-		webClient:          utils.GetWebClient(),
+		webClient:          apiKeyClient(instance.ApiKey),
 		BaseSonarrEndpoint: endpoint,
+		pathMapper:         instance.PathMappings,
+		version:            newAPIVersionResolver(version),
+		apiKey:             instance.ApiKey,
 	}
 }
 
-func (c SonarrClientImpl) sonarrGetRequest(path string, query url.Values, respBody interface{}) error {
-	query.Add("apikey", config.GetConfig().SonarrApiKey)
-	return c.webClient.GetRequest(c.BaseSonarrEndpoint, path, query, respBody)
+// PathMapper translates between the paths Sonarr reports and the paths on this host
+func (c SonarrClientImpl) PathMapper() pathmap.Mapper {
+	return c.pathMapper
+}
+
+func (c SonarrClientImpl) apiVersion() (APIVersion, error) {
+	return c.version.resolve(c.webClient, c.BaseSonarrEndpoint)
+}
+
+// sonarrGetRequest requests an API resource, like "episodeFile", from the route of the server's API version
+func (c SonarrClientImpl) sonarrGetRequest(resource string, query url.Values, respBody interface{}) error {
+	version, err := c.apiVersion()
+	if err != nil {
+		return err
+	}
+	query = version.authorize(query, c.apiKey)
+	return c.webClient.GetRequest(c.BaseSonarrEndpoint, version.route(resource), query, respBody)
 }
 
-func (c SonarrClientImpl) sonarrPostRequest(path string, query url.Values, body interface{}, respBody interface{}) error {
-	query.Add("apikey", config.GetConfig().SonarrApiKey)
-	return c.webClient.PostRequest(c.BaseSonarrEndpoint, path, query, body, respBody)
+func (c SonarrClientImpl) sonarrPostRequest(resource string, query url.Values, body interface{}, respBody interface{}) error {
+	version, err := c.apiVersion()
+	if err != nil {
+		return err
+	}
+	query = version.authorize(query, c.apiKey)
+	return c.webClient.PostRequest(c.BaseSonarrEndpoint, version.route(resource), query, body, respBody)
 }
 
 func (c SonarrClientImpl) GetAllEpisodeFiles(seriesId int) ([]SonarrEpisodeFile, error) {
 	response := make([]SonarrEpisodeFile, 0)
 	vals := url.Values{}
 	vals.Add("seriesId", strconv.Itoa(seriesId))
-	err := c.sonarrGetRequest("api/episodeFile", vals, &response)
+	err := c.sonarrGetRequest("episodeFile", vals, &response)
 
 	if err == utils.NotFoundError {
 		return response, nil
@@ -59,7 +109,7 @@ func (c SonarrClientImpl) GetAllEpisodeFiles(seriesId int) ([]SonarrEpisodeFile,
 
 func (c SonarrClientImpl) GetAllSeries() ([]Series, error) {
 	response := make([]Series, 0)
-	err := c.sonarrGetRequest("api/series/", url.Values{}, &response)
+	err := c.sonarrGetRequest("series", url.Values{}, &response)
 
 	if err == utils.NotFoundError {
 		return response, nil
@@ -67,9 +117,25 @@ func (c SonarrClientImpl) GetAllSeries() ([]Series, error) {
 	return response, err
 }
 
+func (c SonarrClientImpl) LookupSeries(id int) (*Series, error) {
+	var response Series
+	err := c.sonarrGetRequest(fmt.Sprintf("series/%d", id), url.Values{}, &response)
+	if err == utils.NotFoundError {
+		return nil, nil
+	}
+	return &response, err
+}
+
+func (c SonarrClientImpl) GetTags() ([]Tag, error) {
+	response := make([]Tag, 0)
+	err := c.sonarrGetRequest("tag", url.Values{}, &response)
+	return response, err
+}
+
 func (c SonarrClientImpl) CheckSonarrCommand(id int) (*SonarrCommand, error) {
 	var response SonarrCommand
-	err := c.sonarrGetRequest(fmt.Sprintf("api/command/%d", id), url.Values{}, &response)
+	err := c.sonarrGetRequest(fmt.Sprintf("command/%d", id), url.Values{}, &response)
+	response.normalize()
 	return &response, err
 }
 
@@ -81,14 +147,15 @@ func (c SonarrClientImpl) RescanSeries(id int64) (*SonarrCommand, error) {
 	payload["seriesId"] = id
 
 	var response SonarrCommand
-	err := c.sonarrPostRequest("api/command", url.Values{}, payload, &response)
+	err := c.sonarrPostRequest("command", url.Values{}, payload, &response)
+	response.normalize()
 
 	return &response, err
 }
 
 func (c SonarrClientImpl) LookupTVEpisode(id int64) (*SonarrEpisodeFile, error) {
 	var response SonarrEpisodeFile
-	err := c.sonarrGetRequest(fmt.Sprintf("api/episodeFile/%d", id), url.Values{}, &response)
+	err := c.sonarrGetRequest(fmt.Sprintf("episodeFile/%d", id), url.Values{}, &response)
 	if err == utils.NotFoundError {
 		return nil, nil
 	}
@@ -101,7 +168,7 @@ func (c SonarrClientImpl) GetEpisodeFilePath(id int64) (string, int, error) {
 		return "", -1, err
 	}
 	if episodeFile != nil {
-		return episodeFile.Path, episodeFile.SeriesID, nil
+		return c.pathMapper.ToLocal(episodeFile.Path), episodeFile.SeriesID, nil
 	} else {
 		log.Warn().Msg("Could not find episodeFile")
 	}
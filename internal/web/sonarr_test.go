@@ -3,6 +3,7 @@ package web
 import (
 	"encoding/json"
 	"fmt"
+	"media-web/internal/pathmap"
 	"media-web/internal/utils"
 	"net/http"
 	"net/http/httptest"
@@ -146,3 +147,140 @@ func TestLookupTVEpisodeReturnsSuccess(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, episodeFile.ID)
 }
+
+func TestGetEpisodeFilePathMapsToLocal(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&SonarrEpisodeFile{ID: 1, SeriesID: 2, Path: "/tv/Show/Season 1/S01E01.mkv"})
+	}))
+	defer srv.Close()
+
+	parsed, _ := url.Parse(srv.URL)
+	client := SonarrClientImpl{
+		webClient:          utils.GetWebClient(),
+		BaseSonarrEndpoint: *parsed,
+		pathMapper:         pathmap.Mapper{{Remote: "/tv", Local: "/mnt/nas/tv"}},
+	}
+
+	path, seriesId, err := client.GetEpisodeFilePath(1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/nas/tv/Show/Season 1/S01E01.mkv", path)
+	assert.Equal(t, 2, seriesId)
+}
+
+func TestImportedFilesPrefersEpisodeFiles(t *testing.T) {
+	var body SonarrWebhook
+	err := json.Unmarshal([]byte(`{"eventType": "Download", "episodeFile": {"id": 1}, "episodeFiles": [{"id": 2}, {"id": 3}]}`), &body)
+
+	assert.NoError(t, err)
+	files := body.ImportedFiles()
+	assert.Len(t, files, 2)
+	assert.Equal(t, 2, files[0].ID)
+	assert.Equal(t, 3, files[1].ID)
+}
+
+func TestImportedFilesFallsBackToEpisodeFile(t *testing.T) {
+	var body SonarrWebhook
+	err := json.Unmarshal([]byte(`{"eventType": "Download", "episodeFile": {"id": 1}}`), &body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []SonarrWebhookEpisodeFile{{ID: 1}}, body.ImportedFiles())
+	assert.Empty(t, SonarrWebhook{}.ImportedFiles())
+}
+
+func sonarrV3Client(t *testing.T, handler http.HandlerFunc) (SonarrClientImpl, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.URL.Query().Get("apikey"))
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		handler(w, r)
+	}))
+	parsed, _ := url.Parse(srv.URL)
+	client := SonarrClientImpl{
+		webClient:          apiKeyClient("secret"),
+		BaseSonarrEndpoint: *parsed,
+		version:            newAPIVersionResolver(APIV3),
+	}
+	return client, srv.Close
+}
+
+func TestLookupTVEpisodeV3(t *testing.T) {
+	client, closer := sonarrV3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/episodeFile/42", r.URL.Path)
+		serveFixture(t, w, "sonarr_v3_episodefile.json")
+	})
+	defer closer()
+
+	episodeFile, err := client.LookupTVEpisode(42)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 42, episodeFile.ID)
+	assert.Equal(t, 3, episodeFile.SeriesID)
+	assert.EqualValues(t, 3298341019, episodeFile.Size)
+	assert.Equal(t, "/tv/Sintel/Season 01/Sintel - S01E01 - Pilot WEBDL-1080p.mkv", episodeFile.Path)
+}
+
+func TestGetAllSeriesV3(t *testing.T) {
+	client, closer := sonarrV3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/series", r.URL.Path)
+		serveFixture(t, w, "sonarr_v3_series.json")
+	})
+	defer closer()
+
+	series, err := client.GetAllSeries()
+
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	assert.Equal(t, 3, series[0].ID)
+	assert.Equal(t, "/tv/Sintel", series[0].Path)
+	count, size := series[0].FileStats()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(3298341019), size)
+}
+
+func TestLookupSeriesV3(t *testing.T) {
+	client, closer := sonarrV3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/series/3", r.URL.Path)
+		http.NotFound(w, r)
+	})
+	defer closer()
+
+	series, err := client.LookupSeries(3)
+
+	assert.NoError(t, err)
+	assert.Nil(t, series)
+}
+
+func TestGetSonarrTagsV3(t *testing.T) {
+	client, closer := sonarrV3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/tag", r.URL.Path)
+		serveFixture(t, w, "tags.json")
+	})
+	defer closer()
+
+	tags, err := client.GetTags()
+
+	assert.NoError(t, err)
+	assert.Len(t, tags, 2)
+	assert.Equal(t, "transcode", tags[0].Label)
+}
+
+func TestRescanSeriesV3(t *testing.T) {
+	client, closer := sonarrV3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		payload := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		assert.Equal(t, "/api/v3/command", r.URL.Path)
+		assert.Equal(t, "RescanSeries", payload["name"])
+		assert.EqualValues(t, 3, payload["seriesId"])
+		w.WriteHeader(http.StatusCreated)
+		serveFixture(t, w, "sonarr_v3_command.json")
+	})
+	defer closer()
+
+	cmd, err := client.RescanSeries(3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 27, cmd.ID)
+	assert.Equal(t, "queued", cmd.State)
+}
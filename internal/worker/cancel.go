@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrJobCancelled is returned by a transcode stopped through the cancel API. The job isn't retried
+var ErrJobCancelled = errors.New("job cancelled")
+
+// ErrJobInterrupted is returned by a transcode killed because the worker is shutting down. The job is queued again
+var ErrJobInterrupted = errors.New("job interrupted by shutdown")
+
+// cancelTTL is how long a cancel request waits for the worker running the job to see it
+const cancelTTL = 24 * time.Hour
+
+// cancelPollInterval is how often a running transcode checks whether it was cancelled
+var cancelPollInterval = 2 * time.Second
+
+// JobCanceller passes cancel requests from the web service to the worker running the job, which may be in another
+// container
+type JobCanceller interface {
+	RequestCancel(id string) error
+	CancelRequested(id string) (bool, error)
+	ClearCancel(id string) error
+}
+
+type redisJobCanceller struct {
+	namespace string
+	pool      *redis.Pool
+}
+
+// NewJobCanceller creates a JobCanceller keeping cancel requests in Redis
+func NewJobCanceller(namespace string, pool *redis.Pool) JobCanceller {
+	return redisJobCanceller{namespace: namespace, pool: pool}
+}
+
+// GetJobCanceller returns the JobCanceller for the configured job queue
+func GetJobCanceller() JobCanceller {
+	return NewJobCanceller(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+}
+
+func (c redisJobCanceller) key(id string) string {
+	return namespacePrefix(c.namespace) + "cancel:" + id
+}
+
+func (c redisJobCanceller) RequestCancel(id string) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", c.key(id), 1, "EX", int(cancelTTL.Seconds()))
+	return err
+}
+
+func (c redisJobCanceller) CancelRequested(id string) (bool, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	return redis.Bool(conn.Do("EXISTS", c.key(id)))
+}
+
+func (c redisJobCanceller) ClearCancel(id string) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", c.key(id))
+	return err
+}
+
+// jobContext returns the context an encode runs under. It is cancelled when a cancel is requested for the job or
+// when the drain timeout runs out during shutdown
+func (c *WorkerContext) jobContext(job *work.Job) (context.Context, context.CancelFunc) {
+	base := c.Interrupt
+	if base == nil {
+		base = context.Background()
+	}
+	ctx, cancel := context.WithCancel(base)
+	if c.Canceller == nil {
+		return ctx, cancel
+	}
+
+	ticker := time.NewTicker(cancelPollInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				requested, err := c.Canceller.CancelRequested(job.ID)
+				if err != nil {
+					log.Warn().Err(err).Str("jobId", job.ID).Msg("Failed to check for cancel request")
+					continue
+				}
+				if requested {
+					log.Info().Str("jobId", job.ID).Msg("Cancelling job")
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// stoppedError tells a job stopped through the cancel API apart from one interrupted by shutdown
+func (c *WorkerContext) stoppedError(job *work.Job) error {
+	if c.Interrupt != nil && c.Interrupt.Err() != nil {
+		return ErrJobInterrupted
+	}
+	if c.Canceller != nil {
+		if err := c.Canceller.ClearCancel(job.ID); err != nil {
+			log.Warn().Err(err).Str("jobId", job.ID).Msg("Failed to clear cancel request")
+		}
+	}
+	return ErrJobCancelled
+}
+
+// HandleCancellation is a middleware that keeps cancelled jobs from being retried and queues interrupted jobs
+// again without counting a failure
+func (c *WorkerContext) HandleCancellation(job *work.Job, next work.NextMiddlewareFunc) error {
+	err := next()
+	switch err {
+	case ErrJobCancelled:
+		log.Info().Str("jobId", job.ID).Msg("Job cancelled")
+		return nil
+	case ErrJobInterrupted:
+		// The unique lock is released when a job starts, so the same job can be queued again
+		if _, enqueueErr := c.Enqueuer.EnqueueUnique(job.Name, job.Args); enqueueErr != nil {
+			log.Error().Err(enqueueErr).Str("jobId", job.ID).Msg("Failed to queue interrupted job again")
+			return err
+		}
+		log.Info().Str("jobId", job.ID).Msg("Queued interrupted job again")
+		return nil
+	}
+	return err
+}
+
+// jobStatus is how a finished job is reported in the metrics and history
+func jobStatus(err error) string {
+	switch err {
+	case nil:
+		return "success"
+	case ErrJobCancelled:
+		return "cancelled"
+	case ErrJobInterrupted:
+		return "interrupted"
+	}
+	return "error"
+}
+
+// drainContext returns a context that is cancelled timeout after ctx is done, giving running jobs time to finish
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-drain.Done():
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.Warn().Msg("Drain timeout reached. Stopping running jobs")
+			cancel()
+		case <-drain.Done():
+		}
+	}()
+	return drain, cancel
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryCanceller struct {
+	mu        sync.Mutex
+	requested map[string]bool
+}
+
+func (m *memoryCanceller) RequestCancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requested == nil {
+		m.requested = map[string]bool{}
+	}
+	m.requested[id] = true
+	return nil
+}
+
+func (m *memoryCanceller) CancelRequested(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requested[id], nil
+}
+
+func (m *memoryCanceller) ClearCancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.requested, id)
+	return nil
+}
+
+func TestJobContextStopsOnCancelRequest(t *testing.T) {
+	defer func(interval time.Duration) { cancelPollInterval = interval }(cancelPollInterval)
+	cancelPollInterval = time.Millisecond
+	canceller := &memoryCanceller{}
+	context := WorkerContext{Canceller: canceller}
+
+	ctx, cancel := context.jobContext(&work.Job{ID: "job"})
+	defer cancel()
+	assert.NoError(t, canceller.RequestCancel("job"))
+
+	assert.Eventually(t, func() bool { return ctx.Err() != nil }, time.Second, time.Millisecond)
+	assert.Equal(t, ErrJobCancelled, context.stoppedError(&work.Job{ID: "job"}))
+	requested, _ := canceller.CancelRequested("job")
+	assert.False(t, requested)
+}
+
+func TestHandleCancellationDoesNotRetryCancelledJobs(t *testing.T) {
+	context := WorkerContext{}
+
+	err := context.HandleCancellation(&work.Job{ID: "job"}, func() error { return ErrJobCancelled })
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCancellationQueuesInterruptedJobsAgain(t *testing.T) {
+	args := map[string]interface{}{"movieId": 1}
+	w := mockWorker{}
+	w.On("EnqueueUnique", "transcode", args).Once().Return(&work.Job{ID: "again"}, nil)
+	context := WorkerContext{Enqueuer: &w}
+
+	err := context.HandleCancellation(&work.Job{ID: "job", Name: "transcode", Args: args}, func() error { return ErrJobInterrupted })
+
+	assert.NoError(t, err)
+	w.AssertExpectations(t)
+}
+
+func TestHandleCancellationRetriesWhenRequeueFails(t *testing.T) {
+	w := mockWorker{}
+	w.On("EnqueueUnique", "transcode", map[string]interface{}(nil)).Once().Return(nil, errors.New("redis is down"))
+	context := WorkerContext{Enqueuer: &w}
+
+	err := context.HandleCancellation(&work.Job{ID: "job", Name: "transcode"}, func() error { return ErrJobInterrupted })
+
+	assert.Equal(t, ErrJobInterrupted, err)
+}
+
+func TestJobStatus(t *testing.T) {
+	assert.Equal(t, "success", jobStatus(nil))
+	assert.Equal(t, "error", jobStatus(errors.New("boom")))
+	assert.Equal(t, "cancelled", jobStatus(ErrJobCancelled))
+	assert.Equal(t, "interrupted", jobStatus(ErrJobInterrupted))
+}
+
+func TestDrainContextWaitsForTimeout(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	drain, cancel := drainContext(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, drain.Err())
+	stop()
+	assert.NoError(t, drain.Err())
+	assert.Eventually(t, func() bool { return drain.Err() != nil }, time.Second, time.Millisecond)
+}
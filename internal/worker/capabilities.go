@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+	"media-web/internal/utils"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// capabilityTTL is how long a worker class is routed to after its workers last advertised it
+const capabilityTTL = 5 * time.Minute
+
+// advertiseInterval is how often a worker advertises its class
+var advertiseInterval = time.Minute
+
+// Capabilities describes what the workers of a class can run. Every worker of a class is expected to share them
+type Capabilities struct {
+	Class string `json:"class"`
+	// MaxEncodes is how many transcodes the workers of the class run at a time between them
+	MaxEncodes uint `json:"maxEncodes"`
+	// Profiles limits the class to these transcode profiles. Empty allows every profile
+	Profiles []string `json:"profiles,omitempty"`
+	// PathRoots limits the class to files under these folders, as Sonarr, Radarr or Lidarr report them. Empty
+	// allows every file
+	PathRoots []string `json:"pathRoots,omitempty"`
+	CPUClass  string   `json:"cpuClass,omitempty"`
+	SeenAt    int64    `json:"seenAt"`
+}
+
+// Live reports whether a worker of the class advertised it recently enough to route jobs to it
+func (c Capabilities) Live(now time.Time) bool {
+	return c.SeenAt >= now.Add(-capabilityTTL).Unix()
+}
+
+// CapabilitiesFromConfig returns the capabilities this worker advertises
+func CapabilitiesFromConfig(cfg config.Config) Capabilities {
+	return Capabilities{
+		Class:      cfg.WorkerClass,
+		MaxEncodes: cfg.WorkerMaxEncodes,
+		Profiles:   cfg.WorkerProfiles,
+		PathRoots:  cfg.WorkerPathRoots,
+		CPUClass:   cfg.WorkerCPUClass,
+	}
+}
+
+// Allows reports whether the class can transcode the file at path with the named profile. A profile with a CPU
+// class only runs on classes advertising it. path is empty when it isn't known, which only classes without path
+// roots allow
+func (c Capabilities) Allows(profile string, cpuClass string, path string) bool {
+	if cpuClass != "" && cpuClass != c.CPUClass {
+		return false
+	}
+	if len(c.Profiles) > 0 && !containsString(c.Profiles, profile) {
+		return false
+	}
+	if len(c.PathRoots) == 0 {
+		return true
+	}
+	for _, root := range c.PathRoots {
+		if path != "" && utils.IsUnder(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassNamespace is the gocraft/work namespace holding the jobs of a worker class. Workers without a class use the
+// configured namespace
+func ClassNamespace(namespace string, class string) string {
+	if class == "" {
+		return namespace
+	}
+	return strings.TrimSuffix(namespace, ":") + "-" + class
+}
+
+// CapabilityRegistry is where worker classes advertise what they can run, so enqueuers can route jobs to them
+type CapabilityRegistry interface {
+	Advertise(capabilities Capabilities) error
+	// Classes returns every class that was ever advertised, ordered by name
+	Classes() ([]Capabilities, error)
+}
+
+type redisCapabilityRegistry struct {
+	key  string
+	pool *redis.Pool
+}
+
+// NewCapabilityRegistry creates a CapabilityRegistry storing the classes in a Redis hash keyed by class
+func NewCapabilityRegistry(namespace string, pool *redis.Pool) CapabilityRegistry {
+	return redisCapabilityRegistry{key: namespacePrefix(namespace) + "worker-classes", pool: pool}
+}
+
+// GetCapabilityRegistry returns the CapabilityRegistry for the configured job queue
+func GetCapabilityRegistry() CapabilityRegistry {
+	return NewCapabilityRegistry(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+}
+
+func (r redisCapabilityRegistry) Advertise(capabilities Capabilities) error {
+	capabilities.SeenAt = time.Now().Unix()
+	value, err := json.Marshal(capabilities)
+	if err != nil {
+		return err
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", r.key, capabilities.Class, value)
+	return err
+}
+
+func (r redisCapabilityRegistry) Classes() ([]Capabilities, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.key))
+	if err != nil {
+		return nil, err
+	}
+	classes := make([]Capabilities, 0, len(values))
+	for class, value := range values {
+		var capabilities Capabilities
+		if err = json.Unmarshal([]byte(value), &capabilities); err != nil {
+			log.Warn().Err(err).Str("class", class).Msg("Ignoring invalid worker class")
+			continue
+		}
+		classes = append(classes, capabilities)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].Class < classes[j].Class })
+	return classes, nil
+}
+
+// advertise keeps the worker's class in the registry until ctx is done
+func advertise(ctx context.Context, registry CapabilityRegistry, capabilities Capabilities) {
+	ticker := time.NewTicker(advertiseInterval)
+	defer ticker.Stop()
+	for {
+		if err := registry.Advertise(capabilities); err != nil {
+			log.Warn().Err(err).Str("class", capabilities.Class).Msg("Failed to advertise worker class")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
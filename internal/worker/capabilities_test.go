@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesAllows(t *testing.T) {
+	nas := Capabilities{Class: "nas", Profiles: []string{"default"}, PathRoots: []string{"/volume1/tv/"}}
+
+	assert.True(t, nas.Allows("default", "", "/volume1/tv/Show/episode.mkv"))
+	assert.False(t, nas.Allows("hevc", "", "/volume1/tv/Show/episode.mkv"))
+	assert.False(t, nas.Allows("default", "", "/volume1/tvshows/episode.mkv"))
+	assert.False(t, nas.Allows("default", "", ""))
+	assert.False(t, nas.Allows("default", "high", "/volume1/tv/Show/episode.mkv"))
+}
+
+func TestCapabilitiesWithoutLimitsAllowEverything(t *testing.T) {
+	big := Capabilities{Class: "big", CPUClass: "high"}
+
+	assert.True(t, big.Allows("hevc", "high", ""))
+	assert.True(t, big.Allows("default", "", "/media/movie.mkv"))
+	assert.False(t, big.Allows("hevc", "low", ""))
+}
+
+func TestCapabilitiesLive(t *testing.T) {
+	now := time.Now()
+
+	assert.True(t, Capabilities{SeenAt: now.Add(-time.Minute).Unix()}.Live(now))
+	assert.False(t, Capabilities{SeenAt: now.Add(-time.Hour).Unix()}.Live(now))
+}
+
+func TestClassNamespace(t *testing.T) {
+	assert.Equal(t, "media-web", ClassNamespace("media-web", ""))
+	assert.Equal(t, "media-web-nas", ClassNamespace("media-web", "nas"))
+	assert.Equal(t, "media-web-nas", ClassNamespace("media-web:", "nas"))
+}
+
+func TestRedisCapabilityRegistry(t *testing.T) {
+	pool, server := testRedis(t)
+	registry := NewCapabilityRegistry(testNamespace, pool)
+
+	classes, err := registry.Classes()
+	assert.NoError(t, err)
+	assert.Empty(t, classes)
+
+	assert.NoError(t, registry.Advertise(Capabilities{Class: "nas", MaxEncodes: 1, PathRoots: []string{"/volume1"}}))
+	assert.NoError(t, registry.Advertise(Capabilities{Class: "big", MaxEncodes: 4, CPUClass: "high"}))
+	assert.NoError(t, registry.Advertise(Capabilities{Class: "nas", MaxEncodes: 2}))
+	server.HSet(namespacePrefix(testNamespace)+"worker-classes", "broken", "{")
+
+	classes, err = registry.Classes()
+	assert.NoError(t, err)
+	assert.Len(t, classes, 2)
+	assert.Equal(t, "big", classes[0].Class)
+	assert.Equal(t, "high", classes[0].CPUClass)
+	assert.Equal(t, "nas", classes[1].Class)
+	assert.Equal(t, uint(2), classes[1].MaxEncodes)
+	assert.Empty(t, classes[1].PathRoots)
+	assert.True(t, classes[1].Live(time.Now()))
+}
@@ -0,0 +1,454 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/storage"
+	"media-web/internal/transcode"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// chunkTTL is how long an unfinished plan is kept before its progress is forgotten
+const chunkTTL = 7 * 24 * time.Hour
+
+// chunkMaxFails is how often a chunk may fail before the transcode it belongs to gives up
+const chunkMaxFails = 3
+
+// chunkPollInterval is how often a chunked transcode checks on its chunks
+var chunkPollInterval = 5 * time.Second
+
+// ChunkSettings controls when a transcode is split into chunks
+type ChunkSettings struct {
+	// MinDuration is the shortest source that is chunked. 0 turns chunking off
+	MinDuration time.Duration
+	// Duration is the length chunks aim for. Chunks end at the next keyframe so most are a little longer
+	Duration time.Duration
+}
+
+// ChunkPlan is a source split into chunks along with where the encoded chunks are written. The directory has to
+// be shared by every worker that runs chunk jobs
+type ChunkPlan struct {
+	Input    string            `json:"input"`
+	Dir      string            `json:"dir"`
+	Profile  string            `json:"profile"`
+	Duration float64           `json:"duration"`
+	Chunks   []transcode.Chunk `json:"chunks"`
+}
+
+// ChunkPath is where the chunk with the given index is written once it is encoded
+func (p ChunkPlan) ChunkPath(index int) string {
+	return filepath.Join(p.Dir, fmt.Sprintf("%04d.partial", index))
+}
+
+// ChunkProgress is the state of the chunks of a plan, keyed by chunk index
+type ChunkProgress struct {
+	Done   map[int]bool
+	Fails  map[int]int
+	Errors map[int]string
+}
+
+// ChunkStore keeps chunk plans and which of their chunks are encoded, so finished chunks survive a restart
+type ChunkStore interface {
+	// LoadPlan returns nil when there is no plan for key
+	LoadPlan(key string) (*ChunkPlan, error)
+	SavePlan(key string, plan ChunkPlan) error
+	ChunkDone(key string, index int) error
+	ChunkFailed(key string, index int, cause error) error
+	Progress(key string) (ChunkProgress, error)
+	ResetFailures(key string) error
+	DeletePlan(key string) error
+}
+
+type redisChunkStore struct {
+	prefix string
+	pool   *redis.Pool
+}
+
+// NewChunkStore creates a ChunkStore keeping each plan and its progress in a Redis hash
+func NewChunkStore(namespace string, pool *redis.Pool) ChunkStore {
+	return redisChunkStore{prefix: namespacePrefix(namespace) + "chunks:", pool: pool}
+}
+
+// GetChunkStore returns the ChunkStore for the configured job queue
+func GetChunkStore() ChunkStore {
+	return NewChunkStore(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+}
+
+func (s redisChunkStore) LoadPlan(key string) (*ChunkPlan, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("HGET", s.prefix+key, "plan"))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plan ChunkPlan
+	if err = json.Unmarshal(value, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (s redisChunkStore) SavePlan(key string, plan ChunkPlan) error {
+	value, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	return s.set(key, "HSET", "plan", value)
+}
+
+func (s redisChunkStore) ChunkDone(key string, index int) error {
+	return s.set(key, "HSET", "done:"+strconv.Itoa(index), 1)
+}
+
+func (s redisChunkStore) ChunkFailed(key string, index int, cause error) error {
+	if err := s.set(key, "HSET", "error:"+strconv.Itoa(index), cause.Error()); err != nil {
+		return err
+	}
+	return s.set(key, "HINCRBY", "fails:"+strconv.Itoa(index), 1)
+}
+
+// set runs a hash command and pushes back the expiry of the plan
+func (s redisChunkStore) set(key string, command string, field string, value interface{}) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	_ = conn.Send(command, s.prefix+key, field, value)
+	_ = conn.Send("EXPIRE", s.prefix+key, int(chunkTTL.Seconds()))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (s redisChunkStore) Progress(key string) (ChunkProgress, error) {
+	progress := ChunkProgress{Done: map[int]bool{}, Fails: map[int]int{}, Errors: map[int]string{}}
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.StringMap(conn.Do("HGETALL", s.prefix+key))
+	if err != nil {
+		return progress, err
+	}
+	for field, value := range fields {
+		i := strings.IndexByte(field, ':')
+		if i < 0 {
+			continue
+		}
+		index, err := strconv.Atoi(field[i+1:])
+		if err != nil {
+			continue
+		}
+		switch field[:i] {
+		case "done":
+			progress.Done[index] = true
+		case "fails":
+			progress.Fails[index], _ = strconv.Atoi(value)
+		case "error":
+			progress.Errors[index] = value
+		}
+	}
+	return progress, nil
+}
+
+func (s redisChunkStore) ResetFailures(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.Strings(conn.Do("HKEYS", s.prefix+key))
+	if err != nil {
+		return err
+	}
+	args := redis.Args{}.Add(s.prefix + key)
+	for _, field := range fields {
+		if strings.HasPrefix(field, "fails:") || strings.HasPrefix(field, "error:") {
+			args = args.Add(field)
+		}
+	}
+	if len(args) == 1 {
+		return nil
+	}
+	_, err = conn.Do("HDEL", args...)
+	return err
+}
+
+func (s redisChunkStore) DeletePlan(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", s.prefix+key)
+	return err
+}
+
+// chunkPlanKey identifies a plan by the source file and everything that changes how its chunks are encoded, so a
+// replaced file or edited profile starts over
+func chunkPlanKey(input string, info os.FileInfo, profile transcode.Profile, chunkDuration time.Duration) string {
+	hash := fnv.New64a()
+	_, _ = fmt.Fprintf(hash, "%s|%d|%d|%+v|%s", input, info.Size(), info.ModTime().UnixNano(), profile, chunkDuration)
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// chunkDir is where the chunks of a plan are written, next to the scratch output
+func chunkDir(newPath string, key string) string {
+	return filepath.Join(scratchDir(newPath), filepath.Base(newPath)+"."+key+".chunks")
+}
+
+// canChunk reports whether a transcode may be split into chunks. Only the video is chunked, so there has to be
+// exactly one video stream to re-encode
+func (c *WorkerContext) canChunk(opts transcode.Options) bool {
+	if c.Chunks == nil || c.Keyframes == nil || c.Chunking.MinDuration <= 0 || c.Chunking.Duration <= 0 {
+		return false
+	}
+	if opts.Decision != transcode.FullTranscode || opts.Profile.IsAudio() || opts.Info == nil {
+		return false
+	}
+	return len(opts.Info.StreamsOfType("video")) == 1 && opts.Duration() >= c.Chunking.MinDuration
+}
+
+// chunkPlan loads the plan of an earlier attempt at the same transcode or splits the source at its keyframes.
+// A nil plan means the source is too short to be worth splitting
+func (c *WorkerContext) chunkPlan(input string, newPath string, opts transcode.Options) (string, *ChunkPlan, error) {
+	stat, err := os.Stat(input)
+	if err != nil {
+		return "", nil, err
+	}
+	key := chunkPlanKey(input, stat, opts.Profile, c.Chunking.Duration)
+	plan, err := c.Chunks.LoadPlan(key)
+	if err != nil || plan != nil {
+		return key, plan, err
+	}
+
+	keyframes, err := c.Keyframes.Keyframes(input)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read keyframes")
+	}
+	duration := opts.Duration().Seconds()
+	chunks := transcode.PlanChunks(keyframes, duration, c.Chunking.Duration.Seconds())
+	if len(chunks) < 2 {
+		return key, nil, nil
+	}
+	plan = &ChunkPlan{Input: input, Dir: chunkDir(newPath, key), Profile: opts.Profile.Name, Duration: duration, Chunks: chunks}
+	if err = c.Chunks.SavePlan(key, *plan); err != nil {
+		return "", nil, err
+	}
+	log.Info().Str("plan", key).Int("chunks", len(chunks)).Msg("Split transcode into chunks: " + input)
+	return key, plan, nil
+}
+
+// encodeChunks queues a job for every chunk that isn't encoded yet, waits for them and joins the chunks into
+// outputPath. Finished chunks are kept when the job is interrupted or will be retried, so the next attempt only
+// encodes the rest
+func (c *WorkerContext) encodeChunks(ctx context.Context, job *work.Job, key string, plan *ChunkPlan, outputPath string,
+	opts transcode.Options, progress func(transcode.Progress)) error {
+	err := c.runChunks(ctx, job, key, plan, outputPath, opts, progress)
+
+	interrupted := c.Interrupt != nil && c.Interrupt.Err() != nil
+	if interrupted || (err != nil && ctx.Err() == nil && job.Fails+1 < transcodeMaxFails) {
+		return err
+	}
+	// Deleting the plan also stops chunk jobs that are still running or queued
+	if deleteErr := c.Chunks.DeletePlan(key); deleteErr != nil {
+		log.Warn().Err(deleteErr).Str("plan", key).Msg("Failed to delete chunk plan")
+	}
+	if removeErr := os.RemoveAll(plan.Dir); removeErr != nil {
+		log.Warn().Err(removeErr).Msg("Failed to remove chunks: " + plan.Dir)
+	}
+	return err
+}
+
+func (c *WorkerContext) runChunks(ctx context.Context, job *work.Job, key string, plan *ChunkPlan, outputPath string,
+	opts transcode.Options, progress func(transcode.Progress)) error {
+	// Chunks that gave up on an earlier attempt get another go
+	if err := c.Chunks.ResetFailures(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(plan.Dir, 0755); err != nil {
+		return err
+	}
+	state, err := c.Chunks.Progress(key)
+	if err != nil {
+		return err
+	}
+	pending, err := c.pendingChunks(key)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range plan.Chunks {
+		if state.Done[chunk.Index] || pending[chunk.Index] {
+			continue
+		}
+		_, err = c.Enqueuer.EnqueueUnique(constants.TranscodeChunkJobName, work.Q{
+			constants.ChunkPlanKey:  key,
+			constants.ChunkIndexKey: chunk.Index,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		done := 0.0
+		count := 0
+		for _, chunk := range plan.Chunks {
+			if state.Fails[chunk.Index] >= chunkMaxFails {
+				return fmt.Errorf("chunk %d failed: %s", chunk.Index, state.Errors[chunk.Index])
+			}
+			if state.Done[chunk.Index] {
+				done += chunk.Duration()
+				count++
+			}
+		}
+		if count == len(plan.Chunks) {
+			break
+		}
+		job.Checkin(fmt.Sprintf("Transcoding: %s (%.1f%%, %d of %d chunks done)", plan.Input, done*100/plan.Duration,
+			count, len(plan.Chunks)))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(chunkPollInterval):
+		}
+		if state, err = c.Chunks.Progress(key); err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, len(plan.Chunks))
+	for i := range plan.Chunks {
+		paths[i] = plan.ChunkPath(i)
+	}
+	list := filepath.Join(plan.Dir, "list.txt")
+	if err = transcode.WriteConcatList(list, paths); err != nil {
+		return err
+	}
+
+	log.Info().Str("plan", key).Msg("Joining chunks: " + plan.Input)
+	opts.ChunkList = list
+	return c.Encoder.Encode(ctx, plan.Input, outputPath, opts, progress)
+}
+
+// pendingChunks returns the chunks of a plan that are queued, running, retrying or scheduled from an earlier
+// attempt. A running chunk no longer holds its unique lock, so queueing it again would encode it twice
+func (c *WorkerContext) pendingChunks(key string) (map[int]bool, error) {
+	pending := make(map[int]bool)
+	if c.JobInspector == nil {
+		return pending, nil
+	}
+	_, err := c.JobInspector.CountPendingJobs(func(other *work.Job) bool {
+		if other.Name != constants.TranscodeChunkJobName || other.ArgString(constants.ChunkPlanKey) != key {
+			return false
+		}
+		pending[int(other.ArgInt64(constants.ChunkIndexKey))] = true
+		return true
+	})
+	return pending, err
+}
+
+// TranscodeChunkHandler encodes the video of one chunk of a plan. Chunk jobs of a plan that is gone, because the
+// transcode finished or was cancelled, do nothing
+func (c *WorkerContext) TranscodeChunkHandler(job *work.Job) error {
+	key := job.ArgString(constants.ChunkPlanKey)
+	index := int(job.ArgInt64(constants.ChunkIndexKey))
+	if err := job.ArgError(); err != nil {
+		log.Warn().Err(err).Msg("Invalid chunk job")
+		return nil
+	}
+
+	err := c.encodeChunk(job, key, index)
+	if err == nil || err == ErrJobCancelled || err == ErrJobInterrupted {
+		return err
+	}
+	// The transcode waiting on the chunk only sees failures recorded in the store, whatever their cause
+	log.Error().Err(err).Str("plan", key).Int("chunk", index).Msg("Chunk transcode failed")
+	if failErr := c.Chunks.ChunkFailed(key, index, err); failErr != nil {
+		log.Warn().Err(failErr).Str("plan", key).Msg("Failed to record chunk failure")
+	}
+	return err
+}
+
+func (c *WorkerContext) encodeChunk(job *work.Job, key string, index int) error {
+	plan, err := c.Chunks.LoadPlan(key)
+	if err != nil {
+		return err
+	}
+	if plan == nil || index < 0 || index >= len(plan.Chunks) {
+		log.Debug().Str("plan", key).Int("chunk", index).Msg("Chunk no longer needed")
+		return nil
+	}
+	state, err := c.Chunks.Progress(key)
+	if err != nil {
+		return err
+	}
+	if state.Done[index] {
+		return nil
+	}
+	profile, err := transcode.GetProfiles().Get(plan.Profile)
+	if err != nil {
+		return err
+	}
+
+	chunk := plan.Chunks[index]
+	outputPath := plan.ChunkPath(index)
+	partialPath := outputPath + "." + job.ID
+	opts := transcode.Options{Profile: profile, Decision: transcode.FullTranscode, Chunk: &chunk}
+
+	ctx, cancel := c.jobContext(job)
+	defer cancel()
+	c.watchPlan(ctx, cancel, key)
+	err = c.Encoder.Encode(ctx, plan.Input, partialPath, opts, func(progress transcode.Progress) {
+		job.Checkin(fmt.Sprintf("Transcoding chunk %d of %d: %s (%.1f%% at %s)", index+1, len(plan.Chunks), plan.Input,
+			progress.Percent, progress.Speed))
+	})
+	if err != nil {
+		removePartial(partialPath)
+		if ctx.Err() != nil {
+			return c.stoppedError(job)
+		}
+		return err
+	}
+
+	if err = os.Rename(partialPath, outputPath); err != nil {
+		removePartial(partialPath)
+		return err
+	}
+	return c.Chunks.ChunkDone(key, index)
+}
+
+// watchPlan cancels a chunk encode once its plan is deleted
+func (c *WorkerContext) watchPlan(ctx context.Context, cancel context.CancelFunc, key string) {
+	ticker := time.NewTicker(cancelPollInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				plan, err := c.Chunks.LoadPlan(key)
+				if err == nil && plan == nil {
+					log.Info().Str("plan", key).Msg("Chunk plan removed. Stopping chunk")
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+}
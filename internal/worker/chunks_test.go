@@ -0,0 +1,414 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"media-web/internal/constants"
+	"media-web/internal/transcode"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type memoryChunkStore struct {
+	mu       sync.Mutex
+	plans    map[string]ChunkPlan
+	progress map[string]ChunkProgress
+}
+
+func newMemoryChunkStore() *memoryChunkStore {
+	return &memoryChunkStore{plans: map[string]ChunkPlan{}, progress: map[string]ChunkProgress{}}
+}
+
+func (m *memoryChunkStore) state(key string) ChunkProgress {
+	state, ok := m.progress[key]
+	if !ok {
+		state = ChunkProgress{Done: map[int]bool{}, Fails: map[int]int{}, Errors: map[int]string{}}
+		m.progress[key] = state
+	}
+	return state
+}
+
+func (m *memoryChunkStore) LoadPlan(key string) (*ChunkPlan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	plan, ok := m.plans[key]
+	if !ok {
+		return nil, nil
+	}
+	return &plan, nil
+}
+
+func (m *memoryChunkStore) SavePlan(key string, plan ChunkPlan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.plans[key] = plan
+	return nil
+}
+
+func (m *memoryChunkStore) ChunkDone(key string, index int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state(key).Done[index] = true
+	return nil
+}
+
+func (m *memoryChunkStore) ChunkFailed(key string, index int, cause error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state(key).Fails[index]++
+	m.state(key).Errors[index] = cause.Error()
+	return nil
+}
+
+func (m *memoryChunkStore) Progress(key string) (ChunkProgress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.state(key)
+	copied := ChunkProgress{Done: map[int]bool{}, Fails: map[int]int{}, Errors: map[int]string{}}
+	for i := range state.Done {
+		copied.Done[i] = true
+	}
+	for i, fails := range state.Fails {
+		copied.Fails[i] = fails
+	}
+	for i, cause := range state.Errors {
+		copied.Errors[i] = cause
+	}
+	return copied, nil
+}
+
+func (m *memoryChunkStore) ResetFailures(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.state(key)
+	for i := range state.Fails {
+		delete(state.Fails, i)
+		delete(state.Errors, i)
+	}
+	return nil
+}
+
+func (m *memoryChunkStore) DeletePlan(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.plans, key)
+	delete(m.progress, key)
+	return nil
+}
+
+type mockKeyframes struct {
+	keyframes []float64
+}
+
+func (m mockKeyframes) Keyframes(path string) ([]float64, error) {
+	return m.keyframes, nil
+}
+
+// longMovieInfo is an hour long movie with one video and one audio stream
+func longMovieInfo() *transcode.MediaInfo {
+	return &transcode.MediaInfo{
+		Format: transcode.ProbeFormat{Duration: "3600"},
+		Streams: []transcode.ProbeStream{
+			{Index: 0, CodecType: "video", CodecName: "mpeg2video"},
+			{Index: 1, CodecType: "audio", CodecName: "aac"},
+		},
+	}
+}
+
+// chunkingContext splits the movie at path into two chunks, one at 0 and one at 1800 seconds
+func chunkingContext(path string, store ChunkStore, trans transcode.Encoder, w WorkScheduler) WorkerContext {
+	return WorkerContext{
+		Encoder: trans,
+		Analyzer: MockAnalyzer{analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+			return transcode.FullTranscode, longMovieInfo(), nil
+		}},
+		Verifier:     MockVerifier{},
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+		Enqueuer:     w,
+		Chunks:       store,
+		Keyframes:    mockKeyframes{keyframes: []float64{0, 900, 1800, 2700}},
+		Chunking:     ChunkSettings{MinDuration: time.Hour, Duration: 30 * time.Minute},
+	}
+}
+
+func moviePlanKey(t *testing.T, path string) string {
+	stat, err := os.Stat(path)
+	assert.NoError(t, err)
+	profile, err := profileForJob(movieJob(), constants.Movie)
+	assert.NoError(t, err)
+	return chunkPlanKey(path, stat, profile, 30*time.Minute)
+}
+
+func chunkArgs(key string, index int) map[string]interface{} {
+	return map[string]interface{}{constants.ChunkPlanKey: key, constants.ChunkIndexKey: index}
+}
+
+func TestChunkedTranscodeJoinsChunks(t *testing.T) {
+	defer func(interval time.Duration) { chunkPollInterval = interval }(chunkPollInterval)
+	chunkPollInterval = time.Millisecond
+	path := movieFile(t)
+	key := moviePlanKey(t, path)
+	store := newMemoryChunkStore()
+	trans := &mockEncoder{}
+	w := mockWorker{}
+	for i := 0; i < 2; i++ {
+		index := i
+		w.On("EnqueueUnique", constants.TranscodeChunkJobName, chunkArgs(key, index)).Once().
+			Run(func(mock.Arguments) { _ = store.ChunkDone(key, index) }).
+			Return(&work.Job{ID: "chunk"}, nil)
+	}
+	w.On("EnqueueUnique", constants.UpdateRadarrJobName, mock.Anything).Once().Return(&work.Job{ID: "update"}, nil)
+	context := chunkingContext(path, store, trans, &w)
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.NoError(t, err)
+	dir := chunkDir(filepath.Join(filepath.Dir(path), "movie.mp4"), key)
+	assert.Equal(t, filepath.Join(dir, "list.txt"), trans.opts.ChunkList)
+	assert.Equal(t, path, trans.input)
+	assert.NoDirExists(t, dir)
+	assert.Empty(t, store.plans)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "movie.mp4"))
+	w.AssertExpectations(t)
+}
+
+func TestChunkedTranscodeOnlyQueuesUnfinishedChunks(t *testing.T) {
+	path := movieFile(t)
+	key := moviePlanKey(t, path)
+	store := newMemoryChunkStore()
+	_ = store.SavePlan(key, ChunkPlan{Input: path, Dir: filepath.Join(t.TempDir(), "chunks"), Profile: "default", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)})
+	_ = store.ChunkDone(key, 0)
+	interrupt, cancel := context.WithCancel(context.Background())
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeChunkJobName, chunkArgs(key, 1)).Once().
+		Run(func(mock.Arguments) { cancel() }).
+		Return(&work.Job{ID: "chunk"}, nil)
+	context := chunkingContext(path, store, &mockEncoder{}, &w)
+	context.Interrupt = interrupt
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.Equal(t, ErrJobInterrupted, err)
+	assert.Contains(t, store.plans, key)
+	assert.True(t, store.progress[key].Done[0])
+	w.AssertExpectations(t)
+}
+
+func TestChunkedTranscodeKeepsChunksForRetry(t *testing.T) {
+	defer func(interval time.Duration) { chunkPollInterval = interval }(chunkPollInterval)
+	chunkPollInterval = time.Millisecond
+	path := movieFile(t)
+	key := moviePlanKey(t, path)
+	store := newMemoryChunkStore()
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeChunkJobName, mock.Anything).Run(func(args mock.Arguments) {
+		index := args.Get(1).(map[string]interface{})[constants.ChunkIndexKey].(int)
+		for i := 0; i < chunkMaxFails; i++ {
+			_ = store.ChunkFailed(key, index, errors.New("ffmpeg failed"))
+		}
+	}).Return(&work.Job{ID: "chunk"}, nil)
+	context := chunkingContext(path, store, &mockEncoder{}, &w)
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.EqualError(t, err, "chunk 0 failed: ffmpeg failed")
+	assert.Contains(t, store.plans, key)
+	assert.DirExists(t, store.plans[key].Dir)
+
+	job := movieJob()
+	job.Fails = transcodeMaxFails - 1
+	err = context.TranscodeJobHandler(job)
+
+	assert.Error(t, err)
+	assert.Empty(t, store.plans)
+}
+
+func TestShortTranscodeIsNotChunked(t *testing.T) {
+	context := WorkerContext{Chunks: newMemoryChunkStore(), Keyframes: mockKeyframes{},
+		Chunking: ChunkSettings{MinDuration: 2 * time.Hour, Duration: 30 * time.Minute}}
+	opts := transcode.Options{Profile: transcode.DefaultProfile, Decision: transcode.FullTranscode, Info: longMovieInfo()}
+
+	assert.False(t, context.canChunk(opts))
+
+	context.Chunking.MinDuration = time.Hour
+	assert.True(t, context.canChunk(opts))
+
+	opts.Decision = transcode.Remux
+	assert.False(t, context.canChunk(opts))
+}
+
+func chunkJob(key string, index int) *work.Job {
+	return &work.Job{ID: "chunk", Name: constants.TranscodeChunkJobName, Args: chunkArgs(key, index)}
+}
+
+func TestTranscodeChunkEncodesChunk(t *testing.T) {
+	store := newMemoryChunkStore()
+	plan := ChunkPlan{Input: "/movies/movie.mkv", Dir: t.TempDir(), Profile: "default", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)}
+	_ = store.SavePlan("plan", plan)
+	trans := &mockEncoder{}
+	context := WorkerContext{Encoder: trans, Chunks: store}
+
+	err := context.TranscodeChunkHandler(chunkJob("plan", 1))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &plan.Chunks[1], trans.opts.Chunk)
+	assert.Equal(t, plan.ChunkPath(1)+".chunk", trans.output)
+	assert.FileExists(t, plan.ChunkPath(1))
+	assert.NoFileExists(t, trans.output)
+	assert.True(t, store.progress["plan"].Done[1])
+}
+
+func TestTranscodeChunkRecordsFailure(t *testing.T) {
+	store := newMemoryChunkStore()
+	plan := ChunkPlan{Input: "/movies/movie.mkv", Dir: t.TempDir(), Profile: "default", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)}
+	_ = store.SavePlan("plan", plan)
+	trans := &mockEncoder{encode: func(ctx context.Context, progress func(transcode.Progress)) error {
+		return errors.New("ffmpeg failed")
+	}}
+	context := WorkerContext{Encoder: trans, Chunks: store}
+
+	err := context.TranscodeChunkHandler(chunkJob("plan", 0))
+
+	assert.Error(t, err)
+	assert.NoFileExists(t, trans.output)
+	assert.Equal(t, 1, store.progress["plan"].Fails[0])
+	assert.Equal(t, "ffmpeg failed", store.progress["plan"].Errors[0])
+}
+
+func TestTranscodeChunkStopsWhenPlanIsDeleted(t *testing.T) {
+	defer func(interval time.Duration) { cancelPollInterval = interval }(cancelPollInterval)
+	cancelPollInterval = time.Millisecond
+	store := newMemoryChunkStore()
+	_ = store.SavePlan("plan", ChunkPlan{Input: "/movies/movie.mkv", Dir: t.TempDir(), Profile: "default", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)})
+	trans := &mockEncoder{encode: func(ctx context.Context, progress func(transcode.Progress)) error {
+		_ = store.DeletePlan("plan")
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	context := WorkerContext{Encoder: trans, Chunks: store}
+
+	err := context.TranscodeChunkHandler(chunkJob("plan", 0))
+
+	assert.Equal(t, ErrJobCancelled, err)
+	assert.NoFileExists(t, trans.output)
+}
+
+func TestTranscodeChunkIgnoresMissingPlan(t *testing.T) {
+	trans := &mockEncoder{}
+	context := WorkerContext{Encoder: trans, Chunks: newMemoryChunkStore()}
+
+	err := context.TranscodeChunkHandler(chunkJob("plan", 0))
+
+	assert.NoError(t, err)
+	assert.False(t, trans.encoded)
+}
+
+func TestChunkedTranscodeSkipsPendingChunks(t *testing.T) {
+	path := movieFile(t)
+	key := moviePlanKey(t, path)
+	store := newMemoryChunkStore()
+	interrupt, cancel := context.WithCancel(context.Background())
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeChunkJobName, chunkArgs(key, 0)).Once().
+		Run(func(mock.Arguments) { cancel() }).
+		Return(&work.Job{ID: "chunk"}, nil)
+	context := chunkingContext(path, store, &mockEncoder{}, &w)
+	context.Interrupt = interrupt
+	context.JobInspector = mockJobInspector{countPendingJobs: func(match func(job *work.Job) bool) (int, error) {
+		running := &work.Job{Name: constants.TranscodeChunkJobName, Args: chunkArgs(key, 1)}
+		other := &work.Job{Name: constants.TranscodeChunkJobName, Args: chunkArgs("other", 0)}
+		count := 0
+		for _, job := range []*work.Job{running, other} {
+			if match(job) {
+				count++
+			}
+		}
+		return count, nil
+	}}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.Equal(t, ErrJobInterrupted, err)
+	w.AssertExpectations(t)
+}
+
+func TestTranscodeChunkRecordsMissingProfile(t *testing.T) {
+	store := newMemoryChunkStore()
+	_ = store.SavePlan("plan", ChunkPlan{Input: "/movies/movie.mkv", Dir: t.TempDir(), Profile: "missing", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)})
+	trans := &mockEncoder{}
+	context := WorkerContext{Encoder: trans, Chunks: store}
+
+	err := context.TranscodeChunkHandler(chunkJob("plan", 0))
+
+	assert.Error(t, err)
+	assert.False(t, trans.encoded)
+	assert.Equal(t, 1, store.progress["plan"].Fails[0])
+	assert.Equal(t, err.Error(), store.progress["plan"].Errors[0])
+}
+
+func TestTranscodeChunkRecordsRenameFailure(t *testing.T) {
+	store := newMemoryChunkStore()
+	plan := ChunkPlan{Input: "/movies/movie.mkv", Dir: t.TempDir(), Profile: "default", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)}
+	_ = store.SavePlan("plan", plan)
+	// A directory in the way of the chunk makes the rename fail
+	assert.NoError(t, os.MkdirAll(filepath.Join(plan.ChunkPath(0), "taken"), 0755))
+	context := WorkerContext{Encoder: &mockEncoder{}, Chunks: store}
+
+	err := context.TranscodeChunkHandler(chunkJob("plan", 0))
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, store.progress["plan"].Fails[0])
+	assert.False(t, store.progress["plan"].Done[0])
+}
+
+func TestRedisChunkStore(t *testing.T) {
+	pool, server := testRedis(t)
+	store := NewChunkStore(testNamespace, pool)
+	plan := ChunkPlan{Input: "/movies/movie.mkv", Dir: "/scratch/plan", Profile: "default", Duration: 3600,
+		Chunks: transcode.PlanChunks([]float64{0, 1800}, 3600, 1800)}
+
+	loaded, err := store.LoadPlan("plan")
+	assert.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	assert.NoError(t, store.SavePlan("plan", plan))
+	loaded, err = store.LoadPlan("plan")
+	assert.NoError(t, err)
+	assert.Equal(t, &plan, loaded)
+
+	assert.NoError(t, store.ChunkDone("plan", 0))
+	assert.NoError(t, store.ChunkFailed("plan", 1, errors.New("ffmpeg failed")))
+	assert.NoError(t, store.ChunkFailed("plan", 1, errors.New("ffmpeg failed again")))
+	assert.Equal(t, chunkTTL, server.TTL(namespacePrefix(testNamespace)+"chunks:plan"))
+
+	progress, err := store.Progress("plan")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]bool{0: true}, progress.Done)
+	assert.Equal(t, map[int]int{1: 2}, progress.Fails)
+	assert.Equal(t, map[int]string{1: "ffmpeg failed again"}, progress.Errors)
+
+	assert.NoError(t, store.ResetFailures("plan"))
+	progress, err = store.Progress("plan")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]bool{0: true}, progress.Done)
+	assert.Empty(t, progress.Fails)
+	assert.Empty(t, progress.Errors)
+
+	assert.NoError(t, store.DeletePlan("plan"))
+	loaded, err = store.LoadPlan("plan")
+	assert.NoError(t, err)
+	assert.Nil(t, loaded)
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+)
+
+// classJobInspector is a JobInspector over the default queue and the queue of every worker class
+type classJobInspector struct {
+	inspectors func() ([]JobInspector, error)
+}
+
+// NewClassJobInspector creates a JobInspector for the jobs in namespace and in the namespaces of the worker classes
+// in registry. Cancel requests use namespace whichever queue the job is in, as that is where workers look for them
+func NewClassJobInspector(namespace string, registry CapabilityRegistry, pool *redis.Pool) JobInspector {
+	canceller := NewJobCanceller(namespace, pool)
+	return classJobInspector{inspectors: func() ([]JobInspector, error) {
+		classes, err := registry.Classes()
+		if err != nil {
+			return nil, err
+		}
+		inspectors := []JobInspector{NewJobInspector(namespace, pool)}
+		for _, class := range classes {
+			classNamespace := ClassNamespace(namespace, class.Class)
+			inspectors = append(inspectors, jobInspectorImpl{
+				client:    work.NewClient(classNamespace, pool),
+				namespace: classNamespace,
+				pool:      pool,
+				canceller: canceller,
+			})
+		}
+		return inspectors, nil
+	}}
+}
+
+// ListJobs merges the page of every queue, so a page holds up to jobsPageSize jobs from each of them
+func (c classJobInspector) ListJobs(status JobStatus, page uint) (*JobList, error) {
+	inspectors, err := c.inspectors()
+	if err != nil {
+		return nil, err
+	}
+	var merged *JobList
+	for _, inspector := range inspectors {
+		list, err := inspector.ListJobs(status, page)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = list
+			continue
+		}
+		merged.Total += list.Total
+		merged.Jobs = append(merged.Jobs, list.Jobs...)
+	}
+	return merged, nil
+}
+
+func (c classJobInspector) RetryDeadJob(diedAt int64, id string) error {
+	return c.first(func(inspector JobInspector) error {
+		return inspector.RetryDeadJob(diedAt, id)
+	})
+}
+
+func (c classJobInspector) DeleteQueuedJob(name string, id string) error {
+	return c.first(func(inspector JobInspector) error {
+		return inspector.DeleteQueuedJob(name, id)
+	})
+}
+
+func (c classJobInspector) CancelJob(id string) (bool, error) {
+	running := false
+	err := c.first(func(inspector JobInspector) error {
+		var err error
+		running, err = inspector.CancelJob(id)
+		return err
+	})
+	return running, err
+}
+
+func (c classJobInspector) CancelJobs(match func(job *work.Job) bool) ([]*work.Job, error) {
+	inspectors, err := c.inspectors()
+	if err != nil {
+		return nil, err
+	}
+	cancelled := make([]*work.Job, 0)
+	for _, inspector := range inspectors {
+		jobs, err := inspector.CancelJobs(match)
+		cancelled = append(cancelled, jobs...)
+		if err != nil {
+			return cancelled, err
+		}
+	}
+	return cancelled, nil
+}
+
+func (c classJobInspector) UpdateQueuedJobs(update func(job *work.Job) bool) (int, error) {
+	return c.sum(func(inspector JobInspector) (int, error) {
+		return inspector.UpdateQueuedJobs(update)
+	})
+}
+
+func (c classJobInspector) CountPendingJobs(match func(job *work.Job) bool) (int, error) {
+	return c.sum(func(inspector JobInspector) (int, error) {
+		return inspector.CountPendingJobs(match)
+	})
+}
+
+// first runs fn against each queue until one of them has the job
+func (c classJobInspector) first(fn func(inspector JobInspector) error) error {
+	inspectors, err := c.inspectors()
+	if err != nil {
+		return err
+	}
+	for _, inspector := range inspectors {
+		if err = fn(inspector); err != JobNotFoundError {
+			return err
+		}
+	}
+	return JobNotFoundError
+}
+
+// sum runs fn against every queue and adds up the results
+func (c classJobInspector) sum(fn func(inspector JobInspector) (int, error)) (int, error) {
+	inspectors, err := c.inspectors()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, inspector := range inspectors {
+		count, err := fn(inspector)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
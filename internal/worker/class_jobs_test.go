@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+type queueInspector struct {
+	JobInspector
+	jobs    []JobInfo
+	running map[string]bool
+}
+
+func (q queueInspector) ListJobs(status JobStatus, page uint) (*JobList, error) {
+	return &JobList{Status: status, Page: page, Total: int64(len(q.jobs)), Jobs: append([]JobInfo(nil), q.jobs...)}, nil
+}
+
+func (q queueInspector) CancelJob(id string) (bool, error) {
+	running, ok := q.running[id]
+	if !ok {
+		return false, JobNotFoundError
+	}
+	return running, nil
+}
+
+func (q queueInspector) CountPendingJobs(match func(job *work.Job) bool) (int, error) {
+	return len(q.jobs), nil
+}
+
+func classInspector(queues ...queueInspector) classJobInspector {
+	return classJobInspector{inspectors: func() ([]JobInspector, error) {
+		inspectors := make([]JobInspector, 0, len(queues))
+		for _, queue := range queues {
+			inspectors = append(inspectors, queue)
+		}
+		return inspectors, nil
+	}}
+}
+
+func TestClassJobInspectorMergesQueues(t *testing.T) {
+	inspector := classInspector(queueInspector{jobs: []JobInfo{{ID: "a"}}}, queueInspector{jobs: []JobInfo{{ID: "b"}, {ID: "c"}}})
+
+	list, err := inspector.ListJobs(JobsQueued, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), list.Total)
+	assert.Equal(t, []JobInfo{{ID: "a"}, {ID: "b"}, {ID: "c"}}, list.Jobs)
+
+	count, err := inspector.CountPendingJobs(func(job *work.Job) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestClassJobInspectorCancelsInAnyQueue(t *testing.T) {
+	inspector := classInspector(queueInspector{running: map[string]bool{"a": false}}, queueInspector{running: map[string]bool{"b": true}})
+
+	running, err := inspector.CancelJob("b")
+	assert.NoError(t, err)
+	assert.True(t, running)
+
+	_, err = inspector.CancelJob("c")
+	assert.Equal(t, JobNotFoundError, err)
+}
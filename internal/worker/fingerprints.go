@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"fmt"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+	"media-web/internal/web"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// Fingerprints are what a scan last saw of each series, episode file or movie that was found in the right format,
+// keyed by its kind and id. An incremental scan only checks what no longer matches its fingerprint
+type Fingerprints map[string]string
+
+// FingerprintStore keeps the fingerprints of each scanner between runs
+type FingerprintStore interface {
+	Load(scanner string, instance string) (Fingerprints, error)
+	// Save replaces the fingerprints of the scanner, dropping any that weren't carried over
+	Save(scanner string, instance string, fingerprints Fingerprints) error
+}
+
+type redisFingerprintStore struct {
+	prefix string
+	pool   *redis.Pool
+}
+
+// NewFingerprintStore creates a FingerprintStore keeping a Redis hash per scanner and instance
+func NewFingerprintStore(namespace string, pool *redis.Pool) FingerprintStore {
+	return redisFingerprintStore{prefix: namespacePrefix(namespace) + "fingerprints:", pool: pool}
+}
+
+// GetFingerprintStore returns the FingerprintStore for the configured job queue
+func GetFingerprintStore() FingerprintStore {
+	return NewFingerprintStore(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+}
+
+func (s redisFingerprintStore) key(scanner string, instance string) string {
+	return s.prefix + scanner + ":" + instance
+}
+
+func (s redisFingerprintStore) Load(scanner string, instance string) (Fingerprints, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", s.key(scanner, instance)))
+	if err != nil {
+		return nil, err
+	}
+	return Fingerprints(values), nil
+}
+
+func (s redisFingerprintStore) Save(scanner string, instance string, fingerprints Fingerprints) error {
+	key := s.key(scanner, instance)
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("DEL", key)
+	if len(fingerprints) > 0 {
+		args := redis.Args{}.Add(key)
+		for field, value := range fingerprints {
+			args = args.Add(field, value)
+		}
+		_ = conn.Send("HSET", args...)
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// loadFingerprints returns the fingerprints of the last scan. A full scan, or one without a store, starts from none
+func loadFingerprints(store FingerprintStore, scanner string, instance string, full bool) Fingerprints {
+	if store == nil || full {
+		return Fingerprints{}
+	}
+	fingerprints, err := store.Load(scanner, instance)
+	if err != nil {
+		log.Warn().Err(err).Str("scanner", scanner).Str("instance", instance).Msg("Failed to load fingerprints, scanning everything")
+		return Fingerprints{}
+	}
+	return fingerprints
+}
+
+func saveFingerprints(store FingerprintStore, scanner string, instance string, fingerprints Fingerprints) {
+	if store == nil {
+		return
+	}
+	if err := store.Save(scanner, instance, fingerprints); err != nil {
+		log.Warn().Err(err).Str("scanner", scanner).Str("instance", instance).Msg("Failed to save fingerprints")
+	}
+}
+
+// carryOver copies the fingerprints starting with prefix, like those of a series' episode files when the series
+// itself was unchanged and skipped
+func (f Fingerprints) carryOver(from Fingerprints, prefix string) {
+	for key, value := range from {
+		if strings.HasPrefix(key, prefix) {
+			f[key] = value
+		}
+	}
+}
+
+func seriesKey(seriesID int) string {
+	return fmt.Sprintf("series:%d", seriesID)
+}
+
+func episodeFileKey(file web.SonarrEpisodeFile) string {
+	return fmt.Sprintf("series:%d:file:%d", file.SeriesID, file.ID)
+}
+
+func movieKey(movie web.RadarrMovie) string {
+	return fmt.Sprintf("movie:%d", movie.ID)
+}
+
+// The fingerprints start with the scope from fingerprintScope. The tags and quality profile are included because the
+// filter rules can match on them
+
+func seriesFingerprint(scope string, series web.Series) string {
+	count, size := series.FileStats()
+	return fmt.Sprintf("%s|%d|%d|%d|%v|%d", scope, series.ID, count, size, series.Tags, series.QualityProfileID)
+}
+
+func episodeFileFingerprint(scope string, file web.SonarrEpisodeFile) string {
+	return fmt.Sprintf("%s|%d|%d|%s|%s|%s", scope, file.ID, file.Size, file.DateAdded.UTC().Format(time.RFC3339),
+		file.MediaInfo.VideoCodec, file.MediaInfo.AudioCodec)
+}
+
+func movieFingerprint(scope string, movie web.RadarrMovie) string {
+	file := movie.MovieFile
+	return fmt.Sprintf("%s|%d|%d|%s|%s|%s|%v|%d", scope, file.ID, file.Size, file.DateAdded.UTC().Format(time.RFC3339),
+		file.MediaInfo.VideoCodec, file.MediaInfo.AudioCodec, movie.Tags, movie.QualityProfileID)
+}
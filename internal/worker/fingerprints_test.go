@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/filter"
+	"media-web/internal/transcode"
+	"media-web/internal/web"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryFingerprints map[string]Fingerprints
+
+func (m memoryFingerprints) Load(scanner string, instance string) (Fingerprints, error) {
+	loaded := Fingerprints{}
+	for key, value := range m[scanner+":"+instance] {
+		loaded[key] = value
+	}
+	return loaded, nil
+}
+
+func (m memoryFingerprints) Save(scanner string, instance string, fingerprints Fingerprints) error {
+	m[scanner+":"+instance] = fingerprints
+	return nil
+}
+
+type failingFingerprints struct{}
+
+func (failingFingerprints) Load(scanner string, instance string) (Fingerprints, error) {
+	return nil, errors.New("redis is down")
+}
+
+func (failingFingerprints) Save(scanner string, instance string, fingerprints Fingerprints) error {
+	return errors.New("redis is down")
+}
+
+// countingAnalyzer counts the files it analyzes and decides they need a transcode when they are in wrongFormat
+func countingAnalyzer(analyzed map[string]int, wrongFormat map[string]bool) MockAnalyzer {
+	return MockAnalyzer{analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+		analyzed[path]++
+		if wrongFormat[path] {
+			return transcode.FullTranscode, nil, nil
+		}
+		return transcode.Skip, nil, nil
+	}}
+}
+
+func scannedMovie(id int, size int64) web.RadarrMovie {
+	movie := web.RadarrMovie{ID: id, Downloaded: true}
+	movie.MovieFile.ID = id
+	movie.MovieFile.Path = "/movies/" + string(rune('a'+id)) + ".mp4"
+	movie.MovieFile.Size = size
+	movie.MovieFile.DateAdded = time.Unix(1600000000, 0)
+	return movie
+}
+
+func TestIncrementalMovieScanSkipsUnchangedMovies(t *testing.T) {
+	movies := []web.RadarrMovie{scannedMovie(1, 100), scannedMovie(2, 200)}
+	client := MockRadarr{getAllMovies: func() ([]web.RadarrMovie, error) { return movies, nil }}
+	analyzed := map[string]int{}
+	wrongFormat := map[string]bool{"/movies/c.mp4": true}
+	store := memoryFingerprints{}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.Movie,
+		constants.MovieIdKey:       2,
+	}).Twice().Return(nil, nil)
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: transcode.DefaultProfileName}
+	scanner := NewInstanceMovieScanner(instance, client, &w, countingAnalyzer(analyzed, wrongFormat), store, filter.Rules{})
+
+	summary, err := scanner.ScanForMovies(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 2, Enqueued: 1}, summary)
+
+	// The movie in the right format is skipped. The one still waiting for its transcode is checked again
+	summary, err = scanner.ScanForMovies(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1, Skipped: 1, Enqueued: 1}, summary)
+	assert.Equal(t, 1, analyzed["/movies/b.mp4"])
+	assert.Equal(t, 2, analyzed["/movies/c.mp4"])
+
+	// Once transcoded the file changes and is checked once more
+	movies[1].MovieFile.Size = 150
+	wrongFormat["/movies/c.mp4"] = false
+	summary, err = scanner.ScanForMovies(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1, Skipped: 1}, summary)
+	w.AssertExpectations(t)
+	assert.Len(t, store["radarr:default"], 2)
+}
+
+func TestFullMovieScanChecksEveryMovie(t *testing.T) {
+	client := MockRadarr{getAllMovies: func() ([]web.RadarrMovie, error) {
+		return []web.RadarrMovie{scannedMovie(1, 100)}, nil
+	}}
+	analyzed := map[string]int{}
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: transcode.DefaultProfileName}
+	scanner := NewInstanceMovieScanner(instance, client, &mockWorker{}, countingAnalyzer(analyzed, nil), memoryFingerprints{}, filter.Rules{})
+
+	_, _ = scanner.ScanForMovies(false)
+	summary, err := scanner.ScanForMovies(true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1}, summary)
+	assert.Equal(t, 2, analyzed["/movies/b.mp4"])
+}
+
+func TestMovieScanFallsBackToFullScanWithoutFingerprints(t *testing.T) {
+	client := MockRadarr{getAllMovies: func() ([]web.RadarrMovie, error) {
+		return []web.RadarrMovie{scannedMovie(1, 100)}, nil
+	}}
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: transcode.DefaultProfileName}
+	scanner := NewInstanceMovieScanner(instance, client, &mockWorker{}, countingAnalyzer(map[string]int{}, nil), failingFingerprints{}, filter.Rules{})
+
+	summary, err := scanner.ScanForMovies(false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1}, summary)
+}
+
+func TestIncrementalTVScanSkipsUnchangedSeries(t *testing.T) {
+	series := []web.Series{
+		{ID: 1, Title: "Unchanged", EpisodeFileCount: 1, SizeOnDisk: 100},
+		{ID: 2, Title: "New episode", EpisodeFileCount: 1, SizeOnDisk: 200},
+	}
+	episodeFiles := map[int][]web.SonarrEpisodeFile{
+		1: {{ID: 10, SeriesID: 1, Path: "/tv/a/1.mp4", Size: 100}},
+		2: {{ID: 20, SeriesID: 2, Path: "/tv/b/1.mp4", Size: 200}},
+	}
+	fetched := map[int]int{}
+	client := MockSonarr{
+		getAllSeries: func() ([]web.Series, error) { return series, nil },
+		getAllEpisodeFiles: func(seriesId int) ([]web.SonarrEpisodeFile, error) {
+			fetched[seriesId]++
+			return episodeFiles[seriesId], nil
+		},
+	}
+	analyzed := map[string]int{}
+	store := memoryFingerprints{}
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: transcode.DefaultProfileName}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.TV,
+		constants.EpisodeFileIdKey: 21,
+		constants.SeriesIdKey:      2,
+	}).Once().Return(nil, nil)
+
+	summary, err := ScanInstanceForTVShows(instance, client, &w, countingAnalyzer(analyzed, nil), filter.Rules{}, store, false)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 2}, summary)
+
+	// A new episode of the second series changes its size. The first series isn't fetched again and the
+	// existing episode of the second one isn't analyzed again
+	series[1].EpisodeFileCount, series[1].SizeOnDisk = 2, 500
+	episodeFiles[2] = append(episodeFiles[2], web.SonarrEpisodeFile{ID: 21, SeriesID: 2, Path: "/tv/b/2.mkv", Size: 300})
+	summary, err = ScanInstanceForTVShows(instance, client, &w, countingAnalyzer(analyzed, map[string]bool{"/tv/b/2.mkv": true}), filter.Rules{}, store, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1, Skipped: 2, Enqueued: 1}, summary)
+	assert.Equal(t, 1, fetched[1])
+	assert.Equal(t, 2, fetched[2])
+	assert.Equal(t, 1, analyzed["/tv/a/1.mp4"])
+	assert.Equal(t, 1, analyzed["/tv/b/1.mp4"])
+	w.AssertExpectations(t)
+
+	// The series with a pending transcode isn't recorded, but the episode files of the skipped series are kept
+	saved := store["sonarr:default"]
+	assert.Contains(t, saved, "series:1")
+	assert.Contains(t, saved, "series:1:file:10")
+	assert.NotContains(t, saved, "series:2")
+	assert.Contains(t, saved, "series:2:file:20")
+	assert.NotContains(t, saved, "series:2:file:21")
+}
+
+func TestFingerprintsIncludeProfile(t *testing.T) {
+	movie := scannedMovie(1, 100)
+
+	assert.NotEqual(t, movieFingerprint("default", movie), movieFingerprint("hevc", movie))
+}
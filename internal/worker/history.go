@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// HistoryEntry is a finished job attempt
+type HistoryEntry struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Args       map[string]interface{} `json:"args"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+	StartedAt  int64                  `json:"startedAt"`
+	FinishedAt int64                  `json:"finishedAt"`
+}
+
+// JobHistory keeps a capped list of recently finished jobs
+type JobHistory interface {
+	Record(entry HistoryEntry) error
+	Recent() ([]HistoryEntry, error)
+}
+
+type redisHistory struct {
+	key  string
+	size int
+	pool *redis.Pool
+}
+
+// NewJobHistory creates a JobHistory keeping the last size entries in Redis
+func NewJobHistory(namespace string, size int, pool *redis.Pool) JobHistory {
+	return redisHistory{key: namespacePrefix(namespace) + "history", size: size, pool: pool}
+}
+
+// GetJobHistory returns the JobHistory for the configured job queue
+func GetJobHistory() JobHistory {
+	return NewJobHistory(config.GetConfig().JobQueueNamespace, config.GetConfig().JobHistorySize, &storage.RedisPool)
+}
+
+func (h redisHistory) Record(entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	conn := h.pool.Get()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("LPUSH", h.key, data)
+	_ = conn.Send("LTRIM", h.key, 0, h.size-1)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (h redisHistory) Recent() ([]HistoryEntry, error) {
+	conn := h.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", h.key, 0, h.size-1))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, 0, len(values))
+	for _, value := range values {
+		var entry HistoryEntry
+		if err = json.Unmarshal(value, &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// RecordHistory is a middleware that adds every finished job to the job history
+func (c *WorkerContext) RecordHistory(job *work.Job, next work.NextMiddlewareFunc) error {
+	start := time.Now()
+	err := next()
+	if c.JobHistory == nil {
+		return err
+	}
+
+	entry := HistoryEntry{
+		ID:         job.ID,
+		Name:       job.Name,
+		Args:       job.Args,
+		Status:     jobStatus(err),
+		StartedAt:  start.Unix(),
+		FinishedAt: time.Now().Unix(),
+	}
+	if entry.Status == "error" {
+		entry.Error = err.Error()
+	}
+	if recordErr := c.JobHistory.Record(entry); recordErr != nil {
+		log.Warn().Err(recordErr).Str("jobId", job.ID).Msg("Failed to record job history")
+	}
+	return err
+}
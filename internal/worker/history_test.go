@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockHistory struct {
+	entries []HistoryEntry
+}
+
+func (m *mockHistory) Record(entry HistoryEntry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *mockHistory) Recent() ([]HistoryEntry, error) {
+	return m.entries, nil
+}
+
+func TestRecordHistoryRecordsSuccess(t *testing.T) {
+	history := &mockHistory{}
+	context := WorkerContext{JobHistory: history}
+	job := &work.Job{ID: "1", Name: "transcode-job", Args: map[string]interface{}{"movieId": 1}}
+
+	err := context.RecordHistory(job, func() error { return nil })
+
+	assert.NoError(t, err)
+	assert.Len(t, history.entries, 1)
+	assert.Equal(t, "success", history.entries[0].Status)
+	assert.Equal(t, "transcode-job", history.entries[0].Name)
+}
+
+func TestRecordHistoryRecordsError(t *testing.T) {
+	history := &mockHistory{}
+	context := WorkerContext{JobHistory: history}
+
+	err := context.RecordHistory(&work.Job{ID: "1"}, func() error { return errors.New("boom") })
+
+	assert.Error(t, err)
+	assert.Equal(t, "error", history.entries[0].Status)
+	assert.Equal(t, "boom", history.entries[0].Error)
+}
+
+func TestRecordHistoryRecordsCancelledJob(t *testing.T) {
+	history := &mockHistory{}
+	context := WorkerContext{JobHistory: history}
+
+	err := context.RecordHistory(&work.Job{ID: "1"}, func() error { return ErrJobCancelled })
+
+	assert.Equal(t, ErrJobCancelled, err)
+	assert.Equal(t, "cancelled", history.entries[0].Status)
+	assert.Empty(t, history.entries[0].Error)
+}
+
+func TestRecordHistoryWithoutHistory(t *testing.T) {
+	context := WorkerContext{}
+
+	err := context.RecordHistory(&work.Job{ID: "1"}, func() error { return nil })
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"fmt"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+
+	"github.com/gocraft/work"
+)
+
+// SetInstance records which Sonarr or Radarr instance a job belongs to. The default instance is left out so its
+// jobs keep the args, and unique keys, they had before named instances
+func SetInstance(args work.Q, instance string) work.Q {
+	if instance != "" && instance != config.DefaultInstance {
+		args[constants.InstanceKey] = instance
+	}
+	return args
+}
+
+// JobInstance returns the name of the Sonarr or Radarr instance a job belongs to
+func JobInstance(job *work.Job) string {
+	if instance := job.ArgString(constants.InstanceKey); instance != "" {
+		return instance
+	}
+	return config.DefaultInstance
+}
+
+func (c *WorkerContext) radarrClient(job *work.Job) (web.RadarrClient, error) {
+	instance := JobInstance(job)
+	if instance == config.DefaultInstance {
+		return c.RadarrClient, nil
+	}
+	client, ok := c.RadarrClients[instance]
+	if !ok {
+		return nil, fmt.Errorf("unknown radarr instance %q", instance)
+	}
+	return client, nil
+}
+
+func (c *WorkerContext) sonarrClient(job *work.Job) (web.SonarrClient, error) {
+	instance := JobInstance(job)
+	if instance == config.DefaultInstance {
+		return c.SonarrClient, nil
+	}
+	client, ok := c.SonarrClients[instance]
+	if !ok {
+		return nil, fmt.Errorf("unknown sonarr instance %q", instance)
+	}
+	return client, nil
+}
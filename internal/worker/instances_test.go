@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+	"testing"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetInstanceLeavesOutDefault(t *testing.T) {
+	assert.Equal(t, work.Q{}, SetInstance(work.Q{}, ""))
+	assert.Equal(t, work.Q{}, SetInstance(work.Q{}, config.DefaultInstance))
+	assert.Equal(t, work.Q{constants.InstanceKey: "uhd"}, SetInstance(work.Q{}, "uhd"))
+}
+
+func TestJobInstance(t *testing.T) {
+	assert.Equal(t, config.DefaultInstance, JobInstance(&work.Job{Args: map[string]interface{}{}}))
+	assert.Equal(t, "uhd", JobInstance(&work.Job{Args: map[string]interface{}{constants.InstanceKey: "uhd"}}))
+}
+
+func TestRadarrClientForJob(t *testing.T) {
+	defaultClient := MockRadarr{}
+	uhdClient := MockRadarr{}
+	c := WorkerContext{RadarrClient: &defaultClient, RadarrClients: map[string]web.RadarrClient{"uhd": &uhdClient}}
+
+	client, err := c.radarrClient(&work.Job{Args: map[string]interface{}{}})
+	assert.NoError(t, err)
+	assert.Same(t, &defaultClient, client)
+
+	client, err = c.radarrClient(&work.Job{Args: map[string]interface{}{constants.InstanceKey: "uhd"}})
+	assert.NoError(t, err)
+	assert.Same(t, &uhdClient, client)
+
+	_, err = c.radarrClient(&work.Job{Args: map[string]interface{}{constants.InstanceKey: "4k"}})
+	assert.Error(t, err)
+}
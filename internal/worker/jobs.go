@@ -0,0 +1,471 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+)
+
+// JobStatus is the state a job is listed under
+type JobStatus string
+
+const (
+	JobsQueued     JobStatus = "queued"
+	JobsInProgress JobStatus = "in_progress"
+	JobsRetrying   JobStatus = "retrying"
+	JobsScheduled  JobStatus = "scheduled"
+	JobsDead       JobStatus = "dead"
+)
+
+// jobsPageSize matches the page size gocraft/work uses for the retry, scheduled and dead sets
+const jobsPageSize = 20
+
+var JobNotFoundError = errors.New("job not found")
+
+// JobInfo describes a job in one of the work queues
+type JobInfo struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Args       map[string]interface{} `json:"args"`
+	EnqueuedAt int64                  `json:"enqueuedAt,omitempty"`
+	Fails      int64                  `json:"fails,omitempty"`
+	LastErr    string                 `json:"lastError,omitempty"`
+	FailedAt   int64                  `json:"failedAt,omitempty"`
+	// At is when a running job started, when a retrying or scheduled job will run or when a dead job died
+	At        int64  `json:"at,omitempty"`
+	Checkin   string `json:"checkin,omitempty"`
+	CheckinAt int64  `json:"checkinAt,omitempty"`
+	WorkerID  string `json:"workerId,omitempty"`
+}
+
+// JobList is one page of jobs with a given status
+type JobList struct {
+	Status JobStatus `json:"status"`
+	Page   uint      `json:"page"`
+	Total  int64     `json:"total"`
+	Jobs   []JobInfo `json:"jobs"`
+}
+
+// JobInspector reads and manages the jobs stored in Redis
+type JobInspector interface {
+	ListJobs(status JobStatus, page uint) (*JobList, error)
+	RetryDeadJob(diedAt int64, id string) error
+	DeleteQueuedJob(name string, id string) error
+	// CancelJobs returns the jobs it removed. Jobs a worker took in the meantime aren't among them
+	CancelJobs(match func(job *work.Job) bool) ([]*work.Job, error)
+	CancelJob(id string) (bool, error)
+	UpdateQueuedJobs(update func(job *work.Job) bool) (int, error)
+	CountPendingJobs(match func(job *work.Job) bool) (int, error)
+}
+
+type jobInspectorImpl struct {
+	client    *work.Client
+	namespace string
+	pool      *redis.Pool
+	canceller JobCanceller
+}
+
+// NewJobInspector creates a JobInspector for the jobs in namespace
+func NewJobInspector(namespace string, pool *redis.Pool) JobInspector {
+	return jobInspectorImpl{
+		client:    work.NewClient(namespace, pool),
+		namespace: namespace,
+		pool:      pool,
+		canceller: NewJobCanceller(namespace, pool),
+	}
+}
+
+// GetJobInspector returns a JobInspector for the configured job queue and the queues of the worker classes
+func GetJobInspector() JobInspector {
+	return NewClassJobInspector(config.GetConfig().JobQueueNamespace, GetCapabilityRegistry(), &storage.RedisPool)
+}
+
+func newJobInfo(job *work.Job) JobInfo {
+	return JobInfo{
+		ID:         job.ID,
+		Name:       job.Name,
+		Args:       job.Args,
+		EnqueuedAt: job.EnqueuedAt,
+		Fails:      job.Fails,
+		LastErr:    job.LastErr,
+		FailedAt:   job.FailedAt,
+	}
+}
+
+// namespacePrefix mirrors how gocraft/work prefixes its keys so ours sit alongside them
+func namespacePrefix(namespace string) string {
+	return storage.NamespacePrefix(namespace)
+}
+
+// queueKey mirrors the list gocraft/work pushes new jobs onto
+func queueKey(namespace string, name string) string {
+	return namespacePrefix(namespace) + "jobs:" + name
+}
+
+// pageBounds returns the slice bounds of a 1-based page
+func pageBounds(page uint, total int) (int, int) {
+	start := int(page-1) * jobsPageSize
+	if start > total {
+		start = total
+	}
+	end := start + jobsPageSize
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+func (j jobInspectorImpl) ListJobs(status JobStatus, page uint) (*JobList, error) {
+	if page == 0 {
+		page = 1
+	}
+	list := &JobList{Status: status, Page: page, Jobs: make([]JobInfo, 0)}
+	var err error
+	switch status {
+	case JobsQueued:
+		err = j.listQueued(list)
+	case JobsInProgress:
+		err = j.listInProgress(list)
+	case JobsRetrying:
+		var jobs []*work.RetryJob
+		jobs, list.Total, err = j.client.RetryJobs(page)
+		for _, job := range jobs {
+			info := newJobInfo(job.Job)
+			info.At = job.RetryAt
+			list.Jobs = append(list.Jobs, info)
+		}
+	case JobsScheduled:
+		var jobs []*work.ScheduledJob
+		jobs, list.Total, err = j.client.ScheduledJobs(page)
+		for _, job := range jobs {
+			info := newJobInfo(job.Job)
+			info.At = job.RunAt
+			list.Jobs = append(list.Jobs, info)
+		}
+	case JobsDead:
+		var jobs []*work.DeadJob
+		jobs, list.Total, err = j.client.DeadJobs(page)
+		for _, job := range jobs {
+			info := newJobInfo(job.Job)
+			info.At = job.DiedAt
+			list.Jobs = append(list.Jobs, info)
+		}
+	default:
+		return nil, errors.New("unknown job status: " + string(status))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (j jobInspectorImpl) listInProgress(list *JobList) error {
+	observations, err := j.client.WorkerObservations()
+	if err != nil {
+		return err
+	}
+	for _, observation := range observations {
+		if !observation.IsBusy {
+			continue
+		}
+		info := JobInfo{
+			ID:        observation.JobID,
+			Name:      observation.JobName,
+			At:        observation.StartedAt,
+			Checkin:   observation.Checkin,
+			CheckinAt: observation.CheckinAt,
+			WorkerID:  observation.WorkerID,
+		}
+		if observation.ArgsJSON != "" {
+			_ = json.Unmarshal([]byte(observation.ArgsJSON), &info.Args)
+		}
+		list.Jobs = append(list.Jobs, info)
+	}
+	list.Total = int64(len(list.Jobs))
+	return nil
+}
+
+// queuedJobs returns the raw and decoded jobs waiting in a queue, next to run first
+func (j jobInspectorImpl) queuedJobs(conn redis.Conn, name string) ([][]byte, []work.Job, error) {
+	values, err := redis.ByteSlices(conn.Do("LRANGE", queueKey(j.namespace, name), 0, -1))
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := make([][]byte, 0, len(values))
+	jobs := make([]work.Job, 0, len(values))
+	// Workers pop from the end of the list
+	for i := len(values) - 1; i >= 0; i-- {
+		var job work.Job
+		if err = json.Unmarshal(values[i], &job); err != nil {
+			continue
+		}
+		raw = append(raw, values[i])
+		jobs = append(jobs, job)
+	}
+	return raw, jobs, nil
+}
+
+func (j jobInspectorImpl) listQueued(list *JobList) error {
+	queues, err := j.client.Queues()
+	if err != nil {
+		return err
+	}
+	conn := j.pool.Get()
+	defer conn.Close()
+
+	all := make([]JobInfo, 0)
+	for _, queue := range queues {
+		_, jobs, err := j.queuedJobs(conn, queue.JobName)
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			all = append(all, newJobInfo(&jobs[i]))
+		}
+	}
+	start, end := pageBounds(list.Page, len(all))
+	list.Jobs = all[start:end]
+	list.Total = int64(len(all))
+	return nil
+}
+
+func (j jobInspectorImpl) RetryDeadJob(diedAt int64, id string) error {
+	err := j.client.RetryDeadJob(diedAt, id)
+	if err == work.ErrNotRetried {
+		return JobNotFoundError
+	}
+	return err
+}
+
+// DeleteQueuedJob removes a job that hasn't started yet. The unique lock is released as well so the same job
+// can be enqueued again later
+func (j jobInspectorImpl) DeleteQueuedJob(name string, id string) error {
+	conn := j.pool.Get()
+	defer conn.Close()
+
+	raw, jobs, err := j.queuedJobs(conn, name)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		if jobs[i].ID != id {
+			continue
+		}
+		removed, err := j.removeQueued(conn, &jobs[i], raw[i])
+		if err == nil && !removed {
+			return JobNotFoundError
+		}
+		return err
+	}
+	return JobNotFoundError
+}
+
+// removeQueued removes a raw job from its queue and releases its unique lock. It reports false when a worker
+// picked the job up first
+func (j jobInspectorImpl) removeQueued(conn redis.Conn, job *work.Job, raw []byte) (bool, error) {
+	removed, err := redis.Int(conn.Do("LREM", queueKey(j.namespace, job.Name), 1, raw))
+	if err != nil || removed == 0 {
+		return false, err
+	}
+	if job.UniqueKey != "" {
+		_, err = conn.Do("DEL", job.UniqueKey)
+	}
+	return true, err
+}
+
+// CancelJobs removes every queued or retrying job that match selects and returns the removed jobs
+func (j jobInspectorImpl) CancelJobs(match func(job *work.Job) bool) ([]*work.Job, error) {
+	queues, err := j.client.Queues()
+	if err != nil {
+		return nil, err
+	}
+	conn := j.pool.Get()
+	defer conn.Close()
+
+	cancelled := make([]*work.Job, 0)
+	for _, queue := range queues {
+		raw, jobs, err := j.queuedJobs(conn, queue.JobName)
+		if err != nil {
+			return cancelled, err
+		}
+		for i := range jobs {
+			if !match(&jobs[i]) {
+				continue
+			}
+			removed, err := j.removeQueued(conn, &jobs[i], raw[i])
+			if err != nil {
+				return cancelled, err
+			}
+			if removed {
+				cancelled = append(cancelled, &jobs[i])
+			}
+		}
+	}
+
+	// Deleting while paging would shift the later pages, so the matching jobs are collected first
+	retrying := make([]*work.RetryJob, 0)
+	err = eachPage(func(page uint) (int, int64, error) {
+		jobs, total, err := j.client.RetryJobs(page)
+		for _, job := range jobs {
+			if match(job.Job) {
+				retrying = append(retrying, job)
+			}
+		}
+		return len(jobs), total, err
+	})
+	if err != nil {
+		return cancelled, err
+	}
+	for _, job := range retrying {
+		err = j.client.DeleteRetryJob(job.RetryAt, job.ID)
+		if err == work.ErrNotDeleted {
+			continue
+		}
+		if err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, job.Job)
+	}
+	return cancelled, nil
+}
+
+// CancelJob removes a queued or retrying job, or asks the worker running it to stop. It reports whether the job was
+// running, in which case the worker stops it shortly after and removes its partial output
+func (j jobInspectorImpl) CancelJob(id string) (bool, error) {
+	cancelled, err := j.CancelJobs(func(job *work.Job) bool { return job.ID == id })
+	if err != nil || len(cancelled) > 0 {
+		return false, err
+	}
+
+	observations, err := j.client.WorkerObservations()
+	if err != nil {
+		return false, err
+	}
+	for _, observation := range observations {
+		if observation.IsBusy && observation.JobID == id {
+			return true, j.canceller.RequestCancel(id)
+		}
+	}
+	return false, JobNotFoundError
+}
+
+// UpdateQueuedJobs lets update change the args of queued jobs. Changed jobs keep their place in the queue
+func (j jobInspectorImpl) UpdateQueuedJobs(update func(job *work.Job) bool) (int, error) {
+	queues, err := j.client.Queues()
+	if err != nil {
+		return 0, err
+	}
+	conn := j.pool.Get()
+	defer conn.Close()
+
+	updated := 0
+	for _, queue := range queues {
+		key := queueKey(j.namespace, queue.JobName)
+		raw, jobs, err := j.queuedJobs(conn, queue.JobName)
+		if err != nil {
+			return updated, err
+		}
+		for i := range jobs {
+			if !update(&jobs[i]) {
+				continue
+			}
+			data, err := json.Marshal(jobs[i])
+			if err != nil {
+				return updated, err
+			}
+			// LINSERT does nothing if a worker already took the job, in which case the LREM doesn't either
+			_ = conn.Send("MULTI")
+			_ = conn.Send("LINSERT", key, "BEFORE", raw[i], data)
+			_ = conn.Send("LREM", key, 1, raw[i])
+			replies, err := redis.Values(conn.Do("EXEC"))
+			if err != nil {
+				return updated, err
+			}
+			if inserted, _ := redis.Int(replies[0], nil); inserted > 0 {
+				updated++
+			}
+		}
+	}
+	return updated, nil
+}
+
+// CountPendingJobs counts the queued, running, retrying and scheduled jobs matching match
+func (j jobInspectorImpl) CountPendingJobs(match func(job *work.Job) bool) (int, error) {
+	queues, err := j.client.Queues()
+	if err != nil {
+		return 0, err
+	}
+	conn := j.pool.Get()
+	defer conn.Close()
+
+	count := 0
+	for _, queue := range queues {
+		_, jobs, err := j.queuedJobs(conn, queue.JobName)
+		if err != nil {
+			return count, err
+		}
+		for i := range jobs {
+			if match(&jobs[i]) {
+				count++
+			}
+		}
+	}
+
+	observations, err := j.client.WorkerObservations()
+	if err != nil {
+		return count, err
+	}
+	for _, observation := range observations {
+		if !observation.IsBusy {
+			continue
+		}
+		job := work.Job{ID: observation.JobID, Name: observation.JobName}
+		if observation.ArgsJSON != "" {
+			_ = json.Unmarshal([]byte(observation.ArgsJSON), &job.Args)
+		}
+		if match(&job) {
+			count++
+		}
+	}
+
+	err = eachPage(func(page uint) (int, int64, error) {
+		jobs, total, err := j.client.RetryJobs(page)
+		for _, job := range jobs {
+			if match(job.Job) {
+				count++
+			}
+		}
+		return len(jobs), total, err
+	})
+	if err != nil {
+		return count, err
+	}
+	err = eachPage(func(page uint) (int, int64, error) {
+		jobs, total, err := j.client.ScheduledJobs(page)
+		for _, job := range jobs {
+			if match(job.Job) {
+				count++
+			}
+		}
+		return len(jobs), total, err
+	})
+	return count, err
+}
+
+// eachPage calls fetch with every page of a retry or scheduled set until it has seen all of them. fetch returns
+// how many jobs were on the page and how many there are in total
+func eachPage(fetch func(page uint) (int, int64, error)) error {
+	for page := uint(1); ; page++ {
+		n, total, err := fetch(page)
+		if err != nil {
+			return err
+		}
+		if n == 0 || int64(page*jobsPageSize) >= total {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"encoding/json"
+	"media-web/internal/constants"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueKey(t *testing.T) {
+	assert.Equal(t, "media-web:jobs:transcode", queueKey("media-web", "transcode"))
+	assert.Equal(t, "media-web:jobs:transcode", queueKey("media-web:", "transcode"))
+}
+
+func TestPageBounds(t *testing.T) {
+	start, end := pageBounds(1, 5)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 5, end)
+
+	start, end = pageBounds(2, 45)
+	assert.Equal(t, 20, start)
+	assert.Equal(t, 40, end)
+
+	start, end = pageBounds(4, 45)
+	assert.Equal(t, 45, start)
+	assert.Equal(t, 45, end)
+}
+
+func TestListQueuedJobsNextToRunFirst(t *testing.T) {
+	pool, _ := testRedis(t)
+	enqueuer := work.NewEnqueuer(testNamespace, pool)
+	first, err := enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.MovieIdKey: 1})
+	assert.NoError(t, err)
+	second, err := enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.MovieIdKey: 2})
+	assert.NoError(t, err)
+
+	list, err := NewJobInspector(testNamespace, pool).ListJobs(JobsQueued, 1)
+
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, list.Total)
+	assert.Equal(t, []string{first.ID, second.ID}, []string{list.Jobs[0].ID, list.Jobs[1].ID})
+}
+
+func TestDeleteQueuedJobReleasesUniqueLock(t *testing.T) {
+	pool, _ := testRedis(t)
+	enqueuer := work.NewEnqueuer(testNamespace, pool)
+	args := work.Q{constants.MovieIdKey: 1}
+	job, err := enqueuer.EnqueueUnique(constants.TranscodeJobType, args)
+	assert.NoError(t, err)
+	inspector := NewJobInspector(testNamespace, pool)
+
+	assert.NoError(t, inspector.DeleteQueuedJob(constants.TranscodeJobType, job.ID))
+	assert.Equal(t, JobNotFoundError, inspector.DeleteQueuedJob(constants.TranscodeJobType, job.ID))
+
+	again, err := enqueuer.EnqueueUnique(constants.TranscodeJobType, args)
+	assert.NoError(t, err)
+	assert.NotNil(t, again)
+}
+
+func TestCancelJobsRemovesMatchingQueuedJobs(t *testing.T) {
+	pool, _ := testRedis(t)
+	enqueuer := work.NewEnqueuer(testNamespace, pool)
+	_, err := enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.MovieIdKey: 1})
+	assert.NoError(t, err)
+	_, err = enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.MovieIdKey: 2})
+	assert.NoError(t, err)
+	inspector := NewJobInspector(testNamespace, pool)
+
+	cancelled, err := inspector.CancelJobs(func(job *work.Job) bool { return job.ArgInt64(constants.MovieIdKey) == 2 })
+
+	assert.NoError(t, err)
+	assert.Len(t, cancelled, 1)
+	assert.EqualValues(t, 2, cancelled[0].ArgInt64(constants.MovieIdKey))
+	count, err := inspector.CountPendingJobs(func(job *work.Job) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestUpdateQueuedJobsKeepsQueueOrder(t *testing.T) {
+	pool, _ := testRedis(t)
+	enqueuer := work.NewEnqueuer(testNamespace, pool)
+	for id := 1; id <= 3; id++ {
+		_, err := enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.FilePathKey: "/tv/old/" + string(rune('0'+id))})
+		assert.NoError(t, err)
+	}
+	inspector := NewJobInspector(testNamespace, pool)
+
+	updated, err := inspector.UpdateQueuedJobs(func(job *work.Job) bool {
+		if job.ArgString(constants.FilePathKey) != "/tv/old/2" {
+			return false
+		}
+		job.Args[constants.FilePathKey] = "/tv/new/2"
+		return true
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	list, err := inspector.ListJobs(JobsQueued, 1)
+	assert.NoError(t, err)
+	paths := make([]interface{}, 0)
+	for _, job := range list.Jobs {
+		paths = append(paths, job.Args[constants.FilePathKey])
+	}
+	assert.Equal(t, []interface{}{"/tv/old/1", "/tv/new/2", "/tv/old/3"}, paths)
+}
+
+func TestCountPendingJobsIncludesRunningRetryingAndScheduledJobs(t *testing.T) {
+	pool, server := testRedis(t)
+	enqueuer := work.NewEnqueuer(testNamespace, pool)
+	_, err := enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.SeriesIdKey: 1})
+	assert.NoError(t, err)
+	_, err = enqueuer.EnqueueIn(constants.TranscodeJobType, 60, work.Q{constants.SeriesIdKey: 1})
+	assert.NoError(t, err)
+	_, err = enqueuer.Enqueue(constants.TranscodeJobType, work.Q{constants.SeriesIdKey: 2})
+	assert.NoError(t, err)
+	retrying, err := json.Marshal(work.Job{ID: "retrying", Name: constants.TranscodeJobType, Fails: 1, Args: work.Q{constants.SeriesIdKey: 1}})
+	assert.NoError(t, err)
+	_, err = server.ZAdd(testNamespace+":retry", float64(time.Now().Unix()+60), string(retrying))
+	assert.NoError(t, err)
+	// How a worker pool records the job it is running
+	_, err = server.SAdd(testNamespace+":worker_pools", "pool")
+	assert.NoError(t, err)
+	server.HSet(testNamespace+":worker_pools:pool", "worker_ids", "worker")
+	server.HSet(testNamespace+":worker:worker", "job_name", constants.TranscodeJobType, "job_id", "running",
+		"args", `{"seriesId":1}`)
+
+	count, err := NewJobInspector(testNamespace, pool).CountPendingJobs(func(job *work.Job) bool {
+		return job.ArgInt64(constants.SeriesIdKey) == 1
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+}
+
+func TestCancelJobsRemovesMatchingRetryingJobs(t *testing.T) {
+	pool, server := testRedis(t)
+	for _, id := range []string{"first", "second"} {
+		retrying, err := json.Marshal(work.Job{ID: id, Name: constants.TranscodeJobType, Fails: 1, Args: work.Q{constants.MovieIdKey: 1}})
+		assert.NoError(t, err)
+		_, err = server.ZAdd(testNamespace+":retry", float64(time.Now().Unix()+60), string(retrying))
+		assert.NoError(t, err)
+	}
+	inspector := NewJobInspector(testNamespace, pool)
+
+	cancelled, err := inspector.CancelJobs(func(job *work.Job) bool { return job.ID == "second" })
+
+	assert.NoError(t, err)
+	assert.Len(t, cancelled, 1)
+	assert.Equal(t, "second", cancelled[0].ID)
+	members, err := server.ZMembers(testNamespace + ":retry")
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+}
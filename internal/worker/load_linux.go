@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// loadAverage reads the one minute load average of the host
+func loadAverage() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAverage(string(data))
+}
+
+func parseLoadAverage(value string) (float64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid load average %q", value)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
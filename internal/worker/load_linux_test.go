@@ -0,0 +1,20 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLoadAverage(t *testing.T) {
+	load, err := parseLoadAverage("1.52 0.98 0.61 2/345 12345\n")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1.52, load)
+}
+
+func TestLoadAverage(t *testing.T) {
+	_, err := loadAverage()
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package worker
+
+import "errors"
+
+// loadAverage is only read from /proc, so encodes aren't throttled by load on other platforms
+func loadAverage() (float64, error) {
+	return 0, errors.New("the load average is not available on this platform")
+}
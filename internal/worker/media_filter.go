@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"media-web/internal/filter"
+	"media-web/internal/web"
+	"sync"
+	"time"
+)
+
+// tagLabels resolves tag ids to labels, asking the *arr again when it sees a tag it doesn't know yet
+type tagLabels struct {
+	get    func() ([]web.Tag, error)
+	mu     sync.Mutex
+	labels map[int]string
+}
+
+func (t *tagLabels) resolve(ids []int) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := t.labels[id]; ok {
+			continue
+		}
+		tags, err := t.get()
+		if err != nil {
+			return nil, err
+		}
+		t.labels = make(map[int]string, len(tags))
+		for _, tag := range tags {
+			t.labels[tag.ID] = tag.Label
+		}
+		break
+	}
+
+	labels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if label, ok := t.labels[id]; ok {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
+
+// MovieFilter applies the Radarr filter rules to the movies of one instance. A nil MovieFilter allows every movie
+type MovieFilter struct {
+	rules    filter.Rules
+	instance string
+	client   web.RadarrClient
+	tags     *tagLabels
+	now      func() time.Time
+}
+
+// NewMovieFilter creates a MovieFilter, or nil when the rules allow everything
+func NewMovieFilter(rules filter.Rules, instance string, client web.RadarrClient) *MovieFilter {
+	if rules.Empty() {
+		return nil
+	}
+	return &MovieFilter{rules: rules, instance: instance, client: client, tags: &tagLabels{get: client.GetTags}, now: time.Now}
+}
+
+// Allows reports whether the movie may be transcoded
+func (f *MovieFilter) Allows(movie web.RadarrMovie) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	media := filter.Media{
+		Instance:         f.instance,
+		QualityProfileID: movie.QualityProfileID,
+		Path:             movie.FilePath(),
+		Genres:           movie.Genres,
+		Year:             movie.Year,
+		Added:            movie.MovieFile.DateAdded,
+	}
+	if media.Added.IsZero() {
+		media.Added = movie.Added
+	}
+	if f.rules.NeedsTags() {
+		tags, err := f.tags.resolve(movie.Tags)
+		if err != nil {
+			return false, err
+		}
+		media.Tags = tags
+	}
+	return f.rules.Allows(media, f.now()), nil
+}
+
+// AllowsID looks the movie up in Radarr before applying the rules. A movie Radarr doesn't know is allowed, the
+// transcode will find out it's gone
+func (f *MovieFilter) AllowsID(id int) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	movie, err := f.client.LookupMovie(int64(id))
+	if err != nil {
+		return false, err
+	}
+	if movie == nil {
+		return true, nil
+	}
+	return f.Allows(*movie)
+}
+
+// SeriesFilter applies the Sonarr filter rules to the episode files of one instance. A nil SeriesFilter allows every
+// file
+type SeriesFilter struct {
+	rules    filter.Rules
+	instance string
+	client   web.SonarrClient
+	tags     *tagLabels
+	now      func() time.Time
+}
+
+// NewSeriesFilter creates a SeriesFilter, or nil when the rules allow everything
+func NewSeriesFilter(rules filter.Rules, instance string, client web.SonarrClient) *SeriesFilter {
+	if rules.Empty() {
+		return nil
+	}
+	return &SeriesFilter{rules: rules, instance: instance, client: client, tags: &tagLabels{get: client.GetTags}, now: time.Now}
+}
+
+// Allows reports whether an episode file of the series may be transcoded. The path is where Sonarr has the file and
+// added is when it was imported
+func (f *SeriesFilter) Allows(series web.Series, path string, added time.Time) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	media := filter.Media{
+		Instance:         f.instance,
+		QualityProfileID: series.QualityProfileID,
+		Path:             path,
+		Genres:           series.Genres,
+		Year:             series.Year,
+		Added:            added,
+	}
+	if media.Added.IsZero() {
+		media.Added = series.Added
+	}
+	if f.rules.NeedsTags() {
+		tags, err := f.tags.resolve(series.Tags)
+		if err != nil {
+			return false, err
+		}
+		media.Tags = tags
+	}
+	return f.rules.Allows(media, f.now()), nil
+}
+
+// AllowedFiles looks the series up in Sonarr and returns the files of a download that may be transcoded. The files
+// were just imported, so their age is zero
+func (f *SeriesFilter) AllowedFiles(seriesID int, files []web.SonarrWebhookEpisodeFile) ([]web.SonarrWebhookEpisodeFile, error) {
+	if f == nil {
+		return files, nil
+	}
+	series, err := f.client.LookupSeries(seriesID)
+	if err != nil {
+		return nil, err
+	}
+	if series == nil {
+		return files, nil
+	}
+	allowed := make([]web.SonarrWebhookEpisodeFile, 0, len(files))
+	for _, file := range files {
+		ok, err := f.Allows(*series, file.Path, f.now())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allowed = append(allowed, file)
+		}
+	}
+	return allowed, nil
+}
+
+// fingerprintScope is the part of every fingerprint that changes with the profile and the rules, so changing either
+// checks every file again
+func fingerprintScope(profile string, rules filter.Rules) string {
+	if key := rules.Key(); key != "" {
+		return profile + "/" + key
+	}
+	return profile
+}
@@ -0,0 +1,138 @@
+package worker
+
+import (
+	"media-web/internal/config"
+	"media-web/internal/filter"
+	"media-web/internal/transcode"
+	"media-web/internal/web"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagLabelsFetchesUnknownTags(t *testing.T) {
+	fetches := 0
+	known := []web.Tag{{ID: 1, Label: "kids"}}
+	labels := tagLabels{get: func() ([]web.Tag, error) {
+		fetches++
+		return known, nil
+	}}
+
+	resolved, err := labels.resolve([]int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kids"}, resolved)
+
+	resolved, err = labels.resolve([]int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kids"}, resolved)
+	assert.Equal(t, 1, fetches)
+
+	// A tag created since the last lookup is fetched again
+	known = append(known, web.Tag{ID: 2, Label: "transcode"})
+	resolved, err = labels.resolve([]int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kids", "transcode"}, resolved)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestNilFiltersAllowEverything(t *testing.T) {
+	assert.Nil(t, NewMovieFilter(filter.Rules{}, config.DefaultInstance, MockRadarr{}))
+	assert.Nil(t, NewSeriesFilter(filter.Rules{}, config.DefaultInstance, MockSonarr{}))
+
+	var movies *MovieFilter
+	allowed, err := movies.AllowsID(1)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	var series *SeriesFilter
+	files := []web.SonarrWebhookEpisodeFile{{ID: 1}}
+	allowedFiles, err := series.AllowedFiles(1, files)
+	assert.NoError(t, err)
+	assert.Equal(t, files, allowedFiles)
+}
+
+func TestMovieFilterMatchesTags(t *testing.T) {
+	client := MockRadarr{
+		getTags: func() ([]web.Tag, error) { return []web.Tag{{ID: 3, Label: "transcode"}}, nil },
+		lookupMovie: func(id int64) (*web.RadarrMovie, error) {
+			if id == 1 {
+				return &web.RadarrMovie{ID: 1, Tags: []int{3}}, nil
+			}
+			return &web.RadarrMovie{ID: int(id)}, nil
+		},
+	}
+	rules := filter.Rules{Include: []filter.Condition{{Tags: []string{"transcode"}}}}
+	movies := NewMovieFilter(rules, config.DefaultInstance, client)
+
+	allowed, err := movies.AllowsID(1)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = movies.AllowsID(2)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestSeriesFilterUsesFileAge(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	rules := filter.Rules{Exclude: []filter.Condition{{NewerThan: filter.Duration(24 * time.Hour)}}}
+	series := NewSeriesFilter(rules, config.DefaultInstance, MockSonarr{})
+	series.now = func() time.Time { return now }
+
+	allowed, err := series.Allows(web.Series{}, "/tv/a/1.mkv", now.Add(-48*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// Files coming in through the webhook were just imported
+	allowed, err = series.Allows(web.Series{}, "/tv/a/1.mkv", now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestMovieScanCountsFilteredMovies(t *testing.T) {
+	excluded := scannedMovie(1, 100)
+	excluded.MovieFile.Path = "/movies/4k/b.mkv"
+	client := MockRadarr{getAllMovies: func() ([]web.RadarrMovie, error) {
+		return []web.RadarrMovie{excluded, scannedMovie(2, 200)}, nil
+	}}
+	analyzed := map[string]int{}
+	store := memoryFingerprints{}
+	rules := filter.Rules{Exclude: []filter.Condition{{Paths: []string{"/movies/4k"}}}}
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: transcode.DefaultProfileName}
+	scanner := NewInstanceMovieScanner(instance, client, &mockWorker{}, countingAnalyzer(analyzed, nil), store, rules)
+
+	summary, err := scanner.ScanForMovies(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1, Filtered: 1}, summary)
+	assert.Equal(t, 0, analyzed["/movies/4k/b.mkv"])
+
+	// Filtered movies are recorded, so the next scan skips them
+	summary, err = scanner.ScanForMovies(false)
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Skipped: 2}, summary)
+}
+
+func TestTVScanCountsFilteredFiles(t *testing.T) {
+	client := MockSonarr{
+		getAllSeries: func() ([]web.Series, error) {
+			return []web.Series{{ID: 1, EpisodeFileCount: 2, SizeOnDisk: 300}}, nil
+		},
+		getAllEpisodeFiles: func(seriesId int) ([]web.SonarrEpisodeFile, error) {
+			return []web.SonarrEpisodeFile{
+				{ID: 10, SeriesID: 1, Path: "/tv/4k/a/1.mkv", Size: 100},
+				{ID: 11, SeriesID: 1, Path: "/tv/hd/a/2.mkv", Size: 200},
+			}, nil
+		},
+	}
+	analyzed := map[string]int{}
+	rules := filter.Rules{Exclude: []filter.Condition{{Paths: []string{"/tv/4k"}}}}
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: transcode.DefaultProfileName}
+
+	summary, err := ScanInstanceForTVShows(instance, client, &mockWorker{}, countingAnalyzer(analyzed, nil), rules, memoryFingerprints{}, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSummary{Checked: 1, Filtered: 1}, summary)
+	assert.Equal(t, 0, analyzed["/tv/4k/a/1.mkv"])
+	assert.Equal(t, 1, analyzed["/tv/hd/a/2.mkv"])
+}
@@ -1,14 +1,23 @@
 package worker
 
-import "media-web/internal/web"
+import (
+	"context"
+	"errors"
+	"media-web/internal/pathmap"
+	"media-web/internal/transcode"
+	"media-web/internal/web"
+)
 
 type MockSonarr struct {
 	getAllSeries       func() ([]web.Series, error)
 	getAllEpisodeFiles func(seriesId int) ([]web.SonarrEpisodeFile, error)
+	lookupSeries       func(id int) (*web.Series, error)
+	getTags            func() ([]web.Tag, error)
 	checkSonarrCommand func(id int) (*web.SonarrCommand, error)
 	rescanSeries       func(id int64) (*web.SonarrCommand, error)
 	lookupTVEpisode    func(id int64) (*web.SonarrEpisodeFile, error)
 	getEpisodeFilePath func(id int64) (string, int, error)
+	pathMapper         pathmap.Mapper
 }
 
 type MockRadarr struct {
@@ -16,9 +25,85 @@ type MockRadarr struct {
 	rescanMovie        func(id int64) (*web.RadarrCommand, error)
 	lookupMovie        func(id int64) (*web.RadarrMovie, error)
 	getAllMovies       func() ([]web.RadarrMovie, error)
+	getTags            func() ([]web.Tag, error)
 	getMovieFilePath   func(id int64) (string, error)
+	pathMapper         pathmap.Mapper
+}
+
+type MockLidarr struct {
+	checkLidarrCommand func(id int) (*web.LidarrCommand, error)
+	rescanArtist       func(id int64) (*web.LidarrCommand, error)
+	getTrackFilePath   func(id int64) (string, int, error)
+	pathMapper         pathmap.Mapper
+}
+
+func (c MockLidarr) CheckLidarrCommand(id int) (*web.LidarrCommand, error) {
+	return c.checkLidarrCommand(id)
+}
+
+func (c MockLidarr) RescanArtist(id int64) (*web.LidarrCommand, error) {
+	return c.rescanArtist(id)
+}
+
+func (c MockLidarr) LookupTrackFile(id int64) (*web.LidarrTrackFile, error) {
+	panic("implement me")
+}
+
+func (c MockLidarr) GetTrackFilePath(id int64) (string, int, error) {
+	return c.getTrackFilePath(id)
+}
+
+func (c MockLidarr) PathMapper() pathmap.Mapper {
+	return c.pathMapper
 }
 
 func (c MockRadarr) ScanForMissingMovies() (*web.RadarrCommand, error) {
 	panic("implement me")
 }
+
+func (c MockRadarr) GetTags() ([]web.Tag, error) {
+	return c.getTags()
+}
+
+func (m MockSonarr) LookupSeries(id int) (*web.Series, error) {
+	return m.lookupSeries(id)
+}
+
+func (m MockSonarr) GetTags() ([]web.Tag, error) {
+	return m.getTags()
+}
+
+type MockAnalyzer struct {
+	analyze func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error)
+}
+
+func (m MockAnalyzer) Analyze(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+	return m.analyze(path, profile)
+}
+
+// decidingAnalyzer probes every file to the same decision
+func decidingAnalyzer(decision transcode.Decision) MockAnalyzer {
+	return MockAnalyzer{
+		analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+			return decision, nil, nil
+		},
+	}
+}
+
+// unreachableAnalyzer behaves like a scanner host that can't see the media files
+var unreachableAnalyzer = MockAnalyzer{
+	analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+		return "", nil, errors.New("file not found")
+	},
+}
+
+type MockVerifier struct {
+	verify func(ctx context.Context, source *transcode.MediaInfo, output string, expected *transcode.StreamCounts) error
+}
+
+func (m MockVerifier) Verify(ctx context.Context, source *transcode.MediaInfo, output string, expected *transcode.StreamCounts) error {
+	if m.verify == nil {
+		return nil
+	}
+	return m.verify(ctx, source, output, expected)
+}
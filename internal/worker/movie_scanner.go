@@ -1,7 +1,10 @@
 package worker
 
 import (
+	"media-web/internal/config"
 	"media-web/internal/constants"
+	"media-web/internal/filter"
+	"media-web/internal/transcode"
 	"media-web/internal/web"
 	"path/filepath"
 
@@ -11,18 +14,40 @@ import (
 
 // MovieScanner scans for movies to be converted into a consistent format
 type MovieScanner interface {
-	ScanForMovies() error
+	// ScanForMovies enqueues transcodes for movies in the wrong format. Unless full is set, movies whose file hasn't
+	// changed since it was last found in the right format are skipped
+	ScanForMovies(full bool) (ScanSummary, error)
 	SearchForMissingMovies() error
 }
 
 type movieScannerImpl struct {
-	client    web.RadarrClient
-	scheduler WorkScheduler
+	instance     config.Instance
+	client       web.RadarrClient
+	scheduler    WorkScheduler
+	analyzer     transcode.Analyzer
+	fingerprints FingerprintStore
+	rules        filter.Rules
+	filter       *MovieFilter
 }
 
-// NewMovieScanner creates a new instance of MovieScanner
-func NewMovieScanner(client web.RadarrClient, scheduler WorkScheduler) MovieScanner {
-	return movieScannerImpl{client: client, scheduler: scheduler}
+// NewMovieScanner creates a new instance of MovieScanner for the default Radarr instance. It always checks every movie
+func NewMovieScanner(client web.RadarrClient, scheduler WorkScheduler, analyzer transcode.Analyzer) MovieScanner {
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: config.GetConfig().DefaultMovieProfile}
+	return NewInstanceMovieScanner(instance, client, scheduler, analyzer, nil, filter.Rules{})
+}
+
+// NewInstanceMovieScanner creates a MovieScanner for a Radarr instance, checking its movies against the instance's
+// default profile. Movies the rules exclude are left alone. Without a FingerprintStore every scan is a full scan
+func NewInstanceMovieScanner(instance config.Instance, client web.RadarrClient, scheduler WorkScheduler, analyzer transcode.Analyzer, fingerprints FingerprintStore, rules filter.Rules) MovieScanner {
+	return movieScannerImpl{
+		instance:     instance,
+		client:       client,
+		scheduler:    scheduler,
+		analyzer:     analyzer,
+		fingerprints: fingerprints,
+		rules:        rules,
+		filter:       NewMovieFilter(rules, instance.Name, client),
+	}
 }
 
 func (m movieScannerImpl) SearchForMissingMovies() error {
@@ -33,30 +58,76 @@ func (m movieScannerImpl) SearchForMissingMovies() error {
 	return err
 }
 
-func (m movieScannerImpl) ScanForMovies() error {
+func (m movieScannerImpl) ScanForMovies(full bool) (ScanSummary, error) {
+	var summary ScanSummary
+	profile, err := transcode.GetProfiles().Get(m.instance.DefaultProfile)
+	if err != nil {
+		return summary, err
+	}
+
 	movies, err := m.client.GetAllMovies()
 
 	if err != nil {
-		return err
+		return summary, err
 	}
 
+	previous := loadFingerprints(m.fingerprints, RadarrScanner, m.instance.Name, full)
+	current := make(Fingerprints)
+	scope := fingerprintScope(profile.Name, m.rules)
 	for i := 0; i < len(movies); i++ {
 		movie := movies[i]
-		if movie.Downloaded {
-			ext := filepath.Ext(movie.MovieFile.RelativePath)
-
-			if ext != ".mp4" {
-				log.Debug().Msg("Found movie in wrong format: " + movie.MovieFile.RelativePath)
-				_, err := m.scheduler.EnqueueUnique(constants.TranscodeJobType, work.Q{
-					constants.TranscodeTypeKey: constants.Movie,
-					constants.MovieIdKey:       movie.ID,
-				})
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to enqueue movie transcode")
-				}
+		if !movie.Downloaded {
+			continue
+		}
+		key, fingerprint := movieKey(movie), movieFingerprint(scope, movie)
+		if previous[key] == fingerprint {
+			current[key] = fingerprint
+			summary.Skipped++
+			continue
+		}
+
+		allowed, err := m.filter.Allows(movie)
+		if err != nil {
+			return summary, err
+		}
+		if !allowed {
+			summary.Filtered++
+			// A movie the rules exclude today may be allowed once it's old enough, so it has to be checked again
+			if !m.rules.DependsOnAge() {
+				current[key] = fingerprint
 			}
+			continue
+		}
+
+		summary.Checked++
+		path := m.client.PathMapper().ToLocal(movie.FilePath())
+		if !needsTranscode(m.analyzer, path, profile) {
+			current[key] = fingerprint
+			continue
+		}
+		log.Debug().Msg("Found movie in wrong format: " + movie.MovieFile.RelativePath)
+		_, err = m.scheduler.EnqueueUnique(constants.TranscodeJobType, SetInstance(work.Q{
+			constants.TranscodeTypeKey: constants.Movie,
+			constants.MovieIdKey:       movie.ID,
+		}, m.instance.Name))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to enqueue movie transcode")
+			continue
 		}
+		summary.Enqueued++
 	}
 
-	return nil
+	saveFingerprints(m.fingerprints, RadarrScanner, m.instance.Name, current)
+	return summary, nil
+}
+
+// needsTranscode probes the file to decide whether it matches the profile. The scanner may run on a host
+// that can't see the media, so when probing fails it falls back to comparing the file extension
+func needsTranscode(analyzer transcode.Analyzer, path string, profile transcode.Profile) bool {
+	decision, _, err := analyzer.Analyze(path, profile)
+	if err != nil {
+		log.Debug().Err(err).Msg("Could not analyze " + path + ". Falling back to extension check")
+		return filepath.Ext(path) != profile.Extension()
+	}
+	return decision != transcode.Skip
 }
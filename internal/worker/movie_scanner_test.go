@@ -3,6 +3,8 @@ package worker
 import (
 	"errors"
 	"media-web/internal/constants"
+	"media-web/internal/pathmap"
+	"media-web/internal/transcode"
 	"media-web/internal/web"
 	"testing"
 
@@ -26,6 +28,9 @@ func (c MockRadarr) GetAllMovies() ([]web.RadarrMovie, error) {
 func (c MockRadarr) GetMovieFilePath(id int64) (string, error) {
 	return c.getMovieFilePath(id)
 }
+func (c MockRadarr) PathMapper() pathmap.Mapper {
+	return c.pathMapper
+}
 
 type mockWorker struct {
 	mock.Mock
@@ -45,6 +50,14 @@ func (m *mockWorker) EnqueueUnique(jobName string, args map[string]interface{})
 	return nil, resp.Error(1)
 }
 
+func (m *mockWorker) EnqueueUniqueIn(jobName string, secondsFromNow int64, args map[string]interface{}) (*work.ScheduledJob, error) {
+	resp := m.Called(jobName, secondsFromNow, args)
+
+	job, _ := resp.Get(0).(*work.ScheduledJob)
+
+	return job, resp.Error(1)
+}
+
 func TestErrorFromScanner(t *testing.T) {
 
 	mockErr := errors.New("Error!")
@@ -54,8 +67,8 @@ func TestErrorFromScanner(t *testing.T) {
 	}
 	w := mockWorker{}
 	w.On("EnqueueUnique").Times(0).Return(nil, nil)
-	scanner := NewMovieScanner(mockClient, &w)
-	err := scanner.ScanForMovies()
+	scanner := NewMovieScanner(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	_, err := scanner.ScanForMovies(true)
 
 	assert.Error(t, err)
 	w.AssertNotCalled(t, "EnqueueUnique")
@@ -70,8 +83,8 @@ func TestDoesNothingIfNoMovies(t *testing.T) {
 	}
 	w := mockWorker{}
 	w.On("EnqueueUnique").Times(0).Return(nil, nil)
-	scanner := NewMovieScanner(mockClient, &w)
-	err := scanner.ScanForMovies()
+	scanner := NewMovieScanner(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	_, err := scanner.ScanForMovies(true)
 
 	if err != nil {
 		t.Error("Error returned")
@@ -89,8 +102,8 @@ func TestDoesNothingIfNotDownloaded(t *testing.T) {
 	}
 	w := mockWorker{}
 	w.On("EnqueueUnique").Times(0).Return(nil, nil)
-	scanner := NewMovieScanner(mockClient, &w)
-	err := scanner.ScanForMovies()
+	scanner := NewMovieScanner(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	_, err := scanner.ScanForMovies(true)
 
 	if err != nil {
 		t.Error("Error returned")
@@ -107,8 +120,8 @@ func TestSkipsIfAlreadyRightFormat(t *testing.T) {
 	}
 	w := mockWorker{}
 	w.On("EnqueueUnique").Times(0).Return(nil, nil)
-	scanner := NewMovieScanner(mockClient, &w)
-	err := scanner.ScanForMovies()
+	scanner := NewMovieScanner(mockClient, &w, decidingAnalyzer(transcode.Skip))
+	_, err := scanner.ScanForMovies(true)
 
 	if err != nil {
 		t.Error("Error returned")
@@ -126,8 +139,8 @@ func TestEnqueueIfProperFormat(t *testing.T) {
 	w := mockWorker{}
 	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{constants.MovieIdKey: 0,
 		constants.TranscodeTypeKey: constants.Movie}).Once().Return(nil, nil)
-	scanner := NewMovieScanner(mockClient, &w)
-	err := scanner.ScanForMovies()
+	scanner := NewMovieScanner(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	_, err := scanner.ScanForMovies(true)
 
 	if err != nil {
 		t.Error("Error returned")
@@ -145,8 +158,8 @@ func TestEnqueueAndIgnoresEnqueueError(t *testing.T) {
 	}
 	w := mockWorker{}
 	w.On("EnqueueUnique", mock.Anything, mock.Anything).Once().Return(nil, errors.New("boom"))
-	scanner := NewMovieScanner(mockClient, &w)
-	err := scanner.ScanForMovies()
+	scanner := NewMovieScanner(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	_, err := scanner.ScanForMovies(true)
 
 	if err != nil {
 		t.Error("Error returned")
@@ -154,3 +167,82 @@ func TestEnqueueAndIgnoresEnqueueError(t *testing.T) {
 	w.AssertExpectations(t)
 
 }
+
+func TestEnqueueIfProbeNeedsTranscode(t *testing.T) {
+
+	mockClient := MockRadarr{}
+	mockClient.getAllMovies = func() (movies []web.RadarrMovie, e error) {
+		movieList := []web.RadarrMovie{web.RadarrMovie{ID: 3, Downloaded: true, Path: "/movies/a", MovieFile: web.MovieFile{RelativePath: "test.mp4"}}}
+		return movieList, nil
+	}
+	analyzedPath := ""
+	analyzer := MockAnalyzer{analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+		analyzedPath = path
+		return transcode.FullTranscode, nil, nil
+	}}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{constants.MovieIdKey: 3,
+		constants.TranscodeTypeKey: constants.Movie}).Once().Return(nil, nil)
+	scanner := NewMovieScanner(mockClient, &w, analyzer)
+	_, err := scanner.ScanForMovies(true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/movies/a/test.mp4", analyzedPath)
+	w.AssertExpectations(t)
+}
+
+func TestSkipsIfProbeMatchesProfile(t *testing.T) {
+
+	mockClient := MockRadarr{}
+	mockClient.getAllMovies = func() (movies []web.RadarrMovie, e error) {
+		movieList := []web.RadarrMovie{web.RadarrMovie{Downloaded: true, MovieFile: web.MovieFile{RelativePath: "test.mkv"}}}
+		return movieList, nil
+	}
+	analyzer := MockAnalyzer{analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+		return transcode.Skip, nil, nil
+	}}
+	w := mockWorker{}
+	scanner := NewMovieScanner(mockClient, &w, analyzer)
+	_, err := scanner.ScanForMovies(true)
+
+	assert.NoError(t, err)
+	w.AssertNotCalled(t, "EnqueueUnique")
+}
+
+func TestScanAnalyzesMappedPath(t *testing.T) {
+
+	mockClient := MockRadarr{pathMapper: pathmap.Mapper{{Remote: "/movies", Local: "/mnt/nas/movies"}}}
+	mockClient.getAllMovies = func() (movies []web.RadarrMovie, e error) {
+		return []web.RadarrMovie{{ID: 3, Downloaded: true, Path: "/movies/a", MovieFile: web.MovieFile{RelativePath: "test.mp4"}}}, nil
+	}
+	analyzedPath := ""
+	analyzer := MockAnalyzer{analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+		analyzedPath = path
+		return transcode.Skip, nil, nil
+	}}
+	w := mockWorker{}
+	scanner := NewMovieScanner(mockClient, &w, analyzer)
+	_, err := scanner.ScanForMovies(true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/nas/movies/a/test.mp4", analyzedPath)
+}
+
+func TestFallsBackToExtensionWhenProbeFails(t *testing.T) {
+
+	mockClient := MockRadarr{}
+	mockClient.getAllMovies = func() (movies []web.RadarrMovie, e error) {
+		return []web.RadarrMovie{
+			{ID: 1, Downloaded: true, MovieFile: web.MovieFile{RelativePath: "test.mp4"}},
+			{ID: 2, Downloaded: true, MovieFile: web.MovieFile{RelativePath: "test.mkv"}},
+		}, nil
+	}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{constants.MovieIdKey: 2,
+		constants.TranscodeTypeKey: constants.Movie}).Once().Return(nil, nil)
+	scanner := NewMovieScanner(mockClient, &w, unreachableAnalyzer)
+	_, err := scanner.ScanForMovies(true)
+
+	assert.NoError(t, err)
+	w.AssertExpectations(t)
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"media-web/internal/constants"
+	"media-web/internal/web"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +14,12 @@ import (
 func (c *WorkerContext) UpdateMovie(job *work.Job) error {
 	movieId := job.ArgInt64(constants.MovieIdKey)
 
-	cmd, err := c.RadarrClient.RescanMovie(movieId)
+	client, err := c.radarrClient(job)
+	if err != nil {
+		return err
+	}
+
+	cmd, err := client.RescanMovie(movieId)
 
 	if err != nil {
 		log.Err(err).Msg("Error rescanning movie: " + strconv.Itoa(int(movieId)))
@@ -21,11 +27,12 @@ func (c *WorkerContext) UpdateMovie(job *work.Job) error {
 	}
 
 	for count := 0; count < 5; count++ {
-		result, err := c.RadarrClient.CheckRadarrCommand(cmd.ID)
+		result, err := client.CheckRadarrCommand(cmd.ID)
 
 		if err == nil {
 			if strings.Contains(result.State, "complete") {
 				log.Info().Msgf("Rescan complete for: %d", cmd.ID)
+				checkMoviePath(client, movieId, job.ArgString(constants.FilePathKey))
 				return nil
 			} else {
 				log.Info().Msgf("Rescan not complete yet for: %d", cmd.ID)
@@ -39,3 +46,20 @@ func (c *WorkerContext) UpdateMovie(job *work.Job) error {
 
 	return nil
 }
+
+// checkMoviePath warns when Radarr doesn't report the file we just wrote, which usually means the path mappings
+// don't match how Radarr sees the library
+func checkMoviePath(client web.RadarrClient, movieId int64, expected string) {
+	if expected == "" {
+		return
+	}
+	movie, err := client.LookupMovie(movieId)
+	if err != nil || movie == nil {
+		log.Warn().Err(err).Msg("Could not look up movie after rescan: " + strconv.Itoa(int(movieId)))
+		return
+	}
+	actual := movie.FilePath()
+	if actual != expected {
+		log.Warn().Str("expected", expected).Str("actual", actual).Msg("Radarr did not pick up the transcoded file. Check RADARR_PATH_MAPPINGS")
+	}
+}
@@ -96,3 +96,28 @@ func TestRadarrRescanNoErrorCheckCommand(t *testing.T) {
 	assert.True(t, callRescan)
 	assert.NoError(t, err)
 }
+
+func TestRadarrRescanChecksTranscodedPath(t *testing.T) {
+	mockClient := MockRadarr{}
+	mockClient.rescanMovie = func(id int64) (*web.RadarrCommand, error) {
+		return &web.RadarrCommand{ID: 1}, nil
+	}
+	mockClient.checkRadarrCommand = func(id int) (*web.RadarrCommand, error) {
+		return &web.RadarrCommand{ID: 1, State: "complete"}, nil
+	}
+	lookedUp := int64(0)
+	mockClient.lookupMovie = func(id int64) (*web.RadarrMovie, error) {
+		lookedUp = id
+		return &web.RadarrMovie{Path: "/movies/a", MovieFile: web.MovieFile{RelativePath: "test.mp4"}}, nil
+	}
+	context := WorkerContext{
+		RadarrClient: mockClient,
+		Sleep:        func(d time.Duration) {},
+	}
+
+	err := context.UpdateMovie(&work.Job{Args: map[string]interface{}{constants.MovieIdKey: 4,
+		constants.FilePathKey: "/movies/a/test.mp4"}})
+
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, lookedUp)
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"media-web/internal/constants"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/rs/zerolog/log"
+)
+
+// UpdateArtist asks Lidarr to rescan an artist once the transcodes of an album are done
+func (c *WorkerContext) UpdateArtist(job *work.Job) error {
+	artistId := job.ArgInt64(constants.ArtistIdKey)
+
+	if c.deferRescan(job, constants.UpdateLidarrJobName, constants.Music, constants.ArtistIdKey) {
+		return nil
+	}
+
+	cmd, err := c.LidarrClient.RescanArtist(artistId)
+	if err != nil {
+		log.Err(err).Msg("Error rescanning artist")
+		return err
+	}
+
+	for count := 0; count < 5; count++ {
+		result, err := c.LidarrClient.CheckLidarrCommand(cmd.ID)
+
+		if err == nil {
+			if strings.Contains(result.State, "complete") {
+				log.Info().Msg("Rescan complete for: " + strconv.Itoa(cmd.ID))
+				return nil
+			}
+			log.Info().Msg("Rescan not complete yet for: " + strconv.Itoa(cmd.ID))
+		} else {
+			log.Err(err).Msg("Error checking state of command: " + strconv.Itoa(cmd.ID))
+		}
+
+		c.Sleep(time.Second * 15)
+	}
+
+	return nil
+}
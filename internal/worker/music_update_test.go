@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"errors"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRescanArtistErrorReturns(t *testing.T) {
+	context := WorkerContext{
+		LidarrClient: MockLidarr{rescanArtist: func(id int64) (*web.LidarrCommand, error) {
+			return nil, errors.New("test error")
+		}},
+	}
+
+	err := context.UpdateArtist(&work.Job{Args: map[string]interface{}{constants.ArtistIdKey: 4}})
+
+	assert.Error(t, err)
+}
+
+func TestRescanArtistWaitsForCommand(t *testing.T) {
+	checks := 0
+	context := WorkerContext{
+		LidarrClient: MockLidarr{
+			rescanArtist: func(id int64) (*web.LidarrCommand, error) {
+				assert.EqualValues(t, 4, id)
+				return &web.LidarrCommand{ID: 12}, nil
+			},
+			checkLidarrCommand: func(id int) (*web.LidarrCommand, error) {
+				checks++
+				if checks < 2 {
+					return &web.LidarrCommand{ID: id, State: "started"}, nil
+				}
+				return &web.LidarrCommand{ID: id, State: "completed"}, nil
+			},
+		},
+		Sleep: func(d time.Duration) {},
+	}
+
+	err := context.UpdateArtist(&work.Job{Args: map[string]interface{}{constants.ArtistIdKey: 4}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, checks)
+}
+
+func TestRescanArtistDeferredWhileAlbumTranscodesQueued(t *testing.T) {
+	inspector := mockJobInspector{countPendingJobs: func(match func(job *work.Job) bool) (int, error) {
+		assert.True(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.Music), constants.ArtistIdKey: float64(4),
+		}}))
+		assert.False(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.TV), constants.SeriesIdKey: float64(4),
+		}}))
+		return 3, nil
+	}}
+	w := mockWorker{}
+	w.On("EnqueueUniqueIn", constants.UpdateLidarrJobName, int64(rescanDeferSeconds), map[string]interface{}{
+		constants.ArtistIdKey: int64(4),
+	}).Return(nil, nil)
+	context := WorkerContext{
+		LidarrClient: MockLidarr{rescanArtist: func(id int64) (*web.LidarrCommand, error) {
+			t.Error("rescanned while transcodes were queued")
+			return nil, nil
+		}},
+		JobInspector: inspector,
+		Enqueuer:     &w,
+	}
+
+	err := context.UpdateArtist(&work.Job{Args: map[string]interface{}{constants.ArtistIdKey: 4}})
+
+	assert.NoError(t, err)
+	w.AssertExpectations(t)
+}
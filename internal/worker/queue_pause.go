@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/storage"
+	"media-web/internal/utils"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// pauseReportInterval is how often the paused gauge is refreshed, as another instance may pause a queue
+var pauseReportInterval = 30 * time.Second
+
+// PausableJobTypes are the job types the API can pause
+var PausableJobTypes = []string{constants.TranscodeJobType, constants.UpdateSonarrJobName, constants.UpdateRadarrJobName,
+	constants.UpdateLidarrJobName}
+
+// pausedJobNames are the jobs a job type covers. Pausing transcodes also holds back the priority transcodes. Chunks
+// are left running, as they are only queued by running chunked transcodes which would otherwise never finish
+var pausedJobNames = map[string][]string{
+	constants.TranscodeJobType: {constants.TranscodeJobType, constants.PriorityTranscodeJobType},
+}
+
+// UnknownJobTypeError is returned when pausing or resuming a job type that can't be paused
+var UnknownJobTypeError = fmt.Errorf("unknown job type, expected one of %v", PausableJobTypes)
+
+// QueuePauser stops and starts workers picking up job types. Paused jobs stay queued and running jobs finish
+type QueuePauser interface {
+	Pause(jobType string) error
+	Resume(jobType string) error
+	// Paused reports whether each of the PausableJobTypes is paused
+	Paused() (map[string]bool, error)
+}
+
+// redisQueuePauser sets the pause keys gocraft/work checks before fetching a job. They live in Redis so they
+// outlast restarts
+type redisQueuePauser struct {
+	namespace string
+	registry  CapabilityRegistry
+	pool      *redis.Pool
+}
+
+// NewQueuePauser creates a QueuePauser for the jobs in namespace and in the namespaces of the worker classes in
+// registry
+func NewQueuePauser(namespace string, registry CapabilityRegistry, pool *redis.Pool) QueuePauser {
+	return redisQueuePauser{namespace: namespace, registry: registry, pool: pool}
+}
+
+// GetQueuePauser returns the QueuePauser for the configured job queue
+func GetQueuePauser() QueuePauser {
+	return NewQueuePauser(config.GetConfig().JobQueueNamespace, GetCapabilityRegistry(), &storage.RedisPool)
+}
+
+func pauseKey(namespace string, name string) string {
+	return queueKey(namespace, name) + ":paused"
+}
+
+func jobNames(jobType string) ([]string, error) {
+	if !containsString(PausableJobTypes, jobType) {
+		return nil, UnknownJobTypeError
+	}
+	if names, ok := pausedJobNames[jobType]; ok {
+		return names, nil
+	}
+	return []string{jobType}, nil
+}
+
+func (p redisQueuePauser) Pause(jobType string) error {
+	return p.set(jobType, true)
+}
+
+func (p redisQueuePauser) Resume(jobType string) error {
+	return p.set(jobType, false)
+}
+
+func (p redisQueuePauser) set(jobType string, paused bool) error {
+	names, err := jobNames(jobType)
+	if err != nil {
+		return err
+	}
+	namespaces, err := p.namespaces()
+	if err != nil {
+		return err
+	}
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	for _, namespace := range namespaces {
+		for _, name := range names {
+			if paused {
+				err = conn.Send("SET", pauseKey(namespace, name), "1")
+			} else {
+				err = conn.Send("DEL", pauseKey(namespace, name))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, err = conn.Do(""); err != nil {
+		return err
+	}
+	reportPaused(jobType, paused)
+	log.Info().Str("jobType", jobType).Bool("paused", paused).Msg("Changed job queue pause")
+	return nil
+}
+
+// Paused reads the default namespace, which every pause and resume writes to
+func (p redisQueuePauser) Paused() (map[string]bool, error) {
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	paused := make(map[string]bool, len(PausableJobTypes))
+	for _, jobType := range PausableJobTypes {
+		value, err := redis.Bool(conn.Do("EXISTS", pauseKey(p.namespace, jobType)))
+		if err != nil {
+			return nil, err
+		}
+		paused[jobType] = value
+		reportPaused(jobType, value)
+	}
+	return paused, nil
+}
+
+// namespaces are the default namespace and those of every worker class
+func (p redisQueuePauser) namespaces() ([]string, error) {
+	classes, err := p.registry.Classes()
+	if err != nil {
+		return nil, err
+	}
+	namespaces := []string{p.namespace}
+	for _, class := range classes {
+		namespaces = append(namespaces, ClassNamespace(p.namespace, class.Class))
+	}
+	return namespaces, nil
+}
+
+func reportPaused(jobType string, paused bool) {
+	value := 0.0
+	if paused {
+		value = 1
+	}
+	utils.QueuePaused.WithLabelValues(jobType).Set(value)
+}
+
+// syncPauses applies the pauses of the default namespace to a worker class that may not have been advertised when
+// they were set
+func syncPauses(pauser QueuePauser) {
+	paused, err := pauser.Paused()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read paused job queues")
+		return
+	}
+	for jobType, isPaused := range paused {
+		if !isPaused {
+			continue
+		}
+		if err = pauser.Pause(jobType); err != nil {
+			log.Warn().Err(err).Str("jobType", jobType).Msg("Failed to pause job queue for worker class")
+		}
+	}
+}
+
+// ReportQueuePauses keeps the paused gauge up to date until ctx is done
+func ReportQueuePauses(ctx context.Context, pauser QueuePauser) {
+	ticker := time.NewTicker(pauseReportInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := pauser.Paused(); err != nil {
+			log.Warn().Err(err).Msg("Failed to read paused job queues")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
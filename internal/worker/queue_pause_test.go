@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"media-web/internal/constants"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingPauser struct {
+	paused map[string]bool
+	pauses []string
+}
+
+func (r *recordingPauser) Pause(jobType string) error {
+	r.pauses = append(r.pauses, jobType)
+	return nil
+}
+
+func (r *recordingPauser) Resume(jobType string) error {
+	return nil
+}
+
+func (r *recordingPauser) Paused() (map[string]bool, error) {
+	return r.paused, nil
+}
+
+func TestPauseKeyMatchesGocraft(t *testing.T) {
+	assert.Equal(t, "media:jobs:transcode-job:paused", pauseKey("media", constants.TranscodeJobType))
+	assert.Equal(t, "media-gpu:jobs:update-sonarr:paused", pauseKey(ClassNamespace("media:", "gpu"), constants.UpdateSonarrJobName))
+}
+
+func TestJobNamesCoverRelatedTranscodeJobs(t *testing.T) {
+	names, err := jobNames(constants.TranscodeJobType)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{constants.TranscodeJobType, constants.PriorityTranscodeJobType}, names)
+
+	names, err = jobNames(constants.UpdateRadarrJobName)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{constants.UpdateRadarrJobName}, names)
+}
+
+func TestJobNamesRejectsJobsThatCantBePaused(t *testing.T) {
+	_, err := jobNames(constants.TranscodeChunkJobName)
+	assert.Equal(t, UnknownJobTypeError, err)
+
+	_, err = jobNames(constants.PriorityTranscodeJobType)
+	assert.Equal(t, UnknownJobTypeError, err)
+}
+
+func TestSyncPausesReappliesPausedJobTypes(t *testing.T) {
+	pauser := &recordingPauser{paused: map[string]bool{
+		constants.TranscodeJobType:    true,
+		constants.UpdateSonarrJobName: false,
+	}}
+
+	syncPauses(pauser)
+
+	assert.Equal(t, []string{constants.TranscodeJobType}, pauser.pauses)
+}
+
+func TestRedisQueuePauser(t *testing.T) {
+	pool, server := testRedis(t)
+	registry := &memoryRegistry{classes: []Capabilities{{Class: "nas"}}}
+	pauser := NewQueuePauser(testNamespace, registry, pool)
+	classNamespace := ClassNamespace(testNamespace, "nas")
+
+	assert.NoError(t, pauser.Pause(constants.TranscodeJobType))
+
+	for _, namespace := range []string{testNamespace, classNamespace} {
+		assert.True(t, server.Exists(pauseKey(namespace, constants.TranscodeJobType)))
+		assert.True(t, server.Exists(pauseKey(namespace, constants.PriorityTranscodeJobType)))
+		assert.False(t, server.Exists(pauseKey(namespace, constants.TranscodeChunkJobName)))
+	}
+	paused, err := pauser.Paused()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		constants.TranscodeJobType:    true,
+		constants.UpdateSonarrJobName: false,
+		constants.UpdateRadarrJobName: false,
+		constants.UpdateLidarrJobName: false,
+	}, paused)
+
+	assert.NoError(t, pauser.Resume(constants.TranscodeJobType))
+
+	assert.False(t, server.Exists(pauseKey(testNamespace, constants.TranscodeJobType)))
+	assert.False(t, server.Exists(pauseKey(classNamespace, constants.PriorityTranscodeJobType)))
+	paused, err = pauser.Paused()
+	assert.NoError(t, err)
+	assert.False(t, paused[constants.TranscodeJobType])
+
+	assert.Equal(t, UnknownJobTypeError, pauser.Pause(constants.TranscodeChunkJobName))
+}
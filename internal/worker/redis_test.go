@@ -0,0 +1,23 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+const testNamespace = "media-web-test"
+
+// testRedis starts an in-memory Redis for the test and returns a pool connected to it
+func testRedis(t *testing.T) (*redis.Pool, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	assert.NoError(t, err)
+	t.Cleanup(server.Close)
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", server.Addr())
+	}}
+	t.Cleanup(func() { _ = pool.Close() })
+	return pool, server
+}
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/storage"
+	"media-web/internal/transcode"
+	"media-web/internal/web"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// routeCacheTTL is how long the advertised worker classes are used before they are read again
+var routeCacheTTL = 30 * time.Second
+
+// JobRouter is the WorkScheduler the scanners, webhooks and API enqueue through. Transcodes go to the queue of a
+// worker class that can run them. Other jobs, and transcodes no class can run, go to the default queue
+type JobRouter struct {
+	namespace     string
+	base          WorkScheduler
+	registry      CapabilityRegistry
+	pool          *redis.Pool
+	newScheduler  func(namespace string) WorkScheduler
+	sonarrClients map[string]web.SonarrClient
+	radarrClients map[string]web.RadarrClient
+	lidarrClient  web.LidarrClient
+
+	mu         sync.Mutex
+	schedulers map[string]WorkScheduler
+	classes    []Capabilities
+	readAt     time.Time
+}
+
+// NewJobRouter creates a JobRouter. base enqueues into namespace and newScheduler creates the enqueuers of the
+// class namespaces. pool is used to find transcodes already queued in another namespace and may be nil to skip the
+// check. The clients look up the paths of Sonarr, Radarr and Lidarr files for classes with path roots
+func NewJobRouter(namespace string, base WorkScheduler, registry CapabilityRegistry, pool *redis.Pool, newScheduler func(namespace string) WorkScheduler,
+	sonarrClients map[string]web.SonarrClient, radarrClients map[string]web.RadarrClient, lidarrClient web.LidarrClient) *JobRouter {
+	return &JobRouter{
+		namespace:     namespace,
+		base:          base,
+		registry:      registry,
+		pool:          pool,
+		newScheduler:  newScheduler,
+		sonarrClients: sonarrClients,
+		radarrClients: radarrClients,
+		lidarrClient:  lidarrClient,
+		schedulers:    map[string]WorkScheduler{},
+	}
+}
+
+// GetJobRouter returns a JobRouter for the configured job queue and instances
+func GetJobRouter() *JobRouter {
+	return NewJobRouter(config.GetConfig().JobQueueNamespace, worker, GetCapabilityRegistry(), &storage.RedisPool, func(namespace string) WorkScheduler {
+		return work.NewEnqueuer(namespace, &storage.RedisPool)
+	}, web.GetSonarrClients(), web.GetRadarrClients(), web.GetLidarrClient())
+}
+
+// EnqueueUnique returns a nil job, like gocraft/work, when the job is already queued in any of the namespaces
+func (r *JobRouter) EnqueueUnique(jobName string, args map[string]interface{}) (*work.Job, error) {
+	scheduler, namespace := r.scheduler(jobName, args)
+	queued, err := r.queuedElsewhere(namespace, jobName, args)
+	if err != nil || queued {
+		return nil, err
+	}
+	return scheduler.EnqueueUnique(jobName, args)
+}
+
+func (r *JobRouter) EnqueueUniqueIn(jobName string, secondsFromNow int64, args map[string]interface{}) (*work.ScheduledJob, error) {
+	scheduler, namespace := r.scheduler(jobName, args)
+	queued, err := r.queuedElsewhere(namespace, jobName, args)
+	if err != nil || queued {
+		return nil, err
+	}
+	return scheduler.EnqueueUniqueIn(jobName, secondsFromNow, args)
+}
+
+func isTranscodeJob(jobName string) bool {
+	return jobName == constants.TranscodeJobType || jobName == constants.PriorityTranscodeJobType
+}
+
+// scheduler returns the enqueuer of the queue the job is routed to along with its namespace
+func (r *JobRouter) scheduler(jobName string, args map[string]interface{}) (WorkScheduler, string) {
+	if !isTranscodeJob(jobName) {
+		return r.base, r.namespace
+	}
+	class, ok := r.Route(args)
+	if !ok {
+		return r.base, r.namespace
+	}
+	namespace := ClassNamespace(r.namespace, class.Class)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scheduler, ok := r.schedulers[namespace]
+	if !ok {
+		scheduler = r.newScheduler(namespace)
+		r.schedulers[namespace] = scheduler
+	}
+	return scheduler, namespace
+}
+
+// queuedElsewhere reports whether a transcode is queued or scheduled in a namespace other than the one it is routed
+// to. gocraft/work only keeps jobs unique within a namespace, so without it a transcode queued for a class that has
+// since gone stale would be queued again in the default queue and encoded twice
+func (r *JobRouter) queuedElsewhere(namespace string, jobName string, args map[string]interface{}) (bool, error) {
+	if r.pool == nil || !isTranscodeJob(jobName) {
+		return false, nil
+	}
+	r.mu.Lock()
+	namespaces := []string{r.namespace}
+	for _, class := range r.classes {
+		namespaces = append(namespaces, ClassNamespace(r.namespace, class.Class))
+	}
+	r.mu.Unlock()
+
+	conn := r.pool.Get()
+	defer conn.Close()
+	for _, other := range namespaces {
+		if other == namespace {
+			continue
+		}
+		key, err := uniqueKey(other, jobName, args)
+		if err != nil {
+			return false, err
+		}
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			log.Info().Str("namespace", other).Msg("Transcode is already queued for another worker class")
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// uniqueKey is the key gocraft/work holds while a unique job is queued or scheduled
+func uniqueKey(namespace string, jobName string, args map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString(namespacePrefix(namespace) + "unique:" + jobName + ":")
+	if args != nil {
+		if err := json.NewEncoder(&buf).Encode(args); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// Route picks the worker class for a transcode. Classes running more encodes at a time are preferred. It reports
+// false when no class is advertised or none can run the transcode
+func (r *JobRouter) Route(args map[string]interface{}) (Capabilities, bool) {
+	classes := r.liveClasses()
+	if len(classes) == 0 {
+		return Capabilities{}, false
+	}
+
+	job := &work.Job{Args: args}
+	transcodeType := constants.TranscodeType(job.ArgString(constants.TranscodeTypeKey))
+	profileName := defaultProfileName(transcodeType, JobInstance(job))
+	if _, ok := args[constants.ProfileKey]; ok {
+		profileName = job.ArgString(constants.ProfileKey)
+	}
+	cpuClass := ""
+	if profile, err := transcode.GetProfiles().Get(profileName); err == nil {
+		cpuClass = profile.CPUClass
+	}
+
+	path := ""
+	resolved := false
+	candidates := make([]Capabilities, 0, len(classes))
+	for _, class := range classes {
+		// Looking up the path costs a request to Sonarr, Radarr or Lidarr so it is only done when a class needs it
+		if len(class.PathRoots) > 0 && !resolved {
+			path = r.sourcePath(job, transcodeType)
+			resolved = true
+		}
+		if class.Allows(profileName, cpuClass, path) {
+			candidates = append(candidates, class)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Warn().Str("profile", profileName).Str("path", path).Msg("No worker class can run transcode. Using the default queue")
+		return Capabilities{}, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].MaxEncodes > candidates[j].MaxEncodes })
+	return candidates[0], true
+}
+
+// liveClasses returns the classes workers advertised recently, reading them from the registry at most every
+// routeCacheTTL
+func (r *JobRouter) liveClasses() []Capabilities {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.readAt.IsZero() || now.Sub(r.readAt) >= routeCacheTTL {
+		classes, err := r.registry.Classes()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to read worker classes")
+		} else {
+			r.classes = classes
+			r.readAt = now
+		}
+	}
+	live := make([]Capabilities, 0, len(r.classes))
+	for _, class := range r.classes {
+		if class.Live(now) {
+			live = append(live, class)
+		}
+	}
+	return live
+}
+
+// sourcePath is the path of the file a transcode works on, or empty when it can't be looked up
+func (r *JobRouter) sourcePath(job *work.Job, transcodeType constants.TranscodeType) string {
+	var path string
+	var err error
+	switch transcodeType {
+	case constants.TV:
+		if client, ok := r.sonarrClients[JobInstance(job)]; ok {
+			path, _, err = client.GetEpisodeFilePath(job.ArgInt64(constants.EpisodeFileIdKey))
+		}
+	case constants.Movie:
+		if client, ok := r.radarrClients[JobInstance(job)]; ok {
+			path, err = client.GetMovieFilePath(job.ArgInt64(constants.MovieIdKey))
+		}
+	case constants.Music:
+		if r.lidarrClient != nil {
+			path, _, err = r.lidarrClient.GetTrackFilePath(job.ArgInt64(constants.TrackFileIdKey))
+		}
+	case constants.File:
+		path = job.ArgString(constants.FilePathKey)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to look up the path of a transcode for routing")
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"errors"
+	"media-web/internal/constants"
+	"media-web/internal/web"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryRegistry struct {
+	classes []Capabilities
+	err     error
+}
+
+func (m *memoryRegistry) Advertise(capabilities Capabilities) error {
+	m.classes = append(m.classes, capabilities)
+	return nil
+}
+
+func (m *memoryRegistry) Classes() ([]Capabilities, error) {
+	return m.classes, m.err
+}
+
+// recordingScheduler remembers the jobs enqueued into its namespace
+type recordingScheduler struct {
+	namespace string
+	jobs      []string
+}
+
+func (r *recordingScheduler) EnqueueUnique(jobName string, args map[string]interface{}) (*work.Job, error) {
+	r.jobs = append(r.jobs, jobName)
+	return &work.Job{ID: r.namespace}, nil
+}
+
+func (r *recordingScheduler) EnqueueUniqueIn(jobName string, secondsFromNow int64, args map[string]interface{}) (*work.ScheduledJob, error) {
+	r.jobs = append(r.jobs, jobName)
+	return &work.ScheduledJob{Job: &work.Job{ID: r.namespace}}, nil
+}
+
+func testRouter(registry CapabilityRegistry, radarr web.RadarrClient) *JobRouter {
+	base := &recordingScheduler{namespace: "media-web"}
+	return NewJobRouter("media-web", base, registry, nil, func(namespace string) WorkScheduler {
+		return &recordingScheduler{namespace: namespace}
+	}, nil, map[string]web.RadarrClient{"default": radarr}, nil)
+}
+
+func movieArgs() map[string]interface{} {
+	return map[string]interface{}{constants.TranscodeTypeKey: string(constants.Movie), constants.MovieIdKey: 1}
+}
+
+func TestRouterPrefersClassWithMoreEncodes(t *testing.T) {
+	now := time.Now().Unix()
+	registry := &memoryRegistry{classes: []Capabilities{
+		{Class: "big", MaxEncodes: 4, SeenAt: now},
+		{Class: "nas", MaxEncodes: 1, SeenAt: now},
+	}}
+	router := testRouter(registry, MockRadarr{})
+
+	job, err := router.EnqueueUnique(constants.TranscodeJobType, movieArgs())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "media-web-big", job.ID)
+}
+
+func TestRouterChecksPathRoots(t *testing.T) {
+	now := time.Now().Unix()
+	registry := &memoryRegistry{classes: []Capabilities{
+		{Class: "big", MaxEncodes: 4, PathRoots: []string{"/movies/4k"}, SeenAt: now},
+		{Class: "nas", MaxEncodes: 1, PathRoots: []string{"/movies"}, SeenAt: now},
+	}}
+	lookups := 0
+	router := testRouter(registry, MockRadarr{getMovieFilePath: func(id int64) (string, error) {
+		lookups++
+		return "/movies/Movie (2020)/movie.mkv", nil
+	}})
+
+	job, err := router.EnqueueUnique(constants.TranscodeJobType, movieArgs())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "media-web-nas", job.ID)
+	assert.Equal(t, 1, lookups)
+}
+
+func TestRouterUsesDefaultQueue(t *testing.T) {
+	now := time.Now().Unix()
+	registry := &memoryRegistry{classes: []Capabilities{
+		{Class: "nas", Profiles: []string{"music"}, SeenAt: now},
+		{Class: "old", SeenAt: now - int64(time.Hour.Seconds())},
+	}}
+	router := testRouter(registry, MockRadarr{})
+
+	job, err := router.EnqueueUnique(constants.TranscodeJobType, movieArgs())
+	assert.NoError(t, err)
+	assert.Equal(t, "media-web", job.ID)
+
+	job, err = router.EnqueueUnique(constants.UpdateRadarrJobName, movieArgs())
+	assert.NoError(t, err)
+	assert.Equal(t, "media-web", job.ID)
+}
+
+func TestRouterUsesDefaultQueueWhenRegistryFails(t *testing.T) {
+	router := testRouter(&memoryRegistry{err: errors.New("redis is down")}, MockRadarr{})
+
+	job, err := router.EnqueueUnique(constants.PriorityTranscodeJobType, movieArgs())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "media-web", job.ID)
+}
+
+func TestRouterSkipsTranscodesQueuedForStaleClass(t *testing.T) {
+	defer func(ttl time.Duration) { routeCacheTTL = ttl }(routeCacheTTL)
+	routeCacheTTL = 0
+	pool, _ := testRedis(t)
+	registry := &memoryRegistry{classes: []Capabilities{{Class: "nas", SeenAt: time.Now().Unix()}}}
+	router := NewJobRouter(testNamespace, work.NewEnqueuer(testNamespace, pool), registry, pool, func(namespace string) WorkScheduler {
+		return work.NewEnqueuer(namespace, pool)
+	}, nil, map[string]web.RadarrClient{"default": MockRadarr{}}, nil)
+
+	job, err := router.EnqueueUnique(constants.TranscodeJobType, movieArgs())
+	assert.NoError(t, err)
+	assert.NotNil(t, job)
+
+	registry.classes[0].SeenAt = time.Now().Add(-time.Hour).Unix()
+	job, err = router.EnqueueUnique(constants.TranscodeJobType, movieArgs())
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+	scheduled, err := router.EnqueueUniqueIn(constants.TranscodeJobType, 60, movieArgs())
+	assert.NoError(t, err)
+	assert.Nil(t, scheduled)
+
+	queues, err := work.NewClient(testNamespace, pool).Queues()
+	assert.NoError(t, err)
+	assert.Empty(t, queues)
+
+	job, err = router.EnqueueUnique(constants.PriorityTranscodeJobType, movieArgs())
+	assert.NoError(t, err)
+	assert.NotNil(t, job)
+}
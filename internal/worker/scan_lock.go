@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"media-web/internal/config"
+	"media-web/internal/storage"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// scanLockTTL is how long a scan lock outlives a process that died while scanning. A running scan refreshes it well
+// before it expires
+const scanLockTTL = 5 * time.Minute
+
+// ScanLock keeps a scanner from running in more than one process at the same time
+type ScanLock interface {
+	// Acquire takes the lock of a scanner. It returns false when another process holds it
+	Acquire(scanner string, instance string) (bool, error)
+	// Refresh extends a lock this process holds
+	Refresh(scanner string, instance string) error
+	// Release gives up a lock this process holds
+	Release(scanner string, instance string) error
+}
+
+// releaseScanLock and refreshScanLock only touch the lock while it still holds the caller's token, so a process whose
+// lock expired can't release or extend the lock another process took since
+var (
+	releaseScanLock = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	refreshScanLock = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type redisScanLock struct {
+	prefix string
+	token  string
+	ttl    time.Duration
+	pool   *redis.Pool
+}
+
+// NewScanLock creates a ScanLock holding each scanner's lock in a Redis key that expires unless it is refreshed
+func NewScanLock(namespace string, pool *redis.Pool) ScanLock {
+	return redisScanLock{prefix: namespacePrefix(namespace) + "scan-lock:", token: newLockToken(), ttl: scanLockTTL, pool: pool}
+}
+
+// GetScanLock returns the ScanLock for the configured job queue
+func GetScanLock() ScanLock {
+	return NewScanLock(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+}
+
+func newLockToken() string {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+func (l redisScanLock) key(scanner string, instance string) string {
+	return l.prefix + scanner + ":" + instance
+}
+
+func (l redisScanLock) Acquire(scanner string, instance string) (bool, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	_, err := redis.String(conn.Do("SET", l.key(scanner, instance), l.token, "NX", "PX", l.ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l redisScanLock) Refresh(scanner string, instance string) error {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	_, err := refreshScanLock.Do(conn, l.key(scanner, instance), l.token, l.ttl.Milliseconds())
+	return err
+}
+
+func (l redisScanLock) Release(scanner string, instance string) error {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	_, err := releaseScanLock.Do(conn, l.key(scanner, instance), l.token)
+	return err
+}
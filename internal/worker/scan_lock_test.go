@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisScanLock(t *testing.T) {
+	pool, server := testRedis(t)
+	first := NewScanLock(testNamespace, pool)
+	second := NewScanLock(testNamespace, pool)
+
+	acquired, err := first.Acquire(RadarrScanner, "default")
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = second.Acquire(RadarrScanner, "default")
+	assert.NoError(t, err)
+	assert.False(t, acquired, "another process holds the lock")
+	acquired, err = second.Acquire(SonarrScanner, "default")
+	assert.NoError(t, err)
+	assert.True(t, acquired, "each scanner has its own lock")
+
+	assert.NoError(t, second.Release(RadarrScanner, "default"))
+	assert.True(t, server.Exists(testNamespace+":scan-lock:radarr:default"), "only the holder releases the lock")
+
+	server.SetTTL(testNamespace+":scan-lock:radarr:default", time.Second)
+	assert.NoError(t, first.Refresh(RadarrScanner, "default"))
+	assert.Equal(t, scanLockTTL, server.TTL(testNamespace+":scan-lock:radarr:default"))
+
+	assert.NoError(t, first.Release(RadarrScanner, "default"))
+	acquired, err = second.Acquire(RadarrScanner, "default")
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestRedisScanLockExpires(t *testing.T) {
+	pool, server := testRedis(t)
+	first := NewScanLock(testNamespace, pool)
+	second := NewScanLock(testNamespace, pool)
+
+	acquired, _ := first.Acquire(RadarrScanner, "default")
+	assert.True(t, acquired)
+	server.FastForward(scanLockTTL)
+
+	acquired, err := second.Acquire(RadarrScanner, "default")
+	assert.NoError(t, err)
+	assert.True(t, acquired, "the lock of a process that died expires")
+}
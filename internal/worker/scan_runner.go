@@ -0,0 +1,278 @@
+package worker
+
+import (
+	"errors"
+	"media-web/internal/config"
+	"media-web/internal/filter"
+	"media-web/internal/transcode"
+	"media-web/internal/utils"
+	"media-web/internal/web"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Scanner kinds, matching the *arr the library is read from
+const (
+	RadarrScanner = "radarr"
+	SonarrScanner = "sonarr"
+)
+
+// ErrScanRunning is returned when a scan is started while the previous one is still going
+var ErrScanRunning = errors.New("scan is already running")
+
+// ScanSummary counts the files a scan checked, skipped because they were unchanged and enqueued for transcoding
+type ScanSummary struct {
+	Checked  int `json:"checked"`
+	Skipped  int `json:"skipped"`
+	Enqueued int `json:"enqueued"`
+	// Filtered is the number of files the filter rules excluded
+	Filtered int `json:"filtered"`
+}
+
+// ScanStatus is the state of a scanner and the outcome of its last run
+type ScanStatus struct {
+	Scanner  string `json:"scanner"`
+	Instance string `json:"instance"`
+	// Schedule is the cron expression the scanner runs on. Empty when it only runs on demand
+	Schedule string `json:"schedule,omitempty"`
+	Running  bool   `json:"running"`
+	// Full is set when the last or current run checks every file rather than only new or changed ones
+	Full       bool  `json:"full"`
+	StartedAt  int64 `json:"startedAt,omitempty"`
+	FinishedAt int64 `json:"finishedAt,omitempty"`
+	// Result is success or error once the scanner has finished a run
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	ScanSummary
+}
+
+// ScanRunner runs the library scan of one Sonarr or Radarr instance, on a schedule or on demand, and keeps the
+// outcome of the last run. A scan never overlaps a previous one that is still running. Without a lock that only
+// holds within the process; with one it holds across every process sharing the job queue
+type ScanRunner struct {
+	scanner  string
+	instance string
+	scan     func(full bool) (ScanSummary, error)
+	now      func() time.Time
+	lock     ScanLock
+
+	mu     sync.Mutex
+	status ScanStatus
+}
+
+// NewScanRunner wraps a scan of the instance. The schedule is only reported in the status
+func NewScanRunner(scanner string, instance config.Instance, schedule string, scan func(full bool) (ScanSummary, error)) *ScanRunner {
+	return &ScanRunner{
+		scanner:  scanner,
+		instance: instance.Name,
+		scan:     scan,
+		now:      time.Now,
+		status:   ScanStatus{Scanner: scanner, Instance: instance.Name, Schedule: schedule},
+	}
+}
+
+// NewMovieScanRunner scans a Radarr instance for movies in the wrong format, first asking Radarr to search for
+// missing movies when the instance has SearchMissing set
+func NewMovieScanRunner(instance config.Instance, scanner MovieScanner) *ScanRunner {
+	return NewScanRunner(RadarrScanner, instance, scheduleOf(instance), func(full bool) (ScanSummary, error) {
+		var searchErr error
+		if instance.SearchMissing {
+			log.Info().Str("instance", instance.Name).Msg("Scanning for missing movies")
+			if searchErr = scanner.SearchForMissingMovies(); searchErr != nil {
+				log.Err(searchErr).Str("instance", instance.Name).Msg("Error searching for movies")
+			}
+		}
+		log.Info().Str("instance", instance.Name).Bool("full", full).Msg("Scanning for movies in wrong format")
+		summary, err := scanner.ScanForMovies(full)
+		if err != nil {
+			return summary, err
+		}
+		return summary, searchErr
+	})
+}
+
+// NewTVScanRunner scans a Sonarr instance for episodes in the wrong format
+func NewTVScanRunner(instance config.Instance, client web.SonarrClient, scheduler WorkScheduler, analyzer transcode.Analyzer, rules filter.Rules, fingerprints FingerprintStore) *ScanRunner {
+	return NewScanRunner(SonarrScanner, instance, scheduleOf(instance), func(full bool) (ScanSummary, error) {
+		log.Info().Str("instance", instance.Name).Bool("full", full).Msg("Scanning for TV in wrong format")
+		return ScanInstanceForTVShows(instance, client, scheduler, analyzer, rules, fingerprints, full)
+	})
+}
+
+// WithLock makes the scanner take the lock before each run, so it doesn't overlap a run in another process. The
+// status only reports this process's runs
+func (r *ScanRunner) WithLock(lock ScanLock) *ScanRunner {
+	r.lock = lock
+	return r
+}
+
+func scheduleOf(instance config.Instance) string {
+	if !instance.EnableScanner {
+		return ""
+	}
+	return instance.CronSpec()
+}
+
+// Status returns a copy of the scanner's state
+func (r *ScanRunner) Status() ScanStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Run scans and waits for the scan to finish. A full scan checks every file, otherwise only new or changed files are
+// checked. It returns ErrScanRunning without scanning when a scan is in progress
+func (r *ScanRunner) Run(full bool) error {
+	started, err := r.begin(full)
+	if err != nil {
+		return err
+	}
+	return r.perform(full, started)
+}
+
+// Start scans in the background. It returns ErrScanRunning when a scan is in progress
+func (r *ScanRunner) Start(full bool) error {
+	started, err := r.begin(full)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_ = r.perform(full, started)
+	}()
+	return nil
+}
+
+func (r *ScanRunner) begin(full bool) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Running {
+		return time.Time{}, ErrScanRunning
+	}
+	if r.lock != nil {
+		acquired, err := r.lock.Acquire(r.scanner, r.instance)
+		if err != nil {
+			log.Err(err).Str("scanner", r.scanner).Str("instance", r.instance).Msg("Failed to take scan lock")
+			return time.Time{}, err
+		}
+		if !acquired {
+			return time.Time{}, ErrScanRunning
+		}
+	}
+	started := r.now()
+	r.status.Running = true
+	r.status.Full = full
+	r.status.StartedAt = started.Unix()
+	return started, nil
+}
+
+func (r *ScanRunner) perform(full bool, started time.Time) error {
+	var done chan struct{}
+	if r.lock != nil {
+		done = make(chan struct{})
+		go r.holdLock(done)
+	}
+
+	summary, err := r.scan(full)
+	finished := r.now()
+
+	// The lock is released before the run is reported finished, so a scan started once it is can take the lock
+	if r.lock != nil {
+		close(done)
+		if releaseErr := r.lock.Release(r.scanner, r.instance); releaseErr != nil {
+			log.Warn().Err(releaseErr).Str("scanner", r.scanner).Str("instance", r.instance).Msg("Failed to release scan lock")
+		}
+	}
+
+	r.mu.Lock()
+	r.status.Running = false
+	r.status.FinishedAt = finished.Unix()
+	r.status.ScanSummary = summary
+	r.status.Result = "success"
+	r.status.Error = ""
+	if err != nil {
+		r.status.Result = "error"
+		r.status.Error = err.Error()
+	}
+	status := r.status
+	r.mu.Unlock()
+
+	success := 1.0
+	if err != nil {
+		success = 0
+		log.Err(err).Str("scanner", status.Scanner).Str("instance", status.Instance).Msg("Scan failed")
+	} else {
+		log.Info().Str("scanner", status.Scanner).Str("instance", status.Instance).Int("checked", summary.Checked).
+			Int("skipped", summary.Skipped).Int("enqueued", summary.Enqueued).Int("filtered", summary.Filtered).Msg("Done scanning")
+	}
+	utils.ScanCount.WithLabelValues(status.Scanner, status.Instance, status.Result).Inc()
+	utils.ScanTime.WithLabelValues(status.Scanner, status.Instance, status.Result).Observe(finished.Sub(started).Seconds())
+	utils.ScanFiles.WithLabelValues(status.Scanner, status.Instance, "checked").Add(float64(summary.Checked))
+	utils.ScanFiles.WithLabelValues(status.Scanner, status.Instance, "skipped").Add(float64(summary.Skipped))
+	utils.ScanFiles.WithLabelValues(status.Scanner, status.Instance, "enqueued").Add(float64(summary.Enqueued))
+	utils.ScanFiles.WithLabelValues(status.Scanner, status.Instance, "filtered").Add(float64(summary.Filtered))
+	utils.ScanLastRun.WithLabelValues(status.Scanner, status.Instance).Set(float64(finished.Unix()))
+	utils.ScanLastSuccess.WithLabelValues(status.Scanner, status.Instance).Set(success)
+	return err
+}
+
+// holdLock refreshes the scan lock until done is closed
+func (r *ScanRunner) holdLock(done <-chan struct{}) {
+	ticker := time.NewTicker(scanLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := r.lock.Refresh(r.scanner, r.instance); err != nil {
+				log.Warn().Err(err).Str("scanner", r.scanner).Str("instance", r.instance).Msg("Failed to refresh scan lock")
+			}
+		}
+	}
+}
+
+// ScanRunners are the scanners of every configured Sonarr and Radarr instance
+type ScanRunners []*ScanRunner
+
+// Find looks up the scanner of an instance. An empty instance is the default one
+func (s ScanRunners) Find(scanner string, instance string) *ScanRunner {
+	if instance == "" {
+		instance = config.DefaultInstance
+	}
+	for _, runner := range s {
+		if runner.scanner == scanner && runner.instance == instance {
+			return runner
+		}
+	}
+	return nil
+}
+
+// Statuses returns the state of every scanner
+func (s ScanRunners) Statuses() []ScanStatus {
+	statuses := make([]ScanStatus, 0, len(s))
+	for _, runner := range s {
+		statuses = append(statuses, runner.Status())
+	}
+	return statuses
+}
+
+// GetScanRunners creates a scanner for each configured Radarr and Sonarr instance, whether or not it is scheduled, so
+// any of them can be run on demand
+func GetScanRunners() ScanRunners {
+	cfg := config.GetConfig()
+	fingerprints := GetFingerprintStore()
+	lock := GetScanLock()
+	rules := filter.GetRules()
+	runners := make(ScanRunners, 0, len(cfg.RadarrInstances)+len(cfg.SonarrInstances))
+	for _, instance := range cfg.RadarrInstances {
+		scanner := NewInstanceMovieScanner(instance, web.NewRadarrClient(instance), Enqueuer, transcode.GetAnalyzer(), fingerprints, rules.Radarr)
+		runners = append(runners, NewMovieScanRunner(instance, scanner).WithLock(lock))
+	}
+	for _, instance := range cfg.SonarrInstances {
+		runner := NewTVScanRunner(instance, web.NewSonarrClient(instance), Enqueuer, transcode.GetAnalyzer(), rules.Sonarr, fingerprints)
+		runners = append(runners, runner.WithLock(lock))
+	}
+	return runners
+}
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"errors"
+	"media-web/internal/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMovieScanner struct {
+	searches  int
+	scans     int
+	searchErr error
+	scanErr   error
+}
+
+func (f *fakeMovieScanner) ScanForMovies(full bool) (ScanSummary, error) {
+	f.scans++
+	return ScanSummary{Checked: 2, Enqueued: 1}, f.scanErr
+}
+
+func (f *fakeMovieScanner) SearchForMissingMovies() error {
+	f.searches++
+	return f.searchErr
+}
+
+func TestScanRunnerRecordsLastRun(t *testing.T) {
+	instance := config.Instance{Name: config.DefaultInstance, EnableScanner: true, ScannerSchedule: "0 0 * * *"}
+	runner := NewScanRunner(RadarrScanner, instance, "0 0 * * *", func(full bool) (ScanSummary, error) {
+		return ScanSummary{Checked: 3, Skipped: 10, Enqueued: 1}, nil
+	})
+	runner.now = func() time.Time { return time.Unix(100, 0) }
+
+	assert.NoError(t, runner.Run(true))
+
+	status := runner.Status()
+	assert.True(t, status.Full)
+	assert.Equal(t, ScanSummary{Checked: 3, Skipped: 10, Enqueued: 1}, status.ScanSummary)
+	assert.False(t, status.Running)
+	assert.Equal(t, int64(100), status.StartedAt)
+	assert.Equal(t, int64(100), status.FinishedAt)
+	assert.Equal(t, "success", status.Result)
+	assert.Equal(t, "0 0 * * *", status.Schedule)
+
+	runner.scan = func(full bool) (ScanSummary, error) { return ScanSummary{}, errors.New("radarr is down") }
+	assert.Error(t, runner.Run(false))
+
+	status = runner.Status()
+	assert.False(t, status.Full)
+	assert.Equal(t, "error", status.Result)
+	assert.Equal(t, "radarr is down", status.Error)
+}
+
+func TestScanRunnerDoesNotOverlap(t *testing.T) {
+	release := make(chan struct{})
+	scans := 0
+	runner := NewScanRunner(SonarrScanner, config.Instance{Name: config.DefaultInstance}, "", func(full bool) (ScanSummary, error) {
+		scans++
+		<-release
+		return ScanSummary{}, nil
+	})
+
+	assert.NoError(t, runner.Start(false))
+	assert.True(t, runner.Status().Running)
+	assert.Equal(t, ErrScanRunning, runner.Start(true))
+	assert.Equal(t, ErrScanRunning, runner.Run(false))
+
+	close(release)
+	assert.Eventually(t, func() bool { return !runner.Status().Running }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, scans)
+}
+
+func TestScanRunnerDoesNotOverlapOtherProcesses(t *testing.T) {
+	pool, _ := testRedis(t)
+	release := make(chan struct{})
+	scans := 0
+	scan := func(full bool) (ScanSummary, error) {
+		scans++
+		<-release
+		return ScanSummary{}, nil
+	}
+	instance := config.Instance{Name: config.DefaultInstance}
+	first := NewScanRunner(SonarrScanner, instance, "", scan).WithLock(NewScanLock(testNamespace, pool))
+	second := NewScanRunner(SonarrScanner, instance, "", scan).WithLock(NewScanLock(testNamespace, pool))
+
+	assert.NoError(t, first.Start(false))
+	assert.Equal(t, ErrScanRunning, second.Run(false))
+	assert.False(t, second.Status().Running)
+
+	close(release)
+	assert.Eventually(t, func() bool { return !first.Status().Running }, time.Second, time.Millisecond)
+	assert.NoError(t, second.Run(false))
+	assert.Equal(t, 2, scans)
+}
+
+func TestMovieScanRunnerSearchesMissingMovies(t *testing.T) {
+	scanner := &fakeMovieScanner{searchErr: errors.New("search failed")}
+	instance := config.Instance{Name: config.DefaultInstance, SearchMissing: true}
+
+	err := NewMovieScanRunner(instance, scanner).Run(false)
+
+	assert.EqualError(t, err, "search failed")
+	assert.Equal(t, 1, scanner.searches)
+	assert.Equal(t, 1, scanner.scans)
+}
+
+func TestMovieScanRunnerSkipsSearchWhenDisabled(t *testing.T) {
+	scanner := &fakeMovieScanner{}
+	instance := config.Instance{Name: "uhd"}
+
+	runner := NewMovieScanRunner(instance, scanner)
+
+	assert.NoError(t, runner.Run(false))
+	assert.Equal(t, 0, scanner.searches)
+	assert.Equal(t, 1, scanner.scans)
+	assert.Empty(t, runner.Status().Schedule)
+}
+
+func TestScanRunnersFind(t *testing.T) {
+	radarr := NewScanRunner(RadarrScanner, config.Instance{Name: config.DefaultInstance}, "", nil)
+	uhd := NewScanRunner(RadarrScanner, config.Instance{Name: "uhd"}, "", nil)
+	sonarr := NewScanRunner(SonarrScanner, config.Instance{Name: config.DefaultInstance}, "", nil)
+	runners := ScanRunners{radarr, uhd, sonarr}
+
+	assert.Same(t, radarr, runners.Find(RadarrScanner, ""))
+	assert.Same(t, uhd, runners.Find(RadarrScanner, "uhd"))
+	assert.Same(t, sonarr, runners.Find(SonarrScanner, config.DefaultInstance))
+	assert.Nil(t, runners.Find(SonarrScanner, "uhd"))
+	assert.Len(t, runners.Statuses(), 3)
+}
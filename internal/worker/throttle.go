@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"fmt"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/timewindow"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/rs/zerolog/log"
+)
+
+// throttleRetryDelay is how long an encode waits before checking the load again
+var throttleRetryDelay = 2 * time.Minute
+
+// Throttle decides when encodes may start. The zero value lets them start at any time
+type Throttle struct {
+	Windows timewindow.Windows
+	// Location is the time zone of the windows, the local one when nil
+	Location *time.Location
+	// MaxLoad is the one minute load average above which encodes wait. 0 ignores the load
+	MaxLoad     float64
+	LoadAverage func() (float64, error)
+}
+
+// ThrottleFromConfig returns the throttle for the configured windows and load limit
+func ThrottleFromConfig(cfg config.Config) (Throttle, error) {
+	throttle := Throttle{Windows: cfg.TranscodeWindows, MaxLoad: cfg.TranscodeMaxLoad, LoadAverage: loadAverage}
+	if cfg.TranscodeTimezone != "" {
+		location, err := time.LoadLocation(cfg.TranscodeTimezone)
+		if err != nil {
+			return throttle, err
+		}
+		throttle.Location = location
+	}
+	return throttle, nil
+}
+
+func loadConfiguredThrottle() Throttle {
+	throttle, err := ThrottleFromConfig(config.GetConfig())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TRANSCODE_TIMEZONE")
+	}
+	return throttle
+}
+
+// Delay returns how long an encode should wait before starting and why, or 0 when it may start now
+func (t Throttle) Delay(now time.Time) (time.Duration, string) {
+	if t.Location != nil {
+		now = now.In(t.Location)
+	}
+	if !t.Windows.Contains(now) {
+		next := t.Windows.Next(now)
+		return next.Sub(now), "outside the transcode windows until " + next.Format(time.RFC3339)
+	}
+	if t.MaxLoad <= 0 || t.LoadAverage == nil {
+		return 0, ""
+	}
+	load, err := t.LoadAverage()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read the load average")
+		return 0, ""
+	}
+	if load > t.MaxLoad {
+		return throttleRetryDelay, fmt.Sprintf("load average %.2f is above %.2f", load, t.MaxLoad)
+	}
+	return 0, ""
+}
+
+// isEncodeJob reports whether job runs ffmpeg encodes
+func isEncodeJob(job *work.Job) bool {
+	return IsTranscodeJob(job) || job.Name == constants.TranscodeChunkJobName
+}
+
+// ThrottleEncodes is a middleware that holds encodes outside the transcode windows or while the system is busy. The
+// job keeps its place as a running job, so it can still be cancelled and its ID, fails and history stay the same.
+// Held jobs count towards the job's concurrency limit, which keeps the rest queued. Encodes that are already
+// running aren't stopped
+func (c *WorkerContext) ThrottleEncodes(job *work.Job, next work.NextMiddlewareFunc) error {
+	if !isEncodeJob(job) {
+		return next()
+	}
+	delay, reason := c.Throttle.Delay(time.Now())
+	if delay <= 0 {
+		return next()
+	}
+
+	ctx, cancel := c.jobContext(job)
+	defer cancel()
+	for delay > 0 {
+		log.Info().Str("jobId", job.ID).Str("reason", reason).Msgf("Holding job for %s", delay.Round(time.Second))
+		job.Checkin("Waiting: " + reason)
+		select {
+		case <-ctx.Done():
+			return c.stoppedError(job)
+		case <-time.After(delay):
+		}
+		delay, reason = c.Throttle.Delay(time.Now())
+	}
+	return next()
+}
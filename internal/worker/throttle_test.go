@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"media-web/internal/constants"
+	"media-web/internal/timewindow"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleWaitsForWindow(t *testing.T) {
+	windows, _ := timewindow.Parse("Mon-Fri 01:00-08:00")
+	throttle := Throttle{Windows: windows, Location: time.UTC}
+
+	delay, reason := throttle.Delay(time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC))
+	assert.Equal(t, 5*time.Hour, delay)
+	assert.Contains(t, reason, "2024-01-02T01:00:00Z")
+
+	delay, _ = throttle.Delay(time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Duration(0), delay)
+}
+
+func TestThrottleWindowsUseLocation(t *testing.T) {
+	windows, _ := timewindow.Parse("01:00-08:00")
+	throttle := Throttle{Windows: windows, Location: time.FixedZone("UTC+2", 2*60*60)}
+
+	delay, _ := throttle.Delay(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, time.Duration(0), delay)
+}
+
+func TestThrottleWaitsForLoad(t *testing.T) {
+	load := 6.5
+	throttle := Throttle{MaxLoad: 4, LoadAverage: func() (float64, error) { return load, nil }}
+
+	delay, reason := throttle.Delay(time.Now())
+	assert.Equal(t, throttleRetryDelay, delay)
+	assert.Equal(t, "load average 6.50 is above 4.00", reason)
+
+	load = 2
+	delay, _ = throttle.Delay(time.Now())
+	assert.Equal(t, time.Duration(0), delay)
+}
+
+func TestThrottleIgnoresUnreadableLoad(t *testing.T) {
+	throttle := Throttle{MaxLoad: 4, LoadAverage: func() (float64, error) { return 0, errors.New("no /proc") }}
+
+	delay, _ := throttle.Delay(time.Now())
+
+	assert.Equal(t, time.Duration(0), delay)
+}
+
+func TestThrottleEncodesHoldsJob(t *testing.T) {
+	defer func(delay time.Duration) { throttleRetryDelay = delay }(throttleRetryDelay)
+	throttleRetryDelay = time.Millisecond
+	loads := []float64{3, 3, 0.5}
+	context := WorkerContext{Throttle: Throttle{MaxLoad: 1, LoadAverage: func() (float64, error) {
+		load := loads[0]
+		loads = loads[1:]
+		return load, nil
+	}}}
+	ran := false
+
+	err := context.ThrottleEncodes(&work.Job{ID: "job", Name: constants.TranscodeJobType}, func() error {
+		ran = true
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.Empty(t, loads)
+}
+
+func TestThrottleEncodesStopsHoldingOnInterrupt(t *testing.T) {
+	interrupt, cancel := context.WithCancel(context.Background())
+	cancel()
+	worker := WorkerContext{Interrupt: interrupt, Throttle: Throttle{MaxLoad: 1, LoadAverage: func() (float64, error) { return 3, nil }}}
+	ran := false
+
+	err := worker.ThrottleEncodes(&work.Job{ID: "job", Name: constants.TranscodeChunkJobName}, func() error {
+		ran = true
+		return nil
+	})
+
+	assert.Equal(t, ErrJobInterrupted, err)
+	assert.False(t, ran)
+}
+
+func TestThrottleEncodesRunsOtherJobs(t *testing.T) {
+	context := WorkerContext{Throttle: Throttle{MaxLoad: 1, LoadAverage: func() (float64, error) { return 3, nil }}}
+	ran := false
+
+	err := context.ThrottleEncodes(&work.Job{ID: "job", Name: constants.UpdateRadarrJobName}, func() error {
+		ran = true
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, ran)
+}
@@ -4,50 +4,139 @@ import (
 	"fmt"
 	"media-web/internal/config"
 	"media-web/internal/constants"
+	"media-web/internal/recycle"
+	"media-web/internal/transcode"
 	"media-web/internal/utils"
+	"media-web/internal/web"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/pkg/errors"
-
 	"github.com/gocraft/work"
 	"github.com/rs/zerolog/log"
+)
+
+func (c *WorkerContext) TranscodeTVShow() {
 
+}
 
-	"github.com/floostack/transcoder"
-	"github.com/floostack/transcoder/ffmpeg"
-)
+// transcodeMaxFails is how often a transcode is attempted before it is given up
+const transcodeMaxFails = 3
 
-func GetTranscoder() transcoder.Transcoder {
-	ffmpegConf := &ffmpeg.Config{
-		FfmpegBinPath:   config.GetConfig().FfmpegPath,
-		FfprobeBinPath:  config.GetConfig().FfprobePath,
-		ProgressEnabled: true,
+// defaultProfileName is the default profile of the job's Sonarr or Radarr instance
+func defaultProfileName(transcodeType constants.TranscodeType, instance string) string {
+	cfg := config.GetConfig()
+	if transcodeType == constants.TV {
+		if found, ok := cfg.SonarrInstance(instance); ok {
+			return found.DefaultProfile
+		}
+		return cfg.DefaultTVProfile
+	}
+	if transcodeType == constants.Music {
+		return cfg.DefaultMusicProfile
 	}
-	return ffmpeg.New(ffmpegConf)
+	if found, ok := cfg.RadarrInstance(instance); ok && transcodeType == constants.Movie {
+		return found.DefaultProfile
+	}
+	return cfg.DefaultMovieProfile
 }
 
-func (c *WorkerContext) TranscodeTVShow() {
+// scratchPath is where the encode is written before it is verified. The .partial extension keeps Sonarr and
+// Radarr from importing it if the worker dies mid encode
+func scratchPath(job *work.Job, newPath string) string {
+	return filepath.Join(scratchDir(newPath), filepath.Base(newPath)+"."+job.ID+".partial")
+}
+
+// scratchDir is the configured scratch directory, or the directory of the output when none is set
+func scratchDir(newPath string) string {
+	dir := config.GetConfig().ScratchDir
+	if dir == "" {
+		dir = filepath.Dir(newPath)
+	}
+	return dir
+}
+
+func removePartial(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Msg("Failed to remove partial output: " + path)
+	}
+}
+
+// replaceOriginal moves the verified output into place and then recycles or deletes the original. When the
+// container is unchanged the output takes the original's path, so the original is recycled first
+func (c *WorkerContext) replaceOriginal(inputFilePath string, outputPath string, newPath string, entry recycle.Entry) error {
+	if c.RecycleBin == nil {
+		// Moving into place replaces the original when the container is unchanged
+		err := utils.MoveFile(outputPath, newPath)
+		if err != nil {
+			log.Error().Err(err).Msg("Error moving transcoded file into place")
+			return err
+		}
+		if newPath != inputFilePath {
+			log.Info().Msg("Deleting old file")
+
+			if !constants.IsLocal {
+				err = os.Remove(inputFilePath)
+			}
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error removing old file")
+		}
+		return nil
+	}
+
+	recycled, err := c.RecycleBin.Recycle(inputFilePath, entry)
+	if err != nil {
+		log.Error().Err(err).Msg("Error recycling old file")
+		return err
+	}
+	err = utils.MoveFile(outputPath, newPath)
+	if err != nil {
+		log.Error().Err(err).Msg("Error moving transcoded file into place. Restoring old file")
+		if _, restoreErr := c.RecycleBin.Restore(recycled.ID); restoreErr != nil {
+			log.Error().Err(restoreErr).Msg("Error restoring old file: " + inputFilePath)
+		}
+		return err
+	}
+	return nil
+}
 
+// profileForJob resolves the profile requested in the job args, falling back to the default for the transcode type
+func profileForJob(job *work.Job, transcodeType constants.TranscodeType) (transcode.Profile, error) {
+	name := defaultProfileName(transcodeType, JobInstance(job))
+	if _, ok := job.Args[constants.ProfileKey]; ok {
+		name = job.ArgString(constants.ProfileKey)
+	}
+	return transcode.GetProfiles().Get(name)
 }
 
 func (c *WorkerContext) TranscodeJobHandler(job *work.Job) error {
-	// Create new instance of GetTranscoder
-	trans := c.GetTranscoder()
 	transcodeType := constants.TranscodeType(job.ArgString(constants.TranscodeTypeKey))
 
 	var inputFilePath string
 	var id int64
 	var err error
 	var seriesId int
+	var artistId int
+	var sonarr web.SonarrClient
+	var radarr web.RadarrClient
 	switch transcodeType {
 	case constants.TV:
 		id = job.ArgInt64(constants.EpisodeFileIdKey)
-		inputFilePath, seriesId, err = c.SonarrClient.GetEpisodeFilePath(id)
+		if sonarr, err = c.sonarrClient(job); err == nil {
+			inputFilePath, seriesId, err = sonarr.GetEpisodeFilePath(id)
+		}
 	case constants.Movie:
 		id = job.ArgInt64(constants.MovieIdKey)
-		inputFilePath, err = c.RadarrClient.GetMovieFilePath(id)
+		if radarr, err = c.radarrClient(job); err == nil {
+			inputFilePath, err = radarr.GetMovieFilePath(id)
+		}
+	case constants.Music:
+		id = job.ArgInt64(constants.TrackFileIdKey)
+		inputFilePath, artistId, err = c.LidarrClient.GetTrackFilePath(id)
+	case constants.File:
+		inputFilePath = job.ArgString(constants.FilePathKey)
 	default:
 		log.Warn().Msg("Unknown transcodeType: " + string(transcodeType))
 		return nil
@@ -72,95 +161,197 @@ func (c *WorkerContext) TranscodeJobHandler(job *work.Job) error {
 		log.Warn().Msg("Could not find file at path: " + inputFilePath)
 		return nil
 	}
-	// Initialize GetTranscoder passing the input file path and output file path
-	ext := filepath.Ext(inputFilePath)
+	profile, err := profileForJob(job, transcodeType)
+	if err != nil {
+		log.Error().Err(err).Msg("Error resolving transcode profile")
+		return err
+	}
 
-	if ext == ".mp4" {
-		log.Debug().Msg("File is already mp4 extension. Skipping...")
-		return nil
-	} else {
-		log.Debug().Msg("Current extension: " + ext)
+	decision, info, err := c.Analyzer.Analyze(inputFilePath, profile)
+	if err != nil {
+		log.Error().Err(err).Msg("Error analyzing input file")
+		return err
 	}
 
 	fileName := filepath.Base(inputFilePath)
 	baseDir := filepath.Dir(inputFilePath)
-	newPath := baseDir + "/" + strings.Replace(fileName, ext, ".mp4", 1)
-	log.Debug().Msg("Transcoding to path: " + newPath)
+	// Files from a watch folder can be written to a separate destination
+	destination := job.ArgString(constants.DestinationKey)
+	if destination != "" {
+		baseDir = destination
+		if err = os.MkdirAll(destination, 0755); err != nil {
+			log.Error().Err(err).Msg("Error creating destination: " + destination)
+			return err
+		}
+	}
+
+	if decision == transcode.Skip {
+		log.Debug().Msg("File already matches profile " + profile.Name + ". Skipping...")
+		if destination != "" {
+			return c.moveToDestination(job, inputFilePath, filepath.Join(destination, fileName))
+		}
+		return nil
+	}
 
-	trans = trans.Input(inputFilePath).Output(newPath)
+	ext := filepath.Ext(inputFilePath)
+	newPath := filepath.Join(baseDir, strings.TrimSuffix(fileName, ext)+profile.Extension())
+	outputPath := scratchPath(job, newPath)
+	log.Debug().Msg("Transcoding to path: " + outputPath)
 
-	log.Info().Msg("Transcoding: " + inputFilePath)
+	ctx, cancel := c.jobContext(job)
+	defer cancel()
 
-	preset := "veryfast"
-	format := "mp4"
-	codec := "libx264"
-	tune := "film"
-	var crf uint32 = 23
-	opts := ffmpeg.Options{
-		Preset:       &preset,
-		OutputFormat: &format,
-		VideoCodec:   &codec,
-		Tune:         &tune,
-		Crf:          &crf,
+	// The sidecars only belong next to a transcoded file, so they are removed again unless the transcode succeeds
+	succeeded := false
+	if profile.Streams.ExtractImageSubtitles {
+		sidecars := transcode.ImageSubtitleSidecars(info, strings.TrimSuffix(newPath, profile.Extension()))
+		defer func() {
+			if succeeded {
+				return
+			}
+			for _, sidecar := range sidecars {
+				removePartial(sidecar.Path)
+			}
+		}()
+		err = transcode.ExtractImageSubtitles(ctx, config.GetConfig().FfmpegPath, transcode.GetPriority(), inputFilePath, sidecars)
+		if err != nil {
+			if ctx.Err() != nil {
+				return c.stoppedError(job)
+			}
+			log.Error().Err(err).Msg("Error extracting image subtitles")
+			return err
+		}
 	}
 
-	// Start transcoder process with progress checking
-	progress, err := trans.Start(opts)
+	log.Info().Str("profile", profile.Name).Str("decision", string(decision)).Msg("Transcoding: " + inputFilePath)
+
+	// Compatible streams are copied rather than re-encoded
+	opts := transcode.Options{Profile: profile, Decision: decision, Info: info}
+
+	start := 0
+	reportProgress := func(progress transcode.Progress) {
+		if int(progress.Percent) >= (20 + start) {
+			log.Debug().Float64("progress", progress.Percent).Msg("Transcoding: " + inputFilePath)
+			start = int(progress.Percent)
+		}
+		job.Checkin(fmt.Sprintf("Transcoding: %s (%.1f%% at %s)", inputFilePath, progress.Percent, progress.Speed))
+	}
 
-	// Returns a channel to get the transcoding progress
+	// Long sources can be split into chunks encoded by separate jobs
+	var plan *ChunkPlan
+	var planKey string
+	if c.canChunk(opts) {
+		planKey, plan, err = c.chunkPlan(inputFilePath, newPath, opts)
+		if err != nil {
+			log.Error().Err(err).Msg("Error planning chunks")
+			return err
+		}
+	}
+	if plan != nil {
+		err = c.encodeChunks(ctx, job, planKey, plan, outputPath, opts, reportProgress)
+	} else {
+		err = c.Encoder.Encode(ctx, inputFilePath, outputPath, opts, reportProgress)
+	}
 	if err != nil {
+		removePartial(outputPath)
+		if ctx.Err() != nil {
+			return c.stoppedError(job)
+		}
+		log.Error().Err(err).Msg("Transcode failed. Keeping old file")
 		return err
 	}
 
-	//now := time.Now()
-	start := 0
-	var prog float64 = 0
-	// Example of printing transcoding progress
-	for msg := range progress {
-		message := "Transcoding: " + inputFilePath + " -> " + fmt.Sprint(msg)
-		if int(msg.GetProgress()) >= (20 + start) {
-			log.Debug().Float64("progress", msg.GetProgress()).Msg("Transcoding: " + inputFilePath)
-			start = int(msg.GetProgress())
+	job.Checkin("Verifying: " + outputPath)
+	err = c.Verifier.Verify(ctx, info, outputPath, opts.ExpectedStreams())
+	if err != nil {
+		if ctx.Err() != nil {
+			removePartial(outputPath)
+			return c.stoppedError(job)
 		}
-		job.Checkin(message)
-		prog = msg.GetProgress()
+		log.Error().Err(err).Msg("Transcoded file failed verification. Keeping old file")
+		removePartial(outputPath)
+		return err
 	}
 
-	if prog != 100 {
-		return errors.New("Failed to get 100% progress on conversion. Keeping old file")
+	entry := recycle.Entry{ReplacementPath: newPath, TranscodeType: transcodeType, Instance: job.ArgString(constants.InstanceKey)}
+	if transcodeType == constants.Movie {
+		entry.MovieID = id
+	} else if transcodeType == constants.TV {
+		entry.SeriesID = int64(seriesId)
+	} else if transcodeType == constants.Music {
+		entry.ArtistID = int64(artistId)
 	}
+	err = c.replaceOriginal(inputFilePath, outputPath, newPath, entry)
+	if err != nil {
+		removePartial(outputPath)
+		return err
+	}
+	succeeded = true
 
-	log.Info().Msg("Deleting old file")
-
-	if !constants.IsLocal {
-		err = os.Remove(inputFilePath)
+	log.Info().Msg("Done transcoding: " + newPath)
+	if c.WatchTracker != nil && job.ArgBool(constants.WatchedKey) {
+		// The output may land in a watched folder and shouldn't be picked up again
+		trackWatched(c.WatchTracker, newPath, WatchCompleted)
 	}
 
+	remotePath := ""
+	if transcodeType == constants.Movie {
+		remotePath = radarr.PathMapper().ToRemote(newPath)
+	}
+	err = EnqueueRescan(c.Enqueuer, entry, remotePath)
 	if err != nil {
-		log.Error().Err(err).Msg("Error deleting old file")
+		// The transcode itself succeeded so don't retry it
+		log.Error().Err(err).Msg("Failed to enqueue update job")
 	}
+	return nil
+}
 
-	log.Info().Msg("Done transcoding: " + newPath)
+// moveToDestination moves a file that needs no transcode to the destination of its watch folder
+func (c *WorkerContext) moveToDestination(job *work.Job, inputFilePath string, newPath string) error {
+	if err := utils.MoveFile(inputFilePath, newPath); err != nil {
+		log.Error().Err(err).Msg("Error moving file to destination")
+		return err
+	}
+	if c.WatchTracker != nil && job.ArgBool(constants.WatchedKey) {
+		trackWatched(c.WatchTracker, newPath, WatchCompleted)
+	}
+	log.Info().Msg("Moved to destination: " + newPath)
+	return nil
+}
 
-	if transcodeType == constants.TV {
-		updateJob, err := c.Enqueuer.EnqueueUnique("update-sonarr", work.Q{
-			constants.SeriesIdKey: seriesId,
-		})
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to enqueue update job")
-		} else {
-			log.Debug().Msg("Created job: " + updateJob.ID)
-		}
+// IsTranscodeJob reports whether job is a regular or priority transcode
+func IsTranscodeJob(job *work.Job) bool {
+	return job.Name == constants.TranscodeJobType || job.Name == constants.PriorityTranscodeJobType
+}
 
-	} else if transcodeType == constants.Movie {
-		updateJob, err := c.Enqueuer.EnqueueUnique("update-radarr", work.Q{
-			constants.MovieIdKey: id,
-		})
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to enqueue update job")
-		} else {
-			log.Debug().Msg("Created job: " + updateJob.ID)
+// EnqueueRescan queues a job asking the Sonarr, Radarr or Lidarr server of a replaced file to pick up the change.
+// remotePath is the new file as Radarr will see it and is checked once the rescan completes. Sonarr and Lidarr
+// rescans are per series or artist so file paths aren't passed along, which would stop rescans for the same series
+// or artist from being deduplicated
+func EnqueueRescan(scheduler WorkScheduler, entry recycle.Entry, remotePath string) error {
+	var updateJob *work.Job
+	var err error
+	switch entry.TranscodeType {
+	case constants.TV:
+		updateJob, err = scheduler.EnqueueUnique(constants.UpdateSonarrJobName, SetInstance(work.Q{
+			constants.SeriesIdKey: entry.SeriesID,
+		}, entry.Instance))
+	case constants.Movie:
+		args := SetInstance(work.Q{constants.MovieIdKey: entry.MovieID}, entry.Instance)
+		if remotePath != "" {
+			args[constants.FilePathKey] = remotePath
 		}
+		updateJob, err = scheduler.EnqueueUnique(constants.UpdateRadarrJobName, args)
+	case constants.Music:
+		updateJob, err = scheduler.EnqueueUnique(constants.UpdateLidarrJobName, work.Q{
+			constants.ArtistIdKey: entry.ArtistID,
+		})
+	default:
+		return nil
+	}
+	// A nil job means an identical update is already queued
+	if err == nil && updateJob != nil {
+		log.Debug().Msg("Created job: " + updateJob.ID)
 	}
 	return err
 }
@@ -0,0 +1,368 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"media-web/internal/constants"
+	"media-web/internal/pathmap"
+	"media-web/internal/recycle"
+	"media-web/internal/transcode"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockEncoder struct {
+	input   string
+	output  string
+	opts    transcode.Options
+	encoded bool
+	encode  func(ctx context.Context, progress func(transcode.Progress)) error
+}
+
+func (m *mockEncoder) Encode(ctx context.Context, input string, output string, opts transcode.Options, progress func(transcode.Progress)) error {
+	m.input, m.output, m.opts, m.encoded = input, output, opts, true
+	// Stand in for ffmpeg writing the output
+	if err := ioutil.WriteFile(output, []byte("transcoded"), 0644); err != nil {
+		return err
+	}
+	if m.encode != nil {
+		return m.encode(ctx, progress)
+	}
+	progress(transcode.Progress{Percent: 100})
+	return nil
+}
+
+func decidedAnalyzer(decision transcode.Decision) MockAnalyzer {
+	return MockAnalyzer{analyze: func(path string, profile transcode.Profile) (transcode.Decision, *transcode.MediaInfo, error) {
+		return decision, &transcode.MediaInfo{}, nil
+	}}
+}
+
+func movieFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "movie.mkv")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("movie"), 0644))
+	return path
+}
+
+func movieJob() *work.Job {
+	return &work.Job{ID: "job", Args: map[string]interface{}{
+		constants.TranscodeTypeKey: string(constants.Movie),
+		constants.MovieIdKey:       1,
+	}}
+}
+
+func TestTranscodeIgnoresUnknownType(t *testing.T) {
+	context := WorkerContext{Encoder: &mockEncoder{}}
+
+	err := context.TranscodeJobHandler(&work.Job{Args: map[string]interface{}{constants.TranscodeTypeKey: "Other"}})
+
+	assert.NoError(t, err)
+}
+
+func TestTranscodeSkipsMatchingFile(t *testing.T) {
+	path := movieFile(t)
+	trans := &mockEncoder{}
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.Skip),
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.NoError(t, err)
+	assert.False(t, trans.encoded)
+	assert.FileExists(t, path)
+}
+
+func TestTranscodeRemuxesCompatibleFile(t *testing.T) {
+	path := movieFile(t)
+	trans := &mockEncoder{}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.UpdateRadarrJobName, map[string]interface{}{
+		constants.MovieIdKey:  int64(1),
+		constants.FilePathKey: "/movies/movie.mp4",
+	}).Once().Return(&work.Job{ID: "update"}, nil)
+	context := WorkerContext{
+		Encoder:  trans,
+		Analyzer: decidedAnalyzer(transcode.Remux),
+		Verifier: MockVerifier{},
+		RadarrClient: MockRadarr{
+			getMovieFilePath: func(id int64) (string, error) { return path, nil },
+			pathMapper:       pathmap.Mapper{{Remote: "/movies", Local: filepath.Dir(path)}},
+		},
+		Enqueuer: &w,
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.NoError(t, err)
+	assert.Equal(t, path, trans.input)
+	assert.Equal(t, filepath.Join(filepath.Dir(path), "movie.mp4.job.partial"), trans.output)
+	assert.Equal(t, transcode.Remux, trans.opts.Decision)
+	assert.NoFileExists(t, path)
+	assert.NoFileExists(t, trans.output)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "movie.mp4"))
+	w.AssertExpectations(t)
+}
+
+func TestTranscodeKeepsOriginalWhenVerificationFails(t *testing.T) {
+	path := movieFile(t)
+	trans := &mockEncoder{}
+	context := WorkerContext{
+		Encoder:  trans,
+		Analyzer: decidedAnalyzer(transcode.FullTranscode),
+		Verifier: MockVerifier{verify: func(ctx context.Context, source *transcode.MediaInfo, output string, expected *transcode.StreamCounts) error {
+			return errors.New("duration mismatch")
+		}},
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.Error(t, err)
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, trans.output)
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(path), "movie.mp4"))
+}
+
+func TestTranscodeRecyclesOriginal(t *testing.T) {
+	path := movieFile(t)
+	trans := &mockEncoder{}
+	bin := recycle.NewBin(t.TempDir(), time.Hour)
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.UpdateRadarrJobName, mock.Anything).Once().Return(nil, nil)
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.FullTranscode),
+		Verifier:     MockVerifier{},
+		RecycleBin:   bin,
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+		Enqueuer:     &w,
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, path)
+	entries, err := bin.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, path, entries[0].OriginalPath)
+	assert.EqualValues(t, 1, entries[0].MovieID)
+	w.AssertExpectations(t)
+}
+
+func TestTranscodeFileSkipsRescan(t *testing.T) {
+	path := movieFile(t)
+	trans := &mockEncoder{}
+	w := mockWorker{}
+	context := WorkerContext{
+		Encoder:  trans,
+		Analyzer: decidedAnalyzer(transcode.FullTranscode),
+		Verifier: MockVerifier{},
+		Enqueuer: &w,
+	}
+
+	err := context.TranscodeJobHandler(&work.Job{ID: "job", Args: map[string]interface{}{
+		constants.TranscodeTypeKey: constants.File,
+		constants.FilePathKey:      path,
+	}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, path, trans.input)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "movie.mp4"))
+	w.AssertNotCalled(t, "EnqueueUnique", mock.Anything, mock.Anything)
+}
+
+func TestTranscodeMusicRescansArtist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01 - Track.flac")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("track"), 0644))
+	trans := &mockEncoder{}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.UpdateLidarrJobName, map[string]interface{}{
+		constants.ArtistIdKey: int64(4),
+	}).Once().Return(&work.Job{ID: "update"}, nil)
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.AudioTranscode),
+		Verifier:     MockVerifier{},
+		LidarrClient: MockLidarr{getTrackFilePath: func(id int64) (string, int, error) { return path, 4, nil }},
+		Enqueuer:     &w,
+	}
+
+	err := context.TranscodeJobHandler(&work.Job{ID: "job", Args: map[string]interface{}{
+		constants.TranscodeTypeKey: string(constants.Music),
+		constants.TrackFileIdKey:   88,
+		constants.ArtistIdKey:      4,
+	}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, transcode.MusicProfileName, trans.opts.Profile.Name)
+	assert.NoFileExists(t, path)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "01 - Track.opus"))
+	w.AssertExpectations(t)
+}
+
+func TestTranscodeWritesWatchedFileToDestination(t *testing.T) {
+	path := movieFile(t)
+	destination := filepath.Join(t.TempDir(), "done")
+	trans := &mockEncoder{}
+	tracker := memoryWatchTracker{}
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.FullTranscode),
+		Verifier:     MockVerifier{},
+		WatchTracker: tracker,
+		Enqueuer:     &mockWorker{},
+	}
+
+	err := context.TranscodeJobHandler(&work.Job{ID: "job", Args: map[string]interface{}{
+		constants.TranscodeTypeKey: constants.File,
+		constants.FilePathKey:      path,
+		constants.DestinationKey:   destination,
+		constants.WatchedKey:       true,
+	}})
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, path)
+	output := filepath.Join(destination, "movie.mp4")
+	assert.FileExists(t, output)
+	assert.Equal(t, WatchCompleted, tracker[output].Status)
+}
+
+func TestTranscodeMovesMatchingWatchedFileToDestination(t *testing.T) {
+	path := movieFile(t)
+	destination := t.TempDir()
+	context := WorkerContext{
+		Encoder:  &mockEncoder{},
+		Analyzer: decidedAnalyzer(transcode.Skip),
+	}
+
+	err := context.TranscodeJobHandler(&work.Job{ID: "job", Args: map[string]interface{}{
+		constants.TranscodeTypeKey: constants.File,
+		constants.FilePathKey:      path,
+		constants.DestinationKey:   destination,
+	}})
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, path)
+	assert.FileExists(t, filepath.Join(destination, "movie.mkv"))
+}
+
+func TestTranscodeCancelledJobRemovesPartialOutput(t *testing.T) {
+	defer func(interval time.Duration) { cancelPollInterval = interval }(cancelPollInterval)
+	cancelPollInterval = time.Millisecond
+	path := movieFile(t)
+	canceller := &memoryCanceller{}
+	trans := &mockEncoder{encode: func(ctx context.Context, progress func(transcode.Progress)) error {
+		_ = canceller.RequestCancel("job")
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.FullTranscode),
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+		Canceller:    canceller,
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.Equal(t, ErrJobCancelled, err)
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, trans.output)
+}
+
+func TestTranscodeCancelledDuringVerification(t *testing.T) {
+	defer func(interval time.Duration) { cancelPollInterval = interval }(cancelPollInterval)
+	cancelPollInterval = time.Millisecond
+	path := movieFile(t)
+	canceller := &memoryCanceller{}
+	trans := &mockEncoder{}
+	context := WorkerContext{
+		Encoder:  trans,
+		Analyzer: decidedAnalyzer(transcode.FullTranscode),
+		Verifier: MockVerifier{verify: func(ctx context.Context, source *transcode.MediaInfo, output string, expected *transcode.StreamCounts) error {
+			_ = canceller.RequestCancel("job")
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+		Canceller:    canceller,
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.Equal(t, ErrJobCancelled, err)
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, trans.output)
+}
+
+func TestTranscodeInterruptedByShutdown(t *testing.T) {
+	path := movieFile(t)
+	interrupt, cancel := context.WithCancel(context.Background())
+	cancel()
+	trans := &mockEncoder{encode: func(ctx context.Context, progress func(transcode.Progress)) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.FullTranscode),
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+		Interrupt:    interrupt,
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.Equal(t, ErrJobInterrupted, err)
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, trans.output)
+}
+
+func TestTranscodeKeepsOriginalWhenEncodeFails(t *testing.T) {
+	path := movieFile(t)
+	trans := &mockEncoder{encode: func(ctx context.Context, progress func(transcode.Progress)) error {
+		return errors.New("ffmpeg failed")
+	}}
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.FullTranscode),
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.EqualError(t, err, "ffmpeg failed")
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, trans.output)
+}
+
+func TestTranscodeNamesOutputOfExtensionlessSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movie")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("movie"), 0644))
+	trans := &mockEncoder{}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.UpdateRadarrJobName, mock.Anything).Once().Return(nil, nil)
+	context := WorkerContext{
+		Encoder:      trans,
+		Analyzer:     decidedAnalyzer(transcode.FullTranscode),
+		Verifier:     MockVerifier{},
+		RadarrClient: MockRadarr{getMovieFilePath: func(id int64) (string, error) { return path, nil }},
+		Enqueuer:     &w,
+	}
+
+	err := context.TranscodeJobHandler(movieJob())
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(path), "movie.mp4.job.partial"), trans.output)
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "movie.mp4"))
+	assert.NoFileExists(t, path)
+}
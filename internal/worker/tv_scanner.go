@@ -1,42 +1,113 @@
 package worker
 
 import (
+	"media-web/internal/config"
 	"media-web/internal/constants"
+	"media-web/internal/filter"
+	"media-web/internal/transcode"
 	"media-web/internal/web"
-	"path/filepath"
 
 	"github.com/gocraft/work"
 	"github.com/rs/zerolog/log"
 )
 
-func ScanForTVShows(sonarrClient web.SonarrClient, scheduler WorkScheduler) {
+func ScanForTVShows(sonarrClient web.SonarrClient, scheduler WorkScheduler, analyzer transcode.Analyzer) error {
+	instance := config.Instance{Name: config.DefaultInstance, DefaultProfile: config.GetConfig().DefaultTVProfile}
+	_, err := ScanInstanceForTVShows(instance, sonarrClient, scheduler, analyzer, filter.Rules{}, nil, true)
+	return err
+}
+
+// ScanInstanceForTVShows enqueues transcodes for the episodes of a Sonarr instance that don't match its default
+// profile. Episode files the rules exclude are left alone. Failing to list a series' episode files is logged and the
+// scan moves on to the next series.
+// Unless full is set, series whose episode file count and size are unchanged since all their files were last found in
+// the right format aren't fetched, and unchanged episode files of the other series aren't checked again
+func ScanInstanceForTVShows(instance config.Instance, sonarrClient web.SonarrClient, scheduler WorkScheduler, analyzer transcode.Analyzer, rules filter.Rules, fingerprints FingerprintStore, full bool) (ScanSummary, error) {
+	var summary ScanSummary
+
+	profile, err := transcode.GetProfiles().Get(instance.DefaultProfile)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to resolve TV transcode profile")
+		return summary, err
+	}
 
 	series, err := sonarrClient.GetAllSeries()
 
 	if err != nil {
-		return
+		return summary, err
 	}
 
+	previous := loadFingerprints(fingerprints, SonarrScanner, instance.Name, full)
+	current := make(Fingerprints)
+	scope := fingerprintScope(profile.Name, rules)
+	seriesFilter := NewSeriesFilter(rules, instance.Name, sonarrClient)
 	for i := 0; i < len(series); i++ {
+		key, fingerprint := seriesKey(series[i].ID), seriesFingerprint(scope, series[i])
+		if previous[key] == fingerprint {
+			current[key] = fingerprint
+			current.carryOver(previous, key+":")
+			count, _ := series[i].FileStats()
+			summary.Skipped += count
+			continue
+		}
+
 		log.Info().Msg("Scanning: " + series[i].Title)
 		episodeFiles, err := sonarrClient.GetAllEpisodeFiles(series[i].ID)
 		if err != nil {
 			log.Error().Err(err).Msg("Got error for series: " + series[i].Title)
+			current.carryOver(previous, key+":")
+			continue
 		}
+		inFormat := true
 		for j := 0; j < len(episodeFiles); j++ {
 			file := episodeFiles[j]
-			ext := filepath.Ext(file.Path)
-
-			if ext != ".mp4" {
-				log.Info().Msg("Found episode file in wrong format: " + file.Path)
-				_, err := scheduler.EnqueueUnique(constants.TranscodeJobType, work.Q{
-					constants.TranscodeTypeKey: constants.TV,
-					constants.EpisodeFileIdKey: file.ID,
-				})
-				if err != nil {
-					log.Error().Err(err).Msg("Error enqueueing tv transcode")
+			fileKey, fileFingerprint := episodeFileKey(file), episodeFileFingerprint(scope, file)
+			if previous[fileKey] == fileFingerprint {
+				current[fileKey] = fileFingerprint
+				summary.Skipped++
+				continue
+			}
+
+			allowed, err := seriesFilter.Allows(series[i], file.Path, file.DateAdded)
+			if err != nil {
+				return summary, err
+			}
+			if !allowed {
+				summary.Filtered++
+				// A file the rules exclude today may be allowed once it's old enough, so it has to be checked again
+				if rules.DependsOnAge() {
+					inFormat = false
+				} else {
+					current[fileKey] = fileFingerprint
 				}
+				continue
+			}
+
+			summary.Checked++
+			if !needsTranscode(analyzer, sonarrClient.PathMapper().ToLocal(file.Path), profile) {
+				current[fileKey] = fileFingerprint
+				continue
 			}
+			inFormat = false
+			log.Info().Msg("Found episode file in wrong format: " + file.Path)
+			_, err = scheduler.EnqueueUnique(constants.TranscodeJobType, SetInstance(work.Q{
+				constants.TranscodeTypeKey: constants.TV,
+				constants.EpisodeFileIdKey: file.ID,
+				constants.SeriesIdKey:      file.SeriesID,
+			}, instance.Name))
+			if err != nil {
+				log.Error().Err(err).Msg("Error enqueueing tv transcode")
+				continue
+			}
+			summary.Enqueued++
+		}
+		// The series is only skipped next time once every file is in the right format, so files whose transcode
+		// failed are checked again
+		if inFormat {
+			current[key] = fingerprint
 		}
 	}
+
+	saveFingerprints(fingerprints, SonarrScanner, instance.Name, current)
+	return summary, nil
 }
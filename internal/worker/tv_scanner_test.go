@@ -3,6 +3,8 @@ package worker
 import (
 	"errors"
 	"media-web/internal/constants"
+	"media-web/internal/pathmap"
+	"media-web/internal/transcode"
 	"media-web/internal/web"
 	"testing"
 
@@ -33,6 +35,10 @@ func (m MockSonarr) GetAllSeries() ([]web.Series, error) {
 	return m.getAllSeries()
 }
 
+func (m MockSonarr) PathMapper() pathmap.Mapper {
+	return m.pathMapper
+}
+
 func TestErrorFromTVScanner(t *testing.T) {
 
 	mockErr := errors.New("mock Error")
@@ -42,7 +48,8 @@ func TestErrorFromTVScanner(t *testing.T) {
 	}
 	// We'd fail with pointer errors if we called anything on here
 	w := mockWorker{}
-	ScanForTVShows(mockClient, &w)
+	err := ScanForTVShows(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	assert.Equal(t, mockErr, err)
 	w.AssertExpectations(t)
 }
 
@@ -55,8 +62,9 @@ func TestSkipIfUnmatchedExtension(t *testing.T) {
 	})
 	episodes := make([]web.SonarrEpisodeFile, 0)
 	episodes = append(episodes, web.SonarrEpisodeFile{
-		Path: "test.mkv",
-		ID:   2,
+		Path:     "test.mkv",
+		ID:       2,
+		SeriesID: 1,
 	})
 	var inputSeries = -1
 	mockClient := MockSonarr{
@@ -72,8 +80,34 @@ func TestSkipIfUnmatchedExtension(t *testing.T) {
 	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
 		constants.TranscodeTypeKey: constants.TV,
 		constants.EpisodeFileIdKey: 2,
+		constants.SeriesIdKey:      1,
 	}).Once().Return(nil, nil)
-	ScanForTVShows(mockClient, &w)
+	err := ScanForTVShows(mockClient, &w, decidingAnalyzer(transcode.FullTranscode))
+	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, inputSeries)
 	w.AssertExpectations(t)
 }
+
+func TestTVScanFallsBackToExtensionWhenProbeFails(t *testing.T) {
+
+	mockClient := MockSonarr{
+		getAllSeries: func() ([]web.Series, error) {
+			return []web.Series{{Title: "TestTitle", ID: 1}}, nil
+		},
+		getAllEpisodeFiles: func(seriesId int) ([]web.SonarrEpisodeFile, error) {
+			return []web.SonarrEpisodeFile{
+				{Path: "test.mp4", ID: 2, SeriesID: 1},
+				{Path: "test.mkv", ID: 3, SeriesID: 1},
+			}, nil
+		},
+	}
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.TV,
+		constants.EpisodeFileIdKey: 3,
+		constants.SeriesIdKey:      1,
+	}).Once().Return(nil, nil)
+	err := ScanForTVShows(mockClient, &w, unreachableAnalyzer)
+	assert.Equal(t, nil, err)
+	w.AssertExpectations(t)
+}
@@ -2,6 +2,8 @@ package worker
 
 import (
 	"media-web/internal/constants"
+	"media-web/internal/utils"
+	"media-web/internal/web"
 	"strconv"
 	"strings"
 	"time"
@@ -10,11 +12,23 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// rescanDeferSeconds is how long a series rescan waits before checking again for queued transcodes
+const rescanDeferSeconds = 60
+
 func (c *WorkerContext) UpdateTVShow(job *work.Job) error {
 
 	seriesId := job.ArgInt64(constants.SeriesIdKey)
 
-	cmd, err := c.SonarrClient.RescanSeries(seriesId)
+	client, err := c.sonarrClient(job)
+	if err != nil {
+		return err
+	}
+
+	if c.deferRescan(job, constants.UpdateSonarrJobName, constants.TV, constants.SeriesIdKey) {
+		return nil
+	}
+
+	cmd, err := client.RescanSeries(seriesId)
 
 	if err != nil {
 		log.Err(err).Msg("Error rescanning series")
@@ -22,11 +36,12 @@ func (c *WorkerContext) UpdateTVShow(job *work.Job) error {
 	}
 
 	for count := 0; count < 5; count++ {
-		result, err := c.SonarrClient.CheckSonarrCommand(cmd.ID)
+		result, err := client.CheckSonarrCommand(cmd.ID)
 
 		if err == nil {
 			if strings.Contains(result.State, "complete") {
 				log.Info().Msg("Rescan complete for: " + strconv.Itoa(cmd.ID))
+				checkSeriesPaths(client, seriesId)
 				return nil
 			} else {
 				log.Info().Msg("Rescan not complete yet for: " + strconv.Itoa(cmd.ID))
@@ -40,3 +55,62 @@ func (c *WorkerContext) UpdateTVShow(job *work.Job) error {
 
 	return err
 }
+
+// checkSeriesPaths warns when a file Sonarr reports for the series after the rescan isn't on this host. A transcoded
+// episode Sonarr didn't pick up shows as the replaced original, which usually means the path mappings don't match
+// how Sonarr sees the library. Sonarr rescans whole series so there is no single new path to compare against like
+// for Radarr. It returns the files that are missing
+func checkSeriesPaths(client web.SonarrClient, seriesId int64) []string {
+	mapper := client.PathMapper()
+	if len(mapper) == 0 {
+		return nil
+	}
+	files, err := client.GetAllEpisodeFiles(int(seriesId))
+	if err != nil {
+		log.Warn().Err(err).Int64("seriesId", seriesId).Msg("Could not look up episode files after rescan")
+		return nil
+	}
+	missing := make([]string, 0)
+	for _, file := range files {
+		local := mapper.ToLocal(file.Path)
+		if !utils.FileExists(local) {
+			log.Warn().Str("remote", file.Path).Str("local", local).Msg("Sonarr reports a file that isn't on this host. Check SONARR_PATH_MAPPINGS")
+			missing = append(missing, local)
+		}
+	}
+	return missing
+}
+
+// deferRescan reschedules a series or artist rescan while transcodes for it are still queued, running, retrying or
+// scheduled, so a season pack or album is rescanned once after its last file instead of after every one. key is the
+// arg holding the series or artist. Rescans added by the other transcodes in the meantime are deduplicated against
+// the rescheduled job. A rescan picked up before the transcode that queued it has returned waits one round too
+func (c *WorkerContext) deferRescan(job *work.Job, jobName string, transcodeType constants.TranscodeType, key string) bool {
+	if c.JobInspector == nil {
+		return false
+	}
+	id := job.ArgInt64(key)
+	instance := job.ArgString(constants.InstanceKey)
+	pending, err := c.JobInspector.CountPendingJobs(func(other *work.Job) bool {
+		return IsTranscodeJob(other) &&
+			constants.TranscodeType(other.ArgString(constants.TranscodeTypeKey)) == transcodeType &&
+			other.ArgInt64(key) == id &&
+			other.ArgString(constants.InstanceKey) == instance
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to check for pending transcodes, rescanning now")
+		return false
+	}
+	if pending == 0 {
+		return false
+	}
+	_, err = c.Enqueuer.EnqueueUniqueIn(jobName, rescanDeferSeconds, SetInstance(work.Q{
+		key: id,
+	}, instance))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to defer rescan, rescanning now")
+		return false
+	}
+	log.Info().Int64(key, id).Int("pending", pending).Msg("Deferring rescan until pending transcodes finish")
+	return true
+}
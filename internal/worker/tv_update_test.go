@@ -2,8 +2,11 @@ package worker
 
 import (
 	"errors"
+	"io/ioutil"
 	"media-web/internal/constants"
+	"media-web/internal/pathmap"
 	"media-web/internal/web"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -96,3 +99,93 @@ func TestRescanNoErrorCheckCommand(t *testing.T) {
 	assert.True(t, callRescan)
 	assert.NoError(t, err)
 }
+
+type mockJobInspector struct {
+	JobInspector
+	countPendingJobs func(match func(job *work.Job) bool) (int, error)
+}
+
+func (m mockJobInspector) CountPendingJobs(match func(job *work.Job) bool) (int, error) {
+	return m.countPendingJobs(match)
+}
+
+func TestRescanDeferredWhileSeriesTranscodesQueued(t *testing.T) {
+	mockClient := MockSonarr{}
+	mockClient.rescanSeries = func(id int64) (*web.SonarrCommand, error) {
+		t.Error("rescanned while transcodes were queued")
+		return nil, nil
+	}
+	inspector := mockJobInspector{countPendingJobs: func(match func(job *work.Job) bool) (int, error) {
+		assert.True(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.TV), constants.SeriesIdKey: float64(1),
+		}}))
+		assert.False(t, match(&work.Job{Name: constants.TranscodeJobType, Args: map[string]interface{}{
+			constants.TranscodeTypeKey: string(constants.TV), constants.SeriesIdKey: float64(2),
+		}}))
+		assert.False(t, match(&work.Job{Name: constants.UpdateSonarrJobName, Args: map[string]interface{}{
+			constants.SeriesIdKey: float64(1),
+		}}))
+		return 1, nil
+	}}
+	w := mockWorker{}
+	w.On("EnqueueUniqueIn", constants.UpdateSonarrJobName, int64(rescanDeferSeconds), map[string]interface{}{
+		constants.SeriesIdKey: int64(1),
+	}).Return(nil, nil)
+	context := WorkerContext{
+		SonarrClient: mockClient,
+		JobInspector: inspector,
+		Enqueuer:     &w,
+	}
+
+	err := context.UpdateTVShow(&work.Job{Args: map[string]interface{}{constants.SeriesIdKey: 1}})
+
+	assert.NoError(t, err)
+	w.AssertExpectations(t)
+}
+
+func TestRescanRunsWhenNoSeriesTranscodesQueued(t *testing.T) {
+	mockClient := MockSonarr{}
+	callRescan := false
+	mockClient.rescanSeries = func(id int64) (*web.SonarrCommand, error) {
+		callRescan = true
+		return &web.SonarrCommand{ID: 1}, nil
+	}
+	mockClient.checkSonarrCommand = func(id int) (*web.SonarrCommand, error) {
+		return &web.SonarrCommand{ID: 1, State: "completed"}, nil
+	}
+	inspector := mockJobInspector{countPendingJobs: func(match func(job *work.Job) bool) (int, error) {
+		return 0, nil
+	}}
+	context := WorkerContext{
+		SonarrClient: mockClient,
+		JobInspector: inspector,
+		Sleep:        func(d time.Duration) {},
+	}
+
+	err := context.UpdateTVShow(&work.Job{Args: map[string]interface{}{constants.SeriesIdKey: 1}})
+
+	assert.NoError(t, err)
+	assert.True(t, callRescan)
+}
+
+func TestCheckSeriesPathsFindsFilesMissingOnThisHost(t *testing.T) {
+	local := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(local, "S01E01.mp4"), []byte("video"), 0644))
+	var seriesId int
+	mockClient := MockSonarr{
+		pathMapper: pathmap.Mapper{{Remote: "/tv/Show", Local: local}},
+		getAllEpisodeFiles: func(id int) ([]web.SonarrEpisodeFile, error) {
+			seriesId = id
+			return []web.SonarrEpisodeFile{{Path: "/tv/Show/S01E01.mp4"}, {Path: "/tv/Show/S01E02.mkv"}}, nil
+		},
+	}
+
+	missing := checkSeriesPaths(mockClient, 7)
+
+	assert.Equal(t, 7, seriesId)
+	assert.Equal(t, []string{filepath.Join(local, "S01E02.mkv")}, missing)
+}
+
+func TestCheckSeriesPathsSkippedWithoutMappings(t *testing.T) {
+	assert.Nil(t, checkSeriesPaths(MockSonarr{}, 7))
+}
@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"context"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/rs/zerolog/log"
+)
+
+// WatchOptions configures a WatchFolder
+type WatchOptions struct {
+	Dirs []string
+	// Destination is where transcoded files are written. Empty writes them next to the source
+	Destination string
+	// Profile overrides the default movie profile
+	Profile    string
+	Extensions []string
+	// StableTime is how long a file's size must stay the same before it's picked up
+	StableTime   time.Duration
+	PollInterval time.Duration
+}
+
+// WatchOptionsFromConfig returns the configured watch folder options
+func WatchOptionsFromConfig(cfg config.Config) WatchOptions {
+	return WatchOptions{
+		Dirs:         cfg.WatchDirs,
+		Destination:  cfg.WatchDestination,
+		Profile:      cfg.WatchProfile,
+		Extensions:   cfg.WatchExtensions,
+		StableTime:   cfg.WatchStableTime,
+		PollInterval: cfg.WatchPollInterval,
+	}
+}
+
+// dirNotifier signals that something changed in the watched directories
+type dirNotifier interface {
+	Events() <-chan struct{}
+	Close() error
+}
+
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// WatchFolder enqueues path based transcodes for media copied into a set of directories by something other than
+// Sonarr or Radarr
+type WatchFolder struct {
+	opts       WatchOptions
+	extensions map[string]bool
+	scheduler  WorkScheduler
+	tracker    WatchTracker
+	now        func() time.Time
+	pending    map[string]pendingFile
+}
+
+// NewWatchFolder creates a WatchFolder. Files already recorded by the tracker are skipped
+func NewWatchFolder(opts WatchOptions, scheduler WorkScheduler, tracker WatchTracker) *WatchFolder {
+	extensions := make(map[string]bool)
+	for _, ext := range opts.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions[ext] = true
+	}
+	return &WatchFolder{
+		opts:       opts,
+		extensions: extensions,
+		scheduler:  scheduler,
+		tracker:    tracker,
+		now:        time.Now,
+		pending:    make(map[string]pendingFile),
+	}
+}
+
+// Run watches until ctx is done. Changes are picked up through inotify where it's available. The directories are
+// also polled, which is the only way to see changes made by another host on a network share
+func (w *WatchFolder) Run(ctx context.Context) {
+	var events <-chan struct{}
+	notifier, err := newDirNotifier(w.opts.Dirs)
+	if err != nil {
+		log.Warn().Err(err).Msg("Watching folders by polling only")
+	} else {
+		defer notifier.Close()
+		events = notifier.Events()
+	}
+
+	poll := time.NewTicker(w.opts.PollInterval)
+	defer poll.Stop()
+
+	w.Scan()
+	for {
+		// Files waiting to settle are checked again once they could be stable
+		var recheck <-chan time.Time
+		if len(w.pending) > 0 {
+			recheck = time.After(w.opts.StableTime)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+		case <-poll.C:
+		case <-recheck:
+		}
+		w.Scan()
+	}
+}
+
+// Scan walks the watched directories once, enqueueing the files whose size hasn't changed for the stable time
+func (w *WatchFolder) Scan() {
+	now := w.now()
+	seen := make(map[string]bool)
+	unreadable := make([]string, 0)
+	for _, dir := range w.opts.Dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("Failed to read watched path")
+				if info == nil || info.IsDir() {
+					unreadable = append(unreadable, path)
+				}
+				return nil
+			}
+			if info.IsDir() || !w.wanted(info) {
+				return nil
+			}
+			seen[path] = true
+			w.check(path, info, now)
+			return nil
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("dir", dir).Msg("Failed to scan watch folder")
+		}
+	}
+	// Forget files that were removed before they settled
+	for path := range w.pending {
+		if !seen[path] {
+			delete(w.pending, path)
+		}
+	}
+	w.prune(seen, unreadable)
+}
+
+// prune forgets tracked files that are no longer in the watched directories. Files in directories that couldn't be
+// read, like a network share that dropped out, are kept so they aren't processed again once it's back
+func (w *WatchFolder) prune(seen map[string]bool, unreadable []string) {
+	paths, err := w.tracker.Paths()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list watched files")
+		return
+	}
+	gone := make([]string, 0)
+	for _, path := range paths {
+		if seen[path] || isUnderAny(path, unreadable) {
+			continue
+		}
+		gone = append(gone, path)
+	}
+	if err = w.tracker.Delete(gone...); err != nil {
+		log.Warn().Err(err).Msg("Failed to forget removed watched files")
+	}
+}
+
+func isUnderAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if utils.IsUnder(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WatchFolder) wanted(info os.FileInfo) bool {
+	name := info.Name()
+	return !strings.HasPrefix(name, ".") && w.extensions[strings.ToLower(filepath.Ext(name))]
+}
+
+func (w *WatchFolder) check(path string, info os.FileInfo, now time.Time) {
+	pending, ok := w.pending[path]
+	if !ok || pending.size != info.Size() || !pending.modTime.Equal(info.ModTime()) {
+		if !ok && w.processed(path, info) {
+			return
+		}
+		w.pending[path] = pendingFile{size: info.Size(), modTime: info.ModTime(), since: now}
+		return
+	}
+	if now.Sub(pending.since) < w.opts.StableTime {
+		return
+	}
+	if err := w.enqueue(path); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to enqueue watched file")
+		return
+	}
+	delete(w.pending, path)
+	if err := w.tracker.Put(WatchedFile{Path: path, Status: WatchQueued, Size: info.Size(), ModTime: info.ModTime().UnixNano()}); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to track watched file")
+	}
+}
+
+// processed reports whether the tracker already has this version of the file
+func (w *WatchFolder) processed(path string, info os.FileInfo) bool {
+	file, err := w.tracker.Get(path)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to look up watched file")
+		return true
+	}
+	return file != nil && file.Matches(info)
+}
+
+func (w *WatchFolder) enqueue(path string) error {
+	args := work.Q{
+		constants.TranscodeTypeKey: constants.File,
+		constants.FilePathKey:      path,
+		constants.WatchedKey:       true,
+	}
+	if w.opts.Destination != "" {
+		args[constants.DestinationKey] = w.opts.Destination
+	}
+	if w.opts.Profile != "" {
+		args[constants.ProfileKey] = w.opts.Profile
+	}
+	job, err := w.scheduler.EnqueueUnique(constants.TranscodeJobType, args)
+	if err == nil && job != nil {
+		log.Info().Str("path", path).Msg("Enqueued watched file: " + job.ID)
+	}
+	return err
+}
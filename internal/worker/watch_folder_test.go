@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"errors"
+	"io/ioutil"
+	"media-web/internal/constants"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type memoryWatchTracker map[string]WatchedFile
+
+func (m memoryWatchTracker) Get(path string) (*WatchedFile, error) {
+	file, ok := m[path]
+	if !ok {
+		return nil, nil
+	}
+	return &file, nil
+}
+
+func (m memoryWatchTracker) Put(file WatchedFile) error {
+	m[file.Path] = file
+	return nil
+}
+
+func (m memoryWatchTracker) Paths() ([]string, error) {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (m memoryWatchTracker) Delete(paths ...string) error {
+	for _, path := range paths {
+		delete(m, path)
+	}
+	return nil
+}
+
+type watchClock struct {
+	now time.Time
+}
+
+func (c *watchClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestWatchFolder(t *testing.T, opts WatchOptions, scheduler WorkScheduler, tracker WatchTracker) (*WatchFolder, *watchClock) {
+	opts.StableTime = 30 * time.Second
+	opts.Extensions = []string{".mkv", "mp4"}
+	watcher := NewWatchFolder(opts, scheduler, tracker)
+	clock := &watchClock{now: time.Unix(1000, 0)}
+	watcher.now = func() time.Time { return clock.now }
+	return watcher, clock
+}
+
+func writeWatched(t *testing.T, path string, content string) os.FileInfo {
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	return info
+}
+
+func TestWatchFolderEnqueuesStableFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "home video.mkv")
+	writeWatched(t, path, "video")
+	writeWatched(t, filepath.Join(dir, "notes.txt"), "notes")
+	writeWatched(t, filepath.Join(dir, ".hidden.mkv"), "video")
+	w := mockWorker{}
+	w.On("EnqueueUnique", constants.TranscodeJobType, map[string]interface{}{
+		constants.TranscodeTypeKey: constants.File,
+		constants.FilePathKey:      path,
+		constants.WatchedKey:       true,
+		constants.DestinationKey:   "/media/done",
+		constants.ProfileKey:       "hevc",
+	}).Once().Return(&work.Job{ID: "job"}, nil)
+	tracker := memoryWatchTracker{}
+	watcher, clock := newTestWatchFolder(t, WatchOptions{Dirs: []string{dir}, Destination: "/media/done", Profile: "hevc"}, &w, tracker)
+
+	watcher.Scan()
+	w.AssertNotCalled(t, "EnqueueUnique", mock.Anything, mock.Anything)
+
+	clock.advance(31 * time.Second)
+	watcher.Scan()
+	clock.advance(31 * time.Second)
+	watcher.Scan()
+
+	w.AssertExpectations(t)
+	assert.Equal(t, WatchQueued, tracker[path].Status)
+}
+
+func TestWatchFolderWaitsForGrowingFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "movie.mp4")
+	writeWatched(t, path, "part")
+	w := mockWorker{}
+	watcher, clock := newTestWatchFolder(t, WatchOptions{Dirs: []string{dir}}, &w, memoryWatchTracker{})
+
+	watcher.Scan()
+	clock.advance(31 * time.Second)
+	writeWatched(t, path, "part of a bigger file")
+	watcher.Scan()
+
+	w.AssertNotCalled(t, "EnqueueUnique", mock.Anything, mock.Anything)
+	assert.Contains(t, watcher.pending, path)
+}
+
+func TestWatchFolderSkipsTrackedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "movie.mkv")
+	info := writeWatched(t, path, "video")
+	tracker := memoryWatchTracker{path: {Path: path, Status: WatchFailed, Size: info.Size(), ModTime: info.ModTime().UnixNano()}}
+	w := mockWorker{}
+	watcher, clock := newTestWatchFolder(t, WatchOptions{Dirs: []string{dir}}, &w, tracker)
+
+	watcher.Scan()
+	clock.advance(31 * time.Second)
+	watcher.Scan()
+
+	w.AssertNotCalled(t, "EnqueueUnique", mock.Anything, mock.Anything)
+	assert.Empty(t, watcher.pending)
+}
+
+func TestWatchFolderForgetsRemovedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "movie.mkv")
+	writeWatched(t, path, "video")
+	watcher, _ := newTestWatchFolder(t, WatchOptions{Dirs: []string{dir}}, &mockWorker{}, memoryWatchTracker{})
+
+	watcher.Scan()
+	assert.NoError(t, os.Remove(path))
+	watcher.Scan()
+
+	assert.Empty(t, watcher.pending)
+}
+
+func TestWatchFolderForgetsTrackedFilesRemovedFromTheFolder(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.mkv")
+	info := writeWatched(t, kept, "video")
+	removed := filepath.Join(dir, "removed.mkv")
+	offline := filepath.Join(t.TempDir(), "offline")
+	tracker := memoryWatchTracker{
+		kept:                               {Path: kept, Status: WatchCompleted, Size: info.Size(), ModTime: info.ModTime().UnixNano()},
+		removed:                            {Path: removed, Status: WatchCompleted},
+		"/media/done/movie.mp4":            {Path: "/media/done/movie.mp4", Status: WatchCompleted},
+		filepath.Join(offline, "show.mkv"): {Path: filepath.Join(offline, "show.mkv"), Status: WatchCompleted},
+	}
+	watcher, _ := newTestWatchFolder(t, WatchOptions{Dirs: []string{dir, offline}}, &mockWorker{}, tracker)
+
+	watcher.Scan()
+
+	// The folder that can't be read keeps its files so they aren't transcoded again when it's back
+	assert.ElementsMatch(t, []string{kept, filepath.Join(offline, "show.mkv")}, keys(tracker))
+}
+
+func keys(tracker memoryWatchTracker) []string {
+	paths, _ := tracker.Paths()
+	return paths
+}
+
+func TestTrackWatchedFilesMarksResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movie.mkv")
+	writeWatched(t, path, "video")
+	tracker := memoryWatchTracker{}
+	context := WorkerContext{WatchTracker: tracker}
+	job := &work.Job{Args: map[string]interface{}{constants.FilePathKey: path, constants.WatchedKey: true}}
+
+	err := context.TrackWatchedFiles(job, func() error { return errors.New("boom") })
+
+	assert.Error(t, err)
+	assert.Equal(t, WatchFailed, tracker[path].Status)
+	assert.EqualValues(t, 5, tracker[path].Size)
+
+	assert.NoError(t, context.TrackWatchedFiles(job, func() error { return nil }))
+	assert.Equal(t, WatchCompleted, tracker[path].Status)
+}
+
+func TestTrackWatchedFilesIgnoresOtherJobs(t *testing.T) {
+	tracker := memoryWatchTracker{}
+	context := WorkerContext{WatchTracker: tracker}
+
+	err := context.TrackWatchedFiles(movieJob(), func() error { return nil })
+
+	assert.NoError(t, err)
+	assert.Empty(t, tracker)
+}
+
+func TestTrackWatchedFilesForgetsRemovedSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movie.mkv")
+	tracker := memoryWatchTracker{path: {Path: path, Status: WatchQueued, Size: 5}}
+	context := WorkerContext{WatchTracker: tracker}
+	job := &work.Job{Args: map[string]interface{}{constants.FilePathKey: path, constants.WatchedKey: true}}
+
+	assert.NoError(t, context.TrackWatchedFiles(job, func() error { return nil }))
+
+	assert.Empty(t, tracker)
+}
+
+func TestRedisWatchTracker(t *testing.T) {
+	pool, _ := testRedis(t)
+	tracker := NewWatchTracker(testNamespace, pool)
+
+	assert.NoError(t, tracker.Put(WatchedFile{Path: "/watch/a.mkv", Status: WatchQueued, Size: 5}))
+	assert.NoError(t, tracker.Put(WatchedFile{Path: "/watch/b.mkv", Status: WatchFailed}))
+	file, err := tracker.Get("/watch/a.mkv")
+	assert.NoError(t, err)
+	assert.Equal(t, WatchQueued, file.Status)
+	assert.EqualValues(t, 5, file.Size)
+
+	assert.NoError(t, tracker.Delete("/watch/a.mkv"))
+	assert.NoError(t, tracker.Delete())
+
+	paths, err := tracker.Paths()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/watch/b.mkv"}, paths)
+	file, err = tracker.Get("/watch/a.mkv")
+	assert.NoError(t, err)
+	assert.Nil(t, file)
+}
@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+
+type inotifyNotifier struct {
+	file   *os.File
+	fd     int
+	dirs   map[int32]string
+	events chan struct{}
+}
+
+// newDirNotifier watches dirs and their subdirectories with inotify
+func newDirNotifier(dirs []string) (dirNotifier, error) {
+	// A non blocking descriptor lets the runtime poller interrupt the read on Close
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotifyNotifier{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		dirs:   make(map[int32]string),
+		events: make(chan struct{}, 1),
+	}
+	for _, dir := range dirs {
+		if err = n.addTree(dir); err != nil {
+			_ = n.file.Close()
+			return nil, err
+		}
+	}
+	go n.read()
+	return n, nil
+}
+
+func (n *inotifyNotifier) addTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		wd, err := syscall.InotifyAddWatch(n.fd, path, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		n.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+func (n *inotifyNotifier) read() {
+	defer close(n.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(buf[nameStart : nameStart+int(event.Len)])
+			offset = nameStart + int(event.Len)
+
+			// New directories, like a season folder copied in, have to be watched too
+			if event.Mask&syscall.IN_CREATE != 0 && event.Mask&syscall.IN_ISDIR != 0 {
+				if parent, ok := n.dirs[event.Wd]; ok {
+					if err = n.addTree(filepath.Join(parent, trimNull(name))); err != nil {
+						log.Warn().Err(err).Msg("Failed to watch new directory")
+					}
+				}
+			}
+		}
+		// Events are coalesced, the watcher rescans on any change
+		select {
+		case n.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func trimNull(name string) string {
+	for i := 0; i < len(name); i++ {
+		if name[i] == 0 {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+func (n *inotifyNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+func (n *inotifyNotifier) Close() error {
+	return n.file.Close()
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForEvent(t *testing.T, events <-chan struct{}) {
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no inotify event")
+	}
+}
+
+func TestInotifySignalsNewFilesInNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	notifier, err := newDirNotifier([]string{dir})
+	assert.NoError(t, err)
+	defer notifier.Close()
+
+	season := filepath.Join(dir, "Season 1")
+	assert.NoError(t, os.Mkdir(season, 0755))
+	waitForEvent(t, notifier.Events())
+
+	// The new directory is watched once its create event has been read
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(season, "episode.mkv"), []byte("video"), 0644))
+	waitForEvent(t, notifier.Events())
+}
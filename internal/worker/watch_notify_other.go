@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package worker
+
+import "errors"
+
+// newDirNotifier is only implemented with inotify, so other platforms poll
+func newDirNotifier(dirs []string) (dirNotifier, error) {
+	return nil, errors.New("inotify is not available on this platform")
+}
@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"encoding/json"
+	"media-web/internal/config"
+	"media-web/internal/constants"
+	"media-web/internal/storage"
+	"os"
+	"time"
+
+	"github.com/gocraft/work"
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// WatchStatus is how far a file found in a watch folder has been processed
+type WatchStatus string
+
+const (
+	WatchQueued    WatchStatus = "queued"
+	WatchCompleted WatchStatus = "completed"
+	WatchFailed    WatchStatus = "failed"
+)
+
+// WatchedFile is a file found in a watch folder. The size and modification time tell a file apart from a new one
+// copied to the same path
+type WatchedFile struct {
+	Path      string      `json:"path"`
+	Status    WatchStatus `json:"status"`
+	Size      int64       `json:"size"`
+	ModTime   int64       `json:"modTime"`
+	UpdatedAt int64       `json:"updatedAt"`
+}
+
+// Matches reports whether the file on disk is the one that was recorded
+func (f WatchedFile) Matches(info os.FileInfo) bool {
+	return f.Size == info.Size() && f.ModTime == info.ModTime().UnixNano()
+}
+
+// WatchTracker remembers which watched files were queued, completed or failed so they aren't processed again
+// after a restart
+type WatchTracker interface {
+	Get(path string) (*WatchedFile, error)
+	Put(file WatchedFile) error
+	// Paths lists every tracked file
+	Paths() ([]string, error)
+	Delete(paths ...string) error
+}
+
+type redisWatchTracker struct {
+	key  string
+	pool *redis.Pool
+}
+
+// NewWatchTracker creates a WatchTracker storing the files in a Redis hash keyed by path
+func NewWatchTracker(namespace string, pool *redis.Pool) WatchTracker {
+	return redisWatchTracker{key: namespacePrefix(namespace) + "watch", pool: pool}
+}
+
+// GetWatchTracker returns the WatchTracker for the configured job queue
+func GetWatchTracker() WatchTracker {
+	return NewWatchTracker(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+}
+
+func (t redisWatchTracker) Get(path string) (*WatchedFile, error) {
+	conn := t.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("HGET", t.key, path))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file WatchedFile
+	if err = json.Unmarshal(value, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (t redisWatchTracker) Put(file WatchedFile) error {
+	file.UpdatedAt = time.Now().Unix()
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	conn := t.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", t.key, file.Path, data)
+	return err
+}
+
+func (t redisWatchTracker) Paths() ([]string, error) {
+	conn := t.pool.Get()
+	defer conn.Close()
+
+	return redis.Strings(conn.Do("HKEYS", t.key))
+}
+
+func (t redisWatchTracker) Delete(paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	conn := t.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", redis.Args{}.Add(t.key).AddFlat(paths)...)
+	return err
+}
+
+// trackWatched records a file of a watched job with its current size and modification time. A file that is gone,
+// like a source moved to the destination, has nothing left to skip so it's forgotten
+func trackWatched(tracker WatchTracker, path string, status WatchStatus) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if err = tracker.Delete(path); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to forget watched file")
+		}
+		return
+	}
+	file := WatchedFile{Path: path, Status: status}
+	if err == nil {
+		file.Size = info.Size()
+		file.ModTime = info.ModTime().UnixNano()
+	}
+	if err = tracker.Put(file); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to track watched file")
+	}
+}
+
+// TrackWatchedFiles is a middleware that marks the source of a transcode from a watch folder as completed or failed
+func (c *WorkerContext) TrackWatchedFiles(job *work.Job, next work.NextMiddlewareFunc) error {
+	err := next()
+	// An interrupted job is queued again so the file stays queued
+	if c.WatchTracker == nil || !job.ArgBool(constants.WatchedKey) || err == ErrJobInterrupted {
+		return err
+	}
+	status := WatchCompleted
+	if err != nil {
+		status = WatchFailed
+	}
+	trackWatched(c.WatchTracker, job.ArgString(constants.FilePathKey), status)
+	return err
+}
@@ -1,11 +1,12 @@
 package worker
 
 import (
-	"github.com/floostack/transcoder"
 	"context"
 	"media-web/internal/config"
 	"media-web/internal/constants"
+	"media-web/internal/recycle"
 	"media-web/internal/storage"
+	"media-web/internal/transcode"
 	"media-web/internal/utils"
 	"media-web/internal/web"
 	"time"
@@ -16,20 +17,39 @@ import (
 )
 
 type WorkerContext struct {
-	GetTranscoder func() transcoder.Transcoder
-	SonarrClient  web.SonarrClient
-	RadarrClient  web.RadarrClient
+	Encoder      transcode.Encoder
+	Analyzer     transcode.Analyzer
+	Verifier     transcode.Verifier
+	RecycleBin   recycle.Bin
+	JobHistory   JobHistory
+	JobInspector JobInspector
+	WatchTracker WatchTracker
+	SonarrClient web.SonarrClient
+	RadarrClient web.RadarrClient
+	// SonarrClients and RadarrClients hold the named instances, SonarrClient and RadarrClient the default one
+	SonarrClients map[string]web.SonarrClient
+	RadarrClients map[string]web.RadarrClient
+	LidarrClient  web.LidarrClient
 	Enqueuer      WorkScheduler
-	Sleep         func(d time.Duration)
+	Canceller     JobCanceller
+	Chunks        ChunkStore
+	Keyframes     transcode.KeyframeReader
+	Chunking      ChunkSettings
+	Throttle      Throttle
+	// Interrupt is cancelled once the drain timeout runs out during shutdown, stopping running encodes
+	Interrupt context.Context
+	Sleep     func(d time.Duration)
 }
 
 type WorkScheduler interface {
 	EnqueueUnique(jobName string, args map[string]interface{}) (*work.Job, error)
+	EnqueueUniqueIn(jobName string, secondsFromNow int64, args map[string]interface{}) (*work.ScheduledJob, error)
 }
 
 var worker = work.NewEnqueuer(config.GetConfig().JobQueueNamespace, &storage.RedisPool)
 
-var Enqueuer = worker
+// Enqueuer routes transcodes to the queues of the worker classes that can run them
+var Enqueuer WorkScheduler = GetJobRouter()
 
 func (c *WorkerContext) Log(job *work.Job, next work.NextMiddlewareFunc) error {
 	log.Info().Str("jobId", job.ID).Msg("Starting job: " + job.ID)
@@ -42,21 +62,35 @@ func (c *WorkerContext) Metrics(job *work.Job, next work.NextMiddlewareFunc) err
 	start := time.Now()
 	err := next()
 	dur := time.Since(start)
-	status := "success"
-	if err != nil {
-		status = "error"
-	}
+	status := jobStatus(err)
 	utils.JobCount.WithLabelValues(job.Name, status).Inc()
 	utils.JobTime.WithLabelValues(job.Name, status).Observe(dur.Seconds())
 	return err
 }
 
 var workerContext = WorkerContext{
-	GetTranscoder: GetTranscoder,
+	Encoder:       transcode.GetEncoder(),
+	Analyzer:      transcode.GetAnalyzer(),
+	Verifier:      transcode.GetVerifier(),
+	RecycleBin:    recycle.GetBin(),
+	JobHistory:    GetJobHistory(),
+	JobInspector:  GetJobInspector(),
+	WatchTracker:  GetWatchTracker(),
 	SonarrClient:  web.GetSonarrClient(),
 	RadarrClient:  web.GetRadarrClient(),
+	SonarrClients: web.GetSonarrClients(),
+	RadarrClients: web.GetRadarrClients(),
+	LidarrClient:  web.GetLidarrClient(),
 	Enqueuer:      Enqueuer,
+	Canceller:     GetJobCanceller(),
+	Chunks:        GetChunkStore(),
+	Keyframes:     transcode.GetKeyframeReader(),
 	Sleep:         time.Sleep,
+	Throttle:      loadConfiguredThrottle(),
+	Chunking: ChunkSettings{
+		MinDuration: config.GetConfig().ChunkMinDuration,
+		Duration:    config.GetConfig().ChunkDuration,
+	},
 }
 
 func GetWorkerContext() WorkerContext {
@@ -102,19 +136,64 @@ func (w WorkerPoolImpl) Stop() {
 
 func StartWorkerPool(context WorkerContext, factory WorkerPoolFactory, ctx context.Context) {
 	log.Info().Msg("Starting worker pool")
+	cfg := config.GetConfig()
+	namespace := ClassNamespace(cfg.JobQueueNamespace, cfg.WorkerClass)
+	if cfg.WorkerClass != "" {
+		log.Info().Str("class", cfg.WorkerClass).Msg("Serving worker class queue: " + namespace)
+		// Jobs queued by a class worker, such as chunks, rescans and interrupted transcodes, stay in its queue
+		context.Enqueuer = work.NewEnqueuer(namespace, &storage.RedisPool)
+		registry := GetCapabilityRegistry()
+		capabilities := CapabilitiesFromConfig(cfg)
+		if err := registry.Advertise(capabilities); err != nil {
+			log.Warn().Err(err).Str("class", capabilities.Class).Msg("Failed to advertise worker class")
+		}
+		// A class advertised for the first time doesn't have the pauses set before it existed
+		syncPauses(NewQueuePauser(cfg.JobQueueNamespace, registry, &storage.RedisPool))
+		go advertise(ctx, registry, capabilities)
+	}
+	// Running encodes get the drain timeout to finish once ctx is done before they are stopped and queued again
+	interrupt, stopDrain := drainContext(ctx, cfg.WorkerDrainTimeout)
+	defer stopDrain()
+	context.Interrupt = interrupt
 	// Note: normally the worker context isn't shared and would be unique per job
 	// However, here we use it as a mechanism to inject dependencies into the job handler
-	pool := factory.NewWorkerPool(context, 20, config.GetConfig().JobQueueNamespace, &storage.RedisPool)
+	pool := factory.NewWorkerPool(context, 20, namespace, &storage.RedisPool)
 	pool.Middleware(context.Log)
+	pool.Middleware(context.HandleCancellation)
+	// Throttled jobs are held before they count towards the metrics and history, and can be cancelled meanwhile
+	pool.Middleware(context.ThrottleEncodes)
 	pool.Middleware(context.Metrics)
+	pool.Middleware(context.RecordHistory)
+	pool.Middleware(context.TrackWatchedFiles)
 
+	// WORKER_MAX_ENCODES lets a big box run several transcodes at once. gocraft/work keeps the limit in Redis per
+	// namespace, so it is shared by every worker of the queue and the worker started last sets it
 	pool.JobWithOptions(constants.TranscodeJobType, work.JobOptions{
 		Priority:       1,
-		MaxFails:       3,
+		MaxFails:       transcodeMaxFails,
+		SkipDead:       false,
+		MaxConcurrency: cfg.WorkerMaxEncodes,
+	}, context.TranscodeJobHandler)
+
+	// Urgent transcodes get their own queue. gocraft/work samples the queues weighted by priority, so they are
+	// usually but not always fetched first. The queue has its own concurrency limit, so one priority transcode can
+	// run as an extra ffmpeg next to the regular ones
+	pool.JobWithOptions(constants.PriorityTranscodeJobType, work.JobOptions{
+		Priority:       10,
+		MaxFails:       transcodeMaxFails,
 		SkipDead:       false,
 		MaxConcurrency: 1,
 	}, context.TranscodeJobHandler)
 
+	// Chunks of long transcodes run next to the transcode waiting on them and can be spread over several workers.
+	// The limit is kept in Redis per namespace, so it caps the chunks of every worker of the queue together
+	pool.JobWithOptions(constants.TranscodeChunkJobName, work.JobOptions{
+		Priority:       5,
+		MaxFails:       chunkMaxFails,
+		SkipDead:       false,
+		MaxConcurrency: cfg.ChunkConcurrency,
+	}, context.TranscodeChunkHandler)
+
 	pool.JobWithOptions(constants.UpdateSonarrJobName, work.JobOptions{
 		Priority:       2,
 		MaxFails:       3,
@@ -129,6 +208,13 @@ func StartWorkerPool(context WorkerContext, factory WorkerPoolFactory, ctx conte
 		MaxConcurrency: 5,
 	}, context.UpdateMovie)
 
+	pool.JobWithOptions(constants.UpdateLidarrJobName, work.JobOptions{
+		Priority:       2,
+		MaxFails:       3,
+		SkipDead:       false,
+		MaxConcurrency: 5,
+	}, context.UpdateArtist)
+
 	// Start processing jobs
 	pool.Start()
 
@@ -71,5 +71,5 @@ func TestWorkerPoolSetup(t *testing.T) {
 	assert.True(t, start)
 	assert.True(t, stop)
 	assert.True(t, middleware)
-	assert.ElementsMatch(t, jobs, []string{constants.TranscodeJobType, constants.UpdateSonarrJobName, constants.UpdateRadarrJobName})
+	assert.ElementsMatch(t, jobs, []string{constants.TranscodeJobType, constants.PriorityTranscodeJobType, constants.TranscodeChunkJobName, constants.UpdateSonarrJobName, constants.UpdateRadarrJobName, constants.UpdateLidarrJobName})
 }
@@ -28,14 +28,115 @@ angular.module('mediaweb', ['ngRoute'])
             console.log(error)
         });
     })
+    .controller('JobsController', function ($scope, $http, $interval) {
+        var jobsCtl = this;
+        $scope.jobsCtl = jobsCtl;
+        jobsCtl.statuses = ['in_progress', 'queued', 'retrying', 'scheduled', 'dead'];
+        jobsCtl.jobs = {};
+        jobsCtl.error = null;
+
+        jobsCtl.refresh = function () {
+            $http.get('api/jobs').then(function (response) {
+                jobsCtl.jobs = response.data;
+                jobsCtl.error = null;
+            }, function (error) {
+                jobsCtl.error = 'Failed to load jobs';
+                console.log(error)
+            });
+        };
+
+        // Transcode check ins look like "Transcoding: /path (42.0% at 1.5x)"
+        jobsCtl.progress = function (job) {
+            var match = /\(([\d.]+)%/.exec(job.checkin || '');
+            return match ? parseFloat(match[1]) : null;
+        };
+
+        jobsCtl.retry = function (job) {
+            $http.post('api/jobs/dead/' + job.at + '/' + job.id + '/retry').then(jobsCtl.refresh, function (error) {
+                console.log(error)
+            });
+        };
+
+        jobsCtl.remove = function (job) {
+            $http.delete('api/jobs/queued/' + job.name + '/' + job.id).then(jobsCtl.refresh, function (error) {
+                console.log(error)
+            });
+        };
+
+        jobsCtl.cancel = function (job) {
+            $http.delete('api/jobs/' + job.id).then(jobsCtl.refresh, function (error) {
+                console.log(error)
+            });
+        };
+
+        jobsCtl.refresh();
+        var poll = $interval(jobsCtl.refresh, 2000);
+        $scope.$on('$destroy', function () {
+            $interval.cancel(poll);
+        });
+    })
+    .controller('HistoryController', function ($scope, $http) {
+        var historyCtl = this;
+        $scope.historyCtl = historyCtl;
+        historyCtl.entries = [];
+
+        historyCtl.refresh = function () {
+            $http.get('api/history').then(function (response) {
+                historyCtl.entries = response.data;
+            }, function (error) {
+                console.log(error)
+            });
+        };
+        historyCtl.refresh();
+    })
+    .controller('EnqueueController', function ($scope, $http) {
+        var enqueueCtl = this;
+        $scope.enqueueCtl = enqueueCtl;
+        enqueueCtl.request = {type: 'movieId', id: null, path: '', profile: '', priority: 'normal'};
+        enqueueCtl.profiles = [];
+        enqueueCtl.result = null;
+
+        $http.get('api/config').then(function (response) {
+            enqueueCtl.profiles = response.data.Profiles;
+        }, function (error) {
+            console.log(error)
+        });
+
+        enqueueCtl.submit = function () {
+            var body = {profile: enqueueCtl.request.profile, priority: enqueueCtl.request.priority};
+            if (enqueueCtl.request.type === 'path') {
+                body.path = enqueueCtl.request.path;
+            } else {
+                body[enqueueCtl.request.type] = enqueueCtl.request.id;
+            }
+            $http.post('api/transcode', body).then(function (response) {
+                enqueueCtl.result = 'Enqueued ' + response.data.jobIds.length + ' job(s), ' +
+                    response.data.duplicates + ' already queued';
+            }, function (error) {
+                enqueueCtl.result = 'Failed: ' + error.data;
+            });
+        };
+    })
     .config(function ($routeProvider) {
         $routeProvider
             .when('/config', {
                 templateUrl: 'templates/config.html',
                 controller: 'ConfigController'
             })
+            .when('/jobs', {
+                templateUrl: 'templates/jobs.html',
+                controller: 'JobsController'
+            })
+            .when('/history', {
+                templateUrl: 'templates/history.html',
+                controller: 'HistoryController'
+            })
+            .when('/enqueue', {
+                templateUrl: 'templates/enqueue.html',
+                controller: 'EnqueueController'
+            })
             .otherwise({
-                templateUrl: 'templates/main.html'
+                redirectTo: '/jobs'
             });
 
         // configure html5 to get links working on jsfiddle